@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"net/http"
+	"time"
+)
+
+// newAPIKeyResponse is a created API key. The key itself is only ever sent
+// here.
+type newAPIKeyResponse struct {
+	*data.APIKey
+	Key string `json:"key"`
+}
+
+// allAPIKeys lists the API keys of the caller, newest first, revoked and
+// expired ones included.
+// Ex.)curl http://localhost:8090/api-keys -H "Authorization: Bearer ..."
+func (app *application) allAPIKeys(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r.Context())
+
+	keys, err := app.db(r.Context()).UserAPIKeys(p.UserID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []*data.APIKey{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+// createAPIKey creates an API key of the caller. Only a logged in user may
+// create keys, so that a leaked key cannot be used to make more.
+// Ex.)curl http://localhost:8090/api-keys -X POST -H "Authorization: Bearer ..." -d '{"name":"deploy","scopes":["read"]}'
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r.Context())
+	if p.Key != nil {
+		app.errorJSON(w, r, errLoginRequired, http.StatusForbidden)
+		return
+	}
+
+	var req apikey.Request
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if errs := req.Validate(); len(errs) > 0 {
+		app.failedValidation(w, r, errs)
+		return
+	}
+
+	key, k, err := apikey.New(p.UserID, req, time.Now())
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	k.ID, err = app.db(r.Context()).InsertAPIKey(k)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionAPIKeyCreate, audit.TargetAPIKey, k.ID)
+	e.Changes = audit.Diff(nil, audit.APIKeyFields(&k))
+	app.recordAudit(r, e)
+
+	_ = app.writeJSON(w, http.StatusCreated, newAPIKeyResponse{APIKey: &k, Key: key})
+}
+
+// revokeAPIKey revokes an API key of the caller. The key stays in the list.
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	p := principalFrom(r.Context())
+
+	keyID, err := intURLParam(r, "keyID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.db(r.Context()).RevokeAPIKey(p.UserID, keyID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, r, errAPIKeyNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionAPIKeyRevoke, audit.TargetAPIKey, keyID)
+	app.recordAudit(r, e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyRepo records the writes of the API key handlers and middleware. With
+// admin set, the test user is an administrator.
+type apiKeyRepo struct {
+	dbrepo.TestDBRepo
+	admin   bool
+	touched []int
+	events  []data.AuditEvent
+}
+
+func (m *apiKeyRepo) GetUser(id int) (*data.User, error) {
+	u, err := m.TestDBRepo.GetUser(id)
+	if err == nil && m.admin {
+		u.IsAdmin = 1
+	}
+	return u, err
+}
+
+func (m *apiKeyRepo) TouchAPIKey(id int, at time.Time, ip string) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+func (m *apiKeyRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	m.events = append(m.events, e)
+	return &e, nil
+}
+
+// withPrincipal returns req as authRequired passes it on.
+func withPrincipal(req *http.Request, p *principal) *http.Request {
+	req = requestlog.SetUserID(req, "1")
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+func Test_app_allAPIKeys(t *testing.T) {
+	var tests = []struct {
+		name          string
+		userID        int
+		expectedNames []string
+	}{
+		{"allAPIKeys newest first", 1, []string{"revoked", "expired", "everything", "read only"}},
+		{"allAPIKeys none", 2, []string{}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api-keys/", nil)
+		req = withPrincipal(req, &principal{UserID: e.userID})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.allAPIKeys).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200 but got %d: %s", e.name, rr.Code, rr.Body.String())
+			continue
+		}
+		checkHandlerResponse(t, e.name, rr)
+
+		if strings.Contains(rr.Body.String(), `"hash"`) {
+			t.Errorf("%s: expected no hashes but got %s", e.name, rr.Body.String())
+		}
+		var keys []data.APIKey
+		_ = json.Unmarshal(rr.Body.Bytes(), &keys)
+		names := []string{}
+		for _, k := range keys {
+			names = append(names, k.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(e.expectedNames, ",") {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expectedNames, names)
+		}
+	}
+}
+
+func Test_app_createAPIKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		principal          *principal
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"createAPIKey", `{"name":"deploy","scopes":["read","write"],"expires_in_days":30}`, &principal{UserID: 1}, http.StatusCreated, ""},
+		{"createAPIKey default expiry", `{"name":"deploy","scopes":["read"]}`, &principal{UserID: 1}, http.StatusCreated, ""},
+		{"createAPIKey with a key", `{"name":"deploy","scopes":["read"]}`, &principal{UserID: 1, Key: &data.APIKey{ID: 2}}, http.StatusForbidden, "login_required"},
+		{"createAPIKey unknown scope", `{"name":"deploy","scopes":["root"]}`, &principal{UserID: 1}, http.StatusUnprocessableEntity, "validation_failed"},
+		{"createAPIKey too long", `{"name":"deploy","scopes":["read"],"expires_in_days":1000}`, &principal{UserID: 1}, http.StatusUnprocessableEntity, "validation_failed"},
+		{"createAPIKey unknown field", `{"name":"deploy","scopes":["read"],"user_id":2}`, &principal{UserID: 1}, http.StatusBadRequest, ""},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &apiKeyRepo{}
+		app.DB = repo
+
+		req, _ := http.NewRequest("POST", "/api-keys/", strings.NewReader(e.body))
+		req = withPrincipal(req, e.principal)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.createAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		checkHandlerResponse(t, e.name, rr)
+
+		if rr.Code != http.StatusCreated {
+			if e.expectedCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectedCode+`"`) {
+				t.Errorf("%s: expected a problem with code %s, got %s", e.name, e.expectedCode, rr.Body.String())
+			}
+			if len(repo.events) != 0 {
+				t.Errorf("%s: expected no event but got %+v", e.name, repo.events)
+			}
+			continue
+		}
+
+		var created newAPIKeyResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &created)
+		prefix, err := apikey.Prefix(created.Key)
+		if err != nil || prefix != created.Prefix || created.ID != 5 || created.UserID != 1 {
+			t.Errorf("%s: unexpected key %+v", e.name, created)
+		}
+		if len(repo.events) != 1 || repo.events[0].Action != audit.ActionAPIKeyCreate || repo.events[0].TargetID != "5" ||
+			strings.Contains(string(repo.events[0].Changes), created.Key) {
+			t.Errorf("%s: expected the creation to be recorded without the key but got %+v", e.name, repo.events)
+		}
+	}
+}
+
+func Test_app_revokeAPIKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             int
+		keyID              string
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"revokeAPIKey", 1, "2", http.StatusNoContent, ""},
+		{"revokeAPIKey of another user", 2, "2", http.StatusNotFound, "api_key_not_found"},
+		{"revokeAPIKey missing", 1, "99", http.StatusNotFound, "api_key_not_found"},
+		{"revokeAPIKey bad id", 1, "abc", http.StatusBadRequest, "invalid_parameter"},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &apiKeyRepo{}
+		app.DB = repo
+
+		req, _ := http.NewRequest("DELETE", "/api-keys/"+e.keyID, nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("keyID", e.keyID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = withPrincipal(req, &principal{UserID: e.userID})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.revokeAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		checkHandlerResponse(t, e.name, rr)
+
+		if e.expectedCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectedCode+`"`) {
+			t.Errorf("%s: expected a problem with code %s, got %s", e.name, e.expectedCode, rr.Body.String())
+		}
+		recorded := len(repo.events) == 1 && repo.events[0].Action == audit.ActionAPIKeyRevoke && repo.events[0].TargetID == e.keyID
+		if recorded != (rr.Code == http.StatusNoContent) {
+			t.Errorf("%s: unexpected events %+v", e.name, repo.events)
+		}
+	}
+}
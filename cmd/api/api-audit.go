@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http"
+	"strconv"
+)
+
+// auditEvent returns an event of action on the target, done by the user of
+// the access token, see authRequired.
+func (app *application) auditEvent(r *http.Request, action, targetType string, targetID int) data.AuditEvent {
+	e := audit.FromRequest(r, app.ClientIP.String(r), action, targetType, targetID)
+	e.ActorID, _ = strconv.Atoi(requestlog.UserID(r.Context()))
+	return e
+}
+
+// recordAudit appends e to the audit log. Failures are only logged.
+func (app *application) recordAudit(r *http.Request, e data.AuditEvent) {
+	audit.Record(r.Context(), app.db(r.Context()), e)
+}
+
+// auditEvents lists the audit log, newest first, filtered by the query
+// parameters. Pass next_cursor as cursor to get the next page.
+// Ex.)curl "http://localhost:8090/audit-events?action=user.update&limit=20" -H "Authorization: Bearer ..."
+func (app *application) auditEvents(w http.ResponseWriter, r *http.Request) {
+	f, err := audit.ParseFilter(r.URL.Query())
+	var paramErr *audit.ParamError
+	if errors.As(err, &paramErr) {
+		app.errorJSON(w, r, invalidParameter(paramErr.Param, paramErr.Message), http.StatusBadRequest)
+		return
+	}
+
+	page, err := audit.List(app.db(r.Context()), f)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, page)
+}
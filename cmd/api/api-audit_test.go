@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_app_auditEvents(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedIDs        []int64
+		expectedNextCursor int64
+	}{
+		{"auditEvents newest first", "", http.StatusOK, []int64{2, 1}, 0},
+		{"auditEvents by action", "?action=auth.login", http.StatusOK, []int64{1}, 0},
+		{"auditEvents by actor and target", "?actor_id=1&target_type=user&target_id=1", http.StatusOK, []int64{2, 1}, 0},
+		{"auditEvents first page", "?limit=1", http.StatusOK, []int64{2}, 2},
+		{"auditEvents next page", "?limit=1&cursor=2", http.StatusOK, []int64{1}, 0},
+		{"auditEvents since", "?since=2022-08-19T09:01:00Z", http.StatusOK, []int64{2}, 0},
+		{"auditEvents until a date", "?until=2022-08-19", http.StatusOK, []int64{}, 0},
+		{"auditEvents bad limit", "?limit=1000", http.StatusBadRequest, nil, 0},
+		{"auditEvents bad cursor", "?cursor=abc", http.StatusBadRequest, nil, 0},
+		{"auditEvents bad since", "?since=yesterday", http.StatusBadRequest, nil, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/audit-events/"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.auditEvents).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		checkHandlerResponse(t, e.name, rr)
+
+		if rr.Code != http.StatusOK {
+			var p problem
+			_ = json.Unmarshal(rr.Body.Bytes(), &p)
+			if p.Code != "invalid_parameter" {
+				t.Errorf("%s: expected invalid_parameter but got %s", e.name, p.Code)
+			}
+			continue
+		}
+
+		var page audit.Page
+		_ = json.Unmarshal(rr.Body.Bytes(), &page)
+		ids := []int64{}
+		for _, ev := range page.Events {
+			ids = append(ids, ev.ID)
+		}
+		if len(ids) != len(e.expectedIDs) {
+			t.Errorf("%s: expected events %v but got %v", e.name, e.expectedIDs, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != e.expectedIDs[i] {
+				t.Errorf("%s: expected events %v but got %v", e.name, e.expectedIDs, ids)
+				break
+			}
+		}
+		if page.NextCursor != e.expectedNextCursor {
+			t.Errorf("%s: expected next cursor %d but got %d", e.name, e.expectedNextCursor, page.NextCursor)
+		}
+	}
+}
+
+// recordingRepo keeps the audit events written by the handlers.
+type recordingRepo struct {
+	dbrepo.TestDBRepo
+	events []data.AuditEvent
+}
+
+func (m *recordingRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	m.events = append(m.events, e)
+	return &e, nil
+}
+
+func Test_app_recordsAuditEvents(t *testing.T) {
+	var tests = []struct {
+		name            string
+		method          string
+		body            string
+		paramID         string
+		handler         http.HandlerFunc
+		expectedAction  string
+		expectedActorID int
+		expectedActor   string
+		expectedTarget  string
+		expectedChanges string
+	}{
+		{"login", "POST", `{"email":"admin@example.com","password":"secret"}`, "", app.authenticate, audit.ActionLogin, 1, "admin@example.com", "1", ""},
+		{"wrong password", "POST", `{"email":"admin@example.com","password":"wrong"}`, "", app.authenticate, audit.ActionLoginFailed, 0, "admin@example.com", "1", ""},
+		{"unknown email", "POST", `{"email":"nobody@example.com","password":"secret"}`, "", app.authenticate, audit.ActionLoginFailed, 0, "nobody@example.com", "", ""},
+		// 長いメールアドレスは文字の途中で切らずに記録する
+		{"long email", "POST", `{"email":"` + strings.Repeat("a", 254) + `日本@example.com","password":"secret"}`, "", app.authenticate, audit.ActionLoginFailed, 0, strings.Repeat("a", 254) + "日", "", ""},
+		{"update", "PATCH", `{"id":1,"first_name":"Ada","last_name":"User","email":"admin@example.com"}`, "1", app.updateUser, audit.ActionUserUpdate, 1, "", "1", `{"first_name":{"before":"Admin","after":"Ada"}}`},
+		{"update of a missing user", "PATCH", `{"id":2,"first_name":"Ada","last_name":"User","email":"ada@example.com"}`, "2", app.updateUser, "", 0, "", "", ""},
+		{"delete", "DELETE", "", "1", app.deleteUser, audit.ActionUserDelete, 1, "", "1", `{"email":{"before":"admin@example.com","after":null},"first_name":{"before":"Admin","after":null},"is_admin":{"before":0,"after":null},"last_name":{"before":"User","after":null}}`},
+		{"create", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, "", app.insertUser, audit.ActionUserCreate, 1, "", "2", `{"email":{"before":null,"after":"jane@example.com"},"first_name":{"before":null,"after":"Jane"},"is_admin":{"before":null,"after":0},"last_name":{"before":null,"after":"Doe"}}`},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &recordingRepo{}
+		app.DB = repo
+
+		req, _ := http.NewRequest(e.method, "/", strings.NewReader(e.body))
+		req.Header.Set("User-Agent", "audit-test")
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		if e.method != "POST" {
+			// authRequiredが設定するトークンのユーザ
+			req = requestlog.SetUserID(req, "1")
+		}
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if e.expectedAction == "" {
+			if len(repo.events) != 0 {
+				t.Errorf("%s: expected no event but got %+v", e.name, repo.events)
+			}
+			continue
+		}
+		if len(repo.events) != 1 {
+			t.Errorf("%s: expected one event but got %d (status %d)", e.name, len(repo.events), rr.Code)
+			continue
+		}
+		ev := repo.events[0]
+		if ev.Action != e.expectedAction || ev.ActorID != e.expectedActorID || ev.Actor != e.expectedActor || ev.TargetID != e.expectedTarget {
+			t.Errorf("%s: unexpected event %+v", e.name, ev)
+		}
+		if string(ev.Changes) != e.expectedChanges {
+			t.Errorf("%s: expected changes %s but got %s", e.name, e.expectedChanges, ev.Changes)
+		}
+		if ev.UserAgent != "audit-test" {
+			t.Errorf("%s: expected the user agent but got %q", e.name, ev.UserAgent)
+		}
+	}
+}
+
+// 権限の変更は管理者だけができ、通常の変更とは別に記録する
+func Test_app_updateUser_role(t *testing.T) {
+	var tests = []struct {
+		name            string
+		principal       *principal
+		body            string
+		expectedStatus  int
+		expectedActions []string
+	}{
+		{"admin grants admin", &principal{UserID: 1, Admin: true}, `{"first_name":"Admin","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusNoContent, []string{audit.ActionUserRole}},
+		{"admin renames and grants admin", &principal{UserID: 1, Admin: true}, `{"first_name":"Ada","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusNoContent, []string{audit.ActionUserUpdate, audit.ActionUserRole}},
+		{"admin key without the admin scope", &principal{UserID: 1, Admin: true, Key: &data.APIKey{ID: 1, UserID: 1, Scopes: []string{"read", "write"}}}, `{"first_name":"Admin","last_name":"User","email":"admin@example.com","is_admin":1}`, http.StatusForbidden, nil},
+		{"owner keeps the role", &principal{UserID: 1}, `{"first_name":"Ada","last_name":"User","email":"admin@example.com","is_admin":0}`, http.StatusNoContent, []string{audit.ActionUserUpdate}},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &recordingRepo{}
+		app.DB = repo
+
+		req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(e.body))
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = withPrincipal(req, e.principal)
+		rr := httptest.NewRecorder()
+
+		app.updateUser(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+		}
+		var actions []string
+		for _, ev := range repo.events {
+			actions = append(actions, ev.Action)
+		}
+		if strings.Join(actions, ",") != strings.Join(e.expectedActions, ",") {
+			t.Errorf("%s: expected events %v but got %v", e.name, e.expectedActions, actions)
+		}
+	}
+}
+
+// 管理者でないユーザは自分を管理者にできず、監査ログにも何も残らない
+func Test_app_routes_nonAdminCannotGrantAdmin(t *testing.T) {
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+
+	var tests = []struct {
+		name          string
+		authorization string
+	}{
+		{"access token", "Bearer " + tokens.Token},
+		{"api key with the write scope", "Bearer " + dbrepo.TestAPIKeyFull},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &recordingRepo{}
+		app.DB = repo
+
+		req := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"id":1,"first_name":"Admin","last_name":"User","email":"admin@example.com","is_admin":1}`))
+		req.Header.Set("Authorization", e.authorization)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status 403 but got %d: %s", e.name, rr.Code, rr.Body.String())
+		}
+		var p problem
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if p.Code != "admin_required" {
+			t.Errorf("%s: expected code admin_required but got %q", e.name, p.Code)
+		}
+		if len(repo.events) != 0 {
+			t.Errorf("%s: expected no audit event but got %+v", e.name, repo.events)
+		}
+	}
+}
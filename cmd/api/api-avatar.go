@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/imaging"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// putAvatar uploads a new profile picture and makes it the current one. The
+// picture is sent either as multipart/form-data or as the raw request body.
+// Ex.)curl http://localhost:8090/users/1/avatar -X PUT -H "Authorization: Bearer ..." -H "Content-Type: image/jpeg" --data-binary @me.jpg
+func (app *application) putAvatar(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	_, content, err := avatar.ReadUpload(w, r, avatar.DefaultMaxSize)
+	if err != nil {
+		app.errorJSON(w, r, err, uploadErrorStatus(err))
+		return
+	}
+
+	// EXIFを取り除き、正方形の各サイズの画像を生成してストレージに保存する
+	i, files, err := app.Avatars.Save(r.Context(), content)
+	if err != nil {
+		app.errorJSON(w, r, err, uploadErrorStatus(err))
+		return
+	}
+	i.UserID = user.ID
+	i.IsCurrent = true
+
+	// DBに登録できなかったファイルは参照されないので、後でGCが削除する
+	err = app.db(r.Context()).InsertStoredFiles(files...)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	i.ID, err = app.db(r.Context()).InsertUserImage(i)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionImageUpload, audit.TargetImage, i.ID)
+	e.Changes = audit.Diff(nil, imageFields(&i))
+	app.recordAudit(r, e)
+
+	app.Metrics.UploadSize.Observe(float64(len(content)), "avatar")
+
+	resp, err := app.imageURLs(r.Context(), i)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// getAvatar returns the current profile picture. Clients that prefer
+// application/json get the URLs of the picture; anything else gets the image
+// itself, in the variant closest to the requested width. The width is taken
+// from the size query parameter, or from the Sec-CH-Width or Width client hint;
+// without one the full picture is returned.
+func (app *application) getAvatar(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Vary", "Accept, Sec-CH-Width, Width")
+	w.Header().Set("Accept-CH", "Sec-CH-Width")
+
+	if user.ProfilePic.FileName == "" {
+		app.errorJSON(w, r, errNoProfilePicture, http.StatusNotFound)
+		return
+	}
+
+	imageType := mime.TypeByExtension(filepath.Ext(user.ProfilePic.FileName))
+	if imageType == "" {
+		imageType = "application/octet-stream"
+	}
+	switch negotiate(r.Header.Get("Accept"), imageType, "application/json") {
+	case "application/json":
+		resp, err := app.imageURLs(r.Context(), user.ProfilePic)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		_ = app.writeJSON(w, http.StatusOK, resp)
+		return
+	case "":
+		app.errorJSON(w, r, errors.New("the picture is only available as "+imageType+" or application/json"), http.StatusNotAcceptable)
+		return
+	}
+
+	size, err := requestedWidth(r)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	rc, info, err := app.Avatars.Open(r.Context(), user.ProfilePic, size)
+	if errors.Is(err, storage.ErrNotFound) {
+		app.errorJSON(w, r, errPictureMissing, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, rc); err != nil {
+		requestlog.FromContext(r.Context()).Warn("sending the picture", "err", err)
+	}
+}
+
+// deleteAvatar deletes the current profile picture. The newest remaining
+// picture, if any, becomes the current one.
+func (app *application) deleteAvatar(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ProfilePic.FileName == "" {
+		app.errorJSON(w, r, errNoProfilePicture, http.StatusNotFound)
+		return
+	}
+
+	err := app.db(r.Context()).DeleteUserImage(user.ProfilePic.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	app.recordImageDelete(r, &user.ProfilePic)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFromURL loads the user named by the userID URL parameter. It writes the
+// error response itself, 404 for a missing user, and returns false when the
+// user cannot be loaded.
+func (app *application) userFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	userID, err := intURLParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.db(r.Context()).GetUser(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, r, errUserNotFound, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// uploadErrorStatus maps the errors of avatar uploads to response status codes.
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, avatar.ErrTooLarge), errors.Is(err, scanner.ErrTooLargeToScan):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, avatar.ErrNotAnImage), errors.Is(err, imaging.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, avatar.ErrNoFile):
+		return http.StatusBadRequest
+	case errors.Is(err, scanner.ErrInfected), errors.Is(err, imaging.ErrTooManyPixels):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, scanner.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requestedWidth returns the width in pixels asked for by the size query
+// parameter or the client hints, or 0 when there is none.
+func requestedWidth(r *http.Request) (int, error) {
+	if size := r.URL.Query().Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			return 0, invalidParameter("size", "must be a positive number of pixels")
+		}
+		return n, nil
+	}
+
+	// クライアントヒントは不正な値でもエラーにせず無視する
+	for _, header := range []string{"Sec-CH-Width", "Width"} {
+		if n, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(header))); err == nil && n > 0 {
+			return n, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// negotiate returns the offer the Accept header prefers, the first offer when
+// the header is empty, or "" when none is acceptable. Each offer takes the
+// quality of the most specific media range matching it; ties go to the
+// earlier offer.
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+
+			s := matchMediaRange(mediaType, offer)
+			if s <= specificity {
+				continue
+			}
+
+			specificity, q = s, 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// matchMediaRange returns how specifically a media range such as "image/*"
+// matches the media type: 2 for an exact match, 1 for a subtype wildcard, 0
+// for */* and -1 when it does not match.
+func matchMediaRange(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
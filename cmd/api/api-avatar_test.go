@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
+	"hash/crc32"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// avatarRequest builds a request for the avatar handlers with the userID URL parameter set.
+func avatarRequest(method, target, userID string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, target, body)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", userID)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+}
+
+// putAvatarFixtures stores the files of the test user's current picture,
+// "current.png" with 64px and 256px variants. Each file contains its own name.
+func putAvatarFixtures(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{"current.png", "current-64.png", "current-256.png"} {
+		err := app.Storage.Put(context.Background(), key, strings.NewReader(key), int64(len(key)), "image/png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = app.Storage.Delete(context.Background(), key) })
+	}
+}
+
+func Test_app_putAvatar(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	part, _ := mw.CreateFormFile("file", "me.png")
+	_, _ = part.Write(img)
+	_ = mw.Close()
+
+	// ヘッダーだけ50000x50000と偽った、展開すると巨大になる画像
+	bomb := append([]byte(nil), img...)
+	binary.BigEndian.PutUint32(bomb[16:], 50000)
+	binary.BigEndian.PutUint32(bomb[20:], 50000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+
+	var tests = []struct {
+		name               string
+		userID             string
+		contentType        string
+		body               []byte
+		expectedStatusCode int
+	}{
+		{"raw body", "1", "image/png", img, http.StatusCreated},
+		{"too many pixels", "1", "image/png", bomb, http.StatusUnprocessableEntity},
+		{"multipart", "1", mw.FormDataContentType(), multipartBody.Bytes(), http.StatusCreated},
+		{"not an image", "1", "text/plain", []byte("hello, world"), http.StatusUnsupportedMediaType},
+		{"empty body", "1", "image/png", nil, http.StatusBadRequest},
+		{"unknown user", "2", "image/png", img, http.StatusNotFound},
+		{"bad URL param", "YYY", "image/png", img, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req := avatarRequest("PUT", "/users/"+e.userID+"/avatar", e.userID, bytes.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.putAvatar).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if rr.Code != http.StatusCreated {
+			continue
+		}
+
+		var resp imageResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsCurrent || resp.URL == "" || resp.Variants["64"] == "" {
+			t.Errorf("%s: unexpected response %+v", e.name, resp)
+		}
+	}
+
+	// アップロードされたファイルを削除する
+	_ = os.RemoveAll(app.Storage.(*storage.Local).Root)
+}
+
+// infectedScanner reports every upload as infected with the EICAR test file.
+type infectedScanner struct{}
+
+func (infectedScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	return scanner.Result{Status: scanner.Infected, Signature: "Eicar-Signature"}, nil
+}
+
+// failingScanner cannot reach its daemon.
+type failingScanner struct{}
+
+func (failingScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	return scanner.Result{}, errors.New("clamd: dial tcp 127.0.0.1:3310: connection refused")
+}
+
+func Test_app_putAvatarInfected(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Avatars.Scanner = infectedScanner{}
+	defer func() { app.Avatars.Scanner = nil }()
+
+	req := avatarRequest("PUT", "/users/1/avatar", "1", bytes.NewReader(img))
+	req.Header.Set("Content-Type", "image/png")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.putAvatar).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "malware") || !strings.Contains(rr.Body.String(), "Eicar-Signature") {
+		t.Errorf("expected a clear error message, got %s", rr.Body.String())
+	}
+}
+
+// 検査できなかった画像は保存せず、後で送り直せるように503を返す
+func Test_app_putAvatarScannerDown(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Avatars.Scanner = failingScanner{}
+	defer func() { app.Avatars.Scanner = nil }()
+
+	req := avatarRequest("PUT", "/users/1/avatar", "1", bytes.NewReader(img))
+	req.Header.Set("Content-Type", "image/png")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.putAvatar).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"code":"scan_unavailable"`) {
+		t.Errorf("expected the code scan_unavailable, got %s", rr.Body.String())
+	}
+}
+
+func Test_app_getAvatar(t *testing.T) {
+	putAvatarFixtures(t)
+
+	var tests = []struct {
+		name               string
+		userID             string
+		target             string
+		headers            map[string]string
+		expectedStatusCode int
+		expectedType       string
+		expectedBody       string
+	}{
+		{"original", "1", "/", nil, http.StatusOK, "image/png", "current.png"},
+		{"size param", "1", "/?size=64", nil, http.StatusOK, "image/png", "current-64.png"},
+		{"size param between variants", "1", "/?size=100", nil, http.StatusOK, "image/png", "current-256.png"},
+		{"size param larger than variants", "1", "/?size=1000", nil, http.StatusOK, "image/png", "current-256.png"},
+		{"client hint", "1", "/", map[string]string{"Sec-CH-Width": "64"}, http.StatusOK, "image/png", "current-64.png"},
+		{"legacy client hint", "1", "/", map[string]string{"Width": "200"}, http.StatusOK, "image/png", "current-256.png"},
+		{"invalid client hint", "1", "/", map[string]string{"Width": "wide"}, http.StatusOK, "image/png", "current.png"},
+		{"bad size param", "1", "/?size=big", nil, http.StatusBadRequest, problemContentType, ""},
+		{"accept image", "1", "/", map[string]string{"Accept": "image/*"}, http.StatusOK, "image/png", "current.png"},
+		{"accept json", "1", "/", map[string]string{"Accept": "application/json"}, http.StatusOK, "application/json", ""},
+		{"prefer json", "1", "/", map[string]string{"Accept": "image/png;q=0.5, application/json"}, http.StatusOK, "application/json", ""},
+		{"not acceptable", "1", "/", map[string]string{"Accept": "text/html"}, http.StatusNotAcceptable, problemContentType, ""},
+		{"unknown user", "2", "/", nil, http.StatusNotFound, problemContentType, ""},
+		{"bad URL param", "YYY", "/", nil, http.StatusBadRequest, problemContentType, ""},
+	}
+
+	for _, e := range tests {
+		req := avatarRequest("GET", e.target, e.userID, nil)
+		for k, v := range e.headers {
+			req.Header.Set(k, v)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.getAvatar).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != e.expectedType {
+			t.Errorf("%s: expected content type %s, but got %s", e.name, e.expectedType, ct)
+		}
+		if e.expectedBody != "" && rr.Body.String() != e.expectedBody {
+			t.Errorf("%s: expected %s, but got %s", e.name, e.expectedBody, rr.Body.String())
+		}
+	}
+}
+
+func Test_app_getAvatar_vary(t *testing.T) {
+	putAvatarFixtures(t)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.getAvatar).ServeHTTP(rr, avatarRequest("GET", "/", "1", nil))
+
+	if !strings.Contains(rr.Header().Get("Vary"), "Accept") || !strings.Contains(rr.Header().Get("Vary"), "Sec-CH-Width") {
+		t.Errorf("expected the response to vary on Accept and Sec-CH-Width, got %q", rr.Header().Get("Vary"))
+	}
+	if rr.Header().Get("Accept-CH") == "" {
+		t.Error("expected an Accept-CH header")
+	}
+}
+
+func Test_app_deleteAvatar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             string
+		expectedStatusCode int
+	}{
+		{"current picture", "1", http.StatusNoContent},
+		{"unknown user", "2", http.StatusNotFound},
+		{"bad URL param", "YYY", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.deleteAvatar).ServeHTTP(rr, avatarRequest("DELETE", "/", e.userID, nil))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_negotiate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{"no header", "", "image/png"},
+		{"anything", "*/*", "image/png"},
+		{"exact image", "image/png", "image/png"},
+		{"image wildcard", "image/*", "image/png"},
+		{"json", "application/json", "application/json"},
+		{"browser", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/png"},
+		{"json preferred by quality", "image/png;q=0.1, application/json;q=0.9", "application/json"},
+		{"specific range wins", "image/*;q=0.2, image/png;q=0, */*", "application/json"},
+		{"tie goes to the first offer", "application/json, image/png", "image/png"},
+		{"nothing acceptable", "text/html", ""},
+		{"refused", "*/*;q=0", ""},
+	}
+
+	for _, e := range tests {
+		got := negotiate(e.accept, "image/png", "application/json")
+		if got != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+	}
+}
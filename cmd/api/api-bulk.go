@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/userio"
+	"go_test_prac/webApp/pkg/validation"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxImportSize limits the body of an import.
+const maxImportSize = 10 << 20
+
+// maxImportRows limits the rows of an import, whose passwords are each hashed
+// with bcrypt. Larger imports are split by the client or run with the CLI.
+const maxImportRows = 10000
+
+// importUsers creates and updates users from a CSV or JSON Lines body, matched
+// by email. Invalid rows are answered with 422, more than maxImportRows rows
+// with 413, and nothing is written then. Writing stops when the client goes
+// away.
+// Ex.)curl "http://localhost:8090/users/import?dry_run=true" -X POST -H "Authorization: Bearer ..." -H "Content-Type: text/csv" --data-binary @users.csv
+func (app *application) importUsers(w http.ResponseWriter, r *http.Request) {
+	format, err := importFormat(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errImportType) {
+			status = http.StatusUnsupportedMediaType
+		}
+		app.errorJSON(w, r, err, status)
+		return
+	}
+
+	opts, err := importOptions(r)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	opts.Catalog = validation.CatalogFor(r.Header.Get("Accept-Language"))
+	opts.MaxRows = maxImportRows
+
+	// パスワードのハッシュに時間がかかるので、このレスポンスでは書き込みのタイムアウトを外す
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	report, err := userio.Import(r.Context(), app.db(r.Context()), body, format, opts)
+	// 途中で失敗しても、書き込んだバッチの分は記録する
+	if report != nil && report.Created+report.Updated > 0 {
+		e := app.auditEvent(r, audit.ActionUsersImport, audit.TargetUsers, 0)
+		e.Changes = audit.Diff(nil, map[string]interface{}{"created": report.Created, "updated": report.Updated})
+		app.recordAudit(r, e)
+	}
+
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		app.errorJSON(w, r, err, http.StatusRequestEntityTooLarge)
+	case errors.Is(err, userio.ErrTooManyRows):
+		app.errorJSON(w, r, newAPIError("too_many_rows", err.Error()), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, userio.ErrInvalidRows):
+		app.errorJSON(w, r, &apiError{
+			Code:    "invalid_rows",
+			Message: strconv.Itoa(len(report.Errors)) + " of " + strconv.Itoa(report.Rows) + " rows are invalid, nothing was written",
+			Rows:    report.Errors,
+		}, http.StatusUnprocessableEntity)
+	case err != nil && report == nil:
+		// 読めない入力（ヘッダの不足など）
+		app.errorJSON(w, r, newAPIError("invalid_import", err.Error()), http.StatusBadRequest)
+	case err != nil:
+		// バッチごとにコミットしていれば一部は書き込まれている。メールアドレスで
+		// 照合するので、同じ入力をもう一度取り込めばよい
+		requestlog.FromContext(r.Context()).Error("import failed", "created", report.Created, "updated", report.Updated, "err", err)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+	default:
+		_ = app.writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// importFormat returns the format of an import, from the format parameter or
+// else from the Content-Type.
+func importFormat(r *http.Request) (string, error) {
+	if s := r.URL.Query().Get("format"); s != "" {
+		format, err := userio.ParseFormat(s)
+		if err != nil {
+			return "", invalidParameter("format", "must be csv or jsonl")
+		}
+		return format, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return userio.FormatCSV, nil
+	case "application/x-ndjson", "application/jsonl":
+		return userio.FormatJSONL, nil
+	}
+	return "", errImportType
+}
+
+// importOptions reads the dry_run, batch_size and map parameters.
+func importOptions(r *http.Request) (userio.Options, error) {
+	var opts userio.Options
+	q := r.URL.Query()
+
+	if s := q.Get("dry_run"); s != "" {
+		dryRun, err := strconv.ParseBool(s)
+		if err != nil {
+			return opts, invalidParameter("dry_run", "must be true or false")
+		}
+		opts.DryRun = dryRun
+	}
+	if s := q.Get("batch_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return opts, invalidParameter("batch_size", "must be a non-negative integer")
+		}
+		opts.BatchSize = n
+	}
+	mapping, err := userio.ParseMapping(q.Get("map"))
+	if err != nil {
+		return opts, invalidParameter("map", err.Error())
+	}
+	opts.Mapping = mapping
+	return opts, nil
+}
+
+// exportUsers streams every user as CSV or, with format=jsonl, JSON Lines.
+// Ex.)curl "http://localhost:8090/users/export?format=csv" -H "Authorization: Bearer ..." -o users.csv
+func (app *application) exportUsers(w http.ResponseWriter, r *http.Request) {
+	format := userio.FormatCSV
+	if s := r.URL.Query().Get("format"); s != "" {
+		var err error
+		if format, err = userio.ParseFormat(s); err != nil {
+			app.errorJSON(w, r, invalidParameter("format", "must be csv or jsonl"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 件数が多いと書き込みのタイムアウトを超えるので、このレスポンスでは外す
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", userio.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+
+	out := &startedWriter{ResponseWriter: w}
+	err := userio.Export(r.Context(), app.db(r.Context()), out, format)
+	if err == nil || out.started {
+		e := app.auditEvent(r, audit.ActionUsersExport, audit.TargetUsers, 0)
+		e.Changes = audit.Diff(nil, map[string]interface{}{"format": format})
+		app.recordAudit(r, e)
+	}
+	switch {
+	case err == nil:
+	case !out.started:
+		w.Header().Del("Content-Disposition")
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+	default:
+		// ステータスは送ってしまったので、途中で切れたことはログにだけ残す
+		requestlog.FromContext(r.Context()).Error("export failed", "err", err)
+	}
+}
+
+// startedWriter tells whether anything was written to the response, after
+// which an error can no longer be answered.
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
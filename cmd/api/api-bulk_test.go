@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/userio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const importCSV = "email,first_name,last_name,is_admin\nadmin@example.com,Admin,User,1\njane@example.com,Jane,Doe,0\n"
+
+func Test_app_importUsers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		contentType        string
+		acceptLanguage     string
+		body               string
+		expectedStatusCode int
+		expectedCode       string
+		expectedCreated    int
+	}{
+		{"importUsers csv", "", "text/csv; charset=utf-8", "", importCSV, http.StatusOK, "", 1},
+		{"importUsers dry run", "?dry_run=true", "text/csv", "", importCSV, http.StatusOK, "", 0},
+		{"importUsers in batches", "?batch_size=1", "text/csv", "", importCSV, http.StatusOK, "", 1},
+		{"importUsers jsonl", "", "application/x-ndjson", "", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe"}`, http.StatusOK, "", 1},
+		{"importUsers format parameter", "?format=jsonl", "application/octet-stream", "", `{"email":"jane@example.com","first_name":"Jane","last_name":"Doe"}`, http.StatusOK, "", 1},
+		{"importUsers mapping", "?map=Mail%3Demail", "text/csv", "", "Mail,first_name,last_name\njane@example.com,Jane,Doe\n", http.StatusOK, "", 1},
+		{"importUsers invalid rows", "", "text/csv", "", importCSV + "bad,,Doe,\n", http.StatusUnprocessableEntity, "invalid_rows", 0},
+		{"importUsers invalid rows in japanese", "", "text/csv", "ja", importCSV + "bad,,Doe,\n", http.StatusUnprocessableEntity, "invalid_rows", 0},
+		{"importUsers missing column", "", "text/csv", "", "email,first_name\njane@example.com,Jane\n", http.StatusBadRequest, "invalid_import", 0},
+		{"importUsers unknown content type", "", "application/json", "", importCSV, http.StatusUnsupportedMediaType, "unsupported_media_type", 0},
+		{"importUsers bad format", "?format=xlsx", "text/csv", "", importCSV, http.StatusBadRequest, "invalid_parameter", 0},
+		{"importUsers bad batch size", "?batch_size=-1", "text/csv", "", importCSV, http.StatusBadRequest, "invalid_parameter", 0},
+		{"importUsers bad mapping", "?map=Mail%3Drole", "text/csv", "", importCSV, http.StatusBadRequest, "invalid_parameter", 0},
+		{"importUsers too large", "", "text/csv", "", importCSV + strings.Repeat("x", maxImportSize), http.StatusRequestEntityTooLarge, "", 0},
+		{"importUsers too many rows", "", "text/csv", "", "email,first_name,last_name\n" + strings.Repeat("jane@example.com,Jane,Doe\n", maxImportRows+1), http.StatusRequestEntityTooLarge, "too_many_rows", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/users/import"+e.query, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+		if e.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", e.acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.importUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		checkHandlerResponse(t, e.name, rr)
+
+		if e.expectedCode != "" {
+			var p problem
+			_ = json.Unmarshal(rr.Body.Bytes(), &p)
+			if p.Code != e.expectedCode {
+				t.Errorf("%s: expected code %s but got %s", e.name, e.expectedCode, p.Code)
+			}
+			if e.expectedCode == "invalid_rows" && (len(p.Rows) != 1 || p.Rows[0].Line != 4 || p.Rows[0].Errors["first_name"] == "") {
+				t.Errorf("%s: expected line 4 to be rejected but got %+v", e.name, p.Rows)
+			}
+			if e.acceptLanguage == "ja" && len(p.Rows) > 0 && !strings.Contains(p.Rows[0].Errors["email"], "メール") {
+				t.Errorf("%s: expected the messages in Japanese but got %v", e.name, p.Rows[0].Errors)
+			}
+			continue
+		}
+
+		if rr.Code == http.StatusOK {
+			var report userio.Report
+			_ = json.Unmarshal(rr.Body.Bytes(), &report)
+			if report.Created != e.expectedCreated || report.DryRun != strings.Contains(e.query, "dry_run") {
+				t.Errorf("%s: unexpected report %+v", e.name, report)
+			}
+		}
+	}
+}
+
+// failingExportRepo fails the export before writing anything.
+type failingExportRepo struct {
+	dbrepo.TestDBRepo
+}
+
+func (m *failingExportRepo) ExportUsers(ctx context.Context, w io.Writer) error {
+	return errors.New("connection refused")
+}
+
+func Test_app_exportUsers(t *testing.T) {
+	var tests = []struct {
+		name                string
+		query               string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedPrefix      string
+	}{
+		{"exportUsers csv by default", "", http.StatusOK, "text/csv; charset=utf-8", "id,email,first_name,last_name,is_admin,"},
+		{"exportUsers csv", "?format=csv", http.StatusOK, "text/csv; charset=utf-8", "id,email,first_name,last_name,is_admin,"},
+		{"exportUsers jsonl", "?format=jsonl", http.StatusOK, "application/x-ndjson", `{"id":1,"email":"admin@example.com"`},
+		{"exportUsers bad format", "?format=xml", http.StatusBadRequest, problemContentType, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/export"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.exportUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+		checkHandlerResponse(t, e.name, rr)
+
+		if got := rr.Header().Get("Content-Type"); got != e.expectedContentType {
+			t.Errorf("%s: expected Content-Type %s but got %s", e.name, e.expectedContentType, got)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		if !strings.HasPrefix(rr.Body.String(), e.expectedPrefix) {
+			t.Errorf("%s: expected the users but got %s", e.name, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment;") {
+			t.Errorf("%s: expected an attachment but got %q", e.name, got)
+		}
+	}
+}
+
+func Test_app_exportUsers_failed(t *testing.T) {
+	saved := app.DB
+	app.DB = &failingExportRepo{}
+	defer func() { app.DB = saved }()
+
+	req, _ := http.NewRequest("GET", "/users/export", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.exportUsers).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Disposition") != "" {
+		t.Errorf("expected a problem instead of an attachment but got %d %v", rr.Code, rr.Header())
+	}
+	checkHandlerResponse(t, "exportUsers failed", rr)
+}
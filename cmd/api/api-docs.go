@@ -0,0 +1,38 @@
+package main
+
+import (
+	_ "embed"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http"
+)
+
+// openAPISpec describes every route of routes(). Test_app_routes_openAPI
+// fails when they drift apart, so update both together.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsPage renders openAPISpec in the browser.
+//
+//go:embed docs.html
+var docsPage []byte
+
+// openAPI serves the OpenAPI 3.1 document of the API.
+// Ex.)curl http://localhost:8090/openapi.json
+func (app *application) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPISpec); err != nil {
+		requestlog.FromContext(r.Context()).Warn("sending the page", "err", err)
+	}
+}
+
+// docs serves a page listing the operations of /openapi.json, in the manner
+// of Swagger UI but without loading anything from other sites.
+func (app *application) docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(docsPage); err != nil {
+		requestlog.FromContext(r.Context()).Warn("sending the page", "err", err)
+	}
+}
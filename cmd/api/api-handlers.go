@@ -1,14 +1,14 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"go_test_prac/webApp/pkg/audit"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/tracing"
+	"go_test_prac/webApp/pkg/validation"
 	"net/http"
 	"strconv"
 	"time"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,33 +18,43 @@ type Credentials struct {
 }
 
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
+	ok := false
 	var creds Credentials
+	var user *data.User
+	defer func() {
+		app.Metrics.Logins.Inc(outcome(ok))
+		app.recordLogin(r, ok, creds.UserName, user)
+	}()
 
 	// read a json payload
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
 	// look up the user in the database based on the email address
-	user, err := app.DB.GetUserByEmail(creds.UserName)
+	user, err = app.db(r.Context()).GetUserByEmail(creds.UserName)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		user = nil
+		app.errorJSON(w, r, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
 	// check if the password matches
+	// bcryptは意図的に遅いので、どれだけ時間を使ったか見えるようにする
+	_, span := tracing.Start(r.Context(), "bcrypt.CompareHashAndPassword")
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
+	span.End()
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
 	// generate a JWT token
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
@@ -59,56 +69,87 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 		Domain:   "localhost",
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   app.SecureCookies,
 	})
 
+	ok = true
+	app.Metrics.Tokens.Inc("password")
+
 	// send the token back to the client
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 
 }
 
+// recordLogin records a login with a password in the audit log. email is the
+// address given, and user the user with it, if any.
+func (app *application) recordLogin(r *http.Request, ok bool, email string, user *data.User) {
+	action := audit.ActionLoginFailed
+	if ok {
+		action = audit.ActionLogin
+	}
+	e := app.auditEvent(r, action, audit.TargetUser, 0)
+	e.Actor = validation.Truncate(email, 255)
+	if user != nil {
+		e.TargetID = strconv.Itoa(user.ID)
+		if ok {
+			e.ActorID = user.ID
+		}
+	}
+	app.recordAudit(r, e)
+}
+
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
+	ok := false
+	defer func() { app.Metrics.Refreshes.Inc(outcome(ok)) }()
+
 	err := r.ParseForm()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	refreshToken := r.Form.Get("refresh_token")
-	claims := &Claims{}
-
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.JWTSecret), nil
-	})
 
+	claims, err := app.tokens().VerifyRefresh(refreshToken)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, invalidToken(err), http.StatusBadRequest)
 		return
 	}
 
 	// refresh tokenの有効期限が30秒以上残っているか確認
 	// 30秒以上残っている場合は、新しいrefresh tokenを発行しない
 	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+		app.errorJSON(w, r, errTokenNotExpiring, http.StatusTooEarly)
 		return
 	}
 
 	// get the user id from the claims
 	userID, err := strconv.Atoi(claims.Subject)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.db(r.Context()).GetUser(userID)
 	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+		app.errorJSON(w, r, errUnknownUser, http.StatusBadRequest)
+		return
+	}
+
+	// CLIでセッションが失効させられた後のトークンは更新しない
+	revoked, err := app.sessionRevoked(r.Context(), userID, claims)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		app.errorJSON(w, r, errSessionRevoked, http.StatusBadRequest)
 		return
 	}
 
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -123,50 +164,62 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 		Domain:   "localhost",
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   app.SecureCookies,
 	})
 
+	ok = true
+	app.Metrics.Tokens.Inc("refresh_token")
+
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
 func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Request) {
+	ok := false
+	defer func() { app.Metrics.Refreshes.Inc(outcome(ok)) }()
+
 	for _, cookie := range r.Cookies() {
 		if cookie.Name == "Host-refresh_token" {
-			claims := &Claims{}
 			refreshToken := cookie.Value
 
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
-
+			claims, err := app.tokens().VerifyRefresh(refreshToken)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, invalidToken(err), http.StatusBadRequest)
 				return
 			}
 
 			// refresh tokenの有効期限が30秒以上残っているか確認
 			// 30秒以上残っている場合は、新しいrefresh tokenを発行しない
 			// if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30 * time.Second {
-			// 	app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+			// 	app.errorJSON(w, r, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
 			// 	return
 			// }
 
 			// get the user id from the claims
 			userID, err := strconv.Atoi(claims.Subject)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
+				return
+			}
+
+			user, err := app.db(r.Context()).GetUser(userID)
+			if err != nil {
+				app.errorJSON(w, r, errUnknownUser, http.StatusBadRequest)
 				return
 			}
 
-			user, err := app.DB.GetUser(userID)
+			revoked, err := app.sessionRevoked(r.Context(), userID, claims)
 			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				app.errorJSON(w, r, errSessionRevoked, http.StatusBadRequest)
 				return
 			}
 
 			tokenPairs, err := app.generateTokenPair(user)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
 				return
 			}
 
@@ -181,74 +234,164 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 				SameSite: http.SameSiteStrictMode,
 				Domain:   "localhost",
 				HttpOnly: true,
-				Secure:   true,
+				Secure:   app.SecureCookies,
 			})
 
+			ok = true
+			app.Metrics.Tokens.Inc("cookie")
+
 			// send back JSON
 			_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 			return
 		}
 	}
 
-	app.errorJSON(w, errors.New("no refresh token found in cookie"), http.StatusUnauthorized)
+	app.errorJSON(w, r, errNoRefreshCookie, http.StatusUnauthorized)
 }
 
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	users, err := app.db(r.Context()).AllUsers()
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
+	// ユーザがいなくてもnullではなく空の配列を返す
+	if users == nil {
+		users = []*data.User{}
+	}
+
 	_ = app.writeJSON(w, http.StatusOK, users)
 }
 
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+	user, ok := app.userFromURL(w, r)
+	if !ok {
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	var err error
+	resp := userResponse{User: user}
+	if user.ProfilePic.FileName != "" {
+		resp.ProfilePic, err = app.imageURLs(r.Context(), user.ProfilePic)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
+
+// userResponse adds the URLs of the profile picture to a user.
+type userResponse struct {
+	*data.User
+	ProfilePic *imageResponse `json:"profile_pic,omitempty"`
+}
+
+// imageResponse holds the URLs of an image, generated by the storage backend.
+// Variants are keyed by their edge length in pixels.
+type imageResponse struct {
+	ID        int               `json:"id"`
+	IsCurrent bool              `json:"is_current"`
+	URL       string            `json:"url"`
+	Variants  map[string]string `json:"variants,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (app *application) imageURLs(ctx context.Context, i data.UserImage) (*imageResponse, error) {
+	u, err := app.Storage.URL(ctx, i.FileName, app.URLExpiry)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, user)
+	resp := imageResponse{
+		ID:        i.ID,
+		IsCurrent: i.IsCurrent,
+		URL:       u,
+		Variants:  make(map[string]string),
+		CreatedAt: i.CreatedAt,
+	}
+	for _, size := range i.Sizes {
+		resp.Variants[strconv.Itoa(size)], err = app.Storage.URL(ctx, i.VariantFileName(size), app.URLExpiry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resp, nil
 }
 
+// updateUser changes the user of the URL. Only administrators may change
+// is_admin; the change is recorded as its own audit event.
 func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
+	// 権限の確認と監査ログの差分のために、変更前のユーザを読んでおく
+	before, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	userID := before.ID
+
 	var user data.User
 	err := app.readJSON(w, r, &user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// 本文のidは省略できるが、URLと違うユーザは更新しない
+	if user.ID != 0 && user.ID != userID {
+		app.errorJSON(w, r, errUserIDMismatch, http.StatusBadRequest)
 		return
 	}
+	user.ID = userID
 
-	err = app.DB.UpdateUser(user)
+	if errs := user.Validate(); len(errs) > 0 {
+		app.failedValidation(w, r, errs)
+		return
+	}
+
+	if user.IsAdmin != before.IsAdmin && !app.requireAdmin(w, r, principalFrom(r.Context())) {
+		return
+	}
+
+	err = app.db(r.Context()).UpdateUser(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
+	profile, role := audit.UserUpdate(before, &user)
+	if profile != nil || role == nil {
+		e := app.auditEvent(r, audit.ActionUserUpdate, audit.TargetUser, user.ID)
+		e.Changes = profile
+		app.recordAudit(r, e)
+	}
+	if role != nil {
+		e := app.auditEvent(r, audit.ActionUserRole, audit.TargetUser, user.ID)
+		e.Changes = role
+		app.recordAudit(r, e)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+	before, ok := app.userFromURL(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.DB.DeleteUser(userID)
+	err := app.db(r.Context()).DeleteUser(before.ID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
+	e := app.auditEvent(r, audit.ActionUserDelete, audit.TargetUser, before.ID)
+	e.Changes = audit.Diff(audit.UserFields(before), nil)
+	app.recordAudit(r, e)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -256,19 +399,135 @@ func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
 	var user data.User
 	err := app.readJSON(w, r, &user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if errs := user.Validate(); len(errs) > 0 {
+		app.failedValidation(w, r, errs)
+		return
+	}
+
+	user.ID, err = app.db(r.Context()).InsertUser(user)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionUserCreate, audit.TargetUser, user.ID)
+	e.Changes = audit.Diff(nil, audit.UserFields(&user))
+	app.recordAudit(r, e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allUserImages lists every profile picture of a user, newest first.
+func (app *application) allUserImages(w http.ResponseWriter, r *http.Request) {
+	userID, err := intURLParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	images, err := app.db(r.Context()).AllUserImages(userID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]*imageResponse, 0, len(images))
+	for _, i := range images {
+		ir, err := app.imageURLs(r.Context(), *i)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		resp = append(resp, ir)
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
+
+// setCurrentUserImage makes one of the user's images the current profile picture.
+func (app *application) setCurrentUserImage(w http.ResponseWriter, r *http.Request) {
+	i, ok := app.userImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.db(r.Context()).SetCurrentUserImage(i.UserID, i.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	_, err = app.DB.InsertUser(user)
+	e := app.auditEvent(r, audit.ActionImageCurrent, audit.TargetImage, i.ID)
+	e.Changes = audit.Diff(map[string]interface{}{"is_current": i.IsCurrent}, map[string]interface{}{"is_current": true})
+	app.recordAudit(r, e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUserImage deletes one of the user's images. Files no other image refers
+// to are removed later by the periodic garbage collection.
+func (app *application) deleteUserImage(w http.ResponseWriter, r *http.Request) {
+	i, ok := app.userImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.db(r.Context()).DeleteUserImage(i.ID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusInternalServerError)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	app.recordImageDelete(r, i)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// recordImageDelete records the deletion of a profile picture.
+func (app *application) recordImageDelete(r *http.Request, i *data.UserImage) {
+	e := app.auditEvent(r, audit.ActionImageDelete, audit.TargetImage, i.ID)
+	e.Changes = audit.Diff(imageFields(i), nil)
+	app.recordAudit(r, e)
+}
+
+// imageFields returns the fields of a profile picture recorded in the audit
+// log.
+func imageFields(i *data.UserImage) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":    i.UserID,
+		"file_name":  i.FileName,
+		"is_current": i.IsCurrent,
+	}
+}
+
+// userImageFromURL loads the image named by the imageID URL parameter and
+// makes sure it belongs to the user named by userID. It writes the error
+// response itself and returns false when the image cannot be used.
+func (app *application) userImageFromURL(w http.ResponseWriter, r *http.Request) (*data.UserImage, bool) {
+	userID, err := intURLParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	imageID, err := intURLParam(r, "imageID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	i, err := app.db(r.Context()).GetUserImage(imageID)
+	if err != nil || i.UserID != userID {
+		app.errorJSON(w, r, errImageNotFound, http.StatusNotFound)
+		return nil, false
+	}
+
+	return i, true
+}
+
 func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Request) {
 	deleteCookie := http.Cookie{
 		Name:     "Host-refresh_token",
@@ -279,7 +538,7 @@ func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Reque
 		SameSite: http.SameSiteStrictMode,
 		Domain:   "localhost",
 		HttpOnly: true,
-		Secure:   true,
+		Secure:   app.SecureCookies,
 	}
 
 	http.SetCookie(w, &deleteCookie)
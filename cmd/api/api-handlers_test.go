@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"go_test_prac/webApp/pkg/data"
 	"io"
 	"net/http"
@@ -39,6 +40,7 @@ func Test_app_authenticate(t *testing.T) {
 		if e.expectedStatusCode != rr.Code {
 			t.Errorf("test %s, expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
+		checkResponse(t, e.name, "POST", "/auth", rr)
 	}
 }
 
@@ -89,6 +91,7 @@ func Test_app_refresh(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
+		checkResponse(t, e.name, "POST", "/refresh-token", rr)
 
 		refreshTokenExpiry = oldRefreshTime
 	}
@@ -105,15 +108,16 @@ func Test_app_userHandlers(t *testing.T) {
 	}{
 		{name:"allUsers", method:"GET", json:"", paramID:"", handler:app.allUsers, expectedStatusCode:http.StatusOK},
 		{name:"deleteUser", method:"DELETE", json:"", paramID:"1", handler:app.deleteUser, expectedStatusCode:http.StatusNoContent},
+		{name:"deleteUser missing", method:"DELETE", json:"", paramID:"2", handler:app.deleteUser, expectedStatusCode:http.StatusNotFound},
 		{name:"deleteUser bad URL param", method:"DELETE", json:"", paramID:"YYY", handler:app.deleteUser, expectedStatusCode:http.StatusBadRequest},
 		{name:"getUser valid"	, method:"GET", json:"", paramID:"1", handler:app.getUser, expectedStatusCode:http.StatusOK},
-		{name:"getUser invalid", method:"GET", json:"", paramID:"2", handler:app.getUser, expectedStatusCode:http.StatusInternalServerError},
+		{name:"getUser invalid", method:"GET", json:"", paramID:"2", handler:app.getUser, expectedStatusCode:http.StatusNotFound},
 		{name:"getUser bad URL param", method:"GET", json:"", paramID:"YYY", handler:app.getUser, expectedStatusCode:http.StatusBadRequest},
 		{
 			name:"updateUser valid",
 			method:"PATCH",
 			json:`{"id":1,"first_name":"admin","last_name":"user","email":"admin@example.com"}`,
-			paramID:"",
+			paramID:"1",
 			handler: app.updateUser,
 			expectedStatusCode: http.StatusNoContent,
 		},
@@ -121,15 +125,39 @@ func Test_app_userHandlers(t *testing.T) {
 			name:"updateUser invalid",
 			method:"PATCH",
 			json:`{"id":2,"first_name":"admin","last_name":"user","email":"admin@example.com"}`,
-			paramID:"",
+			paramID:"2",
 			handler: app.updateUser,
-			expectedStatusCode: http.StatusInternalServerError,
+			expectedStatusCode: http.StatusNotFound,
 		},
 		{
 			name:"updateUser invalid json",
 			method:"PATCH",
 			json:`{"id":1,first_name:"admin","last_name":"user","email":"admin@example.com"}`,
-			paramID:"",
+			paramID:"1",
+			handler: app.updateUser,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:"updateUser without id",
+			method:"PATCH",
+			json:`{"first_name":"admin","last_name":"user","email":"admin@example.com"}`,
+			paramID:"1",
+			handler: app.updateUser,
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:"updateUser of another id",
+			method:"PATCH",
+			json:`{"id":2,"first_name":"admin","last_name":"user","email":"admin@example.com"}`,
+			paramID:"1",
+			handler: app.updateUser,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:"updateUser bad URL param",
+			method:"PATCH",
+			json:`{"id":1,"first_name":"admin","last_name":"user","email":"admin@example.com"}`,
+			paramID:"YYY",
 			handler: app.updateUser,
 			expectedStatusCode: http.StatusBadRequest,
 		},
@@ -149,6 +177,22 @@ func Test_app_userHandlers(t *testing.T) {
 			handler: app.insertUser,
 			expectedStatusCode: http.StatusBadRequest,
 		},
+		{
+			name:"insertUser invalid email",
+			method:"PUT",
+			json:`{"first_name":"jack","last_name":"test","email":"jack.example.com"}`,
+			paramID:"",
+			handler: app.insertUser,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:"updateUser blank name",
+			method:"PATCH",
+			json:`{"id":1,"first_name":" ","last_name":"user","email":"admin@example.com"}`,
+			paramID:"1",
+			handler: app.updateUser,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
 		{
 			name:"insertUser invalid json",
 			method:"PUT",
@@ -180,6 +224,25 @@ func Test_app_userHandlers(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
+		checkHandlerResponse(t, e.name, rr)
+	}
+}
+
+// いないユーザは404とuser_not_foundになる
+func Test_app_userNotFound(t *testing.T) {
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1})
+
+	for _, method := range []string{"GET", "PATCH", "DELETE"} {
+		req := httptest.NewRequest(method, "/users/2", strings.NewReader(`{"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`))
+		req.Header.Set("Authorization", "Bearer "+admin.Token)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		var p problem
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if rr.Code != http.StatusNotFound || p.Code != "user_not_found" {
+			t.Errorf("%s: expected 404 user_not_found but got %d %q", method, rr.Code, p.Code)
+		}
 	}
 }
 
@@ -239,6 +302,7 @@ func Test_app_refreshUsingCookie(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
+		checkResponse(t, e.name, "GET", "/web/refresh-token", rr)
 	}
 }
 
@@ -251,6 +315,7 @@ func Test_app_deleteRefreshCookie(t *testing.T) {
 	if rr.Code != http.StatusAccepted {
 		t.Errorf("expected status %d, but got %d", http.StatusOK, rr.Code)
 	}
+	checkResponse(t, "deleteRefreshCookie", "GET", "/web/logout", rr)
 
 	foundCookie := false
 	for _, c := range rr.Result().Cookies() {
@@ -265,3 +330,103 @@ func Test_app_deleteRefreshCookie(t *testing.T) {
 		t.Errorf("Host-refresh_token cookie not found")
 	}
 }
+
+func Test_app_refreshCookieSecure(t *testing.T) {
+	saved := app.SecureCookies
+	defer func() { app.SecureCookies = saved }()
+
+	// HTTPSで配信している場合だけSecureを付ける
+	for _, secure := range []bool{true, false} {
+		app.SecureCookies = secure
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.deleteRefreshCookie).ServeHTTP(rr, httptest.NewRequest("GET", "/logout", nil))
+
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "Host-refresh_token" && c.Secure != secure {
+				t.Errorf("expected Secure to be %t but got %t", secure, c.Secure)
+			}
+		}
+	}
+}
+
+func Test_app_imageURLs(t *testing.T) {
+	img := data.UserImage{FileName: "me.jpg", Sizes: []int{64, 256}}
+
+	resp, err := app.imageURLs(context.Background(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(resp.URL, "http://localhost:8080/static/img/me.jpg?expires=") {
+		t.Errorf("unexpected url %s", resp.URL)
+	}
+	if len(resp.Variants) != 2 {
+		t.Fatalf("expected 2 variants but got %d", len(resp.Variants))
+	}
+	if !strings.HasPrefix(resp.Variants["256"], "http://localhost:8080/static/img/me-256.jpg?expires=") {
+		t.Errorf("unexpected variant url %s", resp.Variants["256"])
+	}
+}
+
+func Test_app_userImageHandlers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             string
+		imageID            string
+		handler            http.HandlerFunc
+		expectedStatusCode int
+	}{
+		{"allUserImages", "1", "", app.allUserImages, http.StatusOK},
+		{"allUserImages bad URL param", "YYY", "", app.allUserImages, http.StatusBadRequest},
+		{"setCurrentUserImage", "1", "2", app.setCurrentUserImage, http.StatusNoContent},
+		{"setCurrentUserImage of another user", "1", "3", app.setCurrentUserImage, http.StatusNotFound},
+		{"setCurrentUserImage missing image", "1", "99", app.setCurrentUserImage, http.StatusNotFound},
+		{"setCurrentUserImage bad image id", "1", "YYY", app.setCurrentUserImage, http.StatusBadRequest},
+		{"deleteUserImage", "1", "2", app.deleteUserImage, http.StatusNoContent},
+		{"deleteUserImage of another user", "2", "1", app.deleteUserImage, http.StatusNotFound},
+		{"deleteUserImage bad user id", "YYY", "1", app.deleteUserImage, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.userID)
+		if e.imageID != "" {
+			chiCtx.URLParams.Add("imageID", e.imageID)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		checkHandlerResponse(t, e.name, rr)
+	}
+}
+
+func Test_app_allUserImages_body(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/users/1/images", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.allUserImages).ServeHTTP(rr, req)
+	checkResponse(t, "allUserImages", "GET", "/users/{userID}/images", rr)
+
+	var images []imageResponse
+	if err := json.NewDecoder(rr.Body).Decode(&images); err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected 2 images but got %d", len(images))
+	}
+	if images[0].ID != 1 || !images[0].IsCurrent || images[1].IsCurrent {
+		t.Errorf("unexpected images %+v", images)
+	}
+	if images[0].Variants["256"] == "" {
+		t.Errorf("expected a url for the 256px variant, got %+v", images[0].Variants)
+	}
+}
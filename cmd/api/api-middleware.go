@@ -1,33 +1,157 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+)
 
+// enableCORS applies the CORS policy of the configuration. Preflight requests
+// are answered here; plain OPTIONS requests, such as those asking for the tus
+// extensions, reach the handlers.
 func (app *application) enableCORS(next http.Handler) http.Handler {
+	return app.CORS.Handler(next)
+}
+
+// authRequired lets through requests with a valid access token or API key,
+// and stores who made them for the handlers, see principalFrom. An API key
+// may only make requests its scopes allow.
+func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// CORS（Cross-Origin Resource Sharing）を有効化
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8090")
-		// プリフライトリクエストに対するレスポンス
-		//(HTTPヘッダにAccept, Accept-Language, Content-Language, Content-Type以外のフィールド→Authorization)があるので、全てプリフライトリクエストがくる
-		if r.Method == http.MethodOptions {
-			//Access-Control-Allow-Credentialsは、bearerの認証情報を使用しているため必要
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE, PATCH")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+		p, ok := app.credentialsOr401(w, r)
+		if !ok {
+			return
+		}
+		if p.Key != nil && !apikey.Allows(p.Key, r.Method) {
+			app.insufficientScope(w, r)
 			return
-		} else {
-			next.ServeHTTP(w, r)
 		}
+
+		// 以降のログにユーザIDを含める
+		r = requestlog.SetUserID(r, strconv.Itoa(p.UserID))
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+
+		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) authRequired(next http.Handler) http.Handler {
+// adminRequired lets only administrators through, and only API keys with the
+// admin scope. It is used after authRequired; on its own it authenticates the
+// request itself.
+func (app *application) adminRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderAndVerify(w,r)
+		p := principalFrom(r.Context())
+		if p == nil {
+			var ok bool
+			if p, ok = app.credentialsOr401(w, r); !ok {
+				return
+			}
+		}
+		if !app.requireAdmin(w, r, p) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin answers 403 and returns false unless p is an administrator
+// and, for an API key, the key has the admin scope.
+func (app *application) requireAdmin(w http.ResponseWriter, r *http.Request, p *principal) bool {
+	if p == nil || !p.Admin {
+		app.errorJSON(w, r, errAdminRequired, http.StatusForbidden)
+		return false
+	}
+	if p.Key != nil && !p.Key.HasScope(apikey.ScopeAdmin) {
+		app.insufficientScope(w, r)
+		return false
+	}
+	return true
+}
+
+// ownerOrAdmin lets through only the user named by the userID URL parameter
+// and administrators. An API key needs the admin scope to act on other users.
+// It is used after authRequired.
+func (app *application) ownerOrAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := intURLParam(r, "userID")
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.errorJSON(w, r, err, http.StatusBadRequest)
 			return
 		}
 
+		p := principalFrom(r.Context())
+		if p == nil {
+			var ok bool
+			if p, ok = app.credentialsOr401(w, r); !ok {
+				return
+			}
+		}
+		if p.UserID != userID {
+			if !p.Admin {
+				app.errorJSON(w, r, errOwnerRequired, http.StatusForbidden)
+				return
+			}
+			if p.Key != nil && !p.Key.HasScope(apikey.ScopeAdmin) {
+				app.insufficientScope(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// credentialsOr401 returns the principal of the request, or answers 401 and
+// returns false.
+func (app *application) credentialsOr401(w http.ResponseWriter, r *http.Request) (*principal, bool) {
+	p, err := app.authenticateRequest(w, r)
+	if errors.Is(err, errAuthRequired) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		app.errorJSON(w, r, errAuthRequired, http.StatusUnauthorized)
+		return nil, false
+	}
+	if errors.Is(err, errSessionRevoked) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+		app.errorJSON(w, r, errSessionRevoked, http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	return p, true
+}
+
+// insufficientScope answers 403 to an API key without the scope for the
+// request (RFC 6750).
+func (app *application) insufficientScope(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="insufficient_scope"`)
+	app.errorJSON(w, r, errInsufficientScope, http.StatusForbidden)
+}
+
+// recoverPanic turns a panic in a handler into a 500 problem response, like
+// middleware.Recoverer of chi but with the usual error body.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			// 接続が切れたことを伝えるためのパニックはそのまま返す
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+			requestlog.FromContext(r.Context()).Error("panic", "panic", fmt.Sprint(rvr), "stack", string(debug.Stack()))
+			app.errorJSON(w, r, fmt.Errorf("panic: %v", rvr), http.StatusInternalServerError)
+		}()
+
 		next.ServeHTTP(w, r)
 	})
 }
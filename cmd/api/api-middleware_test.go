@@ -1,46 +1,181 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"go_test_prac/webApp/pkg/apikey"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/resumable"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 // このミドルウェアを通った場合に正しくヘッダーが設定されるかをテスト
-// methodがOPTIONSの場合
+// プリフライトでも実際のリクエストでも、許可したオリジンにはCredentialsを付ける
 func Test_app_enableCORS(t *testing.T) {
 	// dummy handler
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
 	var tests = []struct {
-		name string
-		method string
-		expectHeader bool
+		name          string
+		method        string
+		origin        string
+		preflight     bool
+		expectAllowed bool
 	}{
-		{"preflight request", http.MethodOptions, true},
-		{"get request", http.MethodGet, false},
+		{"preflight request", http.MethodOptions, "http://localhost:8090", true, true},
+		{"get request", http.MethodGet, "http://localhost:8090", false, true},
+		{"post request", http.MethodPost, "http://localhost:8090", false, true},
+		{"preflight from another origin", http.MethodOptions, "https://evil.example", true, false},
+		{"get from another origin", http.MethodGet, "https://evil.example", false, false},
+		{"same origin request", http.MethodGet, "", false, false},
 	}
 
 	for _, e := range tests {
 		handlerToTest := app.enableCORS(nextHandler)
 
 		req := httptest.NewRequest(e.method, "http://testing", nil)
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		if e.preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+		}
 		rr := httptest.NewRecorder()
 
 		handlerToTest.ServeHTTP(rr, req)
 
-		if e.expectHeader && rr.Header().Get("Access-Control-Allow-Credentials") == "" {
+		if e.expectAllowed && rr.Header().Get("Access-Control-Allow-Credentials") == "" {
 			t.Errorf("%s: expected header Access-Control-Allow-Credentials, but not found", e.name)
 		}
-
-		if !e.expectHeader && rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		if !e.expectAllowed && rr.Header().Get("Access-Control-Allow-Credentials") != "" {
 			t.Errorf("%s: unexpected header Access-Control-Allow-Credentials, but found", e.name)
 		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); e.expectAllowed && got != e.origin {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %s but got %q", e.name, e.origin, got)
+		}
+		// キャッシュがオリジンごとに区別できるように、許可しない場合もVaryを付ける
+		if !strings.Contains(strings.Join(rr.Header().Values("Vary"), ","), "Origin") {
+			t.Errorf("%s: expected Vary: Origin but got %q", e.name, rr.Header().Values("Vary"))
+		}
+		// 実際のリクエストではtusのレスポンスヘッダを読めるようにする
+		if e.expectAllowed && !e.preflight && !strings.Contains(rr.Header().Get("Access-Control-Expose-Headers"), "Upload-Offset") {
+			t.Errorf("%s: expected tus headers to be exposed", e.name)
+		}
 	}
 }
 
+// プリフライトでないOPTIONSはハンドラに渡される
+func Test_app_enableCORS_optionsPassThrough(t *testing.T) {
+	var tests = []struct {
+		name         string
+		preflight    bool
+		expectCalled bool
+	}{
+		{"preflight request", true, false},
+		{"plain options request", false, true},
+	}
+
+	for _, e := range tests {
+		called := false
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+		req := httptest.NewRequest(http.MethodOptions, "http://testing", nil)
+		req.Header.Set("Origin", "http://localhost:8090")
+		if e.preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+			req.Header.Set("Access-Control-Request-Headers", "Authorization, Tus-Resumable, Upload-Offset, Content-Type")
+		}
+		rr := httptest.NewRecorder()
+		app.enableCORS(nextHandler).ServeHTTP(rr, req)
+
+		if called != e.expectCalled {
+			t.Errorf("%s: expected the handler to be called: %t, but was: %t", e.name, e.expectCalled, called)
+		}
+		if e.preflight && !strings.Contains(rr.Header().Get("Access-Control-Allow-Headers"), "Upload-Offset") {
+			t.Errorf("%s: expected tus headers to be allowed", e.name)
+		}
+		if e.preflight && rr.Code != http.StatusNoContent {
+			t.Errorf("%s: expected 204 but got %d", e.name, rr.Code)
+		}
+	}
+}
+
+// 設定したオリジン、メソッド、ヘッダーに従ってプリフライトに答える
+func Test_app_enableCORS_configured(t *testing.T) {
+	cfg := defaultCORS()
+	cfg.Origins = "https://app.example.com, https://*.preview.example.com"
+	cfg.Methods = "GET, PUT"
+	cfg.Headers = "Authorization"
+	cfg.MaxAge = time.Hour
+	policy, err := cfg.Policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := app
+	a.CORS = policy
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name          string
+		origin        string
+		method        string
+		headers       string
+		expectAllowed bool
+	}{
+		{"exact origin", "https://app.example.com", "PUT", "Authorization", true},
+		{"wildcard origin", "https://pr-42.preview.example.com", "PUT", "authorization", true},
+		{"parent of the wildcard", "https://preview.example.com", "PUT", "", false},
+		{"method not allowed", "https://app.example.com", "DELETE", "", false},
+		{"header not allowed", "https://app.example.com", "PUT", "Authorization, Upload-Offset", false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(http.MethodOptions, "http://testing/users/1", nil)
+		req.Header.Set("Origin", e.origin)
+		req.Header.Set("Access-Control-Request-Method", e.method)
+		if e.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", e.headers)
+		}
+		rr := httptest.NewRecorder()
+		a.enableCORS(nextHandler).ServeHTTP(rr, req)
+
+		if allowed := rr.Header().Get("Access-Control-Allow-Origin") == e.origin; allowed != e.expectAllowed {
+			t.Errorf("%s: expected allowed %t but got %v", e.name, e.expectAllowed, rr.Header())
+		}
+		if !e.expectAllowed {
+			continue
+		}
+		if rr.Header().Get("Access-Control-Allow-Methods") != "GET, PUT" {
+			t.Errorf("%s: unexpected Access-Control-Allow-Methods %q", e.name, rr.Header().Get("Access-Control-Allow-Methods"))
+		}
+		if rr.Header().Get("Access-Control-Max-Age") != "3600" {
+			t.Errorf("%s: expected Access-Control-Max-Age 3600 but got %q", e.name, rr.Header().Get("Access-Control-Max-Age"))
+		}
+	}
+}
+
+// 認証エラーやルートのないリクエストでも、ブラウザがレスポンスを読めるようにCORSヘッダーを付ける
+func Test_app_routes_cors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set("Origin", "http://localhost:8090")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Origin") != "http://localhost:8090" {
+		t.Errorf("expected CORS headers on errors but got %v", rr.Header())
+	}
+}
 
 func Test_app_authRequired(t *testing.T) {
 	// dummy handler
@@ -82,5 +217,241 @@ func Test_app_authRequired(t *testing.T) {
 		if !e.expectAuthorized && rr.Code != http.StatusUnauthorized {
 			t.Errorf("%s: did not get code 402, and should have", e.name)
 		}
+
+		if !e.expectAuthorized && !strings.Contains(rr.Body.String(), `"code":"authentication_required"`) {
+			t.Errorf("%s: expected a problem with code authentication_required, got %s", e.name, rr.Body.String())
+		}
+	}
+}
+
+// 管理者でないユーザは403、トークンがなければ401になる
+func Test_app_adminRequired(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1})
+	user, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"})
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"admin", "Bearer " + admin.Token, http.StatusOK, ""},
+		{"not an admin", "Bearer " + user.Token, http.StatusForbidden, "admin_required"},
+		{"no token", "", http.StatusUnauthorized, "authentication_required"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", e.token)
+		}
+		rr := httptest.NewRecorder()
+		app.adminRequired(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectedCode+`"`) {
+			t.Errorf("%s: expected a problem with code %s, got %s", e.name, e.expectedCode, rr.Body.String())
+		}
+	}
+}
+
+// ユーザ本人と管理者だけが通れ、管理者のAPIキーにはadminスコープがいる
+func Test_app_ownerOrAdmin(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	adminKey := &data.APIKey{UserID: 1, Scopes: []string{apikey.ScopeRead, apikey.ScopeWrite}}
+
+	var tests = []struct {
+		name               string
+		userID             string
+		principal          *principal
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"owner", "1", &principal{UserID: 1}, http.StatusOK, ""},
+		{"another user", "1", &principal{UserID: 2}, http.StatusForbidden, "owner_required"},
+		{"admin", "2", &principal{UserID: 1, Admin: true}, http.StatusOK, ""},
+		{"admin key without the admin scope", "2", &principal{UserID: 1, Admin: true, Key: adminKey}, http.StatusForbidden, "insufficient_scope"},
+		{"own key without the admin scope", "1", &principal{UserID: 1, Admin: true, Key: adminKey}, http.StatusOK, ""},
+		{"invalid user id", "one", &principal{UserID: 1}, http.StatusBadRequest, "invalid_parameter"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/users/"+e.userID+"/avatar", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.userID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = withPrincipal(req, e.principal)
+		rr := httptest.NewRecorder()
+
+		app.ownerOrAdmin(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+		if e.expectedCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectedCode+`"`) {
+			t.Errorf("%s: expected a problem with code %s, got %s", e.name, e.expectedCode, rr.Body.String())
+		}
+	}
+}
+
+// 他のユーザの画像やファイルのルートは、ルーティングを通しても403になる
+func Test_app_routes_ownerOrAdmin(t *testing.T) {
+	other, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"})
+
+	var tests = []struct {
+		method string
+		target string
+	}{
+		{"PATCH", "/users/1"},
+		{"DELETE", "/users/1"},
+		// 作成は管理者だけ
+		{"PUT", "/users/3"},
+		{"GET", "/users/1/images"},
+		{"PUT", "/users/1/images/1/current"},
+		{"DELETE", "/users/1/images/1"},
+		{"PUT", "/users/1/avatar"},
+		{"DELETE", "/users/1/avatar"},
+		{"POST", "/users/1/uploads"},
+		{"PATCH", "/users/1/uploads/" + strings.Repeat("0", 32)},
+		{"GET", "/users/1/files"},
+		{"DELETE", "/users/1/files/1"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.target, nil)
+		req.Header.Set("Authorization", "Bearer "+other.Token)
+		req.Header.Set("Tus-Resumable", resumable.Version)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected status 403 but got %d: %s", e.method, e.target, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// APIキーはスコープの範囲でだけ使え、失効や期限切れのキーは401になる
+func Test_app_authRequired_apiKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		method             string
+		key                string
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"read", "GET", dbrepo.TestAPIKeyRead, http.StatusOK, ""},
+		{"read a HEAD request", "HEAD", dbrepo.TestAPIKeyRead, http.StatusOK, ""},
+		{"write without the scope", "PATCH", dbrepo.TestAPIKeyRead, http.StatusForbidden, "insufficient_scope"},
+		{"write", "DELETE", dbrepo.TestAPIKeyFull, http.StatusOK, ""},
+		{"expired", "GET", dbrepo.TestAPIKeyExpired, http.StatusUnauthorized, "authentication_required"},
+		{"revoked", "GET", dbrepo.TestAPIKeyRevoked, http.StatusUnauthorized, "authentication_required"},
+		{"wrong secret", "GET", dbrepo.TestAPIKeyRead + "x", http.StatusUnauthorized, "authentication_required"},
+		{"unknown key", "GET", "wak_ffffffffffff_unknown", http.StatusUnauthorized, "authentication_required"},
+		{"malformed key", "GET", "wak_nothing", http.StatusUnauthorized, "authentication_required"},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &apiKeyRepo{}
+		app.DB = repo
+
+		var got *principal
+		var userID string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = principalFrom(r.Context())
+			userID = requestlog.UserID(r.Context())
+		})
+
+		req, _ := http.NewRequest(e.method, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+e.key)
+		rr := httptest.NewRecorder()
+		app.authRequired(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if e.expectedCode != "" {
+			if !strings.Contains(rr.Body.String(), `"code":"`+e.expectedCode+`"`) {
+				t.Errorf("%s: expected a problem with code %s, got %s", e.name, e.expectedCode, rr.Body.String())
+			}
+			if got != nil {
+				t.Errorf("%s: expected the request to be stopped", e.name)
+			}
+			continue
+		}
+
+		if got == nil || got.UserID != 1 || got.Key == nil || userID != "1" {
+			t.Errorf("%s: expected the key of user 1 but got %+v (user %q)", e.name, got, userID)
+		}
+		if len(repo.touched) != 1 {
+			t.Errorf("%s: expected the use to be recorded but got %v", e.name, repo.touched)
+		}
+	}
+}
+
+// APIキーで管理者用の経路を使うには、管理者のキーでadminスコープが必要
+func Test_app_adminRequired_apiKey(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name               string
+		key                string
+		admin              bool
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"admin", dbrepo.TestAPIKeyFull, true, http.StatusOK, ""},
+		{"without the admin scope", dbrepo.TestAPIKeyRead, true, http.StatusForbidden, "insufficient_scope"},
+		{"not an admin", dbrepo.TestAPIKeyFull, false, http.StatusForbidden, "admin_required"},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		app.DB = &apiKeyRepo{admin: e.admin}
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+e.key)
+		rr := httptest.NewRecorder()
+		app.authRequired(app.adminRequired(nextHandler)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectedCode+`"`) {
+			t.Errorf("%s: expected a problem with code %s, got %s", e.name, e.expectedCode, rr.Body.String())
+		}
+		if e.expectedCode == "insufficient_scope" && !strings.Contains(rr.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+			t.Errorf("%s: expected WWW-Authenticate to name the error but got %q", e.name, rr.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func Test_app_recoverPanic(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went very wrong")
+	})
+
+	req := httptest.NewRequest("GET", "/users/", nil)
+	rr := httptest.NewRecorder()
+	app.recoverPanic(nextHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != problemContentType || !strings.Contains(rr.Body.String(), `"code":"internal_error"`) {
+		t.Errorf("expected an internal_error problem, got %s", rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "very wrong") {
+		t.Errorf("the panic leaked to the client: %s", rr.Body.String())
 	}
 }
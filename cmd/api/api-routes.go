@@ -1,41 +1,130 @@
 package main
 
 import (
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/tracing"
+	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// register middleware
-	mux.Use(middleware.Recoverer)
+	mux.Use(requestlog.Middleware(slog.Default(), requestlog.Options{ClientIP: app.ClientIP.String}))
+	mux.Use(tracing.Middleware)
+	mux.Use(app.Metrics.HTTP.Middleware)
+	mux.Use(app.recoverPanic)
 	mux.Use(app.enableCORS)
 
+	// ルーティングできなかったリクエストにもproblem+jsonで答える
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, r, errRouteNotFound, http.StatusNotFound)
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, r, errMethodNotAllowed, http.StatusMethodNotAllowed)
+	})
+
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./html/"))))
 
+	// probes of the orchestrator
+	mux.Get("/healthz", app.Health.Liveness)
+	mux.Get("/readyz", app.Health.Readiness)
+
+	// Prometheus; -metrics-addrを指定した場合はそちらで配信する
+	if app.MetricsAddr == "" {
+		mux.Method(http.MethodGet, "/metrics", app.metricsHandler())
+	}
+
+	// API documentation
+	mux.Get("/openapi.json", app.openAPI)
+	mux.Get("/docs", app.docs)
+
+	// ログインとトークンの更新はクライアントのアドレスごとに回数を制限する
+	authLimit := app.authRateLimit()
+	// 認証が必要なルートも、不正なトークンやキーを繰り返し送れないよう認証の前にアドレスごとに制限する
+	clientLimit := app.clientRateLimit()
+
 	mux.Route("/web", func(mux chi.Router) {
-		mux.Post("/auth", app.authenticate)
-		mux.Get("/refresh-token", app.refreshUsingCookie)
+		mux.With(authLimit).Post("/auth", app.authenticate)
+		mux.With(authLimit).Get("/refresh-token", app.refreshUsingCookie)
 		mux.Get("/logout", app.deleteRefreshCookie)
 	})
 
 	// authenication routes - auth handler, reflesh
 	// Ex.)curl http://localhost:8090/auth -X POST -H "Content-Type:application/json" -d '{"email":"admin@example.com","password":"secret"}'
-	mux.Post("/auth", app.authenticate)
-	mux.Post("/refresh-token", app.refresh)
+	mux.With(authLimit).Post("/auth", app.authenticate)
+	mux.With(authLimit).Post("/refresh-token", app.refresh)
 
 	// protected routes
 	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(clientLimit)
 		mux.Use(app.authRequired)
+		mux.Use(app.usersRateLimit())
 
 		mux.Get("/", app.allUsers)
+
+		// 一括の取り込みと書き出しは管理者だけ
+		mux.With(app.adminRequired).Post("/import", app.importUsers)
+		mux.With(app.adminRequired).Get("/export", app.exportUsers)
+
 		mux.Get("/{userID}", app.getUser)
-		mux.Delete("/{userID}", app.deleteUser)
-		mux.Put("/{userID}", app.insertUser)
-		mux.Patch("/{userID}", app.updateUser)
+		// ユーザを作れるのは管理者だけ、変更と削除は本人と管理者だけ
+		mux.With(app.adminRequired).Put("/{userID}", app.insertUser)
+		mux.With(app.ownerOrAdmin).Delete("/{userID}", app.deleteUser)
+		mux.With(app.ownerOrAdmin).Patch("/{userID}", app.updateUser)
+
+		// プロフィール画像は他のユーザにも見せる
+		mux.Get("/{userID}/avatar", app.getAvatar)
+
+		// 画像とファイルを扱えるのは本人と管理者だけ
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.ownerOrAdmin)
+
+			mux.Get("/{userID}/images", app.allUserImages)
+			mux.Put("/{userID}/images/{imageID}/current", app.setCurrentUserImage)
+			mux.Delete("/{userID}/images/{imageID}", app.deleteUserImage)
+
+			mux.Put("/{userID}/avatar", app.putAvatar)
+			mux.Delete("/{userID}/avatar", app.deleteAvatar)
+
+			// resumable uploads (tus protocol)
+			mux.Route("/{userID}/uploads", func(mux chi.Router) {
+				mux.Use(app.tusResumable)
+
+				mux.Options("/", app.uploadOptions)
+				mux.Post("/", app.createUpload)
+				mux.Head("/{uploadID}", app.uploadOffset)
+				mux.Patch("/{uploadID}", app.writeUploadChunk)
+				mux.Delete("/{uploadID}", app.terminateUpload)
+			})
+
+			mux.Get("/{userID}/files", app.allUserFiles)
+			mux.Delete("/{userID}/files/{fileID}", app.deleteUserFile)
+		})
+	})
+
+	// personal API keys of the caller
+	mux.Route("/api-keys", func(mux chi.Router) {
+		mux.Use(clientLimit)
+		mux.Use(app.authRequired)
+		mux.Use(app.usersRateLimit())
+
+		mux.Get("/", app.allAPIKeys)
+		mux.Post("/", app.createAPIKey)
+		mux.Delete("/{keyID}", app.revokeAPIKey)
+	})
+
+	// the audit log, for administrators
+	mux.Route("/audit-events", func(mux chi.Router) {
+		mux.Use(clientLimit)
+		mux.Use(app.authRequired)
+		mux.Use(app.usersRateLimit())
+		mux.Use(app.adminRequired)
+
+		mux.Get("/", app.auditEvents)
 	})
 
 	return mux
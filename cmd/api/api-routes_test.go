@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"github.com/go-chi/chi/v5"
@@ -12,6 +13,11 @@ func Test_app_routes(t *testing.T) {
 		route string
 		method string
 	}{
+		{"/healthz", "GET"},
+		{"/readyz", "GET"},
+		{"/metrics", "GET"},
+		{"/openapi.json", "GET"},
+		{"/docs", "GET"},
 		{"/auth", "POST"},
 		{"/refresh-token", "POST"},
 		{"/users/", "GET"},
@@ -19,6 +25,19 @@ func Test_app_routes(t *testing.T) {
 		{"/users/{userID}", "DELETE"},
 		{"/users/{userID}", "PUT"},
 		{"/users/{userID}", "PATCH"},
+		{"/users/{userID}/images", "GET"},
+		{"/users/{userID}/images/{imageID}/current", "PUT"},
+		{"/users/{userID}/images/{imageID}", "DELETE"},
+		{"/users/{userID}/avatar", "PUT"},
+		{"/users/{userID}/avatar", "GET"},
+		{"/users/{userID}/avatar", "DELETE"},
+		{"/users/{userID}/uploads/", "OPTIONS"},
+		{"/users/{userID}/uploads/", "POST"},
+		{"/users/{userID}/uploads/{uploadID}", "HEAD"},
+		{"/users/{userID}/uploads/{uploadID}", "PATCH"},
+		{"/users/{userID}/uploads/{uploadID}", "DELETE"},
+		{"/users/{userID}/files", "GET"},
+		{"/users/{userID}/files/{fileID}", "DELETE"},
 	}
 
 	mux := app.routes()
@@ -32,6 +51,34 @@ func Test_app_routes(t *testing.T) {
 	}
 }
 
+// ルーティングできないリクエストもproblem+jsonで答える
+func Test_app_routes_notFound(t *testing.T) {
+	var tests = []struct {
+		name         string
+		method       string
+		target       string
+		expectedCode int
+		expectedBody string
+	}{
+		{"unknown path", "GET", "/nothing-here", http.StatusNotFound, `"code":"route_not_found"`},
+		{"unknown method", "GET", "/auth", http.StatusMethodNotAllowed, `"code":"method_not_allowed"`},
+	}
+
+	mux := app.routes()
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.target, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode || !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("%s: unexpected response %d %s", e.name, rr.Code, rr.Body.String())
+		}
+		if !strings.Contains(rr.Body.String(), `"request_id":"`) {
+			t.Errorf("%s: expected a request id in %s", e.name, rr.Body.String())
+		}
+	}
+}
+
 func routeExists(testRoute, testMethod string, chiRoutes chi.Routes) bool {
 	found := false
 
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/filegc"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/resumable"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/validation"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Resumable uploads follow the tus protocol (https://tus.io/protocols/resumable-upload):
+//
+//	POST   /users/{userID}/uploads             create an upload, Upload-Length and Upload-Metadata headers
+//	HEAD   /users/{userID}/uploads/{uploadID}  current Upload-Offset
+//	PATCH  /users/{userID}/uploads/{uploadID}  append a chunk at Upload-Offset
+//	DELETE /users/{userID}/uploads/{uploadID}  abandon the upload
+//
+// Once every byte has been received the upload becomes one of the user's
+// files, listed at /users/{userID}/files.
+
+// tusResumable checks the Tus-Resumable header, which every request but
+// OPTIONS must send, and adds it to every response.
+func (app *application) tusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", resumable.Version)
+
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != resumable.Version {
+			w.Header().Set("Tus-Version", resumable.Version)
+			app.errorJSON(w, r, errUnsupportedTus, http.StatusPreconditionFailed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadOptions describes the supported protocol version, extensions and limits.
+func (app *application) uploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", resumable.Version)
+	w.Header().Set("Tus-Extension", resumable.Extensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(app.Uploads.MaxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUpload starts a new upload. The file name is taken from the filename
+// (or name) key of the Upload-Metadata header.
+// Ex.)curl http://localhost:8090/users/1/uploads -X POST -H "Authorization: Bearer ..." -H "Tus-Resumable: 1.0.0" -H "Upload-Length: 1048576" -H "Upload-Metadata: filename aW50cm8ubXA0"
+func (app *application) createUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		app.errorJSON(w, r, errUploadLengthMissing, http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := resumable.ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	info, err := app.Uploads.Create(r.Context(), user.ID, length, metadata)
+	if err != nil {
+		app.errorJSON(w, r, err, resumableErrorStatus(err))
+		return
+	}
+
+	// 空のファイルはチャンクを待たずに完了させる
+	if info.Done() {
+		info, err = app.completeUpload(r.Context(), info)
+		if err != nil {
+			app.errorJSON(w, r, err, resumableErrorStatus(err))
+			return
+		}
+
+		e := app.auditEvent(r, audit.ActionFileUpload, audit.TargetFile, info.FileID)
+		e.Changes = audit.Diff(nil, map[string]interface{}{
+			"user_id":      info.UserID,
+			"size":         info.Length,
+			"content_type": info.ContentType,
+		})
+		app.recordAudit(r, e)
+	}
+
+	setUploadHeaders(w, info)
+	w.Header().Set("Location", fmt.Sprintf("/users/%d/uploads/%s", user.ID, info.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// uploadOffset tells the client where to resume the upload.
+func (app *application) uploadOffset(w http.ResponseWriter, r *http.Request) {
+	info, ok := app.uploadFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(w, info)
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if len(info.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", resumable.EncodeMetadata(info.Metadata))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeUploadChunk appends the request body to the upload. The last chunk
+// turns the upload into one of the user's files.
+func (app *application) writeUploadChunk(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		app.errorJSON(w, r, errChunkContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.errorJSON(w, r, errUploadOffsetMissing, http.StatusBadRequest)
+		return
+	}
+
+	info, ok := app.uploadFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	info, err = app.Uploads.WriteChunk(r.Context(), info.ID, offset, r.Body)
+	if err != nil {
+		if info != nil {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		}
+		app.errorJSON(w, r, err, resumableErrorStatus(err))
+		return
+	}
+
+	if info.Done() {
+		info, err = app.completeUpload(r.Context(), info)
+		if err != nil {
+			app.errorJSON(w, r, err, resumableErrorStatus(err))
+			return
+		}
+
+		e := app.auditEvent(r, audit.ActionFileUpload, audit.TargetFile, info.FileID)
+		e.Changes = audit.Diff(nil, map[string]interface{}{
+			"user_id":      info.UserID,
+			"size":         info.Length,
+			"content_type": info.ContentType,
+		})
+		app.recordAudit(r, e)
+	}
+
+	setUploadHeaders(w, info)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminateUpload abandons an upload and removes its chunks.
+func (app *application) terminateUpload(w http.ResponseWriter, r *http.Request) {
+	info, ok := app.uploadFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Uploads.Terminate(r.Context(), info.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, resumableErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeUpload stores the received chunks as one file and records it in the
+// user's files. The file is scanned for malware first; infected uploads and
+// those too large to scan are removed, and the error wraps scanner.ErrInfected
+// or scanner.ErrTooLargeToScan. When the scanner is unavailable the chunks are
+// kept, so that the client can complete the upload later by sending an empty
+// last chunk again. When a file with the same
+// content is already stored, the user file refers to it and the new copy is
+// removed.
+func (app *application) completeUpload(ctx context.Context, info *resumable.Info) (*resumable.Info, error) {
+	name := uploadName(info.Metadata)
+
+	// ファイル名は利用者が付けたものなので、ストレージのキーにはアップロードIDを使う
+	key := fmt.Sprintf("files/%d/%s", info.UserID, info.ID)
+
+	info, err := app.Uploads.Complete(ctx, info.ID, key, func(info *resumable.Info) error {
+		// DBに登録する前に、結合したファイルをマルウェア検査する
+		rc, err := app.Uploads.Storage.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		status, err := scanner.Check(ctx, app.Scanner, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+
+		stored := data.StoredFile{
+			FileName:    key,
+			SHA256:      info.SHA256,
+			Size:        info.Length,
+			ContentType: info.ContentType,
+		}
+
+		// 同じ内容のファイルが既にあれば、そちらを参照して今回のオブジェクトは消す
+		existing, err := app.db(ctx).GetStoredFileBySHA256(info.SHA256)
+		if err == nil && existing.Size == info.Length {
+			stored = *existing
+		}
+
+		// 既にある場合もupdated_atが更新され、参照を登録する前にGCに消されることはない
+		err = app.db(ctx).InsertStoredFiles(stored)
+		if err != nil {
+			return err
+		}
+
+		id, err := app.db(ctx).InsertUserFile(data.UserFile{
+			UserID:      info.UserID,
+			FileName:    stored.FileName,
+			Name:        name,
+			ContentType: info.ContentType,
+			Size:        info.Length,
+			ScanStatus:  string(status),
+		})
+		if err != nil {
+			return err
+		}
+		info.FileID = id
+		app.Metrics.UploadSize.Observe(float64(info.Length), "file")
+
+		if stored.FileName != key {
+			if err := app.Uploads.Storage.Delete(ctx, key); err != nil {
+				requestlog.FromContext(ctx).Warn("deleting the duplicate upload", "key", key, "err", err)
+			}
+		}
+
+		return nil
+	})
+
+	// Completeが失敗するとチャンクは残るが、感染したファイルと検査できない大きさのファイルは再開させずに破棄する
+	if errors.Is(err, scanner.ErrInfected) || errors.Is(err, scanner.ErrTooLargeToScan) {
+		if terminateErr := app.Uploads.Terminate(ctx, info.ID); terminateErr != nil {
+			requestlog.FromContext(ctx).Warn("terminating the rejected upload", "upload_id", info.ID, "err", terminateErr)
+		}
+	}
+
+	return info, err
+}
+
+// uploadName returns the name of the file from the filename or name metadata
+// of an upload, without any directories and cut to the length of the column.
+func uploadName(metadata map[string]string) string {
+	name := metadata["filename"]
+	if name == "" {
+		name = metadata["name"]
+	}
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "upload"
+	}
+	return validation.Truncate(name, 255)
+}
+
+// uploadFromURL loads the upload named by the uploadID URL parameter and
+// makes sure it belongs to the user named by userID. It writes the error
+// response itself and returns false when the upload cannot be used.
+func (app *application) uploadFromURL(w http.ResponseWriter, r *http.Request) (*resumable.Info, bool) {
+	userID, err := intURLParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	info, err := app.Uploads.Get(r.Context(), chi.URLParam(r, "uploadID"))
+	if err != nil {
+		app.errorJSON(w, r, err, resumableErrorStatus(err))
+		return nil, false
+	}
+	if info.UserID != userID {
+		app.errorJSON(w, r, resumable.ErrNotFound, http.StatusNotFound)
+		return nil, false
+	}
+
+	return info, true
+}
+
+// setUploadHeaders adds the headers every response about an upload carries.
+// Completed uploads point to the file they became.
+func setUploadHeaders(w http.ResponseWriter, info *resumable.Info) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	w.Header().Set("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	if info.Completed {
+		w.Header().Set("Content-Location", fmt.Sprintf("/users/%d/files/%d", info.UserID, info.FileID))
+	}
+}
+
+// resumableErrorStatus maps the errors of resumable uploads to response status codes.
+func resumableErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, resumable.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, resumable.ErrExpired):
+		return http.StatusGone
+	case errors.Is(err, resumable.ErrOffsetMismatch), errors.Is(err, resumable.ErrIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, resumable.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, resumable.ErrTooLarge), errors.Is(err, resumable.ErrChunkTooLarge), errors.Is(err, resumable.ErrExceedsLength),
+		errors.Is(err, scanner.ErrTooLargeToScan):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, resumable.ErrInvalidLength), errors.Is(err, resumable.ErrInvalidMetadata):
+		return http.StatusBadRequest
+	case errors.Is(err, scanner.ErrInfected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, scanner.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// expireUploads removes abandoned uploads every interval until ctx is done.
+func (app *application) expireUploads(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.Uploads.Expire(ctx)
+			if err != nil {
+				slog.Error("expiring uploads", "err", err)
+			}
+			if n > 0 {
+				slog.Info("removed expired uploads", "count", n)
+			}
+		}
+	}
+}
+
+// collectGarbage removes the stored files nothing refers to every interval
+// until ctx is done. Deleting an image or a file only releases its reference,
+// so this is what frees the storage.
+func (app *application) collectGarbage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c := &filegc.Collector{DB: app.DB, Storage: app.Storage, MinAge: filegc.DefaultMinAge}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := c.Run(ctx, false)
+			if err != nil {
+				slog.Error("collecting unused files", "err", err)
+			}
+			if report != nil && report.Deleted > 0 {
+				slog.Info("removed unused files", "count", report.Deleted, "bytes", report.Freed)
+			}
+		}
+	}
+}
+
+// fileResponse adds the download URL to a user file.
+type fileResponse struct {
+	*data.UserFile
+	URL string `json:"url"`
+}
+
+// allUserFiles lists the files a user has uploaded, newest first.
+func (app *application) allUserFiles(w http.ResponseWriter, r *http.Request) {
+	userID, err := intURLParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	files, err := app.db(r.Context()).AllUserFiles(userID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]fileResponse, 0, len(files))
+	for _, f := range files {
+		u, err := app.Storage.URL(r.Context(), f.FileName, app.URLExpiry)
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		resp = append(resp, fileResponse{UserFile: f, URL: u})
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, resp)
+}
+
+// deleteUserFile deletes one of the user's files.
+func (app *application) deleteUserFile(w http.ResponseWriter, r *http.Request) {
+	userID, err := intURLParam(r, "userID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	fileID, err := intURLParam(r, "fileID")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	f, err := app.db(r.Context()).GetUserFile(fileID)
+	if err != nil || f.UserID != userID {
+		app.errorJSON(w, r, errFileNotFound, http.StatusNotFound)
+		return
+	}
+
+	err = app.db(r.Context()).DeleteUserFile(f.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionFileDelete, audit.TargetFile, f.ID)
+	e.Changes = audit.Diff(map[string]interface{}{
+		"user_id":      f.UserID,
+		"name":         f.Name,
+		"size":         f.Size,
+		"content_type": f.ContentType,
+	}, nil)
+	app.recordAudit(r, e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
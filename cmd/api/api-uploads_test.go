@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/resumable"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// useTestUploads points the upload manager at an empty storage directory for the test.
+func useTestUploads(t *testing.T) *resumable.Manager {
+	t.Helper()
+	s, err := storage.NewLocal(t.TempDir(), "http://localhost:8080/static/img/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := app.Uploads
+	app.Uploads = resumable.NewManager(s)
+	app.Uploads.MaxSize = 1024
+	t.Cleanup(func() { app.Uploads = previous })
+
+	return app.Uploads
+}
+
+// tusRequest sends a request through the API routes as the admin user.
+func tusRequest(t *testing.T, method, target string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	req.Header.Set("Tus-Resumable", resumable.Version)
+	for k, v := range headers {
+		if v == "" {
+			req.Header.Del(k)
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_app_resumableUpload(t *testing.T) {
+	uploads := useTestUploads(t)
+
+	rr := tusRequest(t, "OPTIONS", "/users/1/uploads", nil, "")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Tus-Version") != resumable.Version || rr.Header().Get("Tus-Max-Size") != "1024" {
+		t.Fatalf("unexpected OPTIONS response %d %v", rr.Code, rr.Header())
+	}
+
+	rr = tusRequest(t, "POST", "/users/1/uploads", map[string]string{
+		"Upload-Length":   "15",
+		"Upload-Metadata": "filename " + "bm90ZXMudHh0", // notes.txt
+	}, "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: expected status 201 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	if !strings.HasPrefix(location, "/users/1/uploads/") || rr.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("create: unexpected headers %v", rr.Header())
+	}
+
+	var steps = []struct {
+		name               string
+		method             string
+		headers            map[string]string
+		body               string
+		expectedStatusCode int
+		expectedOffset     string
+	}{
+		{"initial offset", "HEAD", nil, "", http.StatusOK, "0"},
+		{"first chunk", "PATCH", map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"}, "0123456789", http.StatusNoContent, "10"},
+		{"repeated chunk", "PATCH", map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"}, "0123456789", http.StatusConflict, "10"},
+		{"wrong content type", "PATCH", map[string]string{"Upload-Offset": "10", "Content-Type": "text/plain"}, "abcde", http.StatusUnsupportedMediaType, ""},
+		{"missing offset", "PATCH", map[string]string{"Content-Type": "application/offset+octet-stream"}, "abcde", http.StatusBadRequest, ""},
+		{"no tus version", "PATCH", map[string]string{"Tus-Resumable": "", "Upload-Offset": "10", "Content-Type": "application/offset+octet-stream"}, "abcde", http.StatusPreconditionFailed, ""},
+		{"resumed offset", "HEAD", nil, "", http.StatusOK, "10"},
+		{"past the length", "PATCH", map[string]string{"Upload-Offset": "10", "Content-Type": "application/offset+octet-stream"}, "abcdefg", http.StatusRequestEntityTooLarge, "10"},
+		{"last chunk", "PATCH", map[string]string{"Upload-Offset": "10", "Content-Type": "application/offset+octet-stream"}, "abcde", http.StatusNoContent, "15"},
+		{"final offset", "HEAD", nil, "", http.StatusOK, "15"},
+	}
+
+	for _, e := range steps {
+		rr := tusRequest(t, e.method, location, e.headers, e.body)
+		if rr.Code != e.expectedStatusCode {
+			t.Fatalf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+		if e.expectedOffset != "" && rr.Header().Get("Upload-Offset") != e.expectedOffset {
+			t.Errorf("%s: expected offset %s but got %q", e.name, e.expectedOffset, rr.Header().Get("Upload-Offset"))
+		}
+		if rr.Header().Get("Tus-Resumable") != resumable.Version {
+			t.Errorf("%s: expected the Tus-Resumable header", e.name)
+		}
+	}
+
+	rr = tusRequest(t, "HEAD", location, nil, "")
+	if rr.Header().Get("Content-Location") != "/users/1/files/2" {
+		t.Errorf("expected the completed upload to point to the file, got %q", rr.Header().Get("Content-Location"))
+	}
+	if rr.Header().Get("Upload-Metadata") != "filename bm90ZXMudHh0" || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unexpected HEAD headers %v", rr.Header())
+	}
+
+	id := strings.TrimPrefix(location, "/users/1/uploads/")
+	rc, err := uploads.Storage.Get(context.Background(), "files/1/"+id)
+	if err != nil {
+		t.Fatalf("the completed file was not stored: %s", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(content) != "0123456789abcde" {
+		t.Errorf("unexpected file content %q", content)
+	}
+}
+
+func Test_app_resumableUploadErrors(t *testing.T) {
+	uploads := useTestUploads(t)
+
+	// ユーザ1のアップロードとユーザ2のアップロードを用意する
+	own, _ := uploads.Create(context.Background(), 1, 10, nil)
+	other, _ := uploads.Create(context.Background(), 2, 10, nil)
+
+	var tests = []struct {
+		name               string
+		method             string
+		target             string
+		headers            map[string]string
+		expectedStatusCode int
+	}{
+		{"create without length", "POST", "/users/1/uploads", nil, http.StatusBadRequest},
+		{"create too large", "POST", "/users/1/uploads", map[string]string{"Upload-Length": "1025"}, http.StatusRequestEntityTooLarge},
+		{"create negative length", "POST", "/users/1/uploads", map[string]string{"Upload-Length": "-1"}, http.StatusBadRequest},
+		{"create invalid metadata", "POST", "/users/1/uploads", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename not base64!"}, http.StatusBadRequest},
+		{"create for another user", "POST", "/users/2/uploads", map[string]string{"Upload-Length": "10"}, http.StatusForbidden},
+		{"create without tus version", "POST", "/users/1/uploads", map[string]string{"Upload-Length": "10", "Tus-Resumable": ""}, http.StatusPreconditionFailed},
+		{"unknown upload", "HEAD", "/users/1/uploads/" + strings.Repeat("0", 32), nil, http.StatusNotFound},
+		{"invalid upload id", "HEAD", "/users/1/uploads/info.json", nil, http.StatusNotFound},
+		{"upload of another user", "HEAD", "/users/1/uploads/" + other.ID, nil, http.StatusNotFound},
+		{"terminate upload of another user", "DELETE", "/users/1/uploads/" + other.ID, nil, http.StatusNotFound},
+		{"terminate", "DELETE", "/users/1/uploads/" + own.ID, nil, http.StatusNoContent},
+		{"terminated upload", "HEAD", "/users/1/uploads/" + own.ID, nil, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		rr := tusRequest(t, e.method, e.target, e.headers, "")
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func Test_app_resumableUploadEmptyFile(t *testing.T) {
+	useTestUploads(t)
+
+	rr := tusRequest(t, "POST", "/users/1/uploads", map[string]string{"Upload-Length": "0"}, "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Location") == "" {
+		t.Error("expected an empty upload to be completed right away")
+	}
+}
+
+func Test_app_resumableUploadDeduplicates(t *testing.T) {
+	uploads := useTestUploads(t)
+
+	// テスト用DBのファイル1(files/1/intro.mp4)と同じ内容
+	content := strings.Repeat("\x00", 1024)
+
+	rr := tusRequest(t, "POST", "/users/1/uploads", map[string]string{"Upload-Length": "1024"}, "")
+	location := rr.Header().Get("Location")
+	rr = tusRequest(t, "PATCH", location, map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"}, content)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204 but got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	id := strings.TrimPrefix(location, "/users/1/uploads/")
+	if _, err := uploads.Storage.Stat(context.Background(), "files/1/"+id); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the duplicate copy to be removed, got %v", err)
+	}
+}
+
+func Test_app_resumableUploadInfected(t *testing.T) {
+	uploads := useTestUploads(t)
+	app.Scanner = infectedScanner{}
+	defer func() { app.Scanner = nil }()
+
+	rr := tusRequest(t, "POST", "/users/1/uploads", map[string]string{"Upload-Length": "5"}, "")
+	location := rr.Header().Get("Location")
+	rr = tusRequest(t, "PATCH", location, map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"}, "hello")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "malware") {
+		t.Errorf("expected a clear error message, got %s", rr.Body.String())
+	}
+
+	// 感染したアップロードは再開できない
+	rr = tusRequest(t, "HEAD", location, nil, "")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the infected upload to be removed, got %d", rr.Code)
+	}
+	objects, _ := uploads.Storage.List(context.Background(), "")
+	if len(objects) != 0 {
+		t.Errorf("expected nothing to be stored, got %+v", objects)
+	}
+}
+
+// 検査できなければファイルにせず、チャンクを残して後で完了させられるようにする
+func Test_app_resumableUploadScannerDown(t *testing.T) {
+	useTestUploads(t)
+	app.Scanner = failingScanner{}
+	defer func() { app.Scanner = nil }()
+
+	rr := tusRequest(t, "POST", "/users/1/uploads", map[string]string{"Upload-Length": "5"}, "")
+	location := rr.Header().Get("Location")
+	chunk := map[string]string{"Upload-Offset": "0", "Content-Type": "application/offset+octet-stream"}
+	rr = tusRequest(t, "PATCH", location, chunk, "hello")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 but got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"code":"scan_unavailable"`) || strings.Contains(rr.Body.String(), "connection refused") {
+		t.Errorf("expected the code scan_unavailable without details, got %s", rr.Body.String())
+	}
+
+	rr = tusRequest(t, "HEAD", location, nil, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Location") != "" {
+		t.Fatalf("expected an upload that is not completed yet, got %d %v", rr.Code, rr.Header())
+	}
+
+	// 検査できるようになったら、空の最後のチャンクを送り直して完了させる
+	app.Scanner = nil
+	chunk["Upload-Offset"] = "5"
+	rr = tusRequest(t, "PATCH", location, chunk, "")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Content-Location") == "" {
+		t.Errorf("expected the retried upload to be completed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func Test_uploadName(t *testing.T) {
+	var tests = []struct {
+		name     string
+		metadata map[string]string
+		expected string
+	}{
+		{"filename", map[string]string{"filename": "notes.txt"}, "notes.txt"},
+		{"name", map[string]string{"name": "notes.txt"}, "notes.txt"},
+		{"directories", map[string]string{"filename": "../../etc/passwd"}, "passwd"},
+		{"windows path", map[string]string{"filename": `C:\Users\me\notes.txt`}, "notes.txt"},
+		{"none", nil, "upload"},
+		{"long", map[string]string{"filename": strings.Repeat("a", 300)}, strings.Repeat("a", 255)},
+		// 文字の途中で切らない
+		{"multi-byte at the cut", map[string]string{"filename": strings.Repeat("a", 254) + "日本.txt"}, strings.Repeat("a", 254) + "日"},
+	}
+
+	for _, e := range tests {
+		got := uploadName(e.metadata)
+		if got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: %q is not valid UTF-8", e.name, got)
+		}
+	}
+}
+
+func Test_resumableErrorStatus(t *testing.T) {
+	var tests = []struct {
+		err      error
+		expected int
+	}{
+		{resumable.ErrNotFound, http.StatusNotFound},
+		{resumable.ErrExpired, http.StatusGone},
+		{resumable.ErrOffsetMismatch, http.StatusConflict},
+		{resumable.ErrLocked, http.StatusLocked},
+		{resumable.ErrChunkTooLarge, http.StatusRequestEntityTooLarge},
+		{resumable.ErrInvalidMetadata, http.StatusBadRequest},
+		{fmt.Errorf("wrapped: %w", resumable.ErrExceedsLength), http.StatusRequestEntityTooLarge},
+		{fmt.Errorf("%w (Eicar-Signature)", scanner.ErrInfected), http.StatusUnprocessableEntity},
+		{scanner.ErrUnavailable, http.StatusServiceUnavailable},
+		{scanner.ErrTooLargeToScan, http.StatusRequestEntityTooLarge},
+		{io.ErrUnexpectedEOF, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		if got := resumableErrorStatus(e.err); got != e.expected {
+			t.Errorf("%v: expected %d but got %d", e.err, e.expected, got)
+		}
+	}
+}
+
+func Test_app_userFileHandlers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             string
+		fileID             string
+		handler            http.HandlerFunc
+		expectedStatusCode int
+	}{
+		{"allUserFiles", "1", "", app.allUserFiles, http.StatusOK},
+		{"allUserFiles bad URL param", "YYY", "", app.allUserFiles, http.StatusBadRequest},
+		{"deleteUserFile", "1", "1", app.deleteUserFile, http.StatusNoContent},
+		{"deleteUserFile of another user", "1", "3", app.deleteUserFile, http.StatusNotFound},
+		{"deleteUserFile missing file", "1", "99", app.deleteUserFile, http.StatusNotFound},
+		{"deleteUserFile bad file id", "1", "YYY", app.deleteUserFile, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.userID)
+		if e.fileID != "" {
+			chiCtx.URLParams.Add("fileID", e.fileID)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_allUserFiles_body(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/users/1/files", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.allUserFiles).ServeHTTP(rr, req)
+
+	var files []map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&files); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file but got %d", len(files))
+	}
+	if files[0]["name"] != "intro.mp4" || !strings.HasPrefix(files[0]["url"].(string), "http://localhost:8080/static/img/files/1/intro.mp4") {
+		t.Errorf("unexpected file %v", files[0])
+	}
+	if _, ok := files[0]["file_name"]; ok {
+		t.Error("the storage key should not be exposed")
+	}
+}
@@ -1,30 +1,41 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"go_test_prac/webApp/pkg/apikey"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/token"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtTokenExpiry = time.Minute * 15 // 15分
-var refreshTokenExpiry = time.Hour * 24 // 24時間
+var jwtTokenExpiry = token.DefaultAccessExpiry // 15分
+var refreshTokenExpiry = token.DefaultRefreshExpiry // 24時間
 
-type TokenPairs struct {
-	Token string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-}
+// TokenPairs and Claims are defined by package token, which the CLI shares.
+type TokenPairs = token.Pair
+
+type Claims = token.Claims
 
-type Claims struct {
-	UserName string `json:"username"`
-	jwt.RegisteredClaims
+// tokens returns the issuer of the tokens of the API.
+func (app *application) tokens() token.Issuer {
+	return token.Issuer{
+		Secret:        app.JWTSecret,
+		Domain:        app.Domain,
+		AccessExpiry:  jwtTokenExpiry,
+		RefreshExpiry: refreshTokenExpiry,
+	}
 }
 
-func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
+// bearerToken returns the credentials of the Authorization header, an access
+// token or an API key.
+func bearerToken(w http.ResponseWriter, r *http.Request) (string, error) {
 	// we expect our authorization header to be in the format
 	// Authorization: Bearer {token}
 	// add a header
@@ -35,46 +46,33 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 
 	// check if the authorization header is empty
 	if authHeader == "" {
-		return "", nil, errors.New("authorization header required")
+		return "", errors.New("authorization header required")
 	}
 
 	// split the authorization header on the space
 	headerParts := strings.Split(authHeader, " ")
 	if len(headerParts) != 2 {
-		return "", nil, errors.New("authorization header format must be Bearer {token}")
+		return "", errors.New("authorization header format must be Bearer {token}")
 	}
 
 	// check to see if we have the word "Bearer"
 	if headerParts[0] != "Bearer" {
-		return "", nil, errors.New("authorization header must start with Bearer")
+		return "", errors.New("authorization header must start with Bearer")
 	}
 
-	token := headerParts[1]
-
-	// declare an empty Claims{} struct
-	claims := &Claims{}
-
-	// parse the JWT token, passing the expected Claims{} struct into the method
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		// validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		// jwt secretを返す
-		return []byte(app.JWTSecret), nil
-	})
+	return headerParts[1], nil
+}
 
-	// check if there was an error; note that this cathces expired tokens as well
+func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
+	token, err := bearerToken(w, r)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("token expired")
-		}
 		return "", nil, err
 	}
 
-	// make sure that we issued the token
-	if claims.Issuer != app.Domain {
-		return "", nil, errors.New("incorrect token issuer")
+	// verify the signature, the expiry and the issuer; note that this catches expired tokens as well
+	claims, err := app.tokens().Verify(token)
+	if err != nil {
+		return "", nil, err
 	}
 
 	// valid token
@@ -82,48 +80,109 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 
 }
 
-func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
-	// create the token
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	// set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
-	claims["sub"] = fmt.Sprintf("%d", user.ID)
-	claims["aud"] = app.Domain
-	claims["iss"] = app.Domain
-	if user.IsAdmin == 1 {
-		claims["admin"] = true
-	} else {
-		claims["admin"] = false
-	}
-
-	// set the expiry
-	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
-
-	// create the signed token
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+// principal is who a request is made by, stored in the context by
+// authRequired.
+type principal struct {
+	UserID int
+	Admin  bool
+	Key    *data.APIKey // nil for an access token
+}
+
+type principalKey struct{}
+
+// principalFrom returns the principal stored by authRequired, or nil.
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// apiKeyTouchInterval is how often the last use of an API key is written at
+// most, unless it is used from another address.
+const apiKeyTouchInterval = time.Minute
+
+// authenticateRequest returns the principal of the access token or the API
+// key of the request. Errors wrapping errAuthRequired are the fault of the
+// client, and errSessionRevoked is returned for an access token issued before
+// the sessions of its user were revoked.
+func (app *application) authenticateRequest(w http.ResponseWriter, r *http.Request) (*principal, error) {
+	credentials, err := bearerToken(w, r)
 	if err != nil {
-		return TokenPairs{}, err
+		return nil, fmt.Errorf("%w: %s", errAuthRequired, err)
+	}
+
+	if !apikey.IsKey(credentials) {
+		claims, err := app.tokens().Verify(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errAuthRequired, err)
+		}
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errAuthRequired, err)
+		}
+		// CLIでセッションが失効させられる前に発行されたトークンは、期限前でも使わせない
+		revoked, err := app.sessionRevoked(r.Context(), userID, claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errSessionRevoked
+		}
+		return &principal{UserID: userID, Admin: claims.Admin}, nil
 	}
 
-	// create the refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
-	refreshTokenClaims["sub"] = fmt.Sprintf("%d", user.ID)
-	// set the expiry; must be longer than the access token
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	k, err := app.verifyAPIKey(r, credentials)
+	if err != nil {
+		return nil, err
+	}
+	// 管理者かどうかはキーの作成後に変わりうるので、毎回確かめる
+	user, err := app.db(r.Context()).GetUser(k.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errAuthRequired, err)
+	}
+	return &principal{UserID: k.UserID, Admin: user.IsAdmin == 1, Key: k}, nil
+}
 
-	// create the signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
+// verifyAPIKey returns the stored key of key if it may be used now, and
+// records its use.
+func (app *application) verifyAPIKey(r *http.Request, key string) (*data.APIKey, error) {
+	prefix, err := apikey.Prefix(key)
 	if err != nil {
-		return TokenPairs{}, err
+		return nil, fmt.Errorf("%w: %s", errAuthRequired, err)
+	}
+	k, err := app.db(r.Context()).GetAPIKeyByPrefix(prefix)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", errAuthRequired, apikey.ErrInvalid)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	var tokenPair = TokenPairs{
-		Token: signedAccessToken,
-		RefreshToken: signedRefreshToken,
+	now := time.Now()
+	if err := apikey.Check(k, key, now); err != nil {
+		return nil, fmt.Errorf("%w: %s", errAuthRequired, err)
+	}
+
+	// 使うたびに書き込まないよう、間隔をあける
+	ip := app.ClientIP.String(r)
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval || k.LastUsedIP != ip {
+		if err := app.db(r.Context()).TouchAPIKey(k.ID, now, ip); err != nil {
+			requestlog.FromContext(r.Context()).Error("recording the use of an API key", "api_key_id", k.ID, "err", err)
+		}
 	}
+	return k, nil
+}
 
-	return tokenPair, nil
+func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
+	return app.tokens().Issue(user)
+}
+
+// sessionRevoked reports whether the sessions of the user were revoked, with
+// the CLI, after the token was issued. Tokens without a time of issue predate
+// revocation and count as revoked once the user has a revocation.
+func (app *application) sessionRevoked(ctx context.Context, userID int, claims *Claims) (bool, error) {
+	revokedAt, err := app.db(ctx).SessionsRevokedAt(userID)
+	if err != nil || revokedAt.IsZero() {
+		return false, err
+	}
+	return claims.IssuedAt == nil || !claims.IssuedAt.After(revokedAt), nil
 }
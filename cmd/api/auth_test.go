@@ -3,9 +3,12 @@ package main
 import (
 	"fmt"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
@@ -61,3 +64,80 @@ func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
 	}
 
 }
+
+// revokedRepo is the test repository with the sessions of every user revoked
+// at revokedAt.
+type revokedRepo struct {
+	dbrepo.TestDBRepo
+	revokedAt time.Time
+}
+
+func (m *revokedRepo) SessionsRevokedAt(userID int) (time.Time, error) {
+	return m.revokedAt, nil
+}
+
+func Test_app_refresh_revoked(t *testing.T) {
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User"})
+
+	var tests = []struct {
+		name         string
+		revokedAt    time.Time
+		expectStatus int
+		expectCode   string
+	}{
+		{"never revoked", time.Time{}, http.StatusOK, ""},
+		{"revoked before login", time.Now().Add(-time.Hour), http.StatusOK, ""},
+		{"revoked after login", time.Now().Add(time.Second), http.StatusBadRequest, "session_revoked"},
+	}
+
+	for _, e := range tests {
+		a := app
+		a.DB = &revokedRepo{revokedAt: e.revokedAt}
+
+		req, _ := http.NewRequest("GET", "/web/refresh-token", nil)
+		req.AddCookie(&http.Cookie{Name: "Host-refresh_token", Value: tokens.RefreshToken})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(a.refreshUsingCookie).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectStatus, rr.Code)
+		}
+		if e.expectCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectCode+`"`) {
+			t.Errorf("%s: expected the code %s but got %s", e.name, e.expectCode, rr.Body.String())
+		}
+		checkResponse(t, e.name, "GET", "/web/refresh-token", rr)
+	}
+}
+
+func Test_app_authRequired_revoked(t *testing.T) {
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User"})
+
+	var tests = []struct {
+		name         string
+		revokedAt    time.Time
+		expectStatus int
+		expectCode   string
+	}{
+		{"never revoked", time.Time{}, http.StatusOK, ""},
+		{"revoked before login", time.Now().Add(-time.Hour), http.StatusOK, ""},
+		{"revoked after login", time.Now().Add(time.Second), http.StatusUnauthorized, "session_revoked"},
+	}
+
+	for _, e := range tests {
+		a := app
+		a.DB = &revokedRepo{revokedAt: e.revokedAt}
+
+		// 失効の後は、期限内のアクセストークンも使えない
+		req, _ := http.NewRequest("GET", "/users/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		rr := httptest.NewRecorder()
+		a.authRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectStatus, rr.Code)
+		}
+		if e.expectCode != "" && !strings.Contains(rr.Body.String(), `"code":"`+e.expectCode+`"`) {
+			t.Errorf("%s: expected the code %s but got %s", e.name, e.expectCode, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/cors"
+	"go_test_prac/webApp/pkg/resumable"
+	"strings"
+	"time"
+)
+
+// appConfig is the configuration of the api, loaded by config.Load.
+type appConfig struct {
+	Domain    string           `yaml:"domain" flag:"domain" usage:"Domain for the application, e.g company.com"`
+	Server    config.Server    `yaml:"server"`
+	Database  config.Database  `yaml:"database"`
+	Auth      config.Auth      `yaml:"auth"`
+	Storage   config.Storage   `yaml:"storage"`
+	URLExpiry time.Duration    `yaml:"url_expiry" flag:"url-expiry" usage:"lifetime of signed file URLs"`
+	Uploads   uploadsConfig    `yaml:"uploads"`
+	Clamd     string           `yaml:"clamd" flag:"clamd" usage:"clamd address for malware scanning, host:port or unix:/path; uploads are not scanned when empty"`
+	Log       config.Log       `yaml:"log"`
+	Metrics   config.Metrics   `yaml:"metrics"`
+	Tracing   config.Tracing   `yaml:"tracing"`
+	TLS       config.TLS       `yaml:"tls"`
+	Proxy     config.Proxy     `yaml:"proxy"`
+	RateLimit config.RateLimit `yaml:"rate_limit"`
+	CORS      corsConfig       `yaml:"cors"`
+}
+
+// uploadsConfig limits resumable uploads.
+type uploadsConfig struct {
+	MaxSize int64         `yaml:"max_size" flag:"upload-max-size" usage:"largest file accepted by resumable uploads, in bytes"`
+	Expiry  time.Duration `yaml:"expiry" flag:"upload-expiry" usage:"time after which abandoned resumable uploads are removed"`
+}
+
+// corsConfig lets pages on other origins call the API from the browser. Lists
+// are comma-separated.
+type corsConfig struct {
+	Origins        string        `yaml:"origins" flag:"cors-origins" usage:"origins allowed to call the API from a browser, e.g. https://app.example.com,https://*.example.com; * allows any but not with credentials"`
+	Methods        string        `yaml:"methods" flag:"cors-methods" usage:"methods allowed in addition to GET, HEAD and POST"`
+	Headers        string        `yaml:"headers" flag:"cors-headers" usage:"request headers scripts may send; * allows any"`
+	ExposedHeaders string        `yaml:"exposed_headers" flag:"cors-exposed-headers" usage:"response headers scripts may read"`
+	Credentials    bool          `yaml:"credentials" flag:"cors-credentials" usage:"allow cookies, such as the refresh cookie of /web, on cross-origin requests"`
+	MaxAge         time.Duration `yaml:"max_age" flag:"cors-max-age" usage:"how long browsers may cache a preflight response"`
+}
+
+// defaultCORS allows the tus client headers and exposes the tus, rate limit
+// and request ID headers.
+func defaultCORS() corsConfig {
+	return corsConfig{
+		Origins:        "http://localhost:8090",
+		Methods:        "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS",
+		Headers:        "Accept, Accept-Language, Content-Type, Authorization, X-CSRF-Token, X-Request-ID, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata",
+		ExposedHeaders: "Location, Content-Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After, X-Request-ID",
+		Credentials:    true,
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Policy returns the CORS policy of the configuration.
+func (c corsConfig) Policy() (*cors.Policy, error) {
+	return cors.New(cors.Options{
+		AllowedOrigins:   strings.Split(c.Origins, ","),
+		AllowedMethods:   strings.Split(c.Methods, ","),
+		AllowedHeaders:   strings.Split(c.Headers, ","),
+		ExposedHeaders:   strings.Split(c.ExposedHeaders, ","),
+		AllowCredentials: c.Credentials,
+		MaxAge:           c.MaxAge,
+	})
+}
+
+func defaultConfig() appConfig {
+	cfg := appConfig{
+		Domain:    "example.com",
+		Server:    config.DefaultServer(8090),
+		Database:  config.DefaultDatabase(),
+		Auth:      config.DefaultAuth(),
+		Storage:   config.DefaultStorage(),
+		URLExpiry: 15 * time.Minute,
+		Uploads:   uploadsConfig{MaxSize: resumable.DefaultMaxSize, Expiry: resumable.DefaultExpiry},
+		Log:       config.DefaultLog(),
+		Tracing:   config.DefaultTracing(),
+		TLS:       config.DefaultTLS(),
+		RateLimit: config.DefaultRateLimit(),
+		CORS:      defaultCORS(),
+	}
+	// ファイルはcmd/webが配信する
+	cfg.Storage.BaseURL = "http://localhost:8080/static/img/"
+	return cfg
+}
+
+func (c appConfig) Validate() error {
+	var errs []error
+	if c.Domain == "" {
+		errs = append(errs, errors.New("domain is required"))
+	}
+	if c.Uploads.MaxSize <= 0 {
+		errs = append(errs, errors.New("upload-max-size must be positive"))
+	}
+	if _, err := c.CORS.Policy(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors-max-age must not be negative"))
+	}
+	errs = append(errs,
+		c.Server.Validate(),
+		c.Database.Validate(),
+		c.Auth.Validate(),
+		c.Storage.Validate(),
+		c.Log.Validate(),
+		c.Metrics.Validate(),
+		c.Tracing.Validate(),
+		c.TLS.Validate(),
+		c.Proxy.Validate(),
+		c.RateLimit.Validate(),
+	)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_defaultConfig(t *testing.T) {
+	cfg := defaultConfig()
+	if err := config.Load("api", &cfg, nil); err != nil {
+		t.Fatalf("expected the defaults to be valid but got %v", err)
+	}
+	if cfg.Server.Addr() != ":8090" {
+		t.Errorf("expected the api on :8090 but got %s", cfg.Server.Addr())
+	}
+}
+
+func Test_appConfig_flags(t *testing.T) {
+	// これまでのフラグはそのまま使える
+	cfg := defaultConfig()
+	err := config.Load("api", &cfg, []string{
+		"-dsn", "host=db",
+		"-jwt-secret", strings.Repeat("s", 32),
+		"-storage", "s3",
+		"-upload-expiry", "2h",
+		"-metrics-addr", "127.0.0.1:9090",
+		"-shutdown-timeout", "10s",
+		"-port", "9000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database.DSN != "host=db" || cfg.Storage.Backend != "s3" || cfg.Metrics.Addr != "127.0.0.1:9090" {
+		t.Errorf("flags not applied: %+v", cfg)
+	}
+	if cfg.Uploads.Expiry != 2*time.Hour || cfg.Server.ShutdownTimeout != 10*time.Second || cfg.Server.Port != 9000 {
+		t.Errorf("flags not applied: %+v", cfg)
+	}
+}
+
+func Test_appConfig_Validate(t *testing.T) {
+	var tests = []struct {
+		name          string
+		args          []string
+		expectedError string
+	}{
+		{"short jwt secret", []string{"-jwt-secret", "short"}, "jwt-secret"},
+		{"empty domain", []string{"-domain", ""}, "domain is required"},
+		{"negative upload size", []string{"-upload-max-size", "-1"}, "upload-max-size"},
+		{"several errors", []string{"-domain", "", "-log-format", "xml"}, "log-format"},
+		{"any origin with credentials", []string{"-cors-origins", "*"}, "credentials"},
+		{"invalid origin pattern", []string{"-cors-origins", "https://*.*.example.com"}, "invalid origin"},
+		{"negative cors max age", []string{"-cors-max-age", "-1s"}, "cors-max-age"},
+	}
+
+	for _, e := range tests {
+		cfg := defaultConfig()
+		err := config.Load("api", &cfg, e.args)
+		if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("%s: expected an error containing %q but got %v", e.name, e.expectedError, err)
+		}
+	}
+}
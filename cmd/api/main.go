@@ -1,42 +1,185 @@
 package main
 
 import (
-	"flag"
-	"fmt"
+	"context"
+	"database/sql"
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/clientip"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/cors"
+	"go_test_prac/webApp/pkg/health"
+	"go_test_prac/webApp/pkg/metrics"
+	"go_test_prac/webApp/pkg/ratelimit"
 	"go_test_prac/webApp/pkg/repository"
 	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/resumable"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
+	"go_test_prac/webApp/pkg/tracing"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-const port = 8090
-
 type application struct {
 	DSN string
 	DB repository.DatabaseRepo
 	Domain string
 	JWTSecret string
+	Storage storage.Blob
+	Avatars *avatar.Store
+	Uploads *resumable.Manager
+	Scanner scanner.UploadScanner
+	URLExpiry time.Duration
+	Metrics *appMetrics
+	MetricsAddr string
+	MetricsUser string
+	MetricsPassword string
+	Health *health.Checker
+	ClientIP *clientip.Resolver
+	SecureCookies bool
+	RateLimiter *ratelimit.Limiter
+	RateLimits config.RateLimit
+	CORS *cors.Policy
 }
 
 func main() {
-	var app application
-	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for the application, e.g company.com")
-	flag.StringVar(&app.DSN, "dsn", "host=localhost user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "2dce505d96a53c5768052ee90fsdf2055657518ad489160df9913f66042e160", "singning secret for JWT")
-	flag.Parse()
+	// 既定値 < 設定ファイル < 環境変数 < フラグ の順に読み込む
+	cfg := defaultConfig()
+	config.MustLoad("api", &cfg)
+
+	app := application{
+		DSN:             cfg.Database.DSN,
+		Domain:          cfg.Domain,
+		JWTSecret:       cfg.Auth.JWTSecret,
+		URLExpiry:       cfg.URLExpiry,
+		MetricsAddr:     cfg.Metrics.Addr,
+		MetricsUser:     cfg.Metrics.User,
+		MetricsPassword: cfg.Metrics.Password,
+		// TLSが有効な場合だけCookieにSecureを付ける(HTTPでは送られなくなるため)
+		SecureCookies: cfg.TLS.Enabled(),
+		RateLimits:    cfg.RateLimit,
+	}
+	serverConfig := cfg.Server.ServerConfig()
+
+	// log.Printなども含めて、すべてのログをこのロガーで出力する
+	logger, err := requestlog.NewLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	// 別のオリジンのSPAからブラウザで呼べるようにする
+	app.CORS, err = cfg.CORS.Policy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// X-Forwarded-Forなどは信頼できるプロキシから届いたものだけを使う
+	app.ClientIP, err = cfg.Proxy.Resolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exporter, err := tracing.New(cfg.Tracing.Exporter, os.Stdout, cfg.Tracing.OTLPEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var provider *tracing.Provider
+	if exporter != nil {
+		provider = tracing.NewProvider("api", exporter, cfg.Tracing.SampleRatio)
+		tracing.SetProvider(provider)
+	}
+
+	store, err := storage.New(cfg.Storage.StorageConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// S3への呼び出しにもトレースコンテキストを渡す
+	if s3, ok := store.(*storage.S3); ok {
+		s3.Client.Transport = &tracing.Transport{}
+	}
+	app.Storage = store
+	app.Scanner = scanner.New(cfg.Clamd)
+	app.Avatars = avatar.New(store)
+	app.Avatars.Scanner = app.Scanner
+	app.Uploads = resumable.NewManager(store)
+	app.Uploads.MaxSize = cfg.Uploads.MaxSize
+	app.Uploads.Expiry = cfg.Uploads.Expiry
 
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
 
 	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if exporter != nil {
+		app.DB = &dbrepo.TracingDBRepo{Repo: app.DB}
+	}
+
+	// 複数のインスタンスで制限を共有する場合はDBに数える
+	rateLimits := ratelimit.NewStore(cfg.RateLimit.Backend, conn)
+	app.RateLimiter = app.newRateLimiter(rateLimits)
+
+	app.Health = health.NewServerChecker(app.DB, dbrepo.Schema, app.Storage)
+	app.Metrics = newAppMetrics()
+	metrics.RegisterDBStats(app.Metrics.Registry, conn)
+
+	// SIGINTかSIGTERMを受けたら、処理中のリクエストを待ってから終了する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Starting api on port %d\n", port)
+	// 放置されたアップロードを定期的に削除する
+	go app.expireUploads(ctx, time.Hour)
+	// 参照されなくなったファイルを定期的に削除する
+	go app.collectGarbage(ctx, time.Hour)
+	// 使われなくなったレート制限のカウンターを消す
+	go ratelimit.SweepEvery(ctx, rateLimits, time.Minute, cfg.RateLimit.Idle())
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	srv := serverConfig.New(cfg.Server.Addr(), app.routes())
+	servers := []*http.Server{srv}
+	if cfg.TLS.Enabled() {
+		redirect, err := serverConfig.EnableTLS(ctx, cfg.TLS.TLSConfig(), srv, cfg.Server.Port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if redirect != nil {
+			servers = append(servers, redirect)
+		}
+	}
+	if app.MetricsAddr != "" {
+		servers = append(servers, serverConfig.New(app.MetricsAddr, app.metricsRoutes()))
+		slog.Info("serving metrics", "addr", app.MetricsAddr)
+	}
+
+	slog.Info("starting api", "port", cfg.Server.Port, "tls", cfg.TLS.Enabled())
+
+	// 停止中は/readyzを503にして、新しいリクエストが来ないようにする
+	err = serverConfig.Run(ctx, app.Health.Shutdown, servers...)
+
+	closeConnections(conn, provider)
 	if err != nil {
 		log.Fatal(err)
 	}
+	slog.Info("stopped api")
+}
+
+// closeConnections closes the database pool and exports the remaining spans
+// once the server has stopped.
+func closeConnections(conn *sql.DB, provider *tracing.Provider) {
+	if err := conn.Close(); err != nil {
+		slog.Error("closing database", "err", err)
+	}
+	if provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Error("exporting remaining spans", "err", err)
+		}
+	}
 }
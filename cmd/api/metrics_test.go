@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_app_metrics(t *testing.T) {
+	saved := app.Metrics
+	defer func() { app.Metrics = saved }()
+	app.Metrics = newAppMetrics()
+
+	routes := app.routes()
+
+	// ログインの成功と失敗を数える
+	for _, body := range []string{`{"email":"admin@example.com","password":"secret"}`, `{"email":"admin@example.com","password":"wrong"}`} {
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(body))
+		routes.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest("GET", "/nothing-here", nil)
+	routes.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := app.Metrics.Logins.Value("success"); got != 1 {
+		t.Errorf("expected 1 successful login but got %g", got)
+	}
+	if got := app.Metrics.Logins.Value("failure"); got != 1 {
+		t.Errorf("expected 1 failed login but got %g", got)
+	}
+	if got := app.Metrics.Tokens.Value("password"); got != 1 {
+		t.Errorf("expected 1 issued token pair but got %g", got)
+	}
+
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, line := range []string{
+		`auth_logins_total{result="success"} 1`,
+		`auth_logins_total{result="failure"} 1`,
+		`http_requests_total{method="POST",route="/auth",status="200"} 1`,
+		`http_requests_total{method="POST",route="/auth",status="401"} 1`,
+		`http_request_duration_seconds_count{method="POST",route="/auth"} 2`,
+		// パスごとに系列を作らない
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, body)
+		}
+	}
+}
+
+func Test_app_metrics_protected(t *testing.T) {
+	saved := app
+	defer func() { app = saved }()
+
+	// ベーシック認証
+	app.MetricsUser = "prometheus"
+	app.MetricsPassword = "scrape"
+
+	var tests = []struct {
+		name         string
+		user         string
+		password     string
+		expectedCode int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "prometheus", "wrong", http.StatusUnauthorized},
+		{"valid", "prometheus", "scrape", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if e.user != "" {
+			req.SetBasicAuth(e.user, e.password)
+		}
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+
+	// 別のアドレスで配信する場合はAPIには出さない
+	app.MetricsAddr = "127.0.0.1:9090"
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected /metrics to be off the api router but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// openAPIDocument is the part of an OpenAPI document the contract tests use.
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `json:"parameters"`
+		Responses  map[string]openAPIResponse  `json:"responses"`
+		Schemas    map[string]json.RawMessage  `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters []openAPIParameter         `json:"parameters"`
+	Responses  map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema json.RawMessage `json:"schema"`
+	} `json:"content"`
+}
+
+var (
+	loadSpecOnce sync.Once
+	spec         openAPIDocument
+	specErr      error
+)
+
+func loadSpec(t *testing.T) *openAPIDocument {
+	t.Helper()
+	loadSpecOnce.Do(func() {
+		specErr = json.Unmarshal(openAPISpec, &spec)
+	})
+	if specErr != nil {
+		t.Fatalf("openapi.json: %s", specErr)
+	}
+	return &spec
+}
+
+// operation returns the operation documented for method and path.
+func (d *openAPIDocument) operation(method, path string) (*openAPIOperation, bool) {
+	raw, ok := d.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return nil, false
+	}
+	var op openAPIOperation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return nil, false
+	}
+	return &op, true
+}
+
+// parameter resolves a reference to components/parameters.
+func (d *openAPIDocument) parameter(p openAPIParameter) openAPIParameter {
+	if p.Ref != "" {
+		return d.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+	}
+	return p
+}
+
+// httpMethods are the keys of a path item that are operations.
+var httpMethods = []string{"get", "head", "post", "put", "patch", "delete", "options"}
+
+// undocumented are routes that are not part of the API.
+var undocumented = map[string]bool{
+	"/":        true, // the single page application in ./html/
+	"/metrics": true, // Prometheus, not for API clients
+	"/healthz": true, // probes of the orchestrator
+	"/readyz":  true,
+}
+
+// chiRoutes returns every route of the router as "METHOD /path".
+func chiRoutes(t *testing.T) map[string]bool {
+	t.Helper()
+	routes := make(map[string]bool)
+	err := chi.Walk(app.routes().(chi.Routes), func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if !undocumented[route] {
+			routes[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+// ルーターとopenapi.jsonのルートが一致することを確認する
+func Test_app_routes_openAPI(t *testing.T) {
+	d := loadSpec(t)
+
+	documented := make(map[string]bool)
+	for path, item := range d.Paths {
+		for _, method := range httpMethods {
+			if _, ok := item[method]; ok {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routes := chiRoutes(t)
+	var missing, stale []string
+	for route := range routes {
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range documented {
+		if !routes[route] {
+			stale = append(stale, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+
+	for _, route := range missing {
+		t.Errorf("%s is routed but missing from openapi.json", route)
+	}
+	for _, route := range stale {
+		t.Errorf("%s is in openapi.json but not routed", route)
+	}
+}
+
+// パスの{userID}などのパラメータがすべて定義されていることを確認する
+func Test_openAPI_pathParameters(t *testing.T) {
+	d := loadSpec(t)
+	placeholder := regexp.MustCompile(`\{([^}]+)\}`)
+
+	for path, item := range d.Paths {
+		var common []openAPIParameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &common); err != nil {
+				t.Fatalf("%s: %s", path, err)
+			}
+		}
+
+		for _, method := range httpMethods {
+			op, ok := d.operation(method, path)
+			if !ok {
+				continue
+			}
+
+			declared := make(map[string]bool)
+			for _, p := range append(common, op.Parameters...) {
+				if p = d.parameter(p); p.In == "path" {
+					declared[p.Name] = true
+				}
+			}
+			for _, m := range placeholder.FindAllStringSubmatch(path, -1) {
+				if !declared[m[1]] {
+					t.Errorf("%s %s: path parameter %s is not declared", strings.ToUpper(method), path, m[1])
+				}
+				delete(declared, m[1])
+			}
+			for name := range declared {
+				t.Errorf("%s %s: parameter %s is not in the path", strings.ToUpper(method), path, name)
+			}
+		}
+	}
+}
+
+func Test_app_openAPI(t *testing.T) {
+	for _, target := range []string{"/openapi.json", "/docs"} {
+		req := httptest.NewRequest("GET", target, nil)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || rr.Body.Len() == 0 {
+			t.Errorf("%s: expected a page but got %d", target, rr.Code)
+		}
+		checkResponse(t, target, "GET", target, rr)
+	}
+}
+
+// handlerRoutes are the routes of the handlers exercised by the handler tests,
+// keyed by the first word of the test case names.
+var handlerRoutes = map[string][2]string{
+	"allUsers":            {"GET", "/users/"},
+	"getUser":             {"GET", "/users/{userID}"},
+	"deleteUser":          {"DELETE", "/users/{userID}"},
+	"insertUser":          {"PUT", "/users/{userID}"},
+	"updateUser":          {"PATCH", "/users/{userID}"},
+	"allUserImages":       {"GET", "/users/{userID}/images"},
+	"setCurrentUserImage": {"PUT", "/users/{userID}/images/{imageID}/current"},
+	"deleteUserImage":     {"DELETE", "/users/{userID}/images/{imageID}"},
+	"importUsers":         {"POST", "/users/import"},
+	"exportUsers":         {"GET", "/users/export"},
+	"auditEvents":         {"GET", "/audit-events/"},
+	"allAPIKeys":          {"GET", "/api-keys/"},
+	"createAPIKey":        {"POST", "/api-keys/"},
+	"revokeAPIKey":        {"DELETE", "/api-keys/{keyID}"},
+}
+
+// checkHandlerResponse validates the response of the handler named by the
+// first word of name, see handlerRoutes.
+func checkHandlerResponse(t *testing.T, name string, rr *httptest.ResponseRecorder) {
+	t.Helper()
+	handler := strings.Fields(name)[0]
+	route, ok := handlerRoutes[handler]
+	if !ok {
+		t.Fatalf("%s: no route known for handler %s", name, handler)
+	}
+	checkResponse(t, name, route[0], route[1], rr)
+}
+
+// checkResponse fails the test when the status, content type or body of rr
+// is not documented for the operation.
+func checkResponse(t *testing.T, name, method, path string, rr *httptest.ResponseRecorder) {
+	t.Helper()
+	d := loadSpec(t)
+
+	op, ok := d.operation(method, path)
+	if !ok {
+		t.Errorf("%s: %s %s is not documented", name, method, path)
+		return
+	}
+
+	response, ok := op.Responses[strconv.Itoa(rr.Code)]
+	if !ok {
+		t.Errorf("%s: status %d is not documented for %s %s", name, rr.Code, method, path)
+		return
+	}
+	if response.Ref != "" {
+		response = d.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+
+	if len(response.Content) == 0 {
+		if rr.Body.Len() > 0 {
+			t.Errorf("%s: expected an empty body for status %d but got %s", name, rr.Code, rr.Body.String())
+		}
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	media, ok := response.Content[mediaType]
+	if !ok {
+		t.Errorf("%s: content type %q is not documented for status %d of %s %s", name, mediaType, rr.Code, method, path)
+		return
+	}
+	// JSON Linesは1つのJSONではないので検証しない
+	if !strings.HasSuffix(mediaType, "json") || mediaType == "application/x-ndjson" {
+		return
+	}
+
+	// components.schemasへの参照が解決できるように、スキーマと一緒に読み込む
+	schema := map[string]interface{}{
+		"components": map[string]interface{}{"schemas": d.Components.Schemas},
+		"allOf":      []json.RawMessage{media.Schema},
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewStringLoader(rr.Body.String()))
+	if err != nil {
+		t.Errorf("%s: validating the body: %s", name, err)
+		return
+	}
+	for _, e := range result.Errors() {
+		t.Errorf("%s: %s %s %d: %s", name, method, path, rr.Code, e)
+	}
+}
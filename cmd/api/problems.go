@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/imaging"
+	"go_test_prac/webApp/pkg/resumable"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/userio"
+	"go_test_prac/webApp/pkg/validation"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Every error response is a problem details object (RFC 7807) sent as
+// application/problem+json:
+//
+//	{
+//	  "type": "https://example.com/problems/user_not_found",
+//	  "title": "Not Found",
+//	  "status": 404,
+//	  "detail": "user not found",
+//	  "instance": "/users/42",
+//	  "code": "user_not_found",
+//	  "request_id": "host/abcdef-000001",
+//	  "errors": {"email": "unknown field"}
+//	}
+//
+// Clients should branch on code, which never changes for a given failure;
+// detail is meant for people and may be reworded. errors is only present when
+// individual fields of the request were rejected, and rows only when rows of
+// an import were.
+
+// problemContentType is the media type of error responses.
+const problemContentType = "application/problem+json"
+
+// problem is the body of an error response.
+type problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code"`
+	RequestID string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	Rows      []userio.RowError `json:"rows,omitempty"`
+}
+
+// apiError is an error with a stable code. Handlers return them for failures
+// clients are expected to tell apart; any other error gets the generic code of
+// the response status.
+type apiError struct {
+	Code    string
+	Message string
+	Fields  map[string]string // messages keyed by the name of the rejected field
+	Rows    []userio.RowError // rejected rows of an import
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(code, message string) *apiError {
+	return &apiError{Code: code, Message: message}
+}
+
+var (
+	errAuthRequired        = newAPIError("authentication_required", "a valid access token or API key is required")
+	errAdminRequired       = newAPIError("admin_required", "only administrators may do this")
+	errOwnerRequired       = newAPIError("owner_required", "only the user or an administrator may do this")
+	errInsufficientScope   = newAPIError("insufficient_scope", "the API key does not have the scope for this request")
+	errLoginRequired       = newAPIError("login_required", "API keys cannot create API keys, use an access token")
+	errAPIKeyNotFound      = newAPIError("api_key_not_found", "API key not found")
+	errInvalidCredentials  = newAPIError("invalid_credentials", "invalid email or password")
+	errUnknownUser         = newAPIError("unknown_user", "unknown user")
+	errTokenNotExpiring    = newAPIError("token_not_expiring", "refresh token does not need renewed yet")
+	errNoRefreshCookie     = newAPIError("refresh_token_missing", "no refresh token found in cookie")
+	errSessionRevoked      = newAPIError("session_revoked", "the sessions of the user were revoked, log in again")
+	errUserNotFound        = newAPIError("user_not_found", "user not found")
+	errUserIDMismatch      = newAPIError("user_id_mismatch", "the id in the body is not the user of the URL")
+	errImageNotFound       = newAPIError("image_not_found", "image not found")
+	errFileNotFound        = newAPIError("file_not_found", "file not found")
+	errNoProfilePicture    = newAPIError("no_profile_picture", "the user has no profile picture")
+	errPictureMissing      = newAPIError("picture_missing", "the picture file is missing")
+	errRouteNotFound       = newAPIError("route_not_found", "no such endpoint")
+	errMethodNotAllowed    = newAPIError("method_not_allowed", "the endpoint does not support this method")
+	errUnsupportedTus      = newAPIError("unsupported_tus_version", "unsupported tus version, use "+resumable.Version)
+	errUploadLengthMissing = newAPIError("upload_length_required", "the Upload-Length header is required")
+	errUploadOffsetMissing = newAPIError("upload_offset_required", "the Upload-Offset header is required")
+	errChunkContentType    = newAPIError("unsupported_media_type", "the Content-Type must be application/offset+octet-stream")
+	errRateLimited         = newAPIError("rate_limited", "too many requests, retry after the time in Retry-After")
+	errImportType          = newAPIError("unsupported_media_type", "the Content-Type must be text/csv or application/x-ndjson, or give the format parameter")
+)
+
+// invalidToken rejects a refresh token that could not be verified.
+func invalidToken(err error) *apiError {
+	return newAPIError("invalid_token", err.Error())
+}
+
+// invalidParameter rejects a malformed URL parameter or header.
+func invalidParameter(name, message string) *apiError {
+	return &apiError{
+		Code:    "invalid_parameter",
+		Message: name + " " + message,
+		Fields:  map[string]string{name: message},
+	}
+}
+
+// failedValidation answers 422 Unprocessable Entity with the first message of
+// each rejected field, in the language asked for by Accept-Language.
+func (app *application) failedValidation(w http.ResponseWriter, r *http.Request, errs validation.Errors) {
+	err := &apiError{
+		Code:    "validation_failed",
+		Message: "the request contains invalid fields: " + strings.Join(errs.Fields(), ", "),
+		Fields:  errs.First(validation.CatalogFor(r.Header.Get("Accept-Language"))),
+	}
+	app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
+}
+
+// intURLParam returns the URL parameter name as an integer.
+func intURLParam(r *http.Request, name string) (int, error) {
+	n, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil {
+		return 0, invalidParameter(name, "must be an integer")
+	}
+	return n, nil
+}
+
+// sentinelCodes are the codes of errors returned by other packages.
+var sentinelCodes = []struct {
+	err  error
+	code string
+}{
+	{avatar.ErrTooLarge, "file_too_large"},
+	{avatar.ErrNotAnImage, "not_an_image"},
+	{avatar.ErrNoFile, "file_required"},
+	{imaging.ErrUnsupportedFormat, "unsupported_image_format"},
+	{imaging.ErrTooManyPixels, "image_too_large"},
+	{scanner.ErrInfected, "malware_detected"},
+	{scanner.ErrUnavailable, "scan_unavailable"},
+	{scanner.ErrTooLargeToScan, "too_large_to_scan"},
+	{resumable.ErrNotFound, "upload_not_found"},
+	{resumable.ErrExpired, "upload_expired"},
+	{resumable.ErrOffsetMismatch, "offset_mismatch"},
+	{resumable.ErrIncomplete, "upload_incomplete"},
+	{resumable.ErrLocked, "upload_locked"},
+	{resumable.ErrTooLarge, "upload_too_large"},
+	{resumable.ErrChunkTooLarge, "chunk_too_large"},
+	{resumable.ErrExceedsLength, "exceeds_upload_length"},
+	{resumable.ErrInvalidLength, "invalid_upload_length"},
+	{resumable.ErrInvalidMetadata, "invalid_upload_metadata"},
+}
+
+// statusCodes are the codes of errors without a code of their own.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusLocked:                "locked",
+	http.StatusTooEarly:              "too_early",
+	http.StatusTooManyRequests:       "too_many_requests",
+}
+
+// errorCode returns the stable code of err, answered with status.
+func errorCode(err error, status int) string {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	for _, s := range sentinelCodes {
+		if errors.Is(err, s.err) {
+			return s.code
+		}
+	}
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return "internal_error"
+	}
+	return "error"
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/resumable"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_errorCode(t *testing.T) {
+	var tests = []struct {
+		name     string
+		err      error
+		status   int
+		expected string
+	}{
+		{"coded error", errImageNotFound, http.StatusNotFound, "image_not_found"},
+		{"wrapped coded error", fmt.Errorf("loading: %w", errUserNotFound), http.StatusNotFound, "user_not_found"},
+		{"sentinel", avatar.ErrTooLarge, http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"wrapped sentinel", fmt.Errorf("patch: %w", resumable.ErrOffsetMismatch), http.StatusConflict, "offset_mismatch"},
+		{"plain error", errors.New("nope"), http.StatusConflict, "conflict"},
+		{"server error", errors.New("boom"), http.StatusBadGateway, "internal_error"},
+		{"unknown status", errors.New("teapot"), http.StatusTeapot, "error"},
+	}
+
+	for _, e := range tests {
+		if code := errorCode(e.err, e.status); code != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, code)
+		}
+	}
+}
+
+func Test_intURLParam(t *testing.T) {
+	var tests = []struct {
+		name        string
+		value       string
+		expected    int
+		expectedErr bool
+	}{
+		{"number", "42", 42, false},
+		{"not a number", "YYY", 0, true},
+		{"empty", "", 0, true},
+	}
+
+	for _, e := range tests {
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.value)
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		n, err := intURLParam(req, "userID")
+		if (err != nil) != e.expectedErr || n != e.expected {
+			t.Errorf("%s: unexpected result %d, %v", e.name, n, err)
+		}
+
+		var apiErr *apiError
+		if e.expectedErr && (!errors.As(err, &apiErr) || apiErr.Fields["userID"] == "") {
+			t.Errorf("%s: expected an error for the userID field but got %v", e.name, err)
+		}
+	}
+}
+
+func Test_app_failedValidation(t *testing.T) {
+	user := data.User{FirstName: "", LastName: "user", Email: "not an address", IsAdmin: 2}
+	errs := user.Validate()
+
+	var tests = []struct {
+		name           string
+		acceptLanguage string
+		expectedEmail  string
+	}{
+		{"default language", "", "must be a valid email address"},
+		{"japanese", "ja-JP,ja;q=0.9,en;q=0.8", "有効なメールアドレスを入力してください"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("PUT", "/users/", nil)
+		req.Header.Set("Accept-Language", e.acceptLanguage)
+		rr := httptest.NewRecorder()
+
+		app.failedValidation(rr, req, errs)
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status 422 but got %d", e.name, rr.Code)
+		}
+		var p problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Code != "validation_failed" || len(p.Errors) != 3 {
+			t.Errorf("%s: unexpected problem %+v", e.name, p)
+		}
+		if p.Errors["email"] != e.expectedEmail {
+			t.Errorf("%s: expected %q for email but got %q", e.name, e.expectedEmail, p.Errors["email"])
+		}
+		if p.Detail != "the request contains invalid fields: email, first_name, is_admin" {
+			t.Errorf("%s: unexpected detail %q", e.name, p.Detail)
+		}
+	}
+}
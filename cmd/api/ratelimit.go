@@ -0,0 +1,58 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/ratelimit"
+	"net/http"
+	"strconv"
+)
+
+// newRateLimiter returns a limiter answering rejected requests with
+// problem+json.
+func (app *application) newRateLimiter(store ratelimit.Store) *ratelimit.Limiter {
+	l := ratelimit.New(store)
+	l.Denied = func(w http.ResponseWriter, r *http.Request, res ratelimit.Result) {
+		app.errorJSON(w, r, errRateLimited, http.StatusTooManyRequests)
+	}
+	return l
+}
+
+// authRateLimit limits login and token refresh per client address, before
+// anyone is known, to slow down password guessing.
+func (app *application) authRateLimit() func(http.Handler) http.Handler {
+	return app.RateLimiter.Limit(ratelimit.Policy{
+		Name:  "auth",
+		Limit: app.RateLimits.Auth,
+		Key:   ratelimit.IP(app.ClientIP),
+	})
+}
+
+// clientRateLimit limits the routes needing a token or API key per client
+// address. It comes before authRequired, so that a client sending invalid
+// tokens or keys is limited too.
+func (app *application) clientRateLimit() func(http.Handler) http.Handler {
+	return app.RateLimiter.Limit(ratelimit.Policy{
+		Name:  "clients",
+		Limit: app.RateLimits.Clients,
+		Key:   ratelimit.IP(app.ClientIP),
+	})
+}
+
+// usersRateLimit limits the user routes per API key, or per user for access
+// tokens, so that a script using a key does not use up the limit of its owner
+// in the browser. It must come after authRequired.
+func (app *application) usersRateLimit() func(http.Handler) http.Handler {
+	return app.RateLimiter.Limit(ratelimit.Policy{
+		Name:  "users",
+		Limit: app.RateLimits.Users,
+		Key:   ratelimit.First(apiKeyRateLimitKey, ratelimit.User(), ratelimit.IP(app.ClientIP)),
+	})
+}
+
+// apiKeyRateLimitKey counts requests per API key, as verified by authRequired.
+func apiKeyRateLimitKey(r *http.Request) (string, bool) {
+	p := principalFrom(r.Context())
+	if p == nil || p.Key == nil {
+		return "", false
+	}
+	return "key:" + strconv.Itoa(p.Key.ID), true
+}
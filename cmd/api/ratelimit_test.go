@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rateLimitedApp returns a copy of app limiting to two requests a minute.
+func rateLimitedApp() *application {
+	a := app
+	a.RateLimiter = a.newRateLimiter(ratelimit.NewMemory())
+	a.RateLimits = config.RateLimit{
+		Backend: "memory",
+		Auth:    ratelimit.Limit{Requests: 2, Period: time.Minute},
+		Users:   ratelimit.Limit{Requests: 2, Period: time.Minute},
+	}
+	return &a
+}
+
+func Test_app_authRateLimit(t *testing.T) {
+	a := rateLimitedApp()
+	routes := a.routes()
+
+	var tests = []struct {
+		name         string
+		method       string
+		target       string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{"first login", "POST", "/auth", "192.0.2.1:1234", http.StatusUnauthorized},
+		{"refresh counts against the same limit", "POST", "/refresh-token", "192.0.2.1:1234", http.StatusBadRequest},
+		{"limited", "POST", "/auth", "192.0.2.1:1234", http.StatusTooManyRequests},
+		{"limited through the web routes", "GET", "/web/refresh-token", "192.0.2.1:1234", http.StatusTooManyRequests},
+		{"other client", "POST", "/auth", "192.0.2.2:1234", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.target, strings.NewReader(`{}`))
+		req.RemoteAddr = e.remoteAddr
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("RateLimit-Policy") != "2;w=60" {
+			t.Errorf("%s: expected RateLimit-Policy 2;w=60 but got %q", e.name, rr.Header().Get("RateLimit-Policy"))
+		}
+		if rr.Code != http.StatusTooManyRequests {
+			continue
+		}
+		checkResponse(t, e.name, e.method, e.target, rr)
+
+		var p problem
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if p.Code != "rate_limited" {
+			t.Errorf("%s: expected code rate_limited but got %q", e.name, p.Code)
+		}
+		if rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected Retry-After", e.name)
+		}
+	}
+}
+
+func Test_app_usersRateLimit(t *testing.T) {
+	a := rateLimitedApp()
+	routes := a.routes()
+	tokens, _ := a.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+
+	// 同じアドレスでもユーザーごとに数える
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/users/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("request %d: expected status %d but got %d", i+1, expected, rr.Code)
+		}
+	}
+
+	// 認証されないリクエストは数えない
+	req := httptest.NewRequest("GET", "/users/", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected 401 without rate limit headers but got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func Test_app_clientRateLimit(t *testing.T) {
+	a := rateLimitedApp()
+	a.RateLimits.Clients = ratelimit.Limit{Requests: 2, Period: time.Minute}
+	routes := a.routes()
+
+	// 認証に失敗するリクエストも、認証が必要なルート全体でアドレスごとに数える
+	var tests = []struct {
+		name         string
+		target       string
+		credentials  string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{"bad token", "/users/", "invalid", "192.0.2.1:1234", http.StatusUnauthorized},
+		{"bad key", "/api-keys/", apikey.Marker + "invalid", "192.0.2.1:1234", http.StatusUnauthorized},
+		{"limited", "/audit-events/", "invalid", "192.0.2.1:1234", http.StatusTooManyRequests},
+		{"other client", "/users/", "invalid", "192.0.2.2:1234", http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.target, nil)
+		req.RemoteAddr = e.remoteAddr
+		req.Header.Set("Authorization", "Bearer "+e.credentials)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func Test_apiKeyRateLimitKey(t *testing.T) {
+	var tests = []struct {
+		name        string
+		principal   *principal
+		expectedKey string
+		expectedOK  bool
+	}{
+		{"api key", &principal{UserID: 1, Key: &data.APIKey{ID: 7, UserID: 1}}, "key:7", true},
+		{"access token", &principal{UserID: 1}, "", false},
+		{"not authenticated", nil, "", false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/users/", nil)
+		if e.principal != nil {
+			req = withPrincipal(req, e.principal)
+		}
+		key, ok := apiKeyRateLimitKey(req)
+		if key != e.expectedKey || ok != e.expectedOK {
+			t.Errorf("%s: expected %q, %t but got %q, %t", e.name, e.expectedKey, e.expectedOK, key, ok)
+		}
+	}
+}
+
+func Test_app_usersRateLimit_apiKey(t *testing.T) {
+	a := rateLimitedApp()
+	var called int
+	handler := a.usersRateLimit()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+
+	// キーとアクセストークンは別々に数える
+	token := &principal{UserID: 1}
+	key := &principal{UserID: 1, Key: &data.APIKey{ID: 7, UserID: 1}}
+	for i, p := range []*principal{key, key, token, token} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, withPrincipal(httptest.NewRequest("GET", "/users/", nil), p))
+		if rr.Code != http.StatusOK {
+			t.Errorf("request %d: expected status 200 but got %d", i+1, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, withPrincipal(httptest.NewRequest("GET", "/users/", nil), key))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the third request with the key to be limited but got %d", rr.Code)
+	}
+	if called != 4 {
+		t.Errorf("expected 4 requests through but got %d", called)
+	}
+}
+
+func Test_app_rateLimit_off(t *testing.T) {
+	// テストの他の部分と同じく、RateLimiterがなければ制限しない
+	routes := app.routes()
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{}`))
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		if rr.Code == http.StatusTooManyRequests {
+			t.Fatal("expected no limit without a limiter")
+		}
+	}
+}
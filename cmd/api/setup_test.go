@@ -1,9 +1,15 @@
 package main
 
 import (
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/health"
 	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/resumable"
+	"go_test_prac/webApp/pkg/storage"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var app application
@@ -14,5 +20,13 @@ func TestMain(m *testing.M) {
 	app.DB = &dbrepo.TestDBRepo{}
 	app.Domain = "example.com"
 	app.JWTSecret = "2dce505d96a53c5768052ee90fsdf2055657518ad489160df9913f66042e160"
+	app.Storage, _ = storage.NewLocal(filepath.Join(os.TempDir(), "api-test-uploads"), "http://localhost:8080/static/img/", "secret")
+	app.Avatars = avatar.New(app.Storage)
+	app.Uploads = resumable.NewManager(app.Storage)
+	app.URLExpiry = 15 * time.Minute
+	app.Metrics = newAppMetrics()
+	app.Health = health.NewServerChecker(app.DB, dbrepo.Schema, app.Storage)
+	app.SecureCookies = true
+	app.CORS, _ = defaultCORS().Policy()
 	os.Exit(m.Run())
 }
@@ -3,8 +3,11 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/requestlog"
 	"io"
 	"net/http"
+	"strings"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -42,21 +45,49 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
+// errorJSON answers with a problem details object (see problems.go). The
+// status defaults to 400 Bad Request. Internal errors are logged with the
+// request ID and their message is never sent to the client.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
 	statusCode := http.StatusBadRequest
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
-	type jsonError struct {
-		Message string `json:"message"`
+	p := problem{
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Detail:    err.Error(),
+		Instance:  r.URL.Path,
+		Code:      errorCode(err, statusCode),
+		RequestID: requestlog.RequestID(r.Context()),
 	}
+	p.Type = "https://" + app.Domain + "/problems/" + p.Code
 
-	theError := jsonError{
-		Message: err.Error(),
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		p.Errors = apiErr.Fields
+		p.Rows = apiErr.Rows
 	}
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	// 内部エラーの詳細はログにだけ残し、クライアントにはリクエストIDで問い合わせてもらう
+	if statusCode >= http.StatusInternalServerError {
+		requestlog.FromContext(r.Context()).Error("internal error", "method", r.Method, "path", r.URL.Path, "err", err)
+		p.Detail = "an internal error occurred, please report the request ID if it persists"
+		p.Errors = nil
+		p.Rows = nil
+	}
+
+	out, err := json.Marshal(p)
+	if err != nil {
+		requestlog.FromContext(r.Context()).Error("encoding the problem", "err", err)
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(out)
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -68,14 +99,52 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 	// attempt to decode the data
 	err := dec.Decode(data)
 	if err != nil {
-		return err
+		return invalidJSON(err)
 	}
 
 	// make sure only one JSON value in payload
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return newAPIError("invalid_json", "body must only contain a single JSON value")
 	}
 
 	return nil
 }
+
+// invalidJSON explains why a request body could not be decoded. Fields of the
+// wrong type and unknown fields are reported by name.
+func invalidJSON(err error) error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return newAPIError("invalid_json", fmt.Sprintf("body contains badly-formed JSON (at character %d)", syntaxError.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newAPIError("invalid_json", "body contains badly-formed JSON")
+	case errors.Is(err, io.EOF):
+		return newAPIError("invalid_json", "body must not be empty")
+	case errors.As(err, &typeError):
+		if typeError.Field == "" {
+			return newAPIError("invalid_json", "body contains an incorrect JSON type")
+		}
+		return &apiError{
+			Code:    "invalid_json",
+			Message: "body contains an incorrect JSON type for field " + typeError.Field,
+			Fields:  map[string]string{typeError.Field: "must be of type " + typeError.Type.String()},
+		}
+	case errors.As(err, &maxBytesError):
+		return newAPIError("invalid_json", fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/jsonは未知のフィールドを専用のエラー型で返さない
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &apiError{
+			Code:    "invalid_json",
+			Message: "body contains unknown field " + field,
+			Fields:  map[string]string{field: "unknown field"},
+		}
+	default:
+		return newAPIError("invalid_json", err.Error())
+	}
+}
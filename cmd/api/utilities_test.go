@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/scanner"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_app_errorJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		status         []int
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+		expectedErrors map[string]string
+	}{
+		{"default status", errors.New("something is wrong"), nil, http.StatusBadRequest, "bad_request", "something is wrong", nil},
+		{"coded error", errUserNotFound, []int{http.StatusNotFound}, http.StatusNotFound, "user_not_found", "user not found", nil},
+		{"wrapped sentinel", fmt.Errorf("%w (Eicar-Signature)", scanner.ErrInfected), []int{http.StatusUnprocessableEntity}, http.StatusUnprocessableEntity, "malware_detected", scanner.ErrInfected.Error() + " (Eicar-Signature)", nil},
+		{"field errors", invalidParameter("userID", "must be an integer"), nil, http.StatusBadRequest, "invalid_parameter", "userID must be an integer", map[string]string{"userID": "must be an integer"}},
+		{"internal error", errors.New("pq: password authentication failed"), []int{http.StatusInternalServerError}, http.StatusInternalServerError, "internal_error", "", nil},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/users/1", nil)
+		req = req.WithContext(requestlog.WithRequestID(req.Context(), "req-1"))
+		rr := httptest.NewRecorder()
+
+		app.errorJSON(rr, req, e.err, e.status...)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != problemContentType {
+			t.Errorf("%s: expected content type %s but got %s", e.name, problemContentType, ct)
+		}
+
+		var p problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if p.Code != e.expectedCode || p.Status != e.expectedStatus || p.Title != http.StatusText(e.expectedStatus) {
+			t.Errorf("%s: unexpected problem %+v", e.name, p)
+		}
+		if p.Type != "https://example.com/problems/"+e.expectedCode || p.Instance != "/users/1" || p.RequestID != "req-1" {
+			t.Errorf("%s: unexpected type, instance or request id %+v", e.name, p)
+		}
+		if e.expectedDetail != "" && p.Detail != e.expectedDetail {
+			t.Errorf("%s: expected detail %q but got %q", e.name, e.expectedDetail, p.Detail)
+		}
+		if fmt.Sprint(p.Errors) != fmt.Sprint(e.expectedErrors) {
+			t.Errorf("%s: expected errors %v but got %v", e.name, e.expectedErrors, p.Errors)
+		}
+	}
+}
+
+func Test_app_errorJSON_hidesInternalErrors(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users/", nil)
+	rr := httptest.NewRecorder()
+
+	app.errorJSON(rr, req, errors.New("pq: password authentication failed for user postgres"), http.StatusInternalServerError)
+
+	if strings.Contains(rr.Body.String(), "postgres") {
+		t.Errorf("the internal error leaked to the client: %s", rr.Body.String())
+	}
+}
+
+func Test_app_readJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		body           string
+		expectedErr    bool
+		expectedFields map[string]string
+	}{
+		{"valid", `{"email":"admin@example.com","password":"secret"}`, false, nil},
+		{"empty", ``, true, nil},
+		{"badly formed", `{"email":`, true, nil},
+		{"syntax error", `{"email" "admin@example.com"}`, true, nil},
+		{"wrong type", `{"email":1}`, true, map[string]string{"email": "must be of type string"}},
+		{"unknown field", `{"email":"admin@example.com","name":"admin"}`, true, map[string]string{"name": "unknown field"}},
+		{"two values", `{"email":"a@example.com"}{"email":"b@example.com"}`, true, nil},
+		{"too large", `{"email":"` + strings.Repeat("a", 1024*1024) + `"}`, true, nil},
+	}
+
+	for _, e := range tests {
+		var creds Credentials
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(e.body))
+		rr := httptest.NewRecorder()
+
+		err := app.readJSON(rr, req, &creds)
+		if (err != nil) != e.expectedErr {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.Code != "invalid_json" {
+			t.Errorf("%s: expected an invalid_json error but got %#v", e.name, err)
+			continue
+		}
+		if fmt.Sprint(apiErr.Fields) != fmt.Sprint(e.expectedFields) {
+			t.Errorf("%s: expected fields %v but got %v", e.name, e.expectedFields, apiErr.Fields)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_application_recordsAuditEvents(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		stdin           string
+		expectedAction  string
+		expectedTarget  string
+		expectedChanges string
+	}{
+		{"create", []string{"user", "create", "-email=new@example.com", "-first-name=New", "-last-name=User"}, "", audit.ActionUserCreate, "3",
+			`{"email":{"before":null,"after":"new@example.com"},"first_name":{"before":null,"after":"New"},"is_admin":{"before":null,"after":0},"last_name":{"before":null,"after":"User"}}`},
+		{"update", []string{"user", "update", "2", "-first-name=Janet"}, "", audit.ActionUserUpdate, "2", `{"first_name":{"before":"Jane","after":"Janet"}}`},
+		{"promote", []string{"user", "promote", "jane@example.com"}, "", audit.ActionUserRole, "2", `{"is_admin":{"before":0,"after":1}}`},
+		{"delete", []string{"user", "delete", "2"}, "", audit.ActionUserDelete, "2",
+			`{"email":{"before":"jane@example.com","after":null},"first_name":{"before":"Jane","after":null},"is_admin":{"before":0,"after":null},"last_name":{"before":"Doe","after":null}}`},
+		{"set password", []string{"user", "set-password", "2", "-password-stdin"}, "a new password\n", audit.ActionPasswordSet, "2", `{"password":{"before":"[redacted]","after":"[redacted]"}}`},
+		{"import", []string{"user", "import", "-", "-format=jsonl"}, `{"email":"new@example.com","first_name":"New","last_name":"User"}` + "\n", audit.ActionUsersImport, "", `{"created":{"before":null,"after":1},"updated":{"before":null,"after":0}}`},
+		{"export", []string{"user", "export"}, "", audit.ActionUsersExport, "", `{"format":{"before":null,"after":"csv"}}`},
+	}
+
+	for _, e := range tests {
+		repo := newMemRepo()
+		app, _, _ := newTestApp(repo, e.stdin)
+		run(t, app, e.args...)
+
+		if len(repo.events) != 1 {
+			t.Errorf("%s: expected one event but got %d", e.name, len(repo.events))
+			continue
+		}
+		ev := repo.events[0]
+		if ev.Action != e.expectedAction || ev.TargetID != e.expectedTarget || !strings.HasPrefix(ev.Actor, "cli") || ev.UserAgent != "cli" {
+			t.Errorf("%s: unexpected event %+v", e.name, ev)
+		}
+		if string(ev.Changes) != e.expectedChanges {
+			t.Errorf("%s: expected changes %s but got %s", e.name, e.expectedChanges, ev.Changes)
+		}
+	}
+
+	// 何も変えなかったコマンドは記録しない
+	repo := newMemRepo()
+	app, _, _ := newTestApp(repo, "")
+	_ = app.run([]string{"user", "update", "2"})
+	if len(repo.events) != 0 {
+		t.Errorf("expected no event for a failed command but got %d", len(repo.events))
+	}
+}
+
+func Test_application_sessionRevoke_audit(t *testing.T) {
+	repo := newMemRepo()
+	app, _, _ := newTestApp(repo, "")
+	run(t, app, "session", "revoke", "2")
+
+	if len(repo.events) != 1 || repo.events[0].Action != audit.ActionSessionsRevoke || !strings.Contains(string(repo.events[0].Changes), "revoked_at") {
+		t.Errorf("expected the revocation to be recorded but got %+v", repo.events)
+	}
+}
+
+// auditRepo returns a repository with three recorded events.
+func auditRepo(t *testing.T) *memRepo {
+	t.Helper()
+	repo := newMemRepo()
+	app, _, _ := newTestApp(repo, "")
+	run(t, app, "user", "update", "2", "-first-name=Janet")
+	run(t, app, "user", "promote", "2")
+	run(t, app, "session", "revoke", "1")
+	return repo
+}
+
+func Test_application_auditExport(t *testing.T) {
+	repo := auditRepo(t)
+
+	app, stdout, _ := newTestApp(repo, "")
+	run(t, app, "audit", "export")
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines but got %q", stdout.String())
+	}
+	var first data.AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.ID != 1 || first.Hash == "" {
+		t.Errorf("expected the oldest event first but got %+v (%v)", first, err)
+	}
+
+	stdout.Reset()
+	run(t, app, "audit", "export", "-action=user.update", "-target-id=2", "-since=2000-01-01")
+	if n := strings.Count(stdout.String(), "\n"); n != 1 {
+		t.Errorf("expected the update but not the role change but got %q", stdout.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "audit.csv")
+	run(t, app, "audit", "export", "-format=csv", "-file="+file, "-target-type=user", "-until=2000-01-01")
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil || len(records) != 1 || records[0][0] != "id" || records[0][len(records[0])-1] != "hash" {
+		t.Errorf("expected only the header but got %v (%v)", records, err)
+	}
+
+	for _, args := range [][]string{{"-format=xml"}, {"-since=yesterday"}} {
+		if err := app.run(append([]string{"audit", "export"}, args...)); err == nil {
+			t.Errorf("audit export %v: expected an error", args)
+		}
+	}
+}
+
+func Test_application_auditVerify(t *testing.T) {
+	repo := auditRepo(t)
+	app, stdout, _ := newTestApp(repo, "")
+	app.Output = outputJSON
+	run(t, app, "audit", "verify")
+
+	var v audit.Verification
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Events != 3 || v.LastID != 3 || v.Head != repo.events[2].Hash {
+		t.Errorf("unexpected verification %+v", v)
+	}
+
+	// 2件目を書き換えると、そこで止まる
+	repo.events[1].Actor = "someone else"
+	stdout.Reset()
+	err := app.run([]string{"audit", "verify"})
+	var chainErr *audit.ChainError
+	if !errors.As(err, &chainErr) || chainErr.ID != 2 {
+		t.Errorf("expected the chain to break at event 2 but got %v", err)
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &v); err != nil || v.Events != 1 {
+		t.Errorf("expected 1 event checked but got %+v (%v)", v, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/userio"
+	"os"
+	"sort"
+	"strconv"
+)
+
+func (app *application) userImport(args []string) error {
+	var (
+		format  string
+		mapping string
+		opts    userio.Options
+	)
+	fs := app.flags("user import")
+	fs.StringVar(&format, "format", "", "csv or jsonl; by default the extension of the file")
+	fs.StringVar(&mapping, "map", "", "columns read as other fields, e.g. E-Mail=email,Given name=first_name")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only check the rows")
+	fs.IntVar(&opts.BatchSize, "batch-size", 0, "rows committed together; 0 writes every row in one transaction")
+	positional, err := app.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	name := positional[0]
+	if format == "" {
+		if format = userio.FormatOf(name); format == "" {
+			return fmt.Errorf("cannot tell the format of %s, give -format=csv or -format=jsonl", name)
+		}
+	}
+	if format, err = userio.ParseFormat(format); err != nil {
+		return err
+	}
+	if opts.Mapping, err = userio.ParseMapping(mapping); err != nil {
+		return err
+	}
+
+	r := app.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := userio.Import(context.Background(), app.DB, r, format, opts)
+	if report == nil {
+		return err
+	}
+	// 途中で失敗しても、書き込んだバッチの分は記録する
+	if report.Created+report.Updated > 0 {
+		app.recordAudit(audit.ActionUsersImport, audit.TargetUsers, 0, audit.Diff(nil, map[string]interface{}{"created": report.Created, "updated": report.Updated}))
+	}
+	if printErr := app.printImport(report); printErr != nil {
+		return printErr
+	}
+	if errors.Is(err, userio.ErrInvalidRows) {
+		return fmt.Errorf("%d of %d rows are invalid, nothing was written", len(report.Errors), report.Rows)
+	}
+	return err
+}
+
+// printImport prints the counts of an import and the errors of its rows.
+func (app *application) printImport(report *userio.Report) error {
+	if app.Output == outputJSON {
+		return app.print(report, nil)
+	}
+
+	err := app.print(nil, nil,
+		[]string{"ROWS", strconv.Itoa(report.Rows)},
+		[]string{"CREATED", strconv.Itoa(report.Created)},
+		[]string{"UPDATED", strconv.Itoa(report.Updated)},
+		[]string{"INVALID", strconv.Itoa(len(report.Errors))},
+		[]string{"DRY RUN", strconv.FormatBool(report.DryRun)},
+	)
+	if err != nil || len(report.Errors) == 0 {
+		return err
+	}
+
+	var rows [][]string
+	for _, e := range report.Errors {
+		fields := make([]string, 0, len(e.Errors))
+		for field := range e.Errors {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			rows = append(rows, []string{strconv.Itoa(e.Line), e.Email, field, e.Errors[field]})
+		}
+	}
+	fmt.Fprintln(app.Stdout)
+	return app.print(nil, []string{"LINE", "EMAIL", "FIELD", "ERROR"}, rows...)
+}
+
+func (app *application) userExport(args []string) error {
+	var format, file string
+	fs := app.flags("user export")
+	fs.StringVar(&format, "format", userio.FormatCSV, "csv or jsonl")
+	fs.StringVar(&file, "file", "", "file to write instead of stdout")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+	format, err := userio.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	// -output は使わず、指定された形式でそのまま書く
+	if file == "" {
+		err = userio.Export(context.Background(), app.DB, app.Stdout, format)
+	} else {
+		err = app.exportToFile(file, format)
+	}
+	if err != nil {
+		return err
+	}
+	app.recordAudit(audit.ActionUsersExport, audit.TargetUsers, 0, audit.Diff(nil, map[string]interface{}{"format": format}))
+	return nil
+}
+
+// exportToFile writes every user to the file name.
+func (app *application) exportToFile(name, format string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := userio.Export(context.Background(), app.DB, f, format); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"go_test_prac/webApp/pkg/userio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_application_userImport(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "users.csv")
+	content := "Mail,First,Last,is_admin\njane@example.com,Janet,Doe,1\njohn@example.com,John,Smith,\n"
+	if err := os.WriteFile(csvFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newMemRepo()
+	app, stdout, _ := newTestApp(repo, "")
+	run(t, app, "user", "import", csvFile, "-map=Mail=email,First=first_name,Last=last_name", "-dry-run")
+	if len(repo.users) != 2 || !strings.Contains(stdout.String(), "DRY RUN  true") {
+		t.Errorf("expected a dry run to write nothing, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	run(t, app, "user", "import", csvFile, "-map=Mail=email,First=first_name,Last=last_name", "-batch-size=1", "-output=json")
+	var report userio.Report
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows != 2 || report.Created != 1 || report.Updated != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if jane := repo.users[2]; jane.FirstName != "Janet" || jane.IsAdmin != 1 {
+		t.Errorf("expected jane to be updated but got %+v", jane)
+	}
+	if john, _ := repo.GetUserByEmail("john@example.com"); john == nil || john.IsAdmin != 0 {
+		t.Errorf("expected john to be created but got %+v", john)
+	}
+}
+
+func Test_application_userImport_invalid(t *testing.T) {
+	repo := newMemRepo()
+	input := `{"email":"new@example.com","first_name":"New","last_name":"User"}
+{"email":"jane","first_name":"","last_name":"Doe"}
+`
+	app, stdout, _ := newTestApp(repo, input)
+	err := app.run([]string{"user", "import", "-", "-format=jsonl"})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 rows are invalid") {
+		t.Errorf("expected invalid rows but got %v", err)
+	}
+	if len(repo.users) != 2 {
+		t.Error("expected nothing to be written")
+	}
+	for _, s := range []string{"LINE", "2     jane   email", "2     jane   first_name"} {
+		if !strings.Contains(stdout.String(), s) {
+			t.Errorf("expected %q in %q", s, stdout.String())
+		}
+	}
+
+	for _, args := range [][]string{
+		{"user", "import", "-"},                             // 形式がわからない
+		{"user", "import", "users.csv", "-format=xlsx"},     // 形式が不正
+		{"user", "import", "-", "-format=csv", "-map=x=id"}, // 対応づけが不正
+		{"user", "import", filepath.Join(t.TempDir(), "missing.csv")},
+	} {
+		if err := app.run(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func Test_application_userExport(t *testing.T) {
+	app, stdout, _ := newTestApp(newMemRepo(), "")
+	run(t, app, "user", "export")
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || lines[0] != "id,email,first_name,last_name,is_admin,created_at,updated_at" || !strings.HasPrefix(lines[2], "2,jane@example.com,") {
+		t.Errorf("unexpected export %q", stdout.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "users.jsonl")
+	run(t, app, "user", "export", "-format=jsonl", "-file="+file)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var admin map[string]any
+	if err := json.Unmarshal([]byte(strings.SplitN(string(b), "\n", 2)[0]), &admin); err != nil {
+		t.Fatal(err)
+	}
+	if admin["email"] != "admin@example.com" || admin["is_admin"] != float64(1) {
+		t.Errorf("unexpected first line %v", admin)
+	}
+
+	// エクスポートしたものをそのまま取り込める
+	repo := newMemRepo()
+	app, _, _ = newTestApp(repo, "")
+	run(t, app, "user", "import", file)
+	if len(repo.users) != 2 {
+		t.Errorf("expected the export to update the same users, got %d users", len(repo.users))
+	}
+}
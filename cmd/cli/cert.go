@@ -0,0 +1,42 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/certs"
+	"strings"
+)
+
+// certResult is the output of cert.
+type certResult struct {
+	CertFile string   `json:"cert_file"`
+	KeyFile  string   `json:"key_file"`
+	Hosts    []string `json:"hosts"`
+}
+
+// writeCert writes a self-signed certificate for local development, to be
+// passed to the servers with -tls-cert and -tls-key.
+func (app *application) writeCert(args []string) error {
+	fs := app.flags("cert")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	c := app.Cert
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	var hosts []string
+	for _, h := range strings.Split(c.Hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	if err := certs.WriteSelfSigned(c.CertFile, c.KeyFile, hosts, c.ValidFor); err != nil {
+		return err
+	}
+	return app.print(certResult{CertFile: c.CertFile, KeyFile: c.KeyFile, Hosts: hosts}, nil,
+		[]string{"CERT", c.CertFile},
+		[]string{"KEY", c.KeyFile},
+		[]string{"HOSTS", strings.Join(hosts, ", ")},
+	)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_application_writeCert(t *testing.T) {
+	dir := t.TempDir()
+	app, stdout, _ := newTestApp(nil, "")
+	app.Cert.CertFile = filepath.Join(dir, "cert.pem")
+	app.Cert.KeyFile = filepath.Join(dir, "key.pem")
+	app.Cert.Hosts = "localhost, 127.0.0.1"
+
+	run(t, app, "cert", "-output=json")
+	if _, err := tls.LoadX509KeyPair(app.Cert.CertFile, app.Cert.KeyFile); err != nil {
+		t.Fatalf("expected a usable key pair: %v", err)
+	}
+	var result certResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hosts) != 2 || result.Hosts[1] != "127.0.0.1" {
+		t.Errorf("expected the trimmed hosts but got %v", result.Hosts)
+	}
+
+	app.Cert.ValidFor = 0
+	if err := app.run([]string{"cert"}); err == nil || !strings.Contains(err.Error(), "cert-valid-for") {
+		t.Errorf("expected an invalid lifetime but got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go_test_prac/webApp/pkg/config"
+	"io"
+	"strings"
+)
+
+// errUsage is returned when no command is given, after printing the usage.
+var errUsage = errors.New("usage")
+
+// command is a command of the CLI, such as "user create".
+type command struct {
+	name    string
+	args    string // synopsis of the arguments
+	summary string
+	db      bool // connects to the database first
+	secret  bool // signs or verifies tokens with -jwt-secret
+	run     func(app *application, args []string) error
+}
+
+// commands returns the commands in the order of the usage message.
+func commands() []command {
+	return []command{
+		{name: "user list", summary: "list the users", db: true, run: (*application).userList},
+		{name: "user show", args: "<id|email>", summary: "show a user", db: true, run: (*application).userShow},
+		{name: "user create", args: "-email= -first-name= -last-name= [-admin] [-password-stdin]", summary: "create a user; a password is generated unless read from stdin", db: true, run: (*application).userCreate},
+		{name: "user update", args: "<id|email> [-email=] [-first-name=] [-last-name=]", summary: "change the name or email of a user", db: true, run: (*application).userUpdate},
+		{name: "user delete", args: "<id|email>", summary: "delete a user; cli gc -delete then removes their files", db: true, run: (*application).userDelete},
+		{name: "user set-password", args: "<id|email> [-password-stdin]", summary: "set a new password; one is generated unless read from stdin", db: true, run: (*application).userSetPassword},
+		{name: "user promote", args: "<id|email>", summary: "make a user an admin", db: true, run: (*application).userPromote},
+		{name: "user demote", args: "<id|email>", summary: "take the admin role from a user", db: true, run: (*application).userDemote},
+		{name: "user import", args: "<file|-> [-format=csv|jsonl] [-map=column=field,...] [-dry-run] [-batch-size=0]", summary: "create or update users by email from CSV or JSON Lines; nothing is written if a row is invalid", db: true, run: (*application).userImport},
+		{name: "user export", args: "[-format=csv|jsonl] [-file=]", summary: "write every user as CSV or JSON Lines", db: true, run: (*application).userExport},
+		{name: "token issue", args: "-user=<id|email> [-expires=15m] [-refresh-expires=24h]", summary: "issue the tokens the API gives the user at login", db: true, secret: true, run: (*application).tokenIssue},
+		{name: "token decode", args: "<token>", summary: "print the header and claims of a token without verifying it", run: (*application).tokenDecode},
+		{name: "token verify", args: "<token> [-refresh]", summary: "check the signature, expiry and issuer of a token", secret: true, run: (*application).tokenVerify},
+		{name: "session revoke", args: "<id|email>", summary: "log a user out of the web app and reject their refresh tokens", db: true, run: (*application).sessionRevoke},
+		{name: "audit export", args: "[-format=jsonl|csv] [-file=] [-actor=] [-action=] [-target-type=] [-target-id=] [-since=] [-until=]", summary: "write the audit log, oldest first", db: true, run: (*application).auditExport},
+		{name: "audit verify", summary: "check the chain of hashes of the audit log; prints the head to compare later", db: true, run: (*application).auditVerify},
+		{name: "db ping", summary: "check that the database is reachable and has the expected schema", db: true, run: (*application).dbPing},
+		{name: "gc", args: "[-delete] [-min-age=1h]", summary: "remove uploaded files nothing refers to; only lists them without -delete", db: true, run: (*application).collectGarbage},
+		{name: "cert", summary: "write a self-signed certificate for trying HTTPS locally", run: (*application).writeCert},
+		{name: "help", summary: "show this message", run: (*application).help},
+	}
+}
+
+// run runs the command named by the first words of args.
+func (app *application) run(args []string) error {
+	if len(args) == 0 {
+		app.usage(app.Stderr)
+		return errUsage
+	}
+
+	cmd, rest, ok := findCommand(args)
+	if !ok {
+		return fmt.Errorf("unknown command %q, see cli help", strings.Join(args[:min(len(args), 2)], " "))
+	}
+
+	// -h はデータベースや鍵がなくても表示する
+	if wantsHelp(rest) {
+		return cmd.run(app, rest)
+	}
+	if cmd.secret {
+		if err := (config.Auth{JWTSecret: app.JWTSecret}).Validate(); err != nil {
+			return err
+		}
+	}
+	if cmd.db && app.DB == nil {
+		if err := app.connect(); err != nil {
+			return err
+		}
+	}
+	return cmd.run(app, rest)
+}
+
+// findCommand returns the command named by the first words of args and the
+// arguments after the name.
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands() {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+// wantsHelp reports whether args ask for the flags of a command.
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "-h", "-help", "--h", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+func (app *application) help(args []string) error {
+	app.usage(app.Stdout)
+	return nil
+}
+
+// usage lists the commands.
+func (app *application) usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: cli [flags] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %s\n", strings.TrimSpace(cmd.name+" "+cmd.args))
+		fmt.Fprintf(w, "    \t%s\n", cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command accepts -output=table|json. Run cli -h for the flags shared with the servers.")
+}
+
+// flags returns the flag set of a command, with -output.
+func (app *application) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("cli "+name, flag.ContinueOnError)
+	fs.SetOutput(app.Stderr)
+	fs.StringVar(&app.Output, "output", app.Output, "output format: table|json")
+	return fs
+}
+
+// parse parses the flags of a command, which may come before or after its n
+// positional arguments, and returns the arguments.
+func (app *application) parse(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if err := validOutput(app.Output); err != nil {
+		return nil, err
+	}
+	if len(positional) != n {
+		return nil, fmt.Errorf("%s: expected %d arguments but got %d", fs.Name(), n, len(positional))
+	}
+	return positional, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func Test_application_run(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		secret  string
+		wantErr string
+	}{
+		{"unknown command", []string{"user", "rename"}, testSecret, `unknown command "user rename"`},
+		{"unknown single word", []string{"frobnicate"}, testSecret, `unknown command "frobnicate"`},
+		{"missing argument", []string{"user", "show"}, testSecret, "expected 1 arguments but got 0"},
+		{"extra argument", []string{"user", "list", "all"}, testSecret, "expected 0 arguments but got 1"},
+		{"unknown flag", []string{"user", "list", "-all"}, testSecret, "flag provided but not defined"},
+		{"bad output", []string{"user", "list", "-output=yaml"}, testSecret, `unknown output "yaml"`},
+		{"no secret", []string{"token", "verify", "x"}, "", "jwt-secret"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			app, _, _ := newTestApp(newMemRepo(), "")
+			app.JWTSecret = e.secret
+			err := app.run(e.args)
+			if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+				t.Errorf("expected an error containing %q but got %v", e.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_application_run_usage(t *testing.T) {
+	app, stdout, stderr := newTestApp(nil, "")
+	if err := app.run(nil); !errors.Is(err, errUsage) {
+		t.Errorf("expected errUsage but got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "user create") || stdout.Len() != 0 {
+		t.Errorf("expected the usage on stderr but got %q", stderr.String())
+	}
+
+	run(t, app, "help")
+	for _, cmd := range commands() {
+		if !strings.Contains(stdout.String(), cmd.name) {
+			t.Errorf("expected %q in the help but got %q", cmd.name, stdout.String())
+		}
+	}
+
+	if err := app.run([]string{"user", "list", "-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp but got %v", err)
+	}
+}
+
+func Test_application_run_connect(t *testing.T) {
+	// データベースを使うコマンドはDSNがなければ接続しない
+	app, _, _ := newTestApp(nil, "")
+	err := app.run([]string{"user", "list"})
+	if err == nil || !strings.Contains(err.Error(), "dsn") {
+		t.Errorf("expected an error about the dsn but got %v", err)
+	}
+	if app.DB != nil {
+		t.Error("expected no database")
+	}
+}
+
+func Test_application_parse(t *testing.T) {
+	app, _, _ := newTestApp(nil, "")
+	var refresh bool
+	fs := app.flags("token verify")
+	fs.BoolVar(&refresh, "refresh", false, "")
+
+	args, err := app.parse(fs, []string{"-output=json", "abc", "-refresh"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != "abc" || !refresh || app.Output != outputJSON {
+		t.Errorf("expected flags around the argument to be parsed, got %v refresh=%v output=%s", args, refresh, app.Output)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/certs"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/filegc"
+	"strings"
+	"time"
+)
+
+// appConfig is the configuration of the CLI, loaded by config.Load. It shares
+// the database, auth and storage settings of the servers. Options of a single
+// command are flags of the command instead, e.g. cli gc -delete.
+type appConfig struct {
+	Domain   string          `yaml:"domain" flag:"domain" usage:"issuer and audience of tokens, as configured for the API"`
+	Auth     config.Auth     `yaml:"auth"`
+	Database config.Database `yaml:"database"`
+	Storage  config.Storage  `yaml:"storage"`
+	MinAge   time.Duration   `yaml:"min_age" flag:"min-age" usage:"unreferenced files younger than this are kept (gc)"`
+	Cert     certConfig      `yaml:"cert"`
+	Output   string          `yaml:"output" flag:"output" usage:"output format: table|json"`
+}
+
+// certConfig is where the cert command writes a development certificate. The
+// flags are those the servers read it with.
+type certConfig struct {
+	CertFile string        `yaml:"cert" flag:"tls-cert" usage:"certificate file to write (cert)"`
+	KeyFile  string        `yaml:"key" flag:"tls-key" usage:"private key file to write (cert)"`
+	Hosts    string        `yaml:"hosts" flag:"cert-hosts" usage:"comma-separated host names and IP addresses the certificate is valid for (cert)"`
+	ValidFor time.Duration `yaml:"valid_for" flag:"cert-valid-for" usage:"lifetime of the certificate (cert)"`
+}
+
+func defaultConfig() appConfig {
+	return appConfig{
+		Domain:   "example.com",
+		Auth:     config.DefaultAuth(),
+		Database: config.DefaultDatabase(),
+		Storage:  config.DefaultStorage(),
+		MinAge:   filegc.DefaultMinAge,
+		Cert: certConfig{
+			CertFile: "cert.pem",
+			KeyFile:  "key.pem",
+			Hosts:    strings.Join(certs.DefaultHosts, ","),
+			ValidFor: 365 * 24 * time.Hour,
+		},
+		Output: outputTable,
+	}
+}
+
+// Validate checks the settings every command reads. The commands check the
+// sections they use, so that cli cert works without a database.
+func (c appConfig) Validate() error {
+	return validOutput(c.Output)
+}
+
+func (c certConfig) Validate() error {
+	if c.CertFile == "" || c.KeyFile == "" || c.Hosts == "" {
+		return errors.New("tls-cert, tls-key and cert-hosts are required")
+	}
+	if c.ValidFor <= 0 {
+		return errors.New("cert-valid-for must be positive")
+	}
+	return nil
+}
+
+func validOutput(output string) error {
+	if output != outputTable && output != outputJSON {
+		return fmt.Errorf("unknown output %q, expected %s or %s", output, outputTable, outputJSON)
+	}
+	return nil
+}
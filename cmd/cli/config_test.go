@@ -0,0 +1,50 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/config"
+	"strings"
+	"testing"
+)
+
+func Test_defaultConfig(t *testing.T) {
+	cfg := defaultConfig()
+	args, err := config.LoadCommand("cli", &cfg, []string{"-output=json", "user", "show", "-output=table", "1"})
+	if err != nil {
+		t.Fatalf("expected the defaults to be valid but got %v", err)
+	}
+	if cfg.Output != outputJSON || cfg.Domain != "example.com" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	// コマンド以降はコマンドのフラグとして残す
+	if strings.Join(args, " ") != "user show -output=table 1" {
+		t.Errorf("expected the command line after the flags but got %v", args)
+	}
+}
+
+func Test_appConfig_Validate(t *testing.T) {
+	cfg := defaultConfig()
+	_, err := config.LoadCommand("cli", &cfg, []string{"-output", "yaml"})
+	if err == nil || !strings.Contains(err.Error(), "yaml") {
+		t.Errorf("expected an unknown output but got %v", err)
+	}
+}
+
+func Test_certConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cert    certConfig
+		wantErr bool
+	}{
+		{"default", defaultConfig().Cert, false},
+		{"no hosts", certConfig{CertFile: "c", KeyFile: "k", ValidFor: 1}, true},
+		{"no lifetime", certConfig{CertFile: "c", KeyFile: "k", Hosts: "localhost"}, true},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			if err := e.cert.Validate(); (err != nil) != e.wantErr {
+				t.Errorf("expected error %v but got %v", e.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/health"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"sort"
+
+	_ "github.com/jackc/pgconn"
+	_ "github.com/jackc/pgx/v4"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// connect opens the database of -dsn. It does not wait for the database, so
+// that db ping can report why it is unreachable.
+func (app *application) connect() error {
+	if err := (config.Database{DSN: app.DSN}).Validate(); err != nil {
+		return err
+	}
+	conn, err := sql.Open("pgx", app.DSN)
+	if err != nil {
+		return err
+	}
+	app.conn = conn
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	return nil
+}
+
+// close closes the database opened by connect.
+func (app *application) close() {
+	if app.conn != nil {
+		_ = app.conn.Close()
+	}
+}
+
+// dbPing checks that the database is reachable and has the schema of this
+// build, like the readiness probe of the servers.
+func (app *application) dbPing(args []string) error {
+	fs := app.flags("db ping")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	checker := health.New(
+		health.Check{Name: "database", Run: health.Database(app.DB)},
+		health.Check{Name: "schema", Run: health.Schema(app.DB, dbrepo.Schema)},
+	)
+	results := checker.Run(context.Background())
+	if err := app.printChecks(results); err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Status != health.StatusOK {
+			return errors.New("the database is not ready")
+		}
+	}
+	return nil
+}
+
+// printChecks writes the results of health checks, sorted by name.
+func (app *application) printChecks(results map[string]health.CheckResult) error {
+	status := health.StatusOK
+	names := make([]string, 0, len(results))
+	for name, result := range results {
+		names = append(names, name)
+		if result.Status != health.StatusOK {
+			status = health.StatusFail
+		}
+	}
+	sort.Strings(names)
+
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		result := results[name]
+		rows = append(rows, []string{name, result.Status, result.Duration, result.Error})
+	}
+	return app.print(health.Response{Status: status, Checks: results}, []string{"CHECK", "STATUS", "DURATION", "ERROR"}, rows...)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"go_test_prac/webApp/pkg/health"
+	"strings"
+	"testing"
+)
+
+func Test_application_dbPing(t *testing.T) {
+	// テスト用のリポジトリには接続がないので、どちらのチェックも失敗する
+	app, stdout, _ := newTestApp(newMemRepo(), "")
+	err := app.run([]string{"db", "ping", "-output=json"})
+	if err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Errorf("expected the database not to be ready but got %v", err)
+	}
+
+	var resp health.Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != health.StatusFail || resp.Checks["database"].Status != health.StatusFail || resp.Checks["schema"].Error == "" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func Test_application_printChecks(t *testing.T) {
+	app, stdout, _ := newTestApp(nil, "")
+	err := app.printChecks(map[string]health.CheckResult{
+		"schema":   {Status: health.StatusFail, Duration: "2ms", Error: "missing users.email"},
+		"database": {Status: health.StatusOK, Duration: "1ms"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 checks but got %q", stdout.String())
+	}
+	if !strings.HasPrefix(lines[1], "database") || !strings.HasPrefix(lines[2], "schema") || !strings.Contains(lines[2], "missing users.email") {
+		t.Errorf("expected the checks sorted by name but got %q", stdout.String())
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"go_test_prac/webApp/pkg/filegc"
+	"go_test_prac/webApp/pkg/storage"
+)
+
+// gcResult is the output of gc with -output=json.
+type gcResult struct {
+	DryRun     bool     `json:"dry_run"`
+	Objects    int      `json:"objects"`
+	Referenced int      `json:"referenced"`
+	Orphans    []string `json:"orphans"`
+	Recent     int      `json:"recent"`
+	Missing    []string `json:"missing"`
+	Recounted  int      `json:"recounted"`
+	Forgotten  int      `json:"forgotten"`
+	Deleted    int      `json:"deleted"`
+	Freed      int64    `json:"freed_bytes"`
+}
+
+// collectGarbage reconciles the storage with the database. See package filegc.
+func (app *application) collectGarbage(args []string) error {
+	var del bool
+	minAge := app.MinAge
+	fs := app.flags("gc")
+	fs.BoolVar(&del, "delete", false, "delete the orphaned files and fix the records; without it nothing is changed")
+	fs.DurationVar(&minAge, "min-age", minAge, "unreferenced files younger than this are kept")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	store, err := storage.New(app.Storage)
+	if err != nil {
+		return err
+	}
+
+	c := filegc.New(app.DB, store)
+	c.MinAge = minAge
+	// 表形式では進捗をそのまま表示し、JSONでは結果だけを出す
+	c.Out = app.Stdout
+	if app.Output == outputJSON {
+		c.Out = nil
+	}
+
+	report, err := c.Run(context.Background(), !del)
+	if err != nil {
+		return err
+	}
+	if app.Output != outputJSON {
+		return nil
+	}
+
+	result := gcResult{
+		DryRun:     !del,
+		Objects:    report.Objects,
+		Referenced: report.Referenced,
+		Orphans:    make([]string, 0, len(report.Orphans)),
+		Recent:     report.Recent,
+		Missing:    report.Missing,
+		Recounted:  report.Recounted,
+		Forgotten:  report.Forgotten,
+		Deleted:    report.Deleted,
+		Freed:      report.Freed,
+	}
+	for _, o := range report.Orphans {
+		result.Orphans = append(result.Orphans, o.Key)
+	}
+	if result.Missing == nil {
+		result.Missing = []string{}
+	}
+	return app.print(result, nil)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_application_collectGarbage(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	// current.pngはテスト用のデータベースから参照されている
+	for _, name := range []string{"current.png", "orphan.png"} {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app, stdout, _ := newTestApp(newMemRepo(), "")
+	app.Storage.Root = root
+	app.Storage.BaseURL = "/static/img/"
+
+	run(t, app, "gc", "-output=json")
+	var result gcResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("expected only the report on stdout: %v", err)
+	}
+	if !result.DryRun || len(result.Orphans) != 1 || result.Orphans[0] != "orphan.png" || result.Deleted != 0 {
+		t.Errorf("expected orphan.png to be listed only, got %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(root, "orphan.png")); err != nil {
+		t.Error("expected a dry run to keep the orphan")
+	}
+
+	stdout.Reset()
+	run(t, app, "gc", "-delete", "-output=table")
+	if !strings.Contains(stdout.String(), "orphan   orphan.png") || !strings.Contains(stdout.String(), "deleted 1 orphans") {
+		t.Errorf("expected the progress on stdout but got %q", stdout.String())
+	}
+	if _, err := os.Stat(filepath.Join(root, "orphan.png")); !os.IsNotExist(err) {
+		t.Error("expected the orphan to be deleted")
+	}
+
+	// 参照されていないファイルでも新しいものは残す
+	stdout.Reset()
+	if err := os.WriteFile(filepath.Join(root, "fresh.png"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run(t, app, "gc", "-delete", "-min-age=1h", "-output=json")
+	result = gcResult{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Recent != 1 || len(result.Orphans) != 0 {
+		t.Errorf("expected fresh.png to be kept, got %+v", result)
+	}
+}
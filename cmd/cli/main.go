@@ -1,58 +1,82 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/golang-jwt/jwt/v4"
-	"log"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"os"
 	"time"
 )
 
 type application struct {
 	JWTSecret string
-	Action    string
+	Domain    string
+	DSN       string
+	Storage   storage.Config
+	MinAge    time.Duration
+	Cert      certConfig
+	Output    string                  // table or json
+	DB        repository.DatabaseRepo // connected before the commands using it
+	conn      *sql.DB
+	Stdin     io.Reader // passwords given with -password-stdin
+	Stdout    io.Writer
+	Stderr    io.Writer
 }
 
-// This is used to generate a token, so that we can test our api. Run this with go run ./cmd/cli and copy
-// the token that is printed out.
-// go run ./cmd/cli -action=valid     // will produce a valid token
-// go run ./cmd/cli -action=expired   // will produce an expired token
+// This is the admin tool of the application. It manages users, issues and
+// inspects the tokens of the API and ends sessions, using the database and the
+// settings of the servers. Run go run ./cmd/cli help for the commands.
+// go run ./cmd/cli user create -email=jane@example.com -first-name=Jane -last-name=Doe  // prints a generated password
+// go run ./cmd/cli user list -output=json
+// go run ./cmd/cli token issue -user=admin@example.com  // the same tokens as /auth, to test the api
+// go run ./cmd/cli session revoke jane@example.com      // logs the user out everywhere
+//
+// It also removes uploaded files nothing refers to any more. Without -delete it only prints them.
+// go run ./cmd/cli gc          // dry run
+// go run ./cmd/cli gc -delete  // delete the orphaned files
+//
+// It writes a self-signed certificate for trying HTTPS locally.
+// go run ./cmd/cli cert  // writes cert.pem and key.pem
 
 func main() {
-	var app application
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "2dce505d96a53c5768052ee90fsdf2055657518ad489160df9913f66042e160", "secret")
-	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
-	flag.Parse()
-
-	// generate a token
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	// set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["name"] = "John Doe"
-	claims["sub"] = "1"
-	claims["admin"] = true
-	claims["aud"] = "example.com"
-	claims["iss"] = "example.com"
-	// leave this to 3 days, for easy manual testing
-	if app.Action == "valid" {
-		expires := time.Now().UTC().Add(time.Hour * 72)
-		claims["exp"] = expires.Unix()
-	} else {
-		expires := time.Now().UTC().Add(time.Hour * 100 * -1)
-		claims["exp"] = expires.Unix()
+	// 既定値 < 設定ファイル < 環境変数 < フラグ の順に読み込む。コマンド以降の引数はコマンドが読む
+	cfg := defaultConfig()
+	args, err := config.LoadCommand("cli", &cfg, os.Args[1:])
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp), errors.Is(err, config.ErrPrinted):
+		os.Exit(0)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	// create the token as a slice of bytes
-	if app.Action == "valid" {
-		fmt.Println("VALID Token:")
-	} else {
-		fmt.Println("EXPIRED Token:")
+	app := application{
+		JWTSecret: cfg.Auth.JWTSecret,
+		Domain:    cfg.Domain,
+		DSN:       cfg.Database.DSN,
+		Storage:   cfg.Storage.StorageConfig(),
+		MinAge:    cfg.MinAge,
+		Cert:      cfg.Cert,
+		Output:    cfg.Output,
+		Stdin:     os.Stdin,
+		Stdout:    os.Stdout,
+		Stderr:    os.Stderr,
 	}
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
-	if err != nil {
-		log.Fatal(err)
+
+	err = app.run(args)
+	app.close()
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "cli:", err)
+		os.Exit(1)
 	}
-	// print to console
-	fmt.Println(string(signedAccessToken))
 }
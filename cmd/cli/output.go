@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of -output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// print writes v as indented JSON with -output=json, and the rows under header
+// as a table otherwise. A nil header prints the rows only, as for key-value
+// pairs and messages.
+func (app *application) print(v any, header []string, rows ...[]string) error {
+	if app.Output == outputJSON {
+		enc := json.NewEncoder(app.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(app.Stdout, 0, 0, 2, ' ', 0)
+	if header != nil {
+		if _, err := tw.Write([]byte(strings.Join(header, "\t") + "\n")); err != nil {
+			return err
+		}
+	}
+	for _, row := range rows {
+		// タブや改行は表を崩すので空白にする
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = strings.Map(func(r rune) rune {
+				if r == '\t' || r == '\n' || r == '\r' {
+					return ' '
+				}
+				return r
+			}, cell)
+		}
+		if _, err := tw.Write([]byte(strings.Join(cells, "\t") + "\n")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// formatTime formats t for tables; the zero time is left empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.DateTime)
+}
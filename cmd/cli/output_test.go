@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_application_print(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		header []string
+		rows   [][]string
+		want   string
+	}{
+		{"table", outputTable, []string{"ID", "EMAIL"}, [][]string{{"1", "admin@example.com"}, {"10", "x"}}, "ID  EMAIL\n1   admin@example.com\n10  x\n"},
+		{"no header", outputTable, nil, [][]string{{"KEY", "value"}}, "KEY  value\n"},
+		{"tabs and newlines", outputTable, nil, [][]string{{"a\tb", "c\nd"}}, "a b  c d\n"},
+		{"json", outputJSON, []string{"ID"}, [][]string{{"1"}}, "{\n  \"id\": 1\n}\n"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			app, stdout, _ := newTestApp(nil, "")
+			app.Output = e.output
+			if err := app.print(map[string]int{"id": 1}, e.header, e.rows...); err != nil {
+				t.Fatal(err)
+			}
+			if stdout.String() != e.want {
+				t.Errorf("expected %q but got %q", e.want, stdout.String())
+			}
+		})
+	}
+}
+
+func Test_formatTime(t *testing.T) {
+	if formatTime(time.Time{}) != "" {
+		t.Error("expected the zero time to be empty")
+	}
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	if got := formatTime(tm); got != "2024-01-02 03:04:05" {
+		t.Errorf("unexpected time %q", got)
+	}
+}
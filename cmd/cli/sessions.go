@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"go_test_prac/webApp/pkg/audit"
+	"time"
+)
+
+// sessionRevoke ends the web sessions of a user and invalidates their access
+// and refresh tokens. API keys are not affected.
+func (app *application) sessionRevoke(args []string) error {
+	user, err := app.userCommand(app.flags("session revoke"), args)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := app.DB.RevokeSessions(user.ID, now); err != nil {
+		return err
+	}
+	app.recordAudit(audit.ActionSessionsRevoke, audit.TargetUser, user.ID, audit.Diff(nil, map[string]interface{}{"revoked_at": now.UTC().Format(time.RFC3339)}))
+	return app.printMessage(fmt.Sprintf("revoked the sessions of user %d (%s) at %s", user.ID, user.Email, formatTime(now)))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_application_sessionRevoke(t *testing.T) {
+	repo := newMemRepo()
+	app, stdout, _ := newTestApp(repo, "")
+	before := time.Now()
+	run(t, app, "session", "revoke", "jane@example.com", "-output=json")
+
+	if at, ok := repo.revoked[2]; !ok || at.Before(before) {
+		t.Errorf("expected the sessions of user 2 to be revoked now but got %v", repo.revoked)
+	}
+	var result messageResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Message, "user 2") {
+		t.Errorf("unexpected message %q", result.Message)
+	}
+
+	if err := app.run([]string{"session", "revoke", "john@example.com"}); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "2dce505d96a53c5768052ee90fsdf2055657518ad489160df9913f66042e160"
+
+// memRepo keeps the users in memory, so that the commands can be checked by
+// reading them back. The other methods are those of TestDBRepo.
+type memRepo struct {
+	dbrepo.TestDBRepo
+	users     map[int]*data.User
+	passwords map[int]string
+	revoked   map[int]time.Time
+	nextID    int
+	events    []*data.AuditEvent
+}
+
+func newMemRepo() *memRepo {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &memRepo{
+		users: map[int]*data.User{
+			1: {ID: 1, Email: "admin@example.com", FirstName: "Admin", LastName: "User", IsAdmin: 1, CreatedAt: created, UpdatedAt: created},
+			2: {ID: 2, Email: "jane@example.com", FirstName: "Jane", LastName: "Doe", CreatedAt: created, UpdatedAt: created},
+		},
+		passwords: map[int]string{},
+		revoked:   map[int]time.Time{},
+		nextID:    3,
+	}
+}
+
+func (m *memRepo) AllUsers() ([]*data.User, error) {
+	var users []*data.User
+	for id := 1; id < m.nextID; id++ {
+		if u, ok := m.users[id]; ok {
+			copied := *u
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (m *memRepo) GetUser(id int) (*data.User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (m *memRepo) GetUserByEmail(email string) (*data.User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memRepo) UpdateUser(u data.User) error {
+	if _, ok := m.users[u.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	m.users[u.ID] = &u
+	return nil
+}
+
+func (m *memRepo) DeleteUser(id int) error {
+	delete(m.users, id)
+	return nil
+}
+
+func (m *memRepo) InsertUser(u data.User) (int, error) {
+	u.ID = m.nextID
+	m.nextID++
+	m.passwords[u.ID] = u.Password
+	u.Password = ""
+	m.users[u.ID] = &u
+	return u.ID, nil
+}
+
+func (m *memRepo) ResetPassword(id int, password string) error {
+	m.passwords[id] = password
+	return nil
+}
+
+func (m *memRepo) RevokeSessions(userID int, at time.Time) error {
+	m.revoked[userID] = at
+	return nil
+}
+
+func (m *memRepo) UpsertUsers(ctx context.Context, users []data.UpsertUser) ([]data.UpsertResult, error) {
+	results := make([]data.UpsertResult, len(users))
+	for i, u := range users {
+		existing, err := m.GetUserByEmail(u.Email)
+		if err != nil {
+			isAdmin := 0
+			if u.IsAdmin != nil {
+				isAdmin = *u.IsAdmin
+			}
+			id, _ := m.InsertUser(data.User{Email: u.Email, FirstName: u.FirstName, LastName: u.LastName, Password: u.Password, IsAdmin: isAdmin})
+			results[i] = data.UpsertResult{ID: id, Created: true}
+			continue
+		}
+
+		existing.FirstName, existing.LastName = u.FirstName, u.LastName
+		if u.IsAdmin != nil {
+			existing.IsAdmin = *u.IsAdmin
+		}
+		if u.Password != "" {
+			m.passwords[existing.ID] = u.Password
+		}
+		m.users[existing.ID] = existing
+		results[i] = data.UpsertResult{ID: existing.ID}
+	}
+	return results, nil
+}
+
+func (m *memRepo) ExportUsers(ctx context.Context, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "email", "first_name", "last_name", "is_admin", "created_at", "updated_at"})
+	users, _ := m.AllUsers()
+	for _, u := range users {
+		_ = cw.Write([]string{strconv.Itoa(u.ID), u.Email, u.FirstName, u.LastName, strconv.Itoa(u.IsAdmin),
+			u.CreatedAt.Format(time.DateTime), u.UpdatedAt.Format(time.DateTime)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (m *memRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	prevHash := ""
+	if len(m.events) > 0 {
+		prevHash = m.events[len(m.events)-1].Hash
+	}
+	e.Seal(prevHash)
+	e.ID = int64(len(m.events) + 1)
+	m.events = append(m.events, &e)
+	copied := e
+	return &copied, nil
+}
+
+// AuditEvents filters the events like the database, but only supports the
+// ascending order used by the commands.
+func (m *memRepo) AuditEvents(f data.AuditFilter) ([]*data.AuditEvent, error) {
+	var events []*data.AuditEvent
+	for _, e := range m.events {
+		switch {
+		case e.ID <= f.Cursor,
+			f.ActorID != 0 && e.ActorID != f.ActorID,
+			f.Action != "" && e.Action != f.Action,
+			f.TargetType != "" && e.TargetType != f.TargetType,
+			f.TargetID != "" && e.TargetID != f.TargetID,
+			!f.Since.IsZero() && e.OccurredAt.Before(f.Since),
+			!f.Until.IsZero() && !e.OccurredAt.Before(f.Until):
+			continue
+		}
+		copied := *e
+		events = append(events, &copied)
+		if f.Limit > 0 && len(events) == f.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// newTestApp returns an application using repo, reading stdin and writing to
+// the returned buffers.
+func newTestApp(repo *memRepo, stdin string) (*application, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cfg := defaultConfig()
+	app := &application{
+		JWTSecret: testSecret,
+		Domain:    cfg.Domain,
+		MinAge:    cfg.MinAge,
+		Cert:      cfg.Cert,
+		Output:    cfg.Output,
+		Stdin:     strings.NewReader(stdin),
+		Stdout:    stdout,
+		Stderr:    stderr,
+	}
+	if repo != nil {
+		app.DB = repo
+	}
+	return app, stdout, stderr
+}
+
+// run runs a command line and fails the test if it returns an error.
+func run(t *testing.T, app *application, args ...string) {
+	t.Helper()
+	if err := app.run(args); err != nil {
+		t.Fatalf("cli %s: %v", strings.Join(args, " "), err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/token"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// tokens returns the issuer the API uses, for -domain and -jwt-secret.
+func (app *application) tokens() token.Issuer {
+	return token.Issuer{
+		Secret:        app.JWTSecret,
+		Domain:        app.Domain,
+		AccessExpiry:  token.DefaultAccessExpiry,
+		RefreshExpiry: token.DefaultRefreshExpiry,
+	}
+}
+
+func (app *application) tokenIssue(args []string) error {
+	var userArg string
+	issuer := app.tokens()
+	fs := app.flags("token issue")
+	fs.StringVar(&userArg, "user", "", "ID or email address of the user")
+	fs.DurationVar(&issuer.AccessExpiry, "expires", issuer.AccessExpiry, "lifetime of the access token; negative for an expired token")
+	fs.DurationVar(&issuer.RefreshExpiry, "refresh-expires", issuer.RefreshExpiry, "lifetime of the refresh token")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if userArg == "" {
+		return errors.New("-user is required")
+	}
+
+	user, err := app.findUser(userArg)
+	if err != nil {
+		return err
+	}
+	pair, err := issuer.Issue(user)
+	if err != nil {
+		return err
+	}
+	return app.print(pair, nil,
+		[]string{"ACCESS TOKEN", pair.Token},
+		[]string{"REFRESH TOKEN", pair.RefreshToken},
+	)
+}
+
+// decodedToken is the output of token decode.
+type decodedToken struct {
+	Header map[string]any `json:"header"`
+	Claims map[string]any `json:"claims"`
+}
+
+// timeClaims are the claims holding a Unix time.
+var timeClaims = map[string]bool{"exp": true, "iat": true, "nbf": true}
+
+func (app *application) tokenDecode(args []string) error {
+	fs := app.flags("token decode")
+	positional, err := app.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	header, claims, err := token.Decode(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	rows = append(rows, decodedRows("header", header)...)
+	rows = append(rows, decodedRows("claims", claims)...)
+	return app.print(decodedToken{Header: header, Claims: claims}, []string{"PART", "NAME", "VALUE"}, rows...)
+}
+
+// decodedRows returns the fields of a decoded part sorted by name. Times are
+// shown next to the Unix time.
+func decodedRows(part string, fields map[string]any) [][]string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		value := fmt.Sprint(fields[name])
+		if n, ok := fields[name].(json.Number); ok && timeClaims[name] {
+			if sec, err := n.Int64(); err == nil {
+				value = fmt.Sprintf("%s (%s)", n, formatTime(time.Unix(sec, 0)))
+			}
+		}
+		rows = append(rows, []string{part, name, value})
+	}
+	return rows
+}
+
+// verifiedToken is the output of token verify.
+type verifiedToken struct {
+	Subject   string    `json:"sub"`
+	Name      string    `json:"name,omitempty"`
+	Admin     bool      `json:"admin"`
+	Issuer    string    `json:"iss,omitempty"`
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+func (app *application) tokenVerify(args []string) error {
+	var refresh bool
+	fs := app.flags("token verify")
+	fs.BoolVar(&refresh, "refresh", false, "verify a refresh token, which has no issuer")
+	positional, err := app.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	verify := app.tokens().Verify
+	if refresh {
+		verify = app.tokens().VerifyRefresh
+	}
+	claims, err := verify(positional[0])
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+
+	v := verifiedToken{
+		Subject: claims.Subject,
+		Name:    claims.Name,
+		Admin:   claims.Admin,
+		Issuer:  claims.Issuer,
+	}
+	if claims.IssuedAt != nil {
+		v.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		v.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return app.print(v, nil,
+		[]string{"VALID", "true"},
+		[]string{"SUBJECT", v.Subject},
+		[]string{"NAME", v.Name},
+		[]string{"ADMIN", strconv.FormatBool(v.Admin)},
+		[]string{"ISSUER", v.Issuer},
+		[]string{"ISSUED", formatTime(v.IssuedAt)},
+		[]string{"EXPIRES", formatTime(v.ExpiresAt)},
+	)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"go_test_prac/webApp/pkg/token"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issue returns the tokens of token issue for args.
+func issue(t *testing.T, app *application, args ...string) token.Pair {
+	t.Helper()
+	stdout := app.Stdout.(interface {
+		Bytes() []byte
+		Reset()
+	})
+	stdout.Reset()
+	output := app.Output
+	run(t, app, append([]string{"token", "issue", "-output=json"}, args...)...)
+	app.Output = output
+
+	var pair token.Pair
+	if err := json.Unmarshal(stdout.Bytes(), &pair); err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func Test_application_tokenIssue(t *testing.T) {
+	app, _, _ := newTestApp(newMemRepo(), "")
+	pair := issue(t, app, "-user=admin@example.com")
+
+	// APIと同じ検証を通る
+	claims, err := app.tokens().Verify(pair.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1" || claims.Name != "Admin User" || !claims.Admin || claims.Issuer != "example.com" {
+		t.Errorf("expected the claims of the admin but got %+v", claims)
+	}
+	if d := time.Until(claims.ExpiresAt.Time); d > token.DefaultAccessExpiry || d < token.DefaultAccessExpiry-time.Minute {
+		t.Errorf("expected the default expiry but got %s", d)
+	}
+	if _, err := app.tokens().VerifyRefresh(pair.RefreshToken); err != nil {
+		t.Errorf("expected a valid refresh token but got %v", err)
+	}
+
+	expired := issue(t, app, "-user=2", "-expires=-1h")
+	if _, err := app.tokens().Verify(expired.Token); err != token.ErrExpired {
+		t.Errorf("expected an expired token but got %v", err)
+	}
+
+	for _, args := range [][]string{{"token", "issue"}, {"token", "issue", "-user=9"}} {
+		if err := app.run(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func Test_application_tokenDecode(t *testing.T) {
+	app, stdout, _ := newTestApp(newMemRepo(), "")
+	pair := issue(t, app, "-user=2")
+
+	stdout.Reset()
+	run(t, app, "token", "decode", pair.Token)
+	table := stdout.String()
+	for _, s := range []string{"header  alg", "HS256", "claims  name", "Jane Doe", "claims  exp"} {
+		if !strings.Contains(table, s) {
+			t.Errorf("expected %q in %q", s, table)
+		}
+	}
+
+	stdout.Reset()
+	run(t, app, "token", "decode", "-output=json", pair.RefreshToken)
+	var decoded decodedToken
+	if err := json.Unmarshal(stdout.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Claims["sub"] != "2" || decoded.Header["typ"] != "JWT" {
+		t.Errorf("unexpected token %+v", decoded)
+	}
+
+	if err := app.run([]string{"token", "decode", "not-a-token"}); err == nil {
+		t.Error("expected an error for a malformed token")
+	}
+}
+
+func Test_application_tokenVerify(t *testing.T) {
+	app, _, _ := newTestApp(newMemRepo(), "")
+	pair := issue(t, app, "-user=admin@example.com")
+	expired := issue(t, app, "-user=admin@example.com", "-expires=-1m")
+
+	other, _, _ := newTestApp(newMemRepo(), "")
+	other.Domain = "example.org"
+	foreign := issue(t, other, "-user=1")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"access token", []string{pair.Token}, ""},
+		{"refresh token", []string{"-refresh", pair.RefreshToken}, ""},
+		{"refresh token as access token", []string{pair.RefreshToken}, "issuer"},
+		{"expired", []string{expired.Token}, "expired"},
+		{"other domain", []string{foreign.Token}, "issuer"},
+		{"tampered", []string{pair.Token + "x"}, "signature"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			app, stdout, _ := newTestApp(nil, "")
+			err := app.run(append([]string{"token", "verify", "-output=json"}, e.args...))
+			if e.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+					t.Errorf("expected %q but got %v", e.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v verifiedToken
+			if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+				t.Fatal(err)
+			}
+			if v.Subject != "1" || v.ExpiresAt.IsZero() {
+				t.Errorf("unexpected claims %+v", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/validation"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPasswordLength is the shortest password the CLI sets.
+const minPasswordLength = 8
+
+// userView is a user as the CLI prints it, without the password hash.
+type userView struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newUserView(u *data.User) userView {
+	return userView{
+		ID:        u.ID,
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Admin:     u.IsAdmin == 1,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+// findUser returns the user with the ID or email address arg.
+func (app *application) findUser(arg string) (*data.User, error) {
+	var (
+		user *data.User
+		err  error
+	)
+	if id, convErr := strconv.Atoi(arg); convErr == nil {
+		user, err = app.DB.GetUser(id)
+	} else {
+		user, err = app.DB.GetUserByEmail(arg)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %s not found", arg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", arg, err)
+	}
+	return user, nil
+}
+
+// userCommand parses the flags and the user argument of a command that works
+// on one user.
+func (app *application) userCommand(fs *flag.FlagSet, args []string) (*data.User, error) {
+	positional, err := app.parse(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return app.findUser(positional[0])
+}
+
+func (app *application) userList(args []string) error {
+	fs := app.flags("user list")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	users, err := app.DB.AllUsers()
+	if err != nil {
+		return err
+	}
+
+	views := make([]userView, 0, len(users))
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		v := newUserView(u)
+		views = append(views, v)
+		rows = append(rows, []string{strconv.Itoa(v.ID), v.Email, v.FirstName, v.LastName, strconv.FormatBool(v.Admin), formatTime(v.CreatedAt)})
+	}
+	return app.print(views, []string{"ID", "EMAIL", "FIRST NAME", "LAST NAME", "ADMIN", "CREATED"}, rows...)
+}
+
+func (app *application) userShow(args []string) error {
+	user, err := app.userCommand(app.flags("user show"), args)
+	if err != nil {
+		return err
+	}
+	return app.printUser(newUserView(user))
+}
+
+// printUser prints one user as key-value pairs.
+func (app *application) printUser(v userView) error {
+	return app.print(v, nil,
+		[]string{"ID", strconv.Itoa(v.ID)},
+		[]string{"EMAIL", v.Email},
+		[]string{"FIRST NAME", v.FirstName},
+		[]string{"LAST NAME", v.LastName},
+		[]string{"ADMIN", strconv.FormatBool(v.Admin)},
+		[]string{"CREATED", formatTime(v.CreatedAt)},
+		[]string{"UPDATED", formatTime(v.UpdatedAt)},
+	)
+}
+
+// passwordResult is printed when the CLI generated a password.
+type passwordResult struct {
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	Password string `json:"password,omitempty"`
+}
+
+// printPassword prints the user a password was set for, and the password if
+// the CLI generated it.
+func (app *application) printPassword(id int, email, generated string) error {
+	rows := [][]string{{"ID", strconv.Itoa(id)}, {"EMAIL", email}}
+	if generated != "" {
+		rows = append(rows, []string{"PASSWORD", generated})
+	}
+	return app.print(passwordResult{ID: id, Email: email, Password: generated}, nil, rows...)
+}
+
+func (app *application) userCreate(args []string) error {
+	var (
+		user          data.User
+		admin         bool
+		passwordStdin bool
+	)
+	fs := app.flags("user create")
+	fs.StringVar(&user.Email, "email", "", "email address")
+	fs.StringVar(&user.FirstName, "first-name", "", "first name")
+	fs.StringVar(&user.LastName, "last-name", "", "last name")
+	fs.BoolVar(&admin, "admin", false, "make the user an admin")
+	fs.BoolVar(&passwordStdin, "password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	if _, err := app.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if admin {
+		user.IsAdmin = 1
+	}
+	if errs := user.Validate(); len(errs) > 0 {
+		return errs
+	}
+
+	password, generated, err := app.password(passwordStdin)
+	if err != nil {
+		return err
+	}
+	user.Password = password
+
+	id, err := app.DB.InsertUser(user)
+	if err != nil {
+		return err
+	}
+	app.recordAudit(audit.ActionUserCreate, audit.TargetUser, id, audit.Diff(nil, audit.UserFields(&user)))
+	return app.printPassword(id, user.Email, generated)
+}
+
+func (app *application) userUpdate(args []string) error {
+	var email, firstName, lastName string
+	fs := app.flags("user update")
+	fs.StringVar(&email, "email", "", "new email address")
+	fs.StringVar(&firstName, "first-name", "", "new first name")
+	fs.StringVar(&lastName, "last-name", "", "new last name")
+	user, err := app.userCommand(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// 指定されたフラグだけを変更する
+	changed := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "email":
+			user.Email, changed = email, true
+		case "first-name":
+			user.FirstName, changed = firstName, true
+		case "last-name":
+			user.LastName, changed = lastName, true
+		}
+	})
+	if !changed {
+		return errors.New("nothing to update, give -email, -first-name or -last-name")
+	}
+	return app.updateUser(user)
+}
+
+// updateUser validates and saves user, and prints it as saved.
+func (app *application) updateUser(user *data.User) error {
+	if errs := user.Validate(); len(errs) > 0 {
+		return errs
+	}
+	before, err := app.DB.GetUser(user.ID)
+	if err != nil {
+		return err
+	}
+	if err := app.DB.UpdateUser(*user); err != nil {
+		return err
+	}
+	profile, role := audit.UserUpdate(before, user)
+	if profile != nil || role == nil {
+		app.recordAudit(audit.ActionUserUpdate, audit.TargetUser, user.ID, profile)
+	}
+	if role != nil {
+		app.recordAudit(audit.ActionUserRole, audit.TargetUser, user.ID, role)
+	}
+
+	saved, err := app.DB.GetUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(newUserView(saved))
+}
+
+func (app *application) userDelete(args []string) error {
+	user, err := app.userCommand(app.flags("user delete"), args)
+	if err != nil {
+		return err
+	}
+	if err := app.DB.DeleteUser(user.ID); err != nil {
+		return err
+	}
+	app.recordAudit(audit.ActionUserDelete, audit.TargetUser, user.ID, audit.Diff(audit.UserFields(user), nil))
+	return app.printMessage(fmt.Sprintf("deleted user %d (%s); run cli gc -delete to remove their files", user.ID, user.Email))
+}
+
+func (app *application) userSetPassword(args []string) error {
+	var passwordStdin bool
+	fs := app.flags("user set-password")
+	fs.BoolVar(&passwordStdin, "password-stdin", false, "read the password from the first line of stdin instead of generating one")
+	user, err := app.userCommand(fs, args)
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := app.password(passwordStdin)
+	if err != nil {
+		return err
+	}
+	if err := app.DB.ResetPassword(user.ID, password); err != nil {
+		return err
+	}
+	// パスワードは記録せず、変わったことだけを残す
+	changes := audit.Diff(map[string]interface{}{"password": ""}, map[string]interface{}{"password": password}, "password")
+	app.recordAudit(audit.ActionPasswordSet, audit.TargetUser, user.ID, changes)
+	return app.printPassword(user.ID, user.Email, generated)
+}
+
+func (app *application) userPromote(args []string) error {
+	return app.setAdmin("user promote", args, 1)
+}
+
+func (app *application) userDemote(args []string) error {
+	return app.setAdmin("user demote", args, 0)
+}
+
+func (app *application) setAdmin(name string, args []string, isAdmin int) error {
+	user, err := app.userCommand(app.flags(name), args)
+	if err != nil {
+		return err
+	}
+	user.IsAdmin = isAdmin
+	return app.updateUser(user)
+}
+
+// password returns the password read from stdin, or a generated one, which is
+// also returned as generated so that it can be shown once.
+func (app *application) password(fromStdin bool) (password, generated string, err error) {
+	if !fromStdin {
+		generated, err = generatePassword()
+		return generated, generated, err
+	}
+
+	line, err := bufio.NewReader(app.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", "", fmt.Errorf("reading the password from stdin: %w", err)
+	}
+	password = strings.TrimRight(line, "\r\n")
+
+	v := validation.New()
+	v.Field("password", password, validation.Required(), validation.MinLength(minPasswordLength))
+	if !v.Valid() {
+		return "", "", v.Errors
+	}
+	return password, "", nil
+}
+
+// generatePassword returns a random password of 22 URL-safe characters.
+func generatePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// messageResult is printed by commands that only report what they did.
+type messageResult struct {
+	Message string `json:"message"`
+}
+
+func (app *application) printMessage(message string) error {
+	return app.print(messageResult{Message: message}, nil, []string{message})
+}
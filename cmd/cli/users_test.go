@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_application_userList(t *testing.T) {
+	app, stdout, _ := newTestApp(newMemRepo(), "")
+	run(t, app, "user", "list")
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 users but got %q", stdout.String())
+	}
+	if !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], "admin@example.com") || !strings.Contains(lines[2], "jane@example.com") {
+		t.Errorf("unexpected table %q", stdout.String())
+	}
+
+	stdout.Reset()
+	run(t, app, "user", "list", "-output=json")
+	var users []userView
+	if err := json.Unmarshal(stdout.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || !users[0].Admin || users[1].Email != "jane@example.com" {
+		t.Errorf("unexpected users %+v", users)
+	}
+	if strings.Contains(stdout.String(), "password") {
+		t.Error("expected no password in the output")
+	}
+}
+
+func Test_application_userShow(t *testing.T) {
+	tests := []struct {
+		name      string
+		arg       string
+		wantEmail string
+		wantErr   string
+	}{
+		{"by id", "2", "jane@example.com", ""},
+		{"by email", "admin@example.com", "admin@example.com", ""},
+		{"unknown id", "9", "", "user 9 not found"},
+		{"unknown email", "john@example.com", "", "user john@example.com not found"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			app, stdout, _ := newTestApp(newMemRepo(), "")
+			err := app.run([]string{"user", "show", e.arg, "-output=json"})
+			if e.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+					t.Errorf("expected %q but got %v", e.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var user userView
+			if err := json.Unmarshal(stdout.Bytes(), &user); err != nil {
+				t.Fatal(err)
+			}
+			if user.Email != e.wantEmail {
+				t.Errorf("expected %s but got %+v", e.wantEmail, user)
+			}
+		})
+	}
+}
+
+func Test_application_userCreate(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		stdin        string
+		wantErr      string
+		wantPassword string // empty when generated
+		wantAdmin    int
+	}{
+		{"generated password", []string{"-email=john@example.com", "-first-name=John", "-last-name=Smith"}, "", "", "", 0},
+		{"password from stdin", []string{"-email=john@example.com", "-first-name=John", "-last-name=Smith", "-admin", "-password-stdin"}, "correct horse\n", "", "correct horse", 1},
+		{"short password", []string{"-email=john@example.com", "-first-name=John", "-last-name=Smith", "-password-stdin"}, "secret\n", "password", "", 0},
+		{"empty stdin", []string{"-email=john@example.com", "-first-name=John", "-last-name=Smith", "-password-stdin"}, "", "stdin", "", 0},
+		{"invalid email", []string{"-email=john", "-first-name=John", "-last-name=Smith"}, "", "email", "", 0},
+		{"missing name", []string{"-email=john@example.com"}, "", "first_name", "", 0},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			repo := newMemRepo()
+			app, stdout, _ := newTestApp(repo, e.stdin)
+			err := app.run(append([]string{"user", "create", "-output=json"}, e.args...))
+			if e.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+					t.Errorf("expected an error about %s but got %v", e.wantErr, err)
+				}
+				if len(repo.users) != 2 {
+					t.Error("expected no user to be created")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var result passwordResult
+			if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			user := repo.users[result.ID]
+			if user == nil || user.Email != "john@example.com" || user.IsAdmin != e.wantAdmin {
+				t.Fatalf("unexpected user %+v", user)
+			}
+
+			want := e.wantPassword
+			if want == "" {
+				// 生成したパスワードは一度だけ表示する
+				want = result.Password
+				if len(want) < minPasswordLength {
+					t.Errorf("expected a generated password but got %q", want)
+				}
+			} else if result.Password != "" {
+				t.Error("expected a password from stdin not to be printed")
+			}
+			if repo.passwords[result.ID] != want {
+				t.Errorf("expected the password %q to be set but got %q", want, repo.passwords[result.ID])
+			}
+		})
+	}
+}
+
+func Test_application_userUpdate(t *testing.T) {
+	repo := newMemRepo()
+	app, stdout, _ := newTestApp(repo, "")
+	run(t, app, "user", "update", "jane@example.com", "-last-name=Smith")
+
+	user := repo.users[2]
+	if user.LastName != "Smith" || user.FirstName != "Jane" || user.Email != "jane@example.com" {
+		t.Errorf("expected only the last name to change but got %+v", user)
+	}
+	if !strings.Contains(stdout.String(), "Smith") {
+		t.Errorf("expected the updated user to be printed but got %q", stdout.String())
+	}
+
+	if err := app.run([]string{"user", "update", "2"}); err == nil || !strings.Contains(err.Error(), "nothing to update") {
+		t.Errorf("expected nothing to update but got %v", err)
+	}
+	if err := app.run([]string{"user", "update", "2", "-email=jane"}); err == nil || !strings.Contains(err.Error(), "email") {
+		t.Errorf("expected an invalid email but got %v", err)
+	}
+	if repo.users[2].Email != "jane@example.com" {
+		t.Error("expected an invalid email not to be saved")
+	}
+}
+
+func Test_application_userDelete(t *testing.T) {
+	repo := newMemRepo()
+	app, stdout, _ := newTestApp(repo, "")
+	run(t, app, "user", "delete", "2")
+
+	if _, ok := repo.users[2]; ok {
+		t.Error("expected the user to be deleted")
+	}
+	if !strings.Contains(stdout.String(), "deleted user 2") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+	if err := app.run([]string{"user", "delete", "2"}); err == nil {
+		t.Error("expected an error for a deleted user")
+	}
+}
+
+func Test_application_userSetPassword(t *testing.T) {
+	repo := newMemRepo()
+	app, stdout, _ := newTestApp(repo, "new password\n")
+	run(t, app, "user", "set-password", "2", "-password-stdin")
+	if repo.passwords[2] != "new password" {
+		t.Errorf("expected the password from stdin but got %q", repo.passwords[2])
+	}
+	if strings.Contains(stdout.String(), "new password") {
+		t.Error("expected a password from stdin not to be printed")
+	}
+
+	stdout.Reset()
+	run(t, app, "user", "set-password", "2")
+	if repo.passwords[2] == "new password" || !strings.Contains(stdout.String(), repo.passwords[2]) {
+		t.Errorf("expected the generated password %q to be printed, got %q", repo.passwords[2], stdout.String())
+	}
+}
+
+func Test_application_userPromote(t *testing.T) {
+	repo := newMemRepo()
+	app, _, _ := newTestApp(repo, "")
+
+	run(t, app, "user", "promote", "jane@example.com")
+	if repo.users[2].IsAdmin != 1 {
+		t.Error("expected the user to be an admin")
+	}
+	run(t, app, "user", "demote", "2")
+	if repo.users[2].IsAdmin != 0 {
+		t.Error("expected the user not to be an admin")
+	}
+}
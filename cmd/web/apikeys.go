@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	stderrors "errors" // forms.goのerrors型と名前が衝突するため
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newAPIKeySessionKey holds a created key until the page showing it once.
+const newAPIKeySessionKey = "new_api_key"
+
+// APIKeys lists the API keys of the user, with a form to create one. A key
+// just created is shown once, at the top.
+func (app *application) APIKeys(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	keys, err := app.db(r.Context()).UserAPIKeys(user.ID)
+	if err != nil {
+		requestlog.FromContext(r.Context()).Error("listing API keys", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// 作成したキーを表示するページはキャッシュさせない
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.render(w, r, "apikeys.page.gohtml", &TemplateData{Data: map[string]any{
+		"Keys":              keys,
+		"NewKey":            app.Session.PopString(r.Context(), newAPIKeySessionKey),
+		"Now":               time.Now(),
+		"Scopes":            apikey.Scopes,
+		"DefaultExpiryDays": apikey.DefaultExpiryDays,
+		"MaxExpiryDays":     apikey.MaxExpiryDays,
+	}})
+}
+
+// CreateAPIKey creates an API key of the user from the form on the API keys
+// page.
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	req := apikey.Request{Name: r.PostForm.Get("name"), Scopes: r.PostForm["scopes"]}
+	if days := r.PostForm.Get("expires_in_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			// 範囲外として検証で弾く
+			n = -1
+		}
+		req.ExpiresInDays = n
+	}
+
+	if errs := req.Validate(); len(errs) > 0 {
+		msgs := errs.First(validation.English)
+		var parts []string
+		for _, field := range errs.Fields() {
+			parts = append(parts, field+" "+msgs[field])
+		}
+		app.Session.Put(r.Context(), "error", "API key not created: "+strings.Join(parts, ", "))
+		http.Redirect(w, r, "/user/api-keys", http.StatusSeeOther)
+		return
+	}
+
+	key, k, err := apikey.New(user.ID, req, time.Now())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	k.ID, err = app.db(r.Context()).InsertAPIKey(k)
+	if err != nil {
+		requestlog.FromContext(r.Context()).Error("creating an API key", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionAPIKeyCreate, audit.TargetAPIKey, k.ID)
+	e.Changes = audit.Diff(nil, audit.APIKeyFields(&k))
+	app.recordAudit(r, e)
+
+	app.Session.Put(r.Context(), newAPIKeySessionKey, key)
+	app.Session.Put(r.Context(), "flash", "API key created. Copy it now, it is not shown again")
+	http.Redirect(w, r, "/user/api-keys", http.StatusSeeOther)
+}
+
+// RevokeAPIKey revokes one of the API keys of the user.
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	err = app.db(r.Context()).RevokeAPIKey(user.ID, keyID, time.Now())
+	if stderrors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		requestlog.FromContext(r.Context()).Error("revoking an API key", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionAPIKeyRevoke, audit.TargetAPIKey, keyID)
+	app.recordAudit(r, e)
+
+	app.Session.Put(r.Context(), "flash", "API key revoked")
+	http.Redirect(w, r, "/user/api-keys", http.StatusSeeOther)
+}
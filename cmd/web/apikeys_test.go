@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_app_APIKeys(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/api-keys", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	app.Session.Put(req.Context(), newAPIKeySessionKey, "wak_0123456789ab_new-key")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.APIKeys).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the page not to be cached but got %q", rr.Header().Get("Cache-Control"))
+	}
+
+	body := rr.Body.String()
+	// 有効なキーだけに失効のフォームがある
+	for _, want := range []string{`value="wak_0123456789ab_new-key"`, `action="/user/api-keys/1/revoke"`, `action="/user/api-keys/2/revoke"`, "Expired", "Revoked", "read only"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("did not find %s in %s", want, body)
+		}
+	}
+	for _, unwanted := range []string{`action="/user/api-keys/3/revoke"`, `action="/user/api-keys/4/revoke"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("found %s in %s", unwanted, body)
+		}
+	}
+
+	// 作成したキーは一度しか表示しない
+	if app.Session.Exists(req.Context(), newAPIKeySessionKey) {
+		t.Error("expected the new key to be removed from the session")
+	}
+}
+
+func Test_app_CreateAPIKey(t *testing.T) {
+	var tests = []struct {
+		name          string
+		form          url.Values
+		expectCreated bool
+		expectedError string
+	}{
+		{"valid", url.Values{"name": {"deploy"}, "scopes": {"read", "write"}, "expires_in_days": {"30"}}, true, ""},
+		{"default expiry", url.Values{"name": {"deploy"}, "scopes": {"read"}}, true, ""},
+		{"no name", url.Values{"scopes": {"read"}}, false, "API key not created: name"},
+		{"no scopes", url.Values{"name": {"deploy"}}, false, "API key not created: scopes"},
+		{"bad expiry", url.Values{"name": {"deploy"}, "scopes": {"read"}, "expires_in_days": {"forever"}}, false, "API key not created: expires_in_days"},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &recordingRepo{}
+		app.DB = repo
+
+		req := httptest.NewRequest("POST", "/user/api-keys", strings.NewReader(e.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.CreateAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/api-keys" {
+			t.Errorf("%s: expected a redirect to the API keys but got %d %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+
+		key := app.Session.GetString(req.Context(), newAPIKeySessionKey)
+		if !e.expectCreated {
+			if key != "" || len(repo.events) != 0 {
+				t.Errorf("%s: expected no key but got %q", e.name, key)
+			}
+			if msg := app.Session.GetString(req.Context(), "error"); !strings.HasPrefix(msg, e.expectedError) {
+				t.Errorf("%s: expected the error %q but got %q", e.name, e.expectedError, msg)
+			}
+			continue
+		}
+
+		if _, err := apikey.Prefix(key); err != nil {
+			t.Errorf("%s: expected the new key in the session but got %q", e.name, key)
+		}
+		if len(repo.events) != 1 || repo.events[0].Action != audit.ActionAPIKeyCreate || repo.events[0].ActorID != 1 ||
+			strings.Contains(string(repo.events[0].Changes), key) {
+			t.Errorf("%s: expected the creation to be recorded without the key but got %+v", e.name, repo.events)
+		}
+	}
+}
+
+func Test_app_RevokeAPIKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		keyID              string
+		expectedStatusCode int
+		expectedFlash      string
+	}{
+		{"revoke", "2", http.StatusSeeOther, "API key revoked"},
+		{"revoke missing key", "99", http.StatusNotFound, ""},
+		{"revoke bad id", "abc", http.StatusNotFound, ""},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &recordingRepo{}
+		app.DB = repo
+
+		req, _ := http.NewRequest("POST", "/user/api-keys/"+e.keyID+"/revoke", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("keyID", e.keyID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.RevokeAPIKey).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if flash := app.Session.GetString(req.Context(), "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+		recorded := len(repo.events) == 1 && repo.events[0].Action == audit.ActionAPIKeyRevoke && repo.events[0].TargetID == e.keyID
+		if recorded != (rr.Code == http.StatusSeeOther) {
+			t.Errorf("%s: unexpected events %+v", e.name, repo.events)
+		}
+	}
+}
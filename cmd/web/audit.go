@@ -0,0 +1,106 @@
+package main
+
+import (
+	stderrors "errors" // forms.goのerrors型と名前が衝突するため
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http"
+	"strconv"
+)
+
+// auditEvent returns an event of action on the target, done by the user of
+// the session, if any.
+func (app *application) auditEvent(r *http.Request, action, targetType string, targetID int) data.AuditEvent {
+	e := audit.FromRequest(r, app.ipFromContext(r.Context()), action, targetType, targetID)
+	if user, ok := app.Session.Get(r.Context(), "user").(data.User); ok {
+		e.ActorID = user.ID
+		e.Actor = user.Email
+	}
+	return e
+}
+
+// recordAudit appends e to the audit log. Failures are only logged.
+func (app *application) recordAudit(r *http.Request, e data.AuditEvent) {
+	audit.Record(r.Context(), app.db(r.Context()), e)
+}
+
+// recordLogin records a login with the form. email is the address given, and
+// user the user with it, if any.
+func (app *application) recordLogin(r *http.Request, ok bool, email string, user *data.User) {
+	action := audit.ActionLoginFailed
+	if ok {
+		action = audit.ActionLogin
+	}
+	e := app.auditEvent(r, action, audit.TargetUser, 0)
+	// セッションに残っているのは前にログインしたユーザかもしれない
+	e.Actor = email
+	e.ActorID = 0
+	if user != nil {
+		e.TargetID = strconv.Itoa(user.ID)
+		if ok {
+			e.ActorID = user.ID
+		}
+	}
+	app.recordAudit(r, e)
+}
+
+// imageFields returns the fields of a profile picture recorded in the audit
+// log.
+func imageFields(i *data.UserImage) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":    i.UserID,
+		"file_name":  i.FileName,
+		"is_current": i.IsCurrent,
+	}
+}
+
+// auditRow is an event on the audit log page.
+type auditRow struct {
+	*data.AuditEvent
+	Changes []audit.FieldChange
+}
+
+// AuditLog shows the audit log to administrators, newest first, filtered by
+// the query parameters of the form on the page.
+func (app *application) AuditLog(w http.ResponseWriter, r *http.Request) {
+	td := map[string]any{
+		"Query":   r.URL.Query(),
+		"Actions": audit.Actions,
+	}
+
+	f, err := audit.ParseFilter(r.URL.Query())
+	var paramErr *audit.ParamError
+	if stderrors.As(err, &paramErr) {
+		td["FilterError"] = paramErr.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		_ = app.render(w, r, "audit.page.gohtml", &TemplateData{Data: td})
+		return
+	}
+
+	page, err := audit.List(app.db(r.Context()), f)
+	if err != nil {
+		requestlog.FromContext(r.Context()).Error("listing the audit log", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]auditRow, 0, len(page.Events))
+	for _, e := range page.Events {
+		changes, err := audit.Changes(e)
+		if err != nil {
+			requestlog.FromContext(r.Context()).Warn("reading the changes of an audit event", "id", e.ID, "err", err)
+		}
+		rows = append(rows, auditRow{AuditEvent: e, Changes: changes})
+	}
+	td["Events"] = rows
+
+	// 次のページは同じ条件にカーソルだけを付け替える
+	if page.NextCursor != 0 {
+		q := r.URL.Query()
+		q.Set("cursor", strconv.FormatInt(page.NextCursor, 10))
+		td["Next"] = "?" + q.Encode()
+	}
+
+	_ = app.render(w, r, "audit.page.gohtml", &TemplateData{Data: td})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_app_admin(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name               string
+		user               *data.User
+		expectedStatusCode int
+	}{
+		{"admin", &data.User{ID: 1, IsAdmin: 1}, http.StatusOK},
+		{"not an admin", &data.User{ID: 2}, http.StatusForbidden},
+		{"not logged in", nil, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/audit", nil)
+		req = addContextAndSessionToRequest(req, app)
+		if e.user != nil {
+			app.Session.Put(req.Context(), "user", *e.user)
+		}
+		rr := httptest.NewRecorder()
+		app.admin(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_AuditLog(t *testing.T) {
+	tests := []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expected           []string
+		unexpected         []string
+	}{
+		{"every event", "", http.StatusOK, []string{"auth.login", "user.update", "<strong>first_name</strong>: Admin &rarr; Ada"}, []string{"Older"}},
+		{"by action", "?action=auth.login", http.StatusOK, []string{`<option value="auth.login" selected>`, "admin@example.com (#1)"}, []string{"<td>user.update</td>"}},
+		{"first page", "?limit=1", http.StatusOK, []string{"<td>user.update</td>", `href="/admin/audit?cursor=2&amp;limit=1"`}, []string{"<td>auth.login</td>"}},
+		{"nothing found", "?until=2000-01-01", http.StatusOK, []string{"No events."}, []string{"<table"}},
+		{"bad parameter", "?actor_id=abc", http.StatusBadRequest, []string{"actor_id must be a positive integer"}, []string{"No events."}},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/audit"+e.query, nil)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1, IsAdmin: 1})
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.AuditLog).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		body := rr.Body.String()
+		for _, s := range e.expected {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: expected %q in the page", e.name, s)
+			}
+		}
+		for _, s := range e.unexpected {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: did not expect %q in the page", e.name, s)
+			}
+		}
+	}
+}
+
+// recordingRepo keeps the audit events written by the handlers.
+type recordingRepo struct {
+	dbrepo.TestDBRepo
+	events []data.AuditEvent
+}
+
+func (m *recordingRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	m.events = append(m.events, e)
+	return &e, nil
+}
+
+func Test_app_Login_audit(t *testing.T) {
+	tests := []struct {
+		name            string
+		email           string
+		password        string
+		expectedAction  string
+		expectedActorID int
+		expectedTarget  string
+	}{
+		{"valid login", "admin@example.com", "secret", audit.ActionLogin, 1, "1"},
+		{"wrong password", "admin@example.com", "wrong", audit.ActionLoginFailed, 0, "1"},
+		{"unknown email", "nobody@example.com", "secret", audit.ActionLoginFailed, 0, ""},
+	}
+
+	saved := app.DB
+	defer func() { app.DB = saved }()
+
+	for _, e := range tests {
+		repo := &recordingRepo{}
+		app.DB = repo
+
+		form := url.Values{"email": {e.email}, "password": {e.password}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+		if len(repo.events) != 1 {
+			t.Errorf("%s: expected one event but got %d", e.name, len(repo.events))
+			continue
+		}
+		ev := repo.events[0]
+		if ev.Action != e.expectedAction || ev.ActorID != e.expectedActorID || ev.Actor != e.email || ev.TargetID != e.expectedTarget {
+			t.Errorf("%s: unexpected event %+v", e.name, ev)
+		}
+	}
+}
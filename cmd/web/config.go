@@ -0,0 +1,108 @@
+package main
+
+import (
+	stderrors "errors"
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/secheaders"
+	"time"
+)
+
+// appConfig is the configuration of the web server, loaded by config.Load.
+type appConfig struct {
+	Server    config.Server    `yaml:"server"`
+	Database  config.Database  `yaml:"database"`
+	Storage   config.Storage   `yaml:"storage"` // cmd/apiと同じストレージを指定すること
+	URLExpiry time.Duration    `yaml:"url_expiry" flag:"url-expiry" usage:"lifetime of signed file URLs"`
+	Clamd     string           `yaml:"clamd" flag:"clamd" usage:"clamd address for malware scanning, host:port or unix:/path; uploads are not scanned when empty"`
+	Log       config.Log       `yaml:"log"`
+	Metrics   config.Metrics   `yaml:"metrics"`
+	Tracing   config.Tracing   `yaml:"tracing"`
+	TLS       config.TLS       `yaml:"tls"`
+	Proxy     config.Proxy     `yaml:"proxy"`
+	RateLimit config.RateLimit `yaml:"rate_limit"`
+	Security  securityConfig   `yaml:"security"`
+}
+
+// securityConfig is the default security headers of the pages. Routes may
+// replace them, as uploaded files do.
+type securityConfig struct {
+	CSP               string `yaml:"csp" flag:"csp" usage:"Content-Security-Policy of the pages; 'nonce' stands for the nonce of the request"`
+	ReportOnly        bool   `yaml:"csp_report_only" flag:"csp-report-only" usage:"only report violations of the Content-Security-Policy to /csp-report instead of blocking them"`
+	ReferrerPolicy    string `yaml:"referrer_policy" flag:"referrer-policy" usage:"Referrer-Policy of the pages"`
+	PermissionsPolicy string `yaml:"permissions_policy" flag:"permissions-policy" usage:"Permissions-Policy of the pages"`
+}
+
+// defaultSecurity allows the Bootstrap stylesheet from the CDN and inline
+// scripts and styles with the nonce of the request, and forbids framing.
+func defaultSecurity() securityConfig {
+	return securityConfig{
+		CSP:               "default-src 'self'; script-src 'self' 'nonce'; style-src 'self' 'nonce' https://cdn.jsdelivr.net; img-src 'self' data:; font-src 'self' https://cdn.jsdelivr.net; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'",
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+	}
+}
+
+// Policy returns the headers of the configuration. imageOrigin, the origin
+// uploaded files are served from when it is not this server, is added to
+// img-src.
+func (c securityConfig) Policy(imageOrigin string) (secheaders.Policy, error) {
+	csp, err := secheaders.ParseCSP(c.CSP)
+	if err != nil {
+		return secheaders.Policy{}, err
+	}
+	if imageOrigin != "" {
+		sources, ok := csp.Get("img-src")
+		if !ok {
+			sources = []string{"'self'"}
+		}
+		csp = csp.With("img-src", append(append([]string(nil), sources...), imageOrigin)...)
+	}
+	return secheaders.Policy{
+		CSP:               csp,
+		ReportOnly:        c.ReportOnly,
+		ReportURI:         cspReportPath,
+		ReferrerPolicy:    c.ReferrerPolicy,
+		PermissionsPolicy: c.PermissionsPolicy,
+	}, nil
+}
+
+// securityPolicy returns the security headers of the pages. Pictures in S3 are
+// shown through signed URLs of the S3 endpoint, and those under an absolute
+// storage-url come from its origin, so that origin is added to img-src.
+func (c appConfig) securityPolicy() (secheaders.Policy, error) {
+	return c.Security.Policy(c.Storage.Origin())
+}
+
+func defaultConfig() appConfig {
+	return appConfig{
+		Server:    config.DefaultServer(8080),
+		Database:  config.DefaultDatabase(),
+		Storage:   config.DefaultStorage(),
+		URLExpiry: 15 * time.Minute,
+		Log:       config.DefaultLog(),
+		Tracing:   config.DefaultTracing(),
+		TLS:       config.DefaultTLS(),
+		RateLimit: config.DefaultRateLimit(),
+		Security:  defaultSecurity(),
+	}
+}
+
+func (c appConfig) Validate() error {
+	return stderrors.Join(
+		c.Server.Validate(),
+		c.Database.Validate(),
+		c.Storage.Validate(),
+		c.Log.Validate(),
+		c.Metrics.Validate(),
+		c.Tracing.Validate(),
+		c.TLS.Validate(),
+		c.Proxy.Validate(),
+		c.RateLimit.Validate(),
+		c.Security.Validate(),
+	)
+}
+
+func (c securityConfig) Validate() error {
+	_, err := secheaders.ParseCSP(c.CSP)
+	return err
+}
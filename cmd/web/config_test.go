@@ -0,0 +1,48 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/config"
+	"strings"
+	"testing"
+)
+
+func Test_defaultConfig(t *testing.T) {
+	cfg := defaultConfig()
+	if err := config.Load("web", &cfg, nil); err != nil {
+		t.Fatalf("expected the defaults to be valid but got %v", err)
+	}
+	if cfg.Server.Addr() != ":8080" {
+		t.Errorf("expected the web server on :8080 but got %s", cfg.Server.Addr())
+	}
+}
+
+func Test_appConfig_env(t *testing.T) {
+	t.Setenv("WEBAPP_DSN", "host=db")
+	t.Setenv("WEBAPP_STORAGE_PATH", "/srv/uploads")
+
+	cfg := defaultConfig()
+	if err := config.Load("web", &cfg, []string{"-storage-path", "/tmp/uploads"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database.DSN != "host=db" {
+		t.Errorf("expected the dsn from the environment but got %q", cfg.Database.DSN)
+	}
+	// フラグが環境変数より優先される
+	if cfg.Storage.Root != "/tmp/uploads" {
+		t.Errorf("expected the storage path from the flag but got %q", cfg.Storage.Root)
+	}
+}
+
+func Test_appConfig_Validate(t *testing.T) {
+	cfg := defaultConfig()
+	err := config.Load("web", &cfg, []string{"-storage", "ftp", "-metrics-user", "prometheus", "-csp", "img_src 'self'"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{"storage", "metrics-password", "img_src"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+}
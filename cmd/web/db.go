@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"log/slog"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -30,6 +33,13 @@ func (app *application) connectToDB() (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Println("connected to Postgres")
+	slog.Info("connected to Postgres")
 	return connection, nil
 }
+
+// db returns the repository for the request of ctx. The repository is bound
+// to ctx, so that its spans belong to the request and it logs with the
+// request ID.
+func (app *application) db(ctx context.Context) repository.DatabaseRepo {
+	return dbrepo.WithContext(ctx, app.DB)
+}
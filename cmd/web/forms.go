@@ -1,6 +1,7 @@
 package main
 
 import (
+	"go_test_prac/webApp/pkg/validation"
 	"net/url"
 	"strings"
 )
@@ -20,15 +21,24 @@ func (e errors) Add(field, message string) {
 	e[field] = append(e[field], message)
 }
 
+// AddAll adds the messages of errs, translated with c.
+func (e errors) AddAll(errs validation.Errors, c validation.Catalog) {
+	for _, fe := range errs {
+		e.Add(fe.Field, c.Message(fe))
+	}
+}
+
 type Form struct {
 	Data url.Values
 	Errors errors
+	Catalog validation.Catalog // messages of Validate
 }
 
 func NewForm(data url.Values) *Form {
 	return &Form{
 		Data: data,
 		Errors: errors(map[string][]string{}),
+		Catalog: validation.English,
 	}
 }
 
@@ -55,6 +65,13 @@ func (f *Form) Check(ok bool, key, message string) {
 	}
 }
 
+// Validate checks the posted value of field against rules, see package validation.
+func (f *Form) Validate(field string, rules ...validation.Rule) {
+	v := validation.New()
+	v.Field(field, f.Data.Get(field), rules...)
+	f.Errors.AddAll(v.Errors, f.Catalog)
+}
+
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
 }
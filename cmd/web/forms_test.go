@@ -1,6 +1,7 @@
 package main
 
 import (
+	"go_test_prac/webApp/pkg/validation"
 	"net/http/httptest"
 	"net/url"
 	"testing"
@@ -62,3 +63,29 @@ func TestForm_ErrorGet(t *testing.T) {
 		t.Error("should not have an error but got one")
 	}
 }
+
+func TestForm_Validate(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("email", "admin")
+	postedData.Add("name", "admin")
+	form := NewForm(postedData)
+
+	form.Validate("email", validation.Required(), validation.Email())
+	form.Validate("name", validation.Required(), validation.MaxLength(10))
+	if form.Valid() {
+		t.Error("form shows valid when the email is invalid")
+	}
+	if form.Errors.Get("email") != "must be a valid email address" {
+		t.Errorf("unexpected error for email: %q", form.Errors.Get("email"))
+	}
+	if form.Errors.Get("name") != "" {
+		t.Errorf("unexpected error for name: %q", form.Errors.Get("name"))
+	}
+
+	form = NewForm(postedData)
+	form.Catalog = validation.Japanese
+	form.Validate("email", validation.Email())
+	if form.Errors.Get("email") != "有効なメールアドレスを入力してください" {
+		t.Errorf("expected a japanese message, got %q", form.Errors.Get("email"))
+	}
+}
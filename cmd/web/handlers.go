@@ -1,20 +1,26 @@
 package main
 
 import (
-	"fmt"
+	stderrors "errors" // forms.goのerrors型と名前が衝突するため
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/avatar"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/imaging"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/secheaders"
+	"go_test_prac/webApp/pkg/tracing"
+	"go_test_prac/webApp/pkg/validation"
 	"html/template"
-	"io"
-	"log"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
+	"strconv"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 var pathToTemplates = "./templates/"
-var uploadPath = "./static/img/"
 
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	var td = make(map[string]any)
@@ -38,16 +44,24 @@ type TemplateData struct{
 	Error string
 	Flash string
 	User data.User
+	CSPNonce string // インラインの<script>と<style>に付けるノンス
 }
-func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) error {
+func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) (err error) {
+	_, span := tracing.Start(r.Context(), "render", tracing.String("template", t))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
 	// parse the template from disk.
-	parsedTemplate, err := template.ParseFiles(path.Join(pathToTemplates, t), path.Join(pathToTemplates, "base.layout.gohtml"))
+	parsedTemplate, err := template.New(t).Funcs(app.templateFuncs(r)).ParseFiles(path.Join(pathToTemplates, t), path.Join(pathToTemplates, "base.layout.gohtml"))
 	if err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return err
 	}
 
 	td.IP = app.ipFromContext(r.Context())
+	td.CSPNonce = secheaders.Nonce(r.Context())
 
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -64,10 +78,39 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, t string,
 	return nil
 }
 
+// templateFuncs returns the functions available in templates. Image URLs are
+// generated by the storage backend, so they may be signed and expire.
+func (app *application) templateFuncs(r *http.Request) template.FuncMap {
+	imageURL := func(fileName string) string {
+		u, err := app.Storage.URL(r.Context(), fileName, app.URLExpiry)
+		if err != nil {
+			requestlog.FromContext(r.Context()).Error("signing an image URL", "file", fileName, "err", err)
+			return ""
+		}
+		return u
+	}
+
+	return template.FuncMap{
+		"imageURL": imageURL,
+		"srcset": func(i data.UserImage) string {
+			return i.SrcSet(imageURL)
+		},
+	}
+}
+
 func (app *application) Login(w http.ResponseWriter, r *http.Request) {
+	success := false
+	defer func() {
+		if success {
+			app.Metrics.Logins.Inc("success")
+		} else {
+			app.Metrics.Logins.Inc("failure")
+		}
+	}()
+
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		requestlog.FromContext(r.Context()).Warn("parsing the login form", "err", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
@@ -75,6 +118,7 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	// validate data
 	form := NewForm(r.PostForm)
 	form.Required("email", "password")
+	form.Validate("email", validation.Email(), validation.MaxLength(255))
 
 	if !form.Valid() {
 		// redirect toh the login page with error message
@@ -87,8 +131,9 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
-	user, err := app.DB.GetUserByEmail(email)
+	user, err := app.db(r.Context()).GetUserByEmail(email)
 	if err != nil {
+		app.recordLogin(r, false, email, nil)
 		// redirect toh the login page with error message
 		app.Session.Put(r.Context(), "error", "Invalid Login")// add msg in context
 		http.Redirect(w, r, "/", http.StatusSeeOther)// 303, 別ページへの移動
@@ -96,12 +141,16 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !app.authenticate(r, user, password) {
+		app.recordLogin(r, false, email, user)
 		// redirect toh the login page with error message
 		app.Session.Put(r.Context(), "error", "Invalid Login")// add msg in context
 		http.Redirect(w, r, "/", http.StatusSeeOther)// 303, 別ページへの移動
 		return
 	}
 
+	success = true
+	app.recordLogin(r, true, email, user)
+
 	// prevent fixation attack(セッション固定攻撃対策)
 	_ = app.Session.RenewToken(r.Context())
 
@@ -115,16 +164,23 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 // DBから取得したユーザのパスワード確認後、セッションにユーザ情報を格納
 func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
-	if valid, err := user.PasswordMatches(password); err != nil || !valid {
+	// bcryptは意図的に遅いので、どれだけ時間を使ったか見えるようにする
+	_, span := tracing.Start(r.Context(), "bcrypt.CompareHashAndPassword")
+	valid, err := user.PasswordMatches(password)
+	span.RecordError(err)
+	span.End()
+	if err != nil || !valid {
 		return false
 	}
 
 	app.Session.Put(r.Context(), "user", user)
+	// CLIでセッションが失効させられたかを、ログインした時刻と比べて判定する
+	app.Session.Put(r.Context(), "login_at", time.Now().UnixNano())
 	return true
 }
 
 func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request) {
-	files, err := app.UploadFiles(r, uploadPath)
+	_, content, err := avatar.ReadUpload(w, r, avatar.DefaultMaxSize)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -132,19 +188,41 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 
 	user := app.Session.Get(r.Context(), "user").(data.User)//ミドルウェアに守られているからユーザはnilにならない
 
-	var i = data.UserImage{
-		UserID: user.ID,
-		FileName: files[0].OriginalFileName,
+	// EXIFを取り除き、正方形の各サイズの画像を生成してストレージに保存する
+	i, files, err := app.Avatars.Save(r.Context(), content)
+	if stderrors.Is(err, scanner.ErrInfected) || stderrors.Is(err, scanner.ErrUnavailable) || stderrors.Is(err, scanner.ErrTooLargeToScan) ||
+		stderrors.Is(err, imaging.ErrTooManyPixels) {
+		// フォームにエラーを表示する
+		app.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	i.UserID = user.ID
+
+	// DBに登録できなかったファイルは参照されないので、後でGCが削除する
+	err = app.db(r.Context()).InsertStoredFiles(files...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	_, err = app.DB.InsertUserImage(i)
+	i.ID, err = app.db(r.Context()).InsertUserImage(i)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	e := app.auditEvent(r, audit.ActionImageUpload, audit.TargetImage, i.ID)
+	e.Changes = audit.Diff(nil, imageFields(&i))
+	app.recordAudit(r, e)
+	app.Metrics.UploadSize.Observe(float64(len(content)), "avatar")
+
 	// ユーザ情報を更新
-	updatadUser, err := app.DB.GetUser(user.ID)
+	updatadUser, err := app.db(r.Context()).GetUser(user.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -155,54 +233,78 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
 
-type UploadedFile struct {
-	OriginalFileName string
-	FileSize int64
+// SetProfilePic makes one of the user's earlier uploads the current profile picture.
+func (app *application) SetProfilePic(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	i, ok := app.userImageFromURL(r, user)
+	if !ok {
+		http.Error(w, "image not found", http.StatusNotFound)
+		return
+	}
+
+	err := app.db(r.Context()).SetCurrentUserImage(user.ID, i.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionImageCurrent, audit.TargetImage, i.ID)
+	e.Changes = audit.Diff(map[string]interface{}{"is_current": i.IsCurrent}, map[string]interface{}{"is_current": true})
+	app.recordAudit(r, e)
+
+	app.refreshSessionUser(w, r, user.ID, "Profile picture updated")
+}
+
+// DeleteProfilePic deletes one of the user's uploads. Files no other image
+// refers to are removed later by package filegc.
+func (app *application) DeleteProfilePic(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	i, ok := app.userImageFromURL(r, user)
+	if !ok {
+		http.Error(w, "image not found", http.StatusNotFound)
+		return
+	}
+
+	err := app.db(r.Context()).DeleteUserImage(i.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e := app.auditEvent(r, audit.ActionImageDelete, audit.TargetImage, i.ID)
+	e.Changes = audit.Diff(imageFields(i), nil)
+	app.recordAudit(r, e)
+
+	app.refreshSessionUser(w, r, user.ID, "Profile picture deleted")
 }
 
-// 対象のディレクトリにリクエストから送られてきたファイルを保存する関数
-func (app *application) UploadFiles(r *http.Request, uploadDir string) ([]*UploadedFile, error) {
-	var uploadedFiles []*UploadedFile
+// userImageFromURL loads the image named by the imageID URL parameter, making
+// sure that it belongs to the user.
+func (app *application) userImageFromURL(r *http.Request, user data.User) (*data.UserImage, bool) {
+	imageID, err := strconv.Atoi(chi.URLParam(r, "imageID"))
+	if err != nil {
+		return nil, false
+	}
+
+	i, err := app.db(r.Context()).GetUserImage(imageID)
+	if err != nil || i.UserID != user.ID {
+		return nil, false
+	}
 
-	err := r.ParseMultipartForm(int64(1024 * 1024 * 5)) // 5MB
+	return i, true
+}
+
+// refreshSessionUser reloads the user into the session and redirects to the profile page.
+func (app *application) refreshSessionUser(w http.ResponseWriter, r *http.Request, userID int, flash string) {
+	updatedUser, err := app.db(r.Context()).GetUser(userID)
 	if err != nil {
-		return nil, fmt.Errorf("the uploaded file is too big. Please choose an image less than 5MB in size")
-	}
-
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func (uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()//　メモリリーク防止
-
-				uploadedFile.OriginalFileName = hdr.Filename
-
-				var outfile *os.File
-				defer outfile.Close()//　メモリリーク防止
-
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.OriginalFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-
-				return uploadedFiles, nil
-			}(uploadedFiles)
-			if err != nil {
-				return uploadedFiles, err
-			}
-		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	return uploadedFiles, nil
+	app.Session.Put(r.Context(), "user", updatedUser)
+	app.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	stderrors "errors"
 	"fmt"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
 	"image"
 	"image/png"
 	"io"
@@ -15,9 +18,12 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -165,6 +171,15 @@ func Test_app_Login(t *testing.T) {
 			expectedStatusCode: http.StatusSeeOther,
 			expectedLocation: "/",
 		},
+		{
+			name: "invalid email",
+			postedData: url.Values{
+				"email": {"admin"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLocation: "/",
+		},
 		{
 			name: "user not found",
 			postedData: url.Values{
@@ -191,11 +206,22 @@ func Test_app_Login(t *testing.T) {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(app.Login)
+
+		// ログインの結果がメトリクスに数えられる
+		result := "failure"
+		if e.expectedLocation == "/user/profile" {
+			result = "success"
+		}
+		before := app.Metrics.Logins.Value(result)
+
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s: returned wrong status code; expected %d, got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
+		if app.Metrics.Logins.Value(result) != before+1 {
+			t.Errorf("%s: expected the login to be counted as %s", e.name, result)
+		}
 
 		actualLocation, err := rr.Result().Location()
 		if err == nil {
@@ -209,7 +235,8 @@ func Test_app_Login(t *testing.T) {
 }
 
 
-func Test_app_UploadFiles(t *testing.T) {
+// ファイルをストリーミングでアップロードした場合のテスト
+func Test_app_UploadProfilePicStreamed(t *testing.T) {
 	// set up pipe
 	pr, pw := io.Pipe()//writerに書き込んだデータをreaderで読み込めるようにする(バッファ)
 
@@ -224,25 +251,35 @@ func Test_app_UploadFiles(t *testing.T) {
 	go simulatePNGUpload("./testdata/img.png", writer, t, wg)//goroutineを使うのは同時に複数のファイルをアップロードすることを想定しているため
 
 	// read from the pipe which recieves the file
-	request := httptest.NewRequest("POST", "/", pr)
+	request := httptest.NewRequest("POST", "/user/upload-profile-pic", pr)
+	request = addContextAndSessionToRequest(request, app)
+	app.Session.Put(request.Context(), "user", data.User{ID: 1})
 	request.Header.Add("Content-Type", writer.FormDataContentType())
 
-	// call app.UploadFiles()、この上まではリクエストの生成を再現し、それをapp.UploadFiles()に渡している
-	UploadedFiles, err := app.UploadFiles(request, "./testdata/uploads/")
-	if err != nil {
-		t.Error(err)
-	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.UploadProfilePic).ServeHTTP(rr, request)
+	wg.Wait()
 
 	// perform our tests
-	// この関数の評価としては、来たリクエストのファイルが、ちゃんと意図したディレクトリに保存されているか。
-	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", UploadedFiles[0].OriginalFileName)); os.IsNotExist(err) {
-		t.Errorf("expected file to exist: %s",err.Error())
+	// この関数の評価としては、来たリクエストのファイルが、ちゃんとストレージに保存されているか。
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d; got %d: %s", http.StatusSeeOther, rr.Code, rr.Body.String())
 	}
 
 	// clean up
-	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", UploadedFiles[0].OriginalFileName))
+	files, _ := filepath.Glob("./testdata/uploads/" + hashedName + "*.png")
+	if len(files) == 0 {
+		t.Error("expected the uploaded file to be stored")
+	}
+	for _, f := range files {
+		_ = os.Remove(f)
+	}
 }
 
+// hashedName matches the file names of stored uploads, which are the hex
+// encoded SHA-256 of their content.
+var hashedName = strings.Repeat("[0-9a-f]", 64)
+
 // .testData/img.pngを予め用意しておき、それをmultipart.Writerに書き込む
 // ここでやっているのは、multipart/form-dataで<form>タグから送信されるリクエストを再現するためのもの
 // img.pngがform-dataのfileフィールドにあたる
@@ -275,8 +312,7 @@ func simulatePNGUpload(fileToUpload string, writer *multipart.Writer, t *testing
 
 // 予期したステータスが帰ってくるのかのテスト
 func Test_app_UploadProfilePic(t *testing.T) {
-	uploadPath = "./testdata/uploads/"
-	filepath := "./testdata/img.png"
+	imagePath := "./testdata/img.png"
 
 	// specify a field name for the form
 	fiedlName := "file"
@@ -287,12 +323,12 @@ func Test_app_UploadProfilePic(t *testing.T) {
 	// create a new writer
 	mw := multipart.NewWriter(body)
 
-	file, err := os.Open(filepath)
+	file, err := os.Open(imagePath)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	w, err := mw.CreateFormFile(fiedlName, filepath)//form-dataの生成、fileフィールド作成
+	w, err := mw.CreateFormFile(fiedlName, imagePath)//form-dataの生成、fileフィールド作成
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -318,4 +354,218 @@ func Test_app_UploadProfilePic(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("expected status %d; got %d", http.StatusSeeOther, rr.Code)
 	}
+
+	// img.pngは225x225なので、64と225の正方形画像が生成される
+	// ファイル名は内容のSHA-256になる(<hash>-64.png)
+	for _, size := range []int{64, 225} {
+		variants, _ := filepath.Glob(fmt.Sprintf("./testdata/uploads/%s-%d.png", hashedName, size))
+		if len(variants) != 1 {
+			t.Errorf("expected one variant of size %d but found %v", size, variants)
+		}
+		for _, v := range variants {
+			_ = os.Remove(v)
+		}
+	}
+	originals, _ := filepath.Glob("./testdata/uploads/" + hashedName + ".png")
+	if len(originals) != 1 {
+		t.Errorf("expected one processed original but found %v", originals)
+	}
+	for _, o := range originals {
+		_ = os.Remove(o)
+	}
+}
+
+// infectedScanner reports every upload as infected with the EICAR test file.
+type infectedScanner struct{}
+
+func (infectedScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	return scanner.Result{Status: scanner.Infected, Signature: "Eicar-Signature"}, nil
+}
+
+// failingScanner cannot reach its daemon.
+type failingScanner struct{}
+
+func (failingScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	return scanner.Result{}, stderrors.New("clamd: dial tcp 127.0.0.1:3310: connection refused")
+}
+
+// 感染した画像と検査できなかった画像は保存せず、フォームにエラーを表示する
+func Test_app_UploadProfilePicRejected(t *testing.T) {
+	var tests = []struct {
+		name          string
+		scanner       scanner.UploadScanner
+		expectedError string
+	}{
+		{"infected", infectedScanner{}, "malware"},
+		{"scanner down", failingScanner{}, "try again later"},
+	}
+
+	content, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { app.Avatars.Scanner = nil }()
+
+	for _, e := range tests {
+		app.Avatars.Scanner = e.scanner
+
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		w, _ := mw.CreateFormFile("file", "img.png")
+		_, _ = w.Write(content)
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/user/upload-profile-pic", body)
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		req.Header.Add("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.UploadProfilePic).ServeHTTP(rr, req)
+
+		// プロフィール画面に戻り、フォームにエラーを表示する
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/profile" {
+			t.Errorf("%s: expected a redirect to the profile but got %d %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+		if msg := app.Session.GetString(req.Context(), "error"); !strings.Contains(msg, e.expectedError) {
+			t.Errorf("%s: expected an error about %q but got %q", e.name, e.expectedError, msg)
+		}
+
+		files, _ := filepath.Glob("./testdata/uploads/" + hashedName + "*.png")
+		if len(files) != 0 {
+			t.Errorf("%s: the rejected upload was stored: %v", e.name, files)
+		}
+	}
+}
+
+func Test_app_profilePicActions(t *testing.T) {
+	var tests = []struct {
+		name               string
+		imageID            string
+		handler            http.HandlerFunc
+		expectedStatusCode int
+		expectedFlash      string
+	}{
+		{"set current", "2", app.SetProfilePic, http.StatusSeeOther, "Profile picture updated"},
+		{"set current of another user", "3", app.SetProfilePic, http.StatusNotFound, ""},
+		{"set current missing image", "99", app.SetProfilePic, http.StatusNotFound, ""},
+		{"set current bad id", "abc", app.SetProfilePic, http.StatusNotFound, ""},
+		{"delete", "2", app.DeleteProfilePic, http.StatusSeeOther, "Profile picture deleted"},
+		{"delete image of another user", "3", app.DeleteProfilePic, http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/images/"+e.imageID+"/current", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("imageID", e.imageID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = addContextAndSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if flash := app.Session.GetString(req.Context(), "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+func TestApp_renderProfileWithHistory(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	user, _ := app.DB.GetUser(1)
+	images, _ := app.DB.AllUserImages(1)
+	for _, i := range images {
+		user.Images = append(user.Images, *i)
+	}
+	user.ProfilePic = user.Images[0]
+	app.Session.Put(req.Context(), "user", *user)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Profile)
+	handler.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `action="/user/images/2/current"`) {
+		t.Errorf("did not find the form to use an old picture in %s", body)
+	}
+	if strings.Contains(body, `action="/user/images/1/current"`) {
+		t.Errorf("the current picture should not have a form to use it in %s", body)
+	}
+	if !strings.Contains(body, `action="/user/images/1/delete"`) || !strings.Contains(body, `action="/user/images/2/delete"`) {
+		t.Errorf("did not find the delete forms in %s", body)
+	}
+}
+
+func TestApp_renderProfileWithSrcSet(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{
+		ID:         1,
+		ProfilePic: data.UserImage{FileName: "me.jpg", Sizes: []int{64, 256, 512}},
+	})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Profile)
+	handler.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `src="/static/img/me-256.jpg"`) {
+		t.Errorf("did not find the 256px variant as src in %s", body)
+	}
+	if !strings.Contains(body, `srcset="/static/img/me-64.jpg 64w, /static/img/me-256.jpg 256w, /static/img/me-512.jpg 512w"`) {
+		t.Errorf("did not find srcset in %s", body)
+	}
+}
+
+func TestApp_renderProfileWithSignedURLs(t *testing.T) {
+	oldStorage := app.Storage
+	app.Storage, _ = storage.NewLocal("./testdata/uploads/", "/static/img/", "secret")
+	defer func() { app.Storage = oldStorage }()
+
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{
+		ID:         1,
+		ProfilePic: data.UserImage{FileName: "me.jpg", Sizes: []int{64, 256}},
+	})
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Profile)
+	handler.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `src="/static/img/me-256.jpg?expires=`) {
+		t.Errorf("did not find a signed src in %s", body)
+	}
+	if strings.Count(body, "signature=") != 3 {
+		t.Errorf("expected src and both srcset candidates to be signed in %s", body)
+	}
+}
+
+func Test_app_UploadProfilePicRejectsNonImages(t *testing.T) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	w, _ := mw.CreateFormFile("image", "evil.png")
+	_, _ = w.Write([]byte("<html><script>alert(1)</script></html>"))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/user/upload-profile-pic", body)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	req.Header.Add("Content-Type", mw.FormDataContentType())
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.UploadProfilePic).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d; got %d", http.StatusBadRequest, rr.Code)
+	}
+	if files, _ := filepath.Glob("./testdata/uploads/evil-*"); len(files) != 0 {
+		t.Errorf("rejected upload was stored: %v", files)
+	}
 }
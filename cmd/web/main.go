@@ -1,52 +1,192 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/gob"
-	"flag"
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/clientip"
+	"go_test_prac/webApp/pkg/config"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/health"
+	"go_test_prac/webApp/pkg/metrics"
+	"go_test_prac/webApp/pkg/ratelimit"
 	"go_test_prac/webApp/pkg/repository"
 	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/secheaders"
+	"go_test_prac/webApp/pkg/storage"
+	"go_test_prac/webApp/pkg/tracing"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
 )
 
 type application struct {
 	DSN string // data source name(PW等含む)
 	DB repository.DatabaseRepo // DBconnetion
 	Session *scs.SessionManager
+	Storage storage.Blob // アップロードされたファイルの保存先
+	Avatars *avatar.Store // プロフィール画像の処理と保存
+	URLExpiry time.Duration // 画像URLの有効期限(署名付きURLの場合)
+	Metrics *appMetrics // /metricsで公開するメトリクス
+	MetricsAddr string // /metricsを別のアドレスで配信する場合に指定
+	MetricsUser string // /metricsのベーシック認証
+	MetricsPassword string
+	Health *health.Checker // /healthzと/readyz
+	ClientIP *clientip.Resolver // 信頼できるプロキシを通したクライアントのIPアドレス
+	SecureCookies bool // HTTPSの場合だけCookieにSecureを付ける
+	RateLimiter *ratelimit.Limiter // ログインとユーザーのページのレート制限。nilなら制限しない
+	RateLimits config.RateLimit
+	Security secheaders.Policy // CSPなどのセキュリティヘッダー
 }
 func main() {
 	// app.Session.Put(r.Context(), "user", user)→この関数がgobを使用していて、登録していないとエラーになる
 	gob.Register(data.User{})
 
 	// set up an app config
-	app := application{}
-
-	// flagパッケージを使って、コマンドライン引数をパース
+	// 既定値 < 設定ファイル < 環境変数 < フラグ の順に読み込む
 	// go run ./cmd/web -dsn="user=yourusername password=yourpassword dbname=yourdbname sslmode=disable"
-	// 上記指定しなければ、下記デフォルト値が使用される
-	flag.StringVar(&app.DSN, "dsn", "host=localhost user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
-	flag.Parse()
+	cfg := defaultConfig()
+	config.MustLoad("web", &cfg)
+
+	app := application{
+		DSN:             cfg.Database.DSN,
+		URLExpiry:       cfg.URLExpiry,
+		MetricsAddr:     cfg.Metrics.Addr,
+		MetricsUser:     cfg.Metrics.User,
+		MetricsPassword: cfg.Metrics.Password,
+		// TLSが有効な場合だけCookieにSecureを付ける(HTTPでは送られなくなるため)
+		SecureCookies: cfg.TLS.Enabled(),
+		RateLimits:    cfg.RateLimit,
+	}
+	serverConfig := cfg.Server.ServerConfig()
+
+	// log.Printなども含めて、すべてのログをこのロガーで出力する
+	logger, err := requestlog.NewLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	// X-Forwarded-Forなどは信頼できるプロキシから届いたものだけを使う
+	app.ClientIP, err = cfg.Proxy.Resolver()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ストレージが別のオリジンの場合は、そこからの画像を許可する
+	app.Security, err = cfg.securityPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exporter, err := tracing.New(cfg.Tracing.Exporter, os.Stdout, cfg.Tracing.OTLPEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var provider *tracing.Provider
+	if exporter != nil {
+		provider = tracing.NewProvider("web", exporter, cfg.Tracing.SampleRatio)
+		tracing.SetProvider(provider)
+	}
+
+	store, err := storage.New(cfg.Storage.StorageConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// S3への呼び出しにもトレースコンテキストを渡す
+	if s3, ok := store.(*storage.S3); ok {
+		s3.Client.Transport = &tracing.Transport{}
+	}
+	if local, ok := store.(*storage.Local); ok && len(local.SigningKey) == 0 {
+		slog.Warn("no storage-signing-key, only profile pictures are served")
+	}
+	app.Storage = store
+	app.Avatars = avatar.New(store)
+	app.Avatars.Scanner = scanner.New(cfg.Clamd)
 
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
 
 	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if exporter != nil {
+		app.DB = &dbrepo.TracingDBRepo{Repo: app.DB}
+	}
+
+	// 複数のインスタンスで制限を共有する場合はDBに数える
+	rateLimits := ratelimit.NewStore(cfg.RateLimit.Backend, conn)
+	app.RateLimiter = ratelimit.New(rateLimits)
+
+	app.Health = health.NewServerChecker(app.DB, dbrepo.Schema, app.Storage)
+	app.Metrics = newAppMetrics()
+	metrics.RegisterDBStats(app.Metrics.Registry, conn)
 
 	// get a session manager
-	app.Session = getSession()
+	sessions := memstore.NewWithCleanupInterval(sessionCleanupInterval)
+	app.Session = getSession(app.SecureCookies, sessions)
+
+	// SIGINTかSIGTERMを受けたら、処理中のリクエストを待ってから終了する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 使われなくなったレート制限のカウンターを消す
+	go ratelimit.SweepEvery(ctx, rateLimits, time.Minute, cfg.RateLimit.Idle())
+
+	srv := serverConfig.New(cfg.Server.Addr(), app.routes())
+	servers := []*http.Server{srv}
+	if cfg.TLS.Enabled() {
+		redirect, err := serverConfig.EnableTLS(ctx, cfg.TLS.TLSConfig(), srv, cfg.Server.Port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if redirect != nil {
+			servers = append(servers, redirect)
+		}
+	}
+	if app.MetricsAddr != "" {
+		servers = append(servers, serverConfig.New(app.MetricsAddr, app.metricsRoutes()))
+		slog.Info("serving metrics", "addr", app.MetricsAddr)
+	}
 
 	// print out a message
-	log.Println("starting server on :8080...")
+	slog.Info("starting server", "port", cfg.Server.Port, "tls", cfg.TLS.Enabled())
 
 	// start the server
-	err = http.ListenAndServe(":8080", app.routes())
+	// 停止中は/readyzを503にして、新しいリクエストが来ないようにする
+	err = serverConfig.Run(ctx, app.Health.Shutdown, servers...)
+
+	closeConnections(conn, sessions, provider)
 	if err != nil {
 		log.Fatal(err)
 	}
+	slog.Info("stopped server")
+}
+
+// closeConnections closes the database pool, stops the cleanup of the session
+// store and exports the remaining spans once the server has stopped. Sessions
+// are only kept in memory, so there is nothing to flush: they are lost when
+// the server stops, and users have to log in again after a restart.
+func closeConnections(conn *sql.DB, sessions *memstore.MemStore, provider *tracing.Provider) {
+	if err := conn.Close(); err != nil {
+		slog.Error("closing database", "err", err)
+	}
+	sessions.StopCleanup()
+	if provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Error("exporting remaining spans", "err", err)
+		}
+	}
 }
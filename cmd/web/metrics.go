@@ -0,0 +1,44 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/metrics"
+	"net/http"
+)
+
+// uploadSizeBuckets range from 16KB to 1GB.
+var uploadSizeBuckets = metrics.ExponentialBuckets(16<<10, 4, 9)
+
+// appMetrics are the metrics exported at /metrics, in addition to the HTTP
+// and database pool metrics.
+type appMetrics struct {
+	Registry *metrics.Registry
+	HTTP     *metrics.HTTP
+	// Logins counts submissions of the login form by result, success or failure.
+	Logins *metrics.CounterVec
+	// UploadSize measures accepted profile pictures.
+	UploadSize *metrics.HistogramVec
+}
+
+func newAppMetrics() *appMetrics {
+	r := metrics.NewRegistry()
+	return &appMetrics{
+		Registry:   r,
+		HTTP:       metrics.NewHTTP(r),
+		Logins:     r.Counter("auth_logins_total", "Number of login attempts.", "result"),
+		UploadSize: r.Histogram("upload_size_bytes", "Size of accepted uploads.", uploadSizeBuckets, "kind"),
+	}
+}
+
+// metricsHandler serves the metrics, behind basic authentication when
+// -metrics-user is set.
+func (app *application) metricsHandler() http.Handler {
+	return app.Metrics.Registry.Handler(app.MetricsUser, app.MetricsPassword)
+}
+
+// metricsRoutes serves /metrics on its own address, so that it can be kept
+// off the public network.
+func (app *application) metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metricsHandler())
+	return mux
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_app_metrics(t *testing.T) {
+	saved := app
+	defer func() { app = saved }()
+	app.Metrics = newAppMetrics()
+
+	routes := app.routes()
+	routes.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `http_requests_total{method="GET",route="/",status="200"} 1`+"\n") {
+		t.Errorf("expected the home page to be counted in\n%s", rr.Body.String())
+	}
+
+	// ベーシック認証で守る
+	app.MetricsUser = "prometheus"
+	app.MetricsPassword = "scrape"
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials but got %d", rr.Code)
+	}
+
+	// 別のアドレスで配信する場合はページと一緒に出さない
+	app.MetricsAddr = "127.0.0.1:9091"
+	if routeExists("/metrics", "GET", app.routes().(chi.Routes)) {
+		t.Error("expected /metrics to be off the page router")
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/requestlog"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type contextKey string
@@ -17,44 +19,13 @@ func (app *application) ipFromContext(ctx context.Context) string {
 
 func (app *application) appIPToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
-		var ctx = context.Background()
 		// get the ip as accurately as possible
-		ip, err := getIP(r)
-		if err != nil {
-			ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-			if len(ip) == 0 {
-				ip = "unknown"
-			}
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		} else {
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		}
+		// 信頼できるプロキシを経由している場合だけ転送ヘッダーを見る
+		ctx := context.WithValue(r.Context(), contextUserKey, app.ClientIP.String(r))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func getIP(r *http.Request) (string, error) {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if  err != nil {
-		return "unknown", err
-	}
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("userip: %q is not IP:port", r.RemoteAddr)
-	}
-
-	forward := r.Header.Get("X-Forwarded-For")
-	if len(forward) > 0 {
-		ip = forward
-	}
-
-	if len(ip) == 0 {
-		ip = forward
-	}
-
-	return ip, nil
-}
-
 func (app *application) auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
 		if !app.Session.Exists(r.Context(), "user") {
@@ -62,6 +33,46 @@ func (app *application) auth(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 			return
 		}
+		// リクエストログにユーザーIDを残す
+		if user, ok := app.Session.Get(r.Context(), "user").(data.User); ok {
+			revoked, err := app.sessionRevoked(r, user.ID)
+			if err != nil {
+				requestlog.FromContext(r.Context()).Error("checking session revocation", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				_ = app.Session.Destroy(r.Context())
+				app.Session.Put(r.Context(), "error", "Your session has ended, log in again")
+				http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+				return
+			}
+			r = requestlog.SetUserID(r, strconv.Itoa(user.ID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// admin lets only administrators through. It must come after auth.
+func (app *application) admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		user, ok := app.Session.Get(r.Context(), "user").(data.User)
+		if !ok || user.IsAdmin != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// sessionRevoked reports whether the sessions of the user were revoked, with
+// the CLI, since this session logged in. Sessions without a login time
+// predate revocation and count as revoked once the user has a revocation.
+func (app *application) sessionRevoked(r *http.Request, userID int) (bool, error) {
+	revokedAt, err := app.db(r.Context()).SessionsRevokedAt(userID)
+	if err != nil || revokedAt.IsZero() {
+		return false, err
+	}
+	loginAt := time.Unix(0, app.Session.GetInt64(r.Context(), "login_at"))
+	return !loginAt.After(revokedAt), nil
+}
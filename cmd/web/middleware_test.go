@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"go_test_prac/webApp/pkg/clientip"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_application_addIPToContext(t *testing.T) {
@@ -64,6 +67,46 @@ func Test_application_addIPToContext(t *testing.T) {
 	}
 }
 
+func Test_application_addIPToContext_trustedProxies(t *testing.T) {
+	saved := app.ClientIP
+	defer func() { app.ClientIP = saved }()
+
+	var tests = []struct {
+		name       string
+		header     string
+		trusted    string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"no proxy trusted", "", "", "10.0.0.1:80", "198.51.100.1", "10.0.0.1"},
+		{"spoofed by the client", clientip.HeaderXForwardedFor, "10.0.0.0/8", "203.0.113.7:80", "198.51.100.1", "203.0.113.7"},
+		{"behind a trusted proxy", clientip.HeaderXForwardedFor, "10.0.0.0/8", "10.0.0.1:80", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"proxy sets another header", clientip.HeaderXRealIP, "10.0.0.0/8", "10.0.0.1:80", "198.51.100.1", "10.0.0.1"},
+		{"no remote address", "", "", "", "", "unknown"},
+	}
+
+	for _, e := range tests {
+		app.ClientIP, _ = clientip.Parse(e.header, e.trusted)
+
+		var got string
+		handler := app.appIPToContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = app.ipFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+}
+
 func Test_application_ipFromContext(t *testing.T) {
 	// get a context
 	ctx := context.Background()
@@ -108,3 +151,51 @@ func Test_app_auth(t *testing.T) {
 		}
 	}
 }
+
+// revokedRepo is the test repository with the sessions of every user revoked
+// at revokedAt.
+type revokedRepo struct {
+	dbrepo.TestDBRepo
+	revokedAt time.Time
+}
+
+func (m *revokedRepo) SessionsRevokedAt(userID int) (time.Time, error) {
+	return m.revokedAt, nil
+}
+
+func Test_app_auth_revoked(t *testing.T) {
+	loginAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name         string
+		revokedAt    time.Time
+		setLoginAt   bool
+		expectStatus int
+	}{
+		{"never revoked", time.Time{}, true, http.StatusOK},
+		{"revoked before login", loginAt.Add(-time.Hour), true, http.StatusOK},
+		{"revoked after login", loginAt.Add(time.Second), true, http.StatusTemporaryRedirect},
+		// ログイン時刻を記録する前のセッション
+		{"no login time", loginAt.Add(-time.Hour), false, http.StatusTemporaryRedirect},
+	}
+
+	for _, e := range tests {
+		a := app
+		a.DB = &revokedRepo{revokedAt: e.revokedAt}
+		req := httptest.NewRequest("GET", "http://testing", nil)
+		req = addContextAndSessionToRequest(req, a)
+		a.Session.Put(req.Context(), "user", data.User{ID: 1})
+		if e.setLoginAt {
+			a.Session.Put(req.Context(), "login_at", loginAt.UnixNano())
+		}
+		rr := httptest.NewRecorder()
+		a.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectStatus, rr.Code)
+		}
+		if rr.Code == http.StatusTemporaryRedirect && a.Session.Exists(req.Context(), "user") {
+			t.Errorf("%s: expected the revoked session to be logged out", e.name)
+		}
+	}
+}
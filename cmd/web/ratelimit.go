@@ -0,0 +1,36 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/ratelimit"
+	"net/http"
+)
+
+// loginRateLimit limits login attempts per client address, to slow down
+// password guessing.
+func (app *application) loginRateLimit() func(http.Handler) http.Handler {
+	return app.RateLimiter.Limit(ratelimit.Policy{
+		Name:  "login",
+		Limit: app.RateLimits.Auth,
+		Key:   ratelimit.IP(app.ClientIP),
+	})
+}
+
+// reportRateLimit limits the violation reports per client address, which
+// anyone can send without logging in.
+func (app *application) reportRateLimit() func(http.Handler) http.Handler {
+	return app.RateLimiter.Limit(ratelimit.Policy{
+		Name:  "reports",
+		Limit: app.RateLimits.Clients,
+		Key:   ratelimit.IP(app.ClientIP),
+	})
+}
+
+// userRateLimit limits the pages of a logged in user per user. It must come
+// after auth.
+func (app *application) userRateLimit() func(http.Handler) http.Handler {
+	return app.RateLimiter.Limit(ratelimit.Policy{
+		Name:  "user",
+		Limit: app.RateLimits.Users,
+		Key:   ratelimit.First(ratelimit.User(), ratelimit.IP(app.ClientIP)),
+	})
+}
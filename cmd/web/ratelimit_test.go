@@ -0,0 +1,117 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/config"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rateLimitedApp returns a copy of app limiting to two requests a minute.
+func rateLimitedApp() application {
+	a := app
+	a.RateLimiter = ratelimit.New(ratelimit.NewMemory())
+	a.RateLimits = config.RateLimit{
+		Backend: "memory",
+		Auth:    ratelimit.Limit{Requests: 2, Period: time.Minute},
+		Clients: ratelimit.Limit{Requests: 2, Period: time.Minute},
+		Users:   ratelimit.Limit{Requests: 2, Period: time.Minute},
+	}
+	return a
+}
+
+func Test_app_loginRateLimit(t *testing.T) {
+	a := rateLimitedApp()
+	routes := a.routes()
+
+	var tests = []struct {
+		name          string
+		remoteAddr    string
+		expectLimited bool
+	}{
+		{"first attempt", "192.0.2.1:1234", false},
+		{"second attempt", "192.0.2.1:1234", false},
+		{"third attempt", "192.0.2.1:1234", true},
+		{"other client", "192.0.2.2:1234", false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = e.remoteAddr
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if limited := rr.Code == http.StatusTooManyRequests; limited != e.expectLimited {
+			t.Errorf("%s: expected limited %t but got status %d", e.name, e.expectLimited, rr.Code)
+		}
+		if rr.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("%s: expected RateLimit-Limit 2 but got %q", e.name, rr.Header().Get("RateLimit-Limit"))
+		}
+		if e.expectLimited && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected Retry-After", e.name)
+		}
+	}
+}
+
+func Test_app_userRateLimit(t *testing.T) {
+	a := rateLimitedApp()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := a.auth(a.userRateLimit()(next))
+
+	// 同じアドレスでもユーザーごとに数える
+	var tests = []struct {
+		name         string
+		userID       int
+		expectedCode int
+	}{
+		{"first page", 1, http.StatusOK},
+		{"second page", 1, http.StatusOK},
+		{"limited", 1, http.StatusTooManyRequests},
+		{"other user", 2, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/user/profile", nil)
+		req = addContextAndSessionToRequest(req, a)
+		a.Session.Put(req.Context(), "user", data.User{ID: e.userID})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_reportRateLimit(t *testing.T) {
+	a := rateLimitedApp()
+	routes := a.routes()
+
+	var tests = []struct {
+		name         string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{"first report", "192.0.2.1:1234", http.StatusNoContent},
+		{"second report", "192.0.2.1:1234", http.StatusNoContent},
+		{"limited", "192.0.2.1:1234", http.StatusTooManyRequests},
+		{"other client", "192.0.2.2:1234", http.StatusNoContent},
+	}
+
+	for _, e := range tests {
+		body := `{"csp-report":{"blocked-uri":"inline","effective-directive":"style-src-attr"}}`
+		req := httptest.NewRequest("POST", cspReportPath, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/csp-report")
+		req.RemoteAddr = e.remoteAddr
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/secheaders"
+	"go_test_prac/webApp/pkg/storage"
+	"go_test_prac/webApp/pkg/tracing"
+	"log/slog"
 	"net/http"
+	"strings"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -12,18 +19,58 @@ func (app *application) routes() http.Handler {
 	// register middleware
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.appIPToContext)
+	mux.Use(requestlog.Middleware(slog.Default(), requestlog.Options{ClientIP: func(r *http.Request) string {
+		ip, _ := r.Context().Value(contextUserKey).(string)
+		return ip
+	}}))
+	mux.Use(tracing.Middleware)
+	mux.Use(app.Metrics.HTTP.Middleware)
+	// CSPなどのセキュリティヘッダー。ルートごとに上書きできる
+	mux.Use(app.Security.Handler)
 	mux.Use(app.Session.LoadAndSave)
 
+	// probes of the orchestrator
+	mux.Get("/healthz", app.Health.Liveness)
+	mux.Get("/readyz", app.Health.Readiness)
+
+	// violations of the Content-Security-Policy reported by browsers; ログインせずに送れるのでアドレスごとに制限する
+	mux.With(app.reportRateLimit()).Post(cspReportPath, secheaders.ReportHandler)
+
+	// Prometheus; -metrics-addrを指定した場合はそちらで配信する
+	if app.MetricsAddr == "" {
+		mux.Method(http.MethodGet, "/metrics", app.metricsHandler())
+	}
+
 	// register routes
 	mux.Get("/", app.Home)
-	mux.Post("/login", app.Login)
+	// ログインの試行はクライアントのアドレスごとに回数を制限する
+	mux.With(app.loginRateLimit()).Post("/login", app.Login)
 
 	mux.Route("/user", func(mux chi.Router) {
 		mux.Use(app.auth)
+		mux.Use(app.userRateLimit())
 		mux.Get("/profile", app.Profile)
 		mux.Post("/upload-profile-pic", app.UploadProfilePic)
+		mux.Post("/images/{imageID}/current", app.SetProfilePic)
+		mux.Post("/images/{imageID}/delete", app.DeleteProfilePic)
+		mux.Get("/api-keys", app.APIKeys)
+		mux.Post("/api-keys", app.CreateAPIKey)
+		mux.Post("/api-keys/{keyID}/revoke", app.RevokeAPIKey)
 	})
 
+	// 管理者向けのページ
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(app.auth)
+		mux.Use(app.userRateLimit())
+		mux.Use(app.admin)
+		mux.Get("/audit", app.AuditLog)
+	})
+
+	// uploaded files; ローカル保存の場合は署名を検証して配信する
+	if local, ok := app.Storage.(*storage.Local); ok {
+		mux.With(app.filesSecurity()).Handle(local.BaseURL+"*", http.StripPrefix(strings.TrimSuffix(local.BaseURL, "/"), local))
+	}
+
 	// static assetes
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
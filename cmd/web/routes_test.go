@@ -14,8 +14,19 @@ func Test_application_routes(t *testing.T) {
 	}{
 		{"/", "GET"},
 		{"/login", "POST"},
+		{"/healthz", "GET"},
+		{"/readyz", "GET"},
+		{"/metrics", "GET"},
+		{"/csp-report", "POST"},
 		{"/user/profile", "GET"},
+		{"/user/images/{imageID}/current", "POST"},
+		{"/user/images/{imageID}/delete", "POST"},
+		{"/user/api-keys", "GET"},
+		{"/user/api-keys", "POST"},
+		{"/user/api-keys/{keyID}/revoke", "POST"},
+		{"/admin/audit", "GET"},
 		{"/static/*", "GET"},
+		{"/static/img/*", "GET"},
 	}
 
 	mux := app.routes()
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"go_test_prac/webApp/pkg/secheaders"
+	"net/http"
+)
+
+// cspReportPath receives the Content-Security-Policy violation reports.
+const cspReportPath = "/csp-report"
+
+// filesCSP is the policy of uploaded files opened directly. They should only
+// be images, so nothing else is loaded and an SVG or a file posing as an
+// image cannot run scripts.
+var filesCSP = secheaders.CSP{
+	{Name: "default-src", Sources: []string{"'none'"}},
+	{Name: "img-src", Sources: []string{"'self'"}},
+	{Name: "style-src", Sources: []string{"'unsafe-inline'"}},
+	{Name: "sandbox"},
+}
+
+// filesSecurity replaces the policy of the pages for uploaded files. It is
+// enforced even in report-only mode, since no page depends on it.
+func (app *application) filesSecurity() func(http.Handler) http.Handler {
+	p := app.Security
+	p.CSP = filesCSP
+	p.ReportOnly = false
+	return p.Handler
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/storage"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_securityConfig_Policy(t *testing.T) {
+	var tests = []struct {
+		name         string
+		config       securityConfig
+		imageOrigin  string
+		expectImgSrc string
+		expectError  bool
+	}{
+		{"default", defaultSecurity(), "", "'self' data:", false},
+		{"image origin", defaultSecurity(), "https://s3.example.com", "'self' data: https://s3.example.com", false},
+		{"no img-src", securityConfig{CSP: "default-src 'self'"}, "https://s3.example.com", "'self' https://s3.example.com", false},
+		{"invalid", securityConfig{CSP: "img_src 'self'"}, "", "", true},
+	}
+
+	for _, e := range tests {
+		p, err := e.config.Policy(e.imageOrigin)
+		if e.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error but got %v", e.name, err)
+			continue
+		}
+		sources, _ := p.CSP.Get("img-src")
+		if strings.Join(sources, " ") != e.expectImgSrc {
+			t.Errorf("%s: expected img-src %q but got %q", e.name, e.expectImgSrc, sources)
+		}
+		if p.ReportURI != cspReportPath {
+			t.Errorf("%s: expected reports to %s but got %q", e.name, cspReportPath, p.ReportURI)
+		}
+	}
+
+	// 既定のポリシーでは同じオリジンの画像しか読み込めない
+	p, _ := defaultSecurity().Policy("")
+	if sources, _ := p.CSP.Get("img-src"); len(sources) != 2 {
+		t.Errorf("expected no other image origin by default but got %q", sources)
+	}
+}
+
+// S3に保存した画像は署名付きURLで表示するので、そのオリジンをimg-srcに加える
+func Test_appConfig_securityPolicy(t *testing.T) {
+	s3 := defaultConfig()
+	s3.Storage.Backend = "s3"
+	s3.Storage.Endpoint = "https://s3.ap-northeast-1.amazonaws.com"
+
+	minio := defaultConfig()
+	minio.Storage.Backend = "s3"
+
+	absolute := defaultConfig()
+	absolute.Storage.BaseURL = "https://files.example.com/img/"
+
+	var tests = []struct {
+		name         string
+		config       appConfig
+		expectImgSrc string
+	}{
+		{"local", defaultConfig(), "'self' data:"},
+		{"s3", s3, "'self' data: https://s3.ap-northeast-1.amazonaws.com"},
+		{"s3 with port", minio, "'self' data: http://localhost:9000"},
+		{"absolute storage url", absolute, "'self' data: https://files.example.com"},
+	}
+
+	for _, e := range tests {
+		p, err := e.config.securityPolicy()
+		if err != nil {
+			t.Errorf("%s: expected no error but got %v", e.name, err)
+			continue
+		}
+		sources, _ := p.CSP.Get("img-src")
+		if strings.Join(sources, " ") != e.expectImgSrc {
+			t.Errorf("%s: expected img-src %q but got %q", e.name, e.expectImgSrc, sources)
+		}
+	}
+
+	// 署名付きURLのオリジンが許可されていること
+	blob, err := storage.New(s3.Storage.StorageConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := blob.URL(context.Background(), "avatars/me.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signed)
+	p, _ := s3.securityPolicy()
+	if sources, _ := p.CSP.Get("img-src"); !slices.Contains(sources, u.Scheme+"://"+u.Host) {
+		t.Errorf("expected img-src %q to allow the signed URL %s", sources, signed)
+	}
+
+	// ページのヘッダーにも含まれる
+	rr := httptest.NewRecorder()
+	p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if csp := rr.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "img-src 'self' data: https://s3.ap-northeast-1.amazonaws.com") {
+		t.Errorf("expected the S3 origin in the img-src of %q", csp)
+	}
+}
+
+func Test_app_routes_securityHeaders(t *testing.T) {
+	var tests = []struct {
+		name              string
+		url               string
+		reportOnly        bool
+		expectHeader      string
+		expectDirective   string
+		expectFrameOption string
+	}{
+		{"page", "/", false, "Content-Security-Policy", "script-src 'self' 'nonce-", "DENY"},
+		{"report only", "/", true, "Content-Security-Policy-Report-Only", "report-uri /csp-report", ""},
+		{"uploaded file", "/static/img/me.jpg", false, "Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'; sandbox", ""},
+		// 画像にはレポートのみのモードでもページのポリシーを使わない
+		{"uploaded file report only", "/static/img/me.jpg", true, "Content-Security-Policy", "sandbox", ""},
+	}
+
+	for _, e := range tests {
+		a := app
+		a.Security.ReportOnly = e.reportOnly
+		rr := httptest.NewRecorder()
+		a.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, e.url, nil))
+		h := rr.Header()
+
+		if !strings.Contains(h.Get(e.expectHeader), e.expectDirective) {
+			t.Errorf("%s: expected %q in %s but got %q", e.name, e.expectDirective, e.expectHeader, h.Get(e.expectHeader))
+		}
+		if h.Get("X-Frame-Options") != e.expectFrameOption {
+			t.Errorf("%s: expected X-Frame-Options %q but got %q", e.name, e.expectFrameOption, h.Get("X-Frame-Options"))
+		}
+		if h.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: expected X-Content-Type-Options nosniff", e.name)
+		}
+		if h.Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+			t.Errorf("%s: unexpected Referrer-Policy %q", e.name, h.Get("Referrer-Policy"))
+		}
+		if !strings.Contains(h.Get("Permissions-Policy"), "camera=()") {
+			t.Errorf("%s: unexpected Permissions-Policy %q", e.name, h.Get("Permissions-Policy"))
+		}
+	}
+}
+
+func Test_app_render_nonce(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{
+		ID:         1,
+		ProfilePic: data.UserImage{FileName: "me.jpg", Sizes: []int{256}},
+	})
+
+	rr := httptest.NewRecorder()
+	app.Security.Handler(http.HandlerFunc(app.Profile)).ServeHTTP(rr, req)
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	_, nonce, _ := strings.Cut(csp, "'nonce-")
+	nonce, _, _ = strings.Cut(nonce, "'")
+	if nonce == "" {
+		t.Fatalf("expected a nonce in %q", csp)
+	}
+	// html/templateは属性の「+」を&#43;と書くが、ブラウザは同じ値として読む
+	body := html.UnescapeString(rr.Body.String())
+	if !strings.Contains(body, `<style nonce="`+nonce+`">`) {
+		t.Errorf("expected the inline style to carry the nonce %q in %s", nonce, body)
+	}
+	if strings.Contains(body, `style="`) {
+		t.Errorf("expected no inline style attribute, which the policy blocks, in %s", body)
+	}
+}
+
+func Test_app_cspReport(t *testing.T) {
+	body := `{"csp-report":{"document-uri":"http://localhost:8080/user/profile","blocked-uri":"inline","effective-directive":"style-src-attr"}}`
+	req := httptest.NewRequest(http.MethodPost, cspReportPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/csp-report")
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected 204 but got %d", rr.Code)
+	}
+}
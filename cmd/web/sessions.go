@@ -7,14 +7,20 @@ import (
 	"github.com/alexedwards/scs/v2"
 )
 
-func getSession() *scs.SessionManager {
+// sessionCleanupInterval is how often expired sessions are removed from memory.
+const sessionCleanupInterval = time.Minute
+
+// getSession returns the session manager. secure marks the cookie Secure,
+// which browsers only send over HTTPS. Sessions are kept in store.
+func getSession(secure bool, store scs.Store) *scs.SessionManager {
 	session := scs.New() // sessionマネージャー
+	session.Store = store
 	session.Lifetime = 24 * time.Hour // 24時間
 	session.Cookie.Persist = true // ブラウザを閉じてもセッションを保持
 	// Laxモードでは、GETリクエストによるクロスサイトリクエストであればCookieを送信するが、
 	// POSTリクエストや他のHTTPメソッドによるクロスサイトリクエストではCookieを送信しない。
 	session.Cookie.SameSite = http.SameSiteLaxMode // CSRF攻撃のリスクを軽減
-	session.Cookie.Secure = true // HTTPSのみでCookieを送信(http等通信が暗号化されていない場合使用しないため)
+	session.Cookie.Secure = secure // HTTPSのみでCookieを送信(http等通信が暗号化されていない場合使用しないため)
 
 	return session
 }
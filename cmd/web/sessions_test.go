@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+func Test_getSession(t *testing.T) {
+	for _, secure := range []bool{true, false} {
+		store := memstore.NewWithCleanupInterval(0)
+
+		session := getSession(secure, store)
+		if session.Cookie.Secure != secure {
+			t.Errorf("expected Secure to be %t but got %t", secure, session.Cookie.Secure)
+		}
+		if session.Store != store {
+			t.Errorf("expected the sessions to be kept in the given store")
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/health"
 	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/storage"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2/memstore"
 )
 
 // testに共通の設定
@@ -12,8 +18,15 @@ var app application
 func TestMain(m *testing.M) {
 	pathToTemplates = "./../../templates/"
 
-	app.Session = getSession() // get a session manager
+	// 掃除のgoroutineを動かさないので、止める必要がない
+	app.Session = getSession(true, memstore.NewWithCleanupInterval(0)) // get a session manager
 	app.DB = &dbrepo.TestDBRepo{}
+	app.Storage, _ = storage.NewLocal("./testdata/uploads/", "/static/img/", "")
+	app.URLExpiry = 15 * time.Minute
+	app.Avatars = avatar.New(app.Storage)
+	app.Metrics = newAppMetrics()
+	app.Health = health.NewServerChecker(app.DB, dbrepo.Schema, app.Storage)
+	app.Security, _ = defaultSecurity().Policy("")
 
 	os.Exit(m.Run())
 }
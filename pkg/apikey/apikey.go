@@ -0,0 +1,172 @@
+// Package apikey creates and checks the personal API keys of users. A key
+// looks like
+//
+//	wak_0123456789ab_<43 random characters>
+//
+// The part up to the second underscore is the prefix, stored in clear to look
+// the key up and to tell keys apart in lists. Only the SHA-256 hash of the
+// whole key is stored; the keys are random, so a slow password hash is not
+// needed. The marker "wak_" lets the API tell keys from JSON Web Tokens, and
+// secret scanners find leaked keys.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marker starts every key.
+const Marker = "wak_"
+
+// idLength is the number of hex digits after Marker in the prefix.
+const idLength = 12
+
+// Scopes limit what a key may do.
+const (
+	ScopeRead  = "read"  // GET, HEAD and OPTIONS requests
+	ScopeWrite = "write" // requests of any other method
+	ScopeAdmin = "admin" // routes for administrators, if the user is one
+)
+
+// Scopes lists every scope.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// Lifetimes of keys, in days.
+const (
+	DefaultExpiryDays = 90
+	MaxExpiryDays     = 365
+)
+
+var (
+	// ErrInvalid is returned for a key that is malformed, unknown or does not
+	// match its hash. Callers should not tell these apart to clients.
+	ErrInvalid = errors.New("invalid API key")
+	// ErrExpired is returned for a key past its expiry.
+	ErrExpired = errors.New("API key expired")
+	// ErrRevoked is returned for a revoked key.
+	ErrRevoked = errors.New("API key revoked")
+)
+
+// Request asks for a new key.
+type Request struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"` // DefaultExpiryDays if 0
+}
+
+// Validate checks the name, the scopes and the lifetime of the key.
+func (req *Request) Validate() validation.Errors {
+	v := validation.New()
+	v.Field("name", req.Name, validation.Required(), validation.MaxLength(255))
+	v.Check(len(req.Scopes) > 0, "scopes", "required", nil)
+	for _, s := range req.Scopes {
+		if s == "" || !validation.OneOf(Scopes...).Check(s) {
+			v.Add("scopes", "one_of", map[string]string{"values": strings.Join(Scopes, ", ")})
+			break
+		}
+	}
+	v.Check(req.ExpiresInDays >= 0 && req.ExpiresInDays <= MaxExpiryDays, "expires_in_days", "between",
+		map[string]string{"min": "1", "max": strconv.Itoa(MaxExpiryDays)})
+	return v.Errors
+}
+
+// New returns a new key of the user and what is stored of it. The request
+// must be valid.
+func New(userID int, req Request, now time.Time) (string, data.APIKey, error) {
+	id := make([]byte, idLength/2)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", data.APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", data.APIKey{}, err
+	}
+	prefix := Marker + hex.EncodeToString(id)
+	key := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = DefaultExpiryDays
+	}
+
+	return key, data.APIKey{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		Hash:      Hash(key),
+		Scopes:    normalize(req.Scopes),
+		ExpiresAt: now.AddDate(0, 0, days),
+		CreatedAt: now,
+	}, nil
+}
+
+// normalize returns the scopes in the order of Scopes, without duplicates.
+func normalize(scopes []string) []string {
+	var out []string
+	for _, s := range Scopes {
+		for _, given := range scopes {
+			if given == s {
+				out = append(out, s)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// IsKey reports whether s looks like a key rather than a token.
+func IsKey(s string) bool {
+	return strings.HasPrefix(s, Marker)
+}
+
+// Prefix returns the prefix of key, by which it is stored.
+func Prefix(key string) (string, error) {
+	rest, ok := strings.CutPrefix(key, Marker)
+	if !ok || len(rest) <= idLength || rest[idLength] != '_' {
+		return "", ErrInvalid
+	}
+	if _, err := hex.DecodeString(rest[:idLength]); err != nil {
+		return "", ErrInvalid
+	}
+	return key[:len(Marker)+idLength], nil
+}
+
+// Hash returns the hash of key that is stored.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check returns nil when key is the stored key k and may be used at now.
+func Check(k *data.APIKey, key string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(Hash(key)), []byte(k.Hash)) != 1 {
+		return ErrInvalid
+	}
+	if k.RevokedAt != nil {
+		return ErrRevoked
+	}
+	if !now.Before(k.ExpiresAt) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Allows reports whether k may make a request of method. Whether it may use
+// the routes for administrators is asked with HasScope(ScopeAdmin).
+func Allows(k *data.APIKey, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return k.HasScope(ScopeRead)
+	}
+	return k.HasScope(ScopeWrite)
+}
@@ -0,0 +1,139 @@
+package apikey
+
+import (
+	"go_test_prac/webApp/pkg/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name           string
+		req            Request
+		expectedFields []string
+	}{
+		{"valid", Request{Name: "deploy", Scopes: []string{"read", "write"}}, nil},
+		{"longest lifetime", Request{Name: "deploy", Scopes: []string{"admin"}, ExpiresInDays: MaxExpiryDays}, nil},
+		{"blank name", Request{Name: " ", Scopes: []string{"read"}}, []string{"name"}},
+		{"no scopes", Request{Name: "deploy"}, []string{"scopes"}},
+		{"unknown scope", Request{Name: "deploy", Scopes: []string{"read", "root"}}, []string{"scopes"}},
+		{"blank scope", Request{Name: "deploy", Scopes: []string{""}}, []string{"scopes"}},
+		{"too long", Request{Name: "deploy", Scopes: []string{"read"}, ExpiresInDays: MaxExpiryDays + 1}, []string{"expires_in_days"}},
+		{"negative", Request{Name: "deploy", Scopes: []string{"read"}, ExpiresInDays: -1}, []string{"expires_in_days"}},
+	}
+
+	for _, e := range tests {
+		fields := e.req.Validate().Fields()
+		if strings.Join(fields, ",") != strings.Join(e.expectedFields, ",") {
+			t.Errorf("%s: expected %v to be rejected but got %v", e.name, e.expectedFields, fields)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	key, k, err := New(7, Request{Name: " deploy ", Scopes: []string{"write", "read", "write"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsKey(key) || len(key) != len(Marker)+idLength+1+43 {
+		t.Errorf("unexpected key %q", key)
+	}
+	prefix, err := Prefix(key)
+	if err != nil || prefix != k.Prefix || !strings.HasPrefix(key, prefix+"_") {
+		t.Errorf("expected the prefix %q of %q but got %q (%v)", k.Prefix, key, prefix, err)
+	}
+	if k.Hash != Hash(key) || strings.Contains(k.Hash, key) {
+		t.Errorf("expected only the hash of the key to be stored but got %q", k.Hash)
+	}
+	if k.UserID != 7 || k.Name != "deploy" || strings.Join(k.Scopes, ",") != "read,write" {
+		t.Errorf("unexpected key %+v", k)
+	}
+	if !k.ExpiresAt.Equal(now.AddDate(0, 0, DefaultExpiryDays)) || !k.CreatedAt.Equal(now) {
+		t.Errorf("expected the default expiry but got %v", k.ExpiresAt)
+	}
+
+	other, _, _ := New(7, Request{Name: "deploy", Scopes: []string{"read"}, ExpiresInDays: 1}, now)
+	if other == key {
+		t.Error("expected keys to be random")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"key", "wak_0123456789ab_secret", "wak_0123456789ab"},
+		{"secret with underscores", "wak_0123456789ab_se_cr_et", "wak_0123456789ab"},
+		{"token", "eyJhbGciOiJIUzI1NiJ9.e30.sig", ""},
+		{"short", "wak_0123", ""},
+		{"no secret", "wak_0123456789ab", ""},
+		{"not hex", "wak_0123456789zz_secret", ""},
+		{"no separator", "wak_0123456789abcsecret", ""},
+	}
+
+	for _, e := range tests {
+		prefix, err := Prefix(e.key)
+		if prefix != e.expected || (e.expected == "") != (err == ErrInvalid) {
+			t.Errorf("%s: expected %q but got %q (%v)", e.name, e.expected, prefix, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	key, k, _ := New(1, Request{Name: "deploy", Scopes: []string{"read"}}, now)
+	revoked := k
+	revokedAt := now.Add(-time.Minute)
+	revoked.RevokedAt = &revokedAt
+	expired := k
+	expired.ExpiresAt = now
+
+	tests := []struct {
+		name     string
+		stored   data.APIKey
+		key      string
+		expected error
+	}{
+		{"valid", k, key, nil},
+		{"wrong secret", k, key + "x", ErrInvalid},
+		{"revoked", revoked, key, ErrRevoked},
+		{"expired", expired, key, ErrExpired},
+	}
+
+	for _, e := range tests {
+		if err := Check(&e.stored, e.key, now); err != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+		if active := e.stored.Active(now); active != (e.expected != ErrRevoked && e.expected != ErrExpired) {
+			t.Errorf("%s: unexpected Active %v", e.name, active)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		scopes   []string
+		method   string
+		expected bool
+	}{
+		{[]string{"read"}, "GET", true},
+		{[]string{"read"}, "HEAD", true},
+		{[]string{"read"}, "PATCH", false},
+		{[]string{"write"}, "GET", false},
+		{[]string{"write"}, "DELETE", true},
+		{[]string{"read", "write"}, "POST", true},
+		{[]string{"admin"}, "GET", false},
+	}
+
+	for _, e := range tests {
+		k := &data.APIKey{Scopes: e.scopes}
+		if got := Allows(k, e.method); got != e.expected {
+			t.Errorf("%v %s: expected %v but got %v", e.scopes, e.method, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package audit records security-relevant and administrative actions, such as
+// logins, changes to users and uploads, in the append-only audit_events table.
+// Every event carries the hash of the event before it, so Verify can tell when
+// recorded events were changed or deleted.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/validation"
+	"net/http"
+	"os/user"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions recorded by the servers and the CLI.
+const (
+	ActionLogin          = "auth.login"
+	ActionLoginFailed    = "auth.login_failed"
+	ActionUserCreate     = "user.create"
+	ActionUserUpdate     = "user.update"
+	ActionUserDelete     = "user.delete"
+	ActionUserRole       = "user.role_change"
+	ActionPasswordSet    = "user.password_set"
+	ActionSessionsRevoke = "user.sessions_revoke"
+	ActionUsersImport    = "users.import"
+	ActionUsersExport    = "users.export"
+	ActionImageUpload    = "image.upload"
+	ActionImageCurrent   = "image.set_current"
+	ActionImageDelete    = "image.delete"
+	ActionFileUpload     = "file.upload"
+	ActionFileDelete     = "file.delete"
+	ActionAPIKeyCreate   = "api_key.create"
+	ActionAPIKeyRevoke   = "api_key.revoke"
+)
+
+// Actions lists every action, for filters.
+var Actions = []string{
+	ActionLogin, ActionLoginFailed,
+	ActionUserCreate, ActionUserUpdate, ActionUserDelete, ActionUserRole, ActionPasswordSet, ActionSessionsRevoke,
+	ActionUsersImport, ActionUsersExport,
+	ActionImageUpload, ActionImageCurrent, ActionImageDelete,
+	ActionFileUpload, ActionFileDelete,
+	ActionAPIKeyCreate, ActionAPIKeyRevoke,
+}
+
+// Types of targets.
+const (
+	TargetUser   = "user"
+	TargetUsers  = "users" // every user, e.g. of an import
+	TargetImage  = "image"
+	TargetFile   = "file"
+	TargetAPIKey = "api_key"
+)
+
+// maxUserAgent is the length of the user_agent column.
+const maxUserAgent = 512
+
+// Redacted replaces values that must not be recorded, like passwords.
+const Redacted = "[redacted]"
+
+// FromRequest returns an event of action on the target, with the user agent
+// and the ID of r. The client address is resolved by the server, so it is
+// passed as ip.
+func FromRequest(r *http.Request, ip, action, targetType string, targetID int) data.AuditEvent {
+	return data.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   id(targetID),
+		IP:         ip,
+		UserAgent:  validation.Truncate(r.UserAgent(), maxUserAgent),
+		RequestID:  requestlog.RequestID(r.Context()),
+	}
+}
+
+// FromCLI returns an event of action on the target, done with the CLI by the
+// user of the operating system.
+func FromCLI(action, targetType string, targetID int) data.AuditEvent {
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	return data.AuditEvent{
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   id(targetID),
+		UserAgent:  "cli",
+	}
+}
+
+// id formats the ID of a target; 0 means none.
+func id(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// Record appends e to the audit log of db. A failure is logged but not
+// returned: the action it describes has already happened and is not undone.
+func Record(ctx context.Context, db repository.DatabaseRepo, e data.AuditEvent) {
+	if _, err := db.InsertAuditEvent(e); err != nil {
+		requestlog.FromContext(ctx).Error("writing the audit log", "action", e.Action, "target_type", e.TargetType, "target_id", e.TargetID, "err", err)
+	}
+}
+
+// Change is the value of a field before and after an action. Nil stands for
+// a field that did not exist, e.g. before a user was created.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff returns the fields whose values differ between before and after, as
+// JSON for AuditEvent.Changes, or nil if none do. A nil map stands for an
+// object that does not exist. Fields in redact are listed as changed with
+// Redacted as their values.
+func Diff(before, after map[string]interface{}, redact ...string) json.RawMessage {
+	changes := make(map[string]Change)
+	for field, b := range before {
+		if a, ok := after[field]; !ok || !reflect.DeepEqual(a, b) {
+			changes[field] = Change{Before: b, After: after[field]}
+		}
+	}
+	for field, a := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = Change{Before: nil, After: a}
+		}
+	}
+	for _, field := range redact {
+		c, ok := changes[field]
+		if !ok {
+			continue
+		}
+		if c.Before != nil {
+			c.Before = Redacted
+		}
+		if c.After != nil {
+			c.After = Redacted
+		}
+		changes[field] = c
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// マップのキーは並べ替えて書き出されるので、同じ差分は同じJSONになる
+	out, _ := json.Marshal(changes)
+	return out
+}
+
+// UserFields returns the fields of u recorded in the audit log. The password
+// hash is never recorded.
+func UserFields(u *data.User) map[string]interface{} {
+	if u == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"email":      u.Email,
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"is_admin":   u.IsAdmin,
+	}
+}
+
+// UserUpdate returns the changes of updating before to after: the profile for
+// ActionUserUpdate and is_admin for ActionUserRole, each nil when nothing of
+// it changed. Role changes are recorded on their own, so that granting admin
+// is easy to find.
+func UserUpdate(before, after *data.User) (profile, role json.RawMessage) {
+	b, a := UserFields(before), UserFields(after)
+	role = Diff(map[string]interface{}{"is_admin": b["is_admin"]}, map[string]interface{}{"is_admin": a["is_admin"]})
+	delete(b, "is_admin")
+	delete(a, "is_admin")
+	return Diff(b, a), role
+}
+
+// APIKeyFields returns the fields of k recorded in the audit log. The hash is
+// never recorded.
+func APIKeyFields(k *data.APIKey) map[string]interface{} {
+	if k == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"name":       k.Name,
+		"prefix":     k.Prefix,
+		"scopes":     strings.Join(k.Scopes, ","),
+		"expires_at": k.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// FieldChange is the Change of one field.
+type FieldChange struct {
+	Field string
+	Change
+}
+
+// Changes returns the changes of e sorted by field.
+func Changes(e *data.AuditEvent) ([]FieldChange, error) {
+	if len(e.Changes) == 0 {
+		return nil, nil
+	}
+	var changes map[string]Change
+	if err := json.Unmarshal(e.Changes, &changes); err != nil {
+		return nil, err
+	}
+	fields := make([]FieldChange, 0, len(changes))
+	for field, c := range changes {
+		fields = append(fields, FieldChange{Field: field, Change: c})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields, nil
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/requestlog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	jane := &data.User{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe"}
+	renamed := &data.User{Email: "jane@example.com", FirstName: "Janet", LastName: "Doe", IsAdmin: 1}
+
+	tests := []struct {
+		name   string
+		before map[string]interface{}
+		after  map[string]interface{}
+		redact []string
+		want   string
+	}{
+		{"update", UserFields(jane), UserFields(renamed), nil, `{"first_name":{"before":"Jane","after":"Janet"},"is_admin":{"before":0,"after":1}}`},
+		{"create", nil, UserFields(jane), nil, `{"email":{"before":null,"after":"jane@example.com"},"first_name":{"before":null,"after":"Jane"},"is_admin":{"before":null,"after":0},"last_name":{"before":null,"after":"Doe"}}`},
+		{"delete", map[string]interface{}{"email": "jane@example.com"}, nil, nil, `{"email":{"before":"jane@example.com","after":null}}`},
+		{"api key", nil, APIKeyFields(&data.APIKey{Name: "deploy", Prefix: "wak_0123456789ab", Hash: "secret", Scopes: []string{"read", "write"}, ExpiresAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}), nil,
+			`{"expires_at":{"before":null,"after":"2024-01-02T03:04:05Z"},"name":{"before":null,"after":"deploy"},"prefix":{"before":null,"after":"wak_0123456789ab"},"scopes":{"before":null,"after":"read,write"}}`},
+		{"nothing changed", UserFields(jane), UserFields(jane), nil, ``},
+		{"redacted", map[string]interface{}{"password": "old"}, map[string]interface{}{"password": "new"}, []string{"password"}, `{"password":{"before":"[redacted]","after":"[redacted]"}}`},
+		{"redacted when set", nil, map[string]interface{}{"password": "new"}, []string{"password"}, `{"password":{"before":null,"after":"[redacted]"}}`},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			if got := string(Diff(e.before, e.after, e.redact...)); got != e.want {
+				t.Errorf("expected %s but got %s", e.want, got)
+			}
+		})
+	}
+}
+
+func TestUserUpdate(t *testing.T) {
+	jane := &data.User{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe"}
+
+	tests := []struct {
+		name        string
+		after       *data.User
+		wantProfile string
+		wantRole    string
+	}{
+		{"profile", &data.User{Email: "jane@example.com", FirstName: "Janet", LastName: "Doe"}, `{"first_name":{"before":"Jane","after":"Janet"}}`, ``},
+		{"role", &data.User{Email: "jane@example.com", FirstName: "Jane", LastName: "Doe", IsAdmin: 1}, ``, `{"is_admin":{"before":0,"after":1}}`},
+		{"both", &data.User{Email: "jane@example.com", FirstName: "Janet", LastName: "Doe", IsAdmin: 1}, `{"first_name":{"before":"Jane","after":"Janet"}}`, `{"is_admin":{"before":0,"after":1}}`},
+		{"nothing changed", jane, ``, ``},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			profile, role := UserUpdate(jane, e.after)
+			if string(profile) != e.wantProfile || string(role) != e.wantRole {
+				t.Errorf("expected %s and %s but got %s and %s", e.wantProfile, e.wantRole, profile, role)
+			}
+		})
+	}
+}
+
+func TestChanges(t *testing.T) {
+	e := &data.AuditEvent{Changes: Diff(UserFields(&data.User{FirstName: "Jane"}), UserFields(&data.User{FirstName: "Janet", LastName: "Doe"}))}
+	changes, err := Changes(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Field != "first_name" || changes[0].After != "Janet" || changes[1].Field != "last_name" {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+
+	if changes, err := Changes(&data.AuditEvent{}); err != nil || changes != nil {
+		t.Errorf("expected no changes but got %v, %v", changes, err)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("PATCH", "/users/2", nil)
+	r.Header.Set("User-Agent", strings.Repeat("a", 600))
+	r = r.WithContext(requestlog.WithRequestID(r.Context(), "req-1"))
+
+	e := FromRequest(r, "192.0.2.1", ActionUserUpdate, TargetUser, 2)
+	if e.Action != ActionUserUpdate || e.TargetType != TargetUser || e.TargetID != "2" || e.IP != "192.0.2.1" || e.RequestID != "req-1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if len(e.UserAgent) != maxUserAgent {
+		t.Errorf("expected the user agent to be cut to %d characters but got %d", maxUserAgent, len(e.UserAgent))
+	}
+
+	// 文字の途中で切らない
+	r.Header.Set("User-Agent", strings.Repeat("a", maxUserAgent-1)+"日本語")
+	e = FromRequest(r, "192.0.2.1", ActionUserUpdate, TargetUser, 2)
+	if e.UserAgent != strings.Repeat("a", maxUserAgent-1)+"日" {
+		t.Errorf("expected the user agent to be cut after the first multi-byte character but got %q", e.UserAgent[maxUserAgent-4:])
+	}
+
+	if e := FromRequest(r, "", ActionUsersExport, TargetUsers, 0); e.TargetID != "" {
+		t.Errorf("expected no target ID but got %q", e.TargetID)
+	}
+}
+
+func TestFromCLI(t *testing.T) {
+	e := FromCLI(ActionPasswordSet, TargetUser, 3)
+	if !strings.HasPrefix(e.Actor, "cli") || e.ActorID != 0 || e.TargetID != "3" || e.UserAgent != "cli" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+// Seal と Digest は data パッケージにあるが、ハッシュの連鎖はここで確かめる
+func TestSeal(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.FixedZone("JST", 9*60*60))
+	e := data.AuditEvent{OccurredAt: at, ActorID: 1, Action: ActionUserUpdate, TargetType: TargetUser, TargetID: "2"}
+	e.Seal("")
+
+	if !e.OccurredAt.Equal(at.Truncate(time.Microsecond)) || e.OccurredAt.Location() != time.UTC {
+		t.Errorf("expected the time in UTC to the microsecond but got %s", e.OccurredAt)
+	}
+	if len(e.Hash) != 64 || e.Hash != e.Digest() {
+		t.Errorf("unexpected hash %q", e.Hash)
+	}
+
+	// 保存して読み直した時刻でも同じハッシュになる
+	read := e
+	read.OccurredAt = e.OccurredAt.In(time.Local)
+	if read.Digest() != e.Hash {
+		t.Error("expected the hash not to depend on the time zone")
+	}
+
+	next := data.AuditEvent{OccurredAt: at, ActorID: 1, Action: ActionUserUpdate, TargetType: TargetUser, TargetID: "2"}
+	next.Seal(e.Hash)
+	if next.Hash == e.Hash {
+		t.Error("expected the hash to depend on the event before")
+	}
+
+	// 値の境目をずらしても同じハッシュにはならない
+	shifted := e
+	shifted.TargetType, shifted.TargetID = "user2", ""
+	if shifted.Digest() == e.Hash {
+		t.Error("expected moving a value between fields to change the hash")
+	}
+}
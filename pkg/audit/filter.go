@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultLimit is the number of events on a page unless limit is given.
+	DefaultLimit = 50
+	// MaxLimit is the largest page.
+	MaxLimit = 200
+)
+
+// ParamError rejects a query parameter of a filter.
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return e.Param + " " + e.Message
+}
+
+// ParseFilter reads a page of events from the query parameters actor_id,
+// action, target_type, target_id, since, until, cursor and limit. Times are
+// RFC 3339, or dates meaning midnight UTC.
+func ParseFilter(q url.Values) (data.AuditFilter, error) {
+	f := data.AuditFilter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Limit:      DefaultLimit,
+	}
+
+	var err error
+	if f.ActorID, err = positiveInt(q, "actor_id"); err != nil {
+		return f, err
+	}
+	cursor, err := positiveInt(q, "cursor")
+	if err != nil {
+		return f, err
+	}
+	f.Cursor = int64(cursor)
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxLimit {
+			return f, &ParamError{"limit", "must be an integer from 1 to " + strconv.Itoa(MaxLimit)}
+		}
+		f.Limit = n
+	}
+	if f.Since, err = parseTime(q, "since"); err != nil {
+		return f, err
+	}
+	if f.Until, err = parseTime(q, "until"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// positiveInt reads a positive integer parameter; a missing one is 0.
+func positiveInt(q url.Values, name string) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, &ParamError{name, "must be a positive integer"}
+	}
+	return n, nil
+}
+
+// ParseTime reads a time given as RFC 3339 or as a date.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+func parseTime(q url.Values, name string) (time.Time, error) {
+	s := q.Get(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := ParseTime(s)
+	if err != nil {
+		return time.Time{}, &ParamError{name, "must be a time like 2006-01-02T15:04:05Z or a date like 2006-01-02"}
+	}
+	return t, nil
+}
+
+// Page is a page of events, in the order of the filter.
+type Page struct {
+	Events []*data.AuditEvent `json:"events"`
+	// NextCursor is the cursor of the next page; 0 on the last page.
+	NextCursor int64 `json:"next_cursor,omitempty"`
+}
+
+// List returns the page of events selected by f.
+func List(db repository.DatabaseRepo, f data.AuditFilter) (*Page, error) {
+	// 1件多く読み、続きがあるかを判定する
+	limit := f.Limit
+	if limit > 0 {
+		f.Limit++
+	}
+	events, err := db.AuditEvents(f)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Events: events}
+	if page.Events == nil {
+		page.Events = []*data.AuditEvent{}
+	}
+	if limit > 0 && len(events) > limit {
+		page.Events = events[:limit]
+		page.NextCursor = page.Events[limit-1].ID
+	}
+	return page, nil
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		want      data.AuditFilter
+		wantParam string
+	}{
+		{"defaults", "", data.AuditFilter{Limit: DefaultLimit}, ""},
+		{
+			"every parameter",
+			"actor_id=1&action=user.update&target_type=user&target_id=2&since=2024-01-02&until=2024-01-03T09:00:00%2B09:00&cursor=10&limit=20",
+			data.AuditFilter{
+				ActorID: 1, Action: "user.update", TargetType: "user", TargetID: "2",
+				Since:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				Until:  time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+				Cursor: 10, Limit: 20,
+			},
+			"",
+		},
+		{"bad actor", "actor_id=x", data.AuditFilter{}, "actor_id"},
+		{"negative cursor", "cursor=-1", data.AuditFilter{}, "cursor"},
+		{"limit too large", "limit=201", data.AuditFilter{}, "limit"},
+		{"zero limit", "limit=0", data.AuditFilter{}, "limit"},
+		{"bad since", "since=yesterday", data.AuditFilter{}, "since"},
+		{"bad until", "until=2024-13-01", data.AuditFilter{}, "until"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(e.query)
+			f, err := ParseFilter(q)
+			if e.wantParam != "" {
+				var paramErr *ParamError
+				if !errors.As(err, &paramErr) || paramErr.Param != e.wantParam {
+					t.Errorf("expected an error on %s but got %v", e.wantParam, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.ActorID != e.want.ActorID || f.Action != e.want.Action || f.TargetType != e.want.TargetType || f.TargetID != e.want.TargetID ||
+				!f.Since.Equal(e.want.Since) || !f.Until.Equal(e.want.Until) || f.Cursor != e.want.Cursor || f.Limit != e.want.Limit {
+				t.Errorf("expected %+v but got %+v", e.want, f)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	db := &dbrepo.TestDBRepo{}
+
+	page, err := List(db, data.AuditFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].ID != 2 || page.NextCursor != 2 {
+		t.Fatalf("expected the newest event and a cursor but got %+v", page)
+	}
+
+	page, err = List(db, data.AuditFilter{Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].ID != 1 || page.NextCursor != 0 {
+		t.Fatalf("expected the oldest event on the last page but got %+v", page)
+	}
+
+	page, err = List(db, data.AuditFilter{Action: "no.such_action", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Events == nil || len(page.Events) != 0 {
+		t.Errorf("expected an empty page but got %+v", page)
+	}
+}
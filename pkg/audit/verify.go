@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"fmt"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository"
+)
+
+// verifyBatch is the number of events read at a time by Verify.
+const verifyBatch = 1000
+
+// ChainError tells which event breaks the chain of hashes.
+type ChainError struct {
+	ID     int64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("the audit log was tampered with at event %d: %s", e.ID, e.Reason)
+}
+
+// Verification is the outcome of walking the chain.
+type Verification struct {
+	Events int    `json:"events"`         // events checked
+	LastID int64  `json:"last_id"`        // ID of the newest event, 0 if none
+	Head   string `json:"head,omitempty"` // hash of the newest event
+}
+
+// Verify walks every event from the oldest and checks that its hash matches
+// its content and that it follows the event before it. A *ChainError is
+// returned with the events checked so far when an event was changed, or when
+// events before it were deleted. Deleting the newest events leaves a valid
+// chain; compare Head with one recorded earlier to notice that.
+func Verify(db repository.DatabaseRepo) (*Verification, error) {
+	v := &Verification{}
+	for {
+		events, err := db.AuditEvents(data.AuditFilter{Cursor: v.LastID, Ascending: true, Limit: verifyBatch})
+		if err != nil {
+			return v, err
+		}
+		for _, e := range events {
+			if err := check(e, v.Head); err != nil {
+				return v, err
+			}
+			v.Events++
+			v.LastID = e.ID
+			v.Head = e.Hash
+		}
+		if len(events) < verifyBatch {
+			return v, nil
+		}
+	}
+}
+
+// check checks e, which follows the event with the hash prevHash.
+func check(e *data.AuditEvent, prevHash string) error {
+	if e.PrevHash != prevHash {
+		return &ChainError{ID: e.ID, Reason: "it does not follow the event before it, which was changed or deleted"}
+	}
+	if e.Digest() != e.Hash {
+		return &ChainError{ID: e.ID, Reason: "its content does not match its hash"}
+	}
+	return nil
+}
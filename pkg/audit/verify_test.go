@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// chainRepo keeps the audit events in memory.
+type chainRepo struct {
+	dbrepo.TestDBRepo
+	events []*data.AuditEvent
+}
+
+func (m *chainRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	prev := ""
+	if n := len(m.events); n > 0 {
+		prev = m.events[n-1].Hash
+	}
+	e.Seal(prev)
+	e.ID = int64(len(m.events) + 1)
+	m.events = append(m.events, &e)
+	return &e, nil
+}
+
+func (m *chainRepo) AuditEvents(f data.AuditFilter) ([]*data.AuditEvent, error) {
+	var events []*data.AuditEvent
+	for _, e := range m.events {
+		if e.ID > f.Cursor && (f.Limit == 0 || len(events) < f.Limit) {
+			copied := *e
+			events = append(events, &copied)
+		}
+	}
+	return events, nil
+}
+
+// newChain returns a repository with n events.
+func newChain(n int) *chainRepo {
+	m := &chainRepo{}
+	for i := 1; i <= n; i++ {
+		_, _ = m.InsertAuditEvent(data.AuditEvent{
+			OccurredAt: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			ActorID:    1,
+			Action:     ActionUserUpdate,
+			TargetType: TargetUser,
+			TargetID:   strconv.Itoa(i),
+			Changes:    Diff(map[string]interface{}{"first_name": "A"}, map[string]interface{}{"first_name": "B"}),
+		})
+	}
+	return m
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name       string
+		events     int
+		tamper     func(m *chainRepo)
+		wantBroken int64
+		wantEvents int
+	}{
+		{"empty", 0, func(m *chainRepo) {}, 0, 0},
+		{"intact", 3, func(m *chainRepo) {}, 0, 3},
+		{"more than a batch", verifyBatch + 5, func(m *chainRepo) {}, 0, verifyBatch + 5},
+		{"changed field", 3, func(m *chainRepo) { m.events[1].TargetID = "9" }, 2, 1},
+		{"changed diff", 3, func(m *chainRepo) { m.events[2].Changes = []byte(`{}`) }, 3, 2},
+		{"rehashed event", 3, func(m *chainRepo) {
+			m.events[0].Actor = "someone else"
+			m.events[0].Hash = m.events[0].Digest()
+		}, 2, 1},
+		{"deleted event", 3, func(m *chainRepo) { m.events = append(m.events[:1], m.events[2:]...) }, 3, 1},
+		{"deleted first event", 3, func(m *chainRepo) { m.events = m.events[1:] }, 2, 0},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			m := newChain(e.events)
+			e.tamper(m)
+
+			v, err := Verify(m)
+			if v.Events != e.wantEvents {
+				t.Errorf("expected %d events checked but got %d", e.wantEvents, v.Events)
+			}
+			if e.wantBroken == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e.events > 0 && (v.LastID != int64(e.events) || v.Head != m.events[e.events-1].Hash) {
+					t.Errorf("unexpected verification %+v", v)
+				}
+				return
+			}
+			var chainErr *ChainError
+			if !errors.As(err, &chainErr) || chainErr.ID != e.wantBroken {
+				t.Errorf("expected the chain to break at %d but got %v", e.wantBroken, err)
+			}
+		})
+	}
+}
+
+func TestVerify_testRepo(t *testing.T) {
+	v, err := Verify(&dbrepo.TestDBRepo{})
+	if err != nil || v.Events != 2 {
+		t.Errorf("expected the test events to be a valid chain but got %+v, %v", v, err)
+	}
+}
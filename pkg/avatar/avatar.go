@@ -0,0 +1,117 @@
+// Package avatar stores and serves profile pictures. It is shared by the web
+// application and the API so that both validate, process and store uploads in
+// the same way.
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/imaging"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+)
+
+// Store processes uploaded pictures with the imaging package and keeps the
+// results in a storage backend.
+type Store struct {
+	Storage storage.Blob
+	Sizes   []int                 // edge lengths of the square variants, imaging.DefaultSizes when empty
+	Scanner scanner.UploadScanner // checks uploads for malware, every upload is clean when nil
+}
+
+// New returns a Store generating the default sizes.
+func New(s storage.Blob) *Store {
+	return &Store{Storage: s, Sizes: imaging.DefaultSizes}
+}
+
+// Save validates, scans and processes an uploaded picture, then stores an
+// upright copy without metadata plus the square variants. The returned image
+// has its FileName, Sizes and ScanStatus set, and the returned files describe
+// what was stored; the caller records both in the database. Pictures with
+// malware are rejected with an error wrapping scanner.ErrInfected, and
+// pictures that could not be scanned with the other errors of scanner.Check.
+//
+// Files are named after the SHA-256 of their content, so uploading the same
+// picture twice shares the files. They are written again even when they
+// exist, which renews the modification time that package filegc checks
+// before removing a file nothing refers to yet.
+func (s *Store) Save(ctx context.Context, content []byte) (data.UserImage, []data.StoredFile, error) {
+	if err := Validate(content); err != nil {
+		return data.UserImage{}, nil, err
+	}
+
+	// 加工する前の、アップロードされたままの内容を検査する
+	status, err := scanner.Check(ctx, s.Scanner, bytes.NewReader(content))
+	if err != nil {
+		return data.UserImage{}, nil, err
+	}
+
+	sizes := s.Sizes
+	if len(sizes) == 0 {
+		sizes = imaging.DefaultSizes
+	}
+
+	result, err := imaging.Process(content, sizes)
+	if err != nil {
+		return data.UserImage{}, nil, err
+	}
+
+	// GIFはPNGとして保存されるため、拡張子を出力形式に合わせる
+	sum := sha256.Sum256(result.Original)
+	i := data.UserImage{
+		FileName:   hex.EncodeToString(sum[:]) + imaging.Extension(result.Format),
+		Sizes:      result.Sizes(),
+		ScanStatus: string(status),
+	}
+	contentType := "image/" + result.Format
+
+	// FileNamesと同じ順に、元の画像と各サイズの内容を並べる
+	names := i.FileNames()
+	bodies := [][]byte{result.Original}
+	for _, size := range i.Sizes {
+		bodies = append(bodies, result.Variants[size])
+	}
+
+	// 途中で失敗しても、書き込んだファイルは他の画像と共有しているかもしれないので消さない
+	// 参照されないまま残ったファイルはGCで削除される
+	files := make([]data.StoredFile, 0, len(bodies))
+	for n, body := range bodies {
+		sum := sha256.Sum256(body)
+		f := data.StoredFile{FileName: names[n], SHA256: hex.EncodeToString(sum[:]), Size: int64(len(body)), ContentType: contentType}
+
+		// 既にあっても内容は同じなので上書きし、登録するまでGCに消されないよう更新日時を新しくする
+		err := s.Storage.Put(ctx, f.FileName, bytes.NewReader(body), f.Size, contentType)
+		if err != nil {
+			return data.UserImage{}, nil, err
+		}
+
+		files = append(files, f)
+	}
+
+	return i, files, nil
+}
+
+// Open returns the file of the variant that best fits size (see
+// data.UserImage.Src), or of the full image when size is 0.
+func (s *Store) Open(ctx context.Context, i data.UserImage, size int) (io.ReadCloser, *storage.ObjectInfo, error) {
+	key := i.FileName
+	if size > 0 {
+		key = i.Src(size)
+	}
+
+	info, err := s.Storage.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rc, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rc, info, nil
+}
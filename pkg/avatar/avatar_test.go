@@ -0,0 +1,150 @@
+package avatar
+
+import (
+	"context"
+	"errors"
+	"go_test_prac/webApp/pkg/scanner"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	local, err := storage.NewLocal(t.TempDir(), "/static/img/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(local)
+}
+
+func TestStore_SaveOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+
+	content, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, files, err := s.Save(ctx, content)
+	if err != nil {
+		t.Fatalf("save: %s", err)
+	}
+
+	// ファイル名は内容のSHA-256
+	if len(i.FileName) != 64+len(".png") || !strings.HasSuffix(i.FileName, ".png") || i.FileName[:64] != files[0].SHA256 {
+		t.Errorf("unexpected file name %s", i.FileName)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected the original and 2 variants but got %d files", len(files))
+	}
+	for n, name := range i.FileNames() {
+		info, err := s.Storage.Stat(ctx, name)
+		if err != nil {
+			t.Fatalf("%s was not stored: %s", name, err)
+		}
+		if files[n].FileName != name || files[n].Size != info.Size || files[n].ContentType != "image/png" {
+			t.Errorf("unexpected stored file %+v for %+v", files[n], info)
+		}
+	}
+	// img.pngは225x225
+	if len(i.Sizes) != 2 || i.Sizes[0] != 64 || i.Sizes[1] != 225 {
+		t.Errorf("unexpected sizes %v", i.Sizes)
+	}
+
+	rc, info, err := s.Open(ctx, i, 100)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	rc.Close()
+	if info.Key != i.VariantFileName(225) {
+		t.Errorf("expected the 225px variant for size 100, got %s", info.Key)
+	}
+
+	rc, info, err = s.Open(ctx, i, 0)
+	if err != nil {
+		t.Fatalf("open original: %s", err)
+	}
+	_, _ = io.Copy(io.Discard, rc)
+	rc.Close()
+	if info.Key != i.FileName {
+		t.Errorf("expected the original for size 0, got %s", info.Key)
+	}
+
+	// 同じ画像をもう一度アップロードすると、同じファイルを共有する
+	second, _, err := s.Save(ctx, content)
+	if err != nil {
+		t.Fatalf("second save: %s", err)
+	}
+	if second.FileName != i.FileName {
+		t.Errorf("expected the same content to share %s but got %s", i.FileName, second.FileName)
+	}
+
+	for _, key := range []string{i.FileName, i.VariantFileName(64), i.VariantFileName(225)} {
+		if _, err := s.Storage.Stat(ctx, key); err != nil {
+			t.Errorf("expected %s to be stored, got %v", key, err)
+		}
+	}
+}
+
+func TestStore_SaveRejectsNonImages(t *testing.T) {
+	s := newTestStore(t)
+
+	_, _, err := s.Save(context.Background(), []byte("<html><script>alert(1)</script></html>"))
+	if !errors.Is(err, ErrNotAnImage) {
+		t.Errorf("expected ErrNotAnImage but got %v", err)
+	}
+}
+
+// fakeScanner reports every upload with the given result and error.
+type fakeScanner struct {
+	result scanner.Result
+	err    error
+}
+
+func (f fakeScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	return f.result, f.err
+}
+
+func TestStore_SaveScansUploads(t *testing.T) {
+	ctx := context.Background()
+	content, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name           string
+		scanner        scanner.UploadScanner
+		expectedStatus string
+		expectedErr    error
+	}{
+		{"no scanner", nil, "clean", nil},
+		{"clean", fakeScanner{result: scanner.Result{Status: scanner.Clean}}, "clean", nil},
+		{"infected", fakeScanner{result: scanner.Result{Status: scanner.Infected, Signature: "Eicar-Signature"}}, "", scanner.ErrInfected},
+		{"scanner down", fakeScanner{err: errors.New("connection refused")}, "", scanner.ErrUnavailable},
+		{"too large to scan", fakeScanner{result: scanner.Result{Status: scanner.Quarantined}}, "", scanner.ErrTooLargeToScan},
+	}
+
+	for _, e := range tests {
+		s := newTestStore(t)
+		s.Scanner = e.scanner
+
+		i, files, err := s.Save(ctx, content)
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if i.ScanStatus != e.expectedStatus {
+			t.Errorf("%s: expected status %q but got %q", e.name, e.expectedStatus, i.ScanStatus)
+		}
+
+		// 感染したファイルや検査できなかったファイルは保存しない
+		objects, _ := s.Storage.List(ctx, "")
+		if e.expectedErr != nil && (len(objects) != 0 || len(files) != 0) {
+			t.Errorf("%s: the rejected upload was stored: %v", e.name, objects)
+		}
+	}
+}
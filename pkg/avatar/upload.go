@@ -0,0 +1,129 @@
+package avatar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxSize is the largest picture accepted by ReadUpload, 5MB.
+const DefaultMaxSize = 1024 * 1024 * 5
+
+var (
+	// ErrTooLarge is returned when the upload exceeds the size limit.
+	ErrTooLarge = errors.New("the uploaded file is too big. Please choose an image less than 5MB in size")
+	// ErrNoFile is returned when the request does not contain a file.
+	ErrNoFile = errors.New("no file was uploaded")
+	// ErrNotAnImage is returned when the content is not a JPEG, PNG or GIF image.
+	ErrNotAnImage = errors.New("the uploaded file is not an image. Please choose a JPEG, PNG or GIF file")
+)
+
+// allowedTypes are the content types accepted for profile pictures, as detected
+// from the file content rather than trusted from the client.
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// ReadUpload reads a picture from the request body. multipart/form-data
+// requests (HTML forms) use the first file part; any other content type is
+// read as the raw file content, named by the filename parameter of the
+// Content-Disposition header when present. At most maxSize bytes are accepted.
+func ReadUpload(w http.ResponseWriter, r *http.Request, maxSize int64) (string, []byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	// multipartのヘッダ分の余裕を持たせる
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+64*1024)
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return readMultipart(r, params["boundary"], maxSize)
+	}
+
+	fileName := "avatar"
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		fileName = params["filename"]
+	}
+
+	content, err := readAll(r.Body, maxSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fileName, content, nil
+}
+
+func readMultipart(r *http.Request, boundary string, maxSize int64) (string, []byte, error) {
+	if boundary == "" {
+		return "", nil, ErrNoFile
+	}
+
+	mr := multipart.NewReader(r.Body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return "", nil, ErrNoFile
+		}
+		if err != nil {
+			return "", nil, tooLargeOr(err)
+		}
+
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		content, err := readAll(part, maxSize)
+		part.Close()
+		if err != nil {
+			return "", nil, err
+		}
+
+		return part.FileName(), content, nil
+	}
+}
+
+// readAll reads at most maxSize bytes, returning ErrTooLarge when there is more.
+func readAll(r io.Reader, maxSize int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, tooLargeOr(err)
+	}
+	if int64(len(content)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	if len(content) == 0 {
+		return nil, ErrNoFile
+	}
+	return content, nil
+}
+
+func tooLargeOr(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrTooLarge
+	}
+	return err
+}
+
+// Validate checks that the content is a JPEG, PNG or GIF image by sniffing its
+// first bytes; the file name and the client supplied content type are ignored.
+func Validate(content []byte) error {
+	if len(content) == 0 {
+		return ErrNoFile
+	}
+
+	contentType := http.DetectContentType(content)
+	if !allowedTypes[contentType] {
+		return fmt.Errorf("%w (got %s)", ErrNotAnImage, strings.SplitN(contentType, ";", 2)[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func multipartBody(t *testing.T, fieldName, fileName string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("note", "hello")
+	if fileName != "" {
+		w, err := mw.CreateFormFile(fieldName, fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(content)
+	}
+	mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+func TestReadUpload(t *testing.T) {
+	img, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formBody, formType := multipartBody(t, "image", "img.png", img)
+	emptyForm, emptyFormType := multipartBody(t, "", "", nil)
+	bigForm, bigFormType := multipartBody(t, "image", "big.png", bytes.Repeat([]byte("x"), 2048))
+
+	var tests = []struct {
+		name               string
+		body               *bytes.Buffer
+		contentType        string
+		contentDisposition string
+		expectedFileName   string
+		expectedErr        error
+	}{
+		{"multipart", formBody, formType, "", "img.png", nil},
+		{"multipart without file", emptyForm, emptyFormType, "", "", ErrNoFile},
+		{"multipart too large", bigForm, bigFormType, "", "", ErrTooLarge},
+		{"raw body", bytes.NewBuffer(img), "image/png", "", "avatar", nil},
+		{"raw body with file name", bytes.NewBuffer(img), "image/png", `attachment; filename="me.png"`, "me.png", nil},
+		{"raw body too large", bytes.NewBuffer(bytes.Repeat([]byte("x"), 2048)), "application/octet-stream", "", "", ErrTooLarge},
+		{"empty body", new(bytes.Buffer), "image/png", "", "", ErrNoFile},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("PUT", "/users/1/avatar", e.body)
+		req.Header.Set("Content-Type", e.contentType)
+		if e.contentDisposition != "" {
+			req.Header.Set("Content-Disposition", e.contentDisposition)
+		}
+
+		fileName, content, err := ReadUpload(httptest.NewRecorder(), req, 1024)
+		// img.pngは1024バイト未満なので、制限内で読み込める
+		if e.expectedErr == nil && !bytes.Equal(content, img) {
+			t.Errorf("%s: content does not match the uploaded file", e.name)
+		}
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if fileName != e.expectedFileName {
+			t.Errorf("%s: expected file name %q but got %q", e.name, e.expectedFileName, fileName)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	img, _ := os.ReadFile("./testdata/img.png")
+
+	var tests = []struct {
+		name        string
+		content     []byte
+		expectedErr error
+	}{
+		{"png", img, nil},
+		{"gif", []byte("GIF89a......"), nil},
+		{"jpeg", []byte("\xFF\xD8\xFF\xE0......"), nil},
+		{"html", []byte("<html></html>"), ErrNotAnImage},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrNotAnImage},
+		{"empty", nil, ErrNoFile},
+	}
+
+	for _, e := range tests {
+		if err := Validate(e.content); !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+	}
+}
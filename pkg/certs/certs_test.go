@@ -0,0 +1,108 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePair writes a new certificate for host and returns its PEM.
+func writePair(t *testing.T, certFile, keyFile, host string, mod time.Time) []byte {
+	t.Helper()
+	if err := WriteSelfSigned(certFile, keyFile, []string{host}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// 更新時刻で変更を検出するので、確実にずらす
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func leaf(t *testing.T, r *Reloader) []byte {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, "first.localhost", time.Now().Add(-time.Hour))
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := leaf(t, r)
+
+	if c := r.TLSConfig(); c.MinVersion != tls.VersionTLS12 || c.GetCertificate == nil {
+		t.Errorf("unexpected tls config %+v", c)
+	}
+
+	// 変更がなければ読み直さない
+	if changed, err := r.changed(); err != nil || changed {
+		t.Errorf("expected no change but got %v, %v", changed, err)
+	}
+
+	writePair(t, certFile, keyFile, "second.localhost", time.Now())
+	if changed, err := r.changed(); err != nil || !changed {
+		t.Errorf("expected a change but got %v, %v", changed, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for bytes.Equal(leaf(t, r), first) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the certificate to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloader_keepsCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, "localhost", time.Now())
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := leaf(t, r)
+
+	// 証明書だけが更新された途中の状態
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("expected an error for a broken certificate")
+	}
+	if !bytes.Equal(leaf(t, r), before) {
+		t.Error("expected the previous certificate to stay in use")
+	}
+}
+
+func TestNewReloader_missingFiles(t *testing.T) {
+	if _, err := NewReloader("does-not-exist.pem", "does-not-exist-key.pem"); err == nil {
+		t.Error("expected an error for missing files")
+	}
+}
@@ -0,0 +1,81 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSigned(DefaultHosts, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("expected a valid key pair but got %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		host  string
+		valid bool
+	}{
+		{"localhost", true},
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"example.com", false},
+	}
+	for _, e := range tests {
+		err := cert.VerifyHostname(e.host)
+		if e.valid && err != nil {
+			t.Errorf("%s: expected the certificate to be valid but got %v", e.host, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("%s: expected the certificate to be invalid", e.host)
+		}
+	}
+
+	if cert.NotAfter.After(time.Now().Add(25 * time.Hour)) {
+		t.Errorf("expected the certificate to expire within a day but got %v", cert.NotAfter)
+	}
+	if len(cert.IPAddresses) != 2 || !cert.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("unexpected IP addresses %v", cert.IPAddresses)
+	}
+}
+
+func TestGenerateSelfSigned_noHosts(t *testing.T) {
+	if _, _, err := GenerateSelfSigned(nil, time.Hour); err == nil {
+		t.Error("expected an error without hosts")
+	}
+}
+
+func TestWriteSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	if err := WriteSelfSigned(certFile, keyFile, []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 秘密鍵は所有者だけが読める
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the key to be 0600 but got %v", info.Mode().Perm())
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Errorf("expected the files to load but got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package clientip finds the address of the client of a request behind
+// reverse proxies.
+//
+// Forwarding headers are only believed when the request comes from a trusted
+// proxy, as anyone else can send them. Only the one header the proxies are
+// configured to set is read, since a client can send any of the others and
+// the proxies pass them on unchanged. The chain of addresses in the header is
+// read from right to left, skipping further trusted proxies, and the first
+// address that is not a trusted proxy is the client. Parsing stops at an
+// entry that is not a valid IP address, since the hops further left cannot be
+// checked.
+//
+// The supported headers are:
+//
+//   - Forwarded (RFC 7239), the for= parameter of each element,
+//   - X-Forwarded-For,
+//   - X-Real-IP.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Headers the trusted proxies may set to the client address.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// Headers lists every supported header.
+var Headers = []string{HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP}
+
+// Resolver resolves client addresses. A nil *Resolver trusts no proxy and
+// always returns the address of the connection.
+type Resolver struct {
+	header  string
+	trusted []netip.Prefix
+}
+
+// New returns a resolver trusting the proxies in cidrs, written as CIDRs like
+// 10.0.0.0/8 or as single addresses, to set the client address in header, one
+// of Headers. The header is required when there are trusted proxies.
+func New(header string, cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("clientip: invalid trusted proxy %q", s)
+			}
+			addr = addr.Unmap()
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("clientip: invalid trusted proxy %q", s)
+		}
+		r.trusted = append(r.trusted, p.Masked())
+	}
+
+	header = strings.TrimSpace(header)
+	for _, h := range Headers {
+		if strings.EqualFold(header, h) {
+			r.header = h
+		}
+	}
+	switch {
+	case header != "" && r.header == "":
+		return nil, fmt.Errorf("clientip: unsupported header %q, use one of %s", header, strings.Join(Headers, ", "))
+	case r.header == "" && len(r.trusted) > 0:
+		return nil, fmt.Errorf("clientip: trusted proxies need the header they set, one of %s", strings.Join(Headers, ", "))
+	}
+	return r, nil
+}
+
+// Parse returns a resolver trusting the comma-separated proxies in s to set
+// header, as given on the command line.
+func Parse(header, s string) (*Resolver, error) {
+	return New(header, strings.Split(s, ","))
+}
+
+// Trusted reports whether addr is a trusted proxy.
+func (r *Resolver) Trusted(addr netip.Addr) bool {
+	if r == nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client of req. It is not valid when
+// the address of the connection is not an IP address either.
+func (r *Resolver) ClientIP(req *http.Request) netip.Addr {
+	remote := parseAddr(req.RemoteAddr)
+	if !remote.IsValid() || !r.Trusted(remote) {
+		return remote
+	}
+
+	// 他のヘッダーはクライアントが送ったものがそのまま届くので読まない
+	var chain []string
+	values := req.Header.Values(r.header)
+	switch r.header {
+	case HeaderForwarded:
+		chain = forwardedFor(values)
+	case HeaderXForwardedFor:
+		for _, v := range values {
+			chain = append(chain, strings.Split(v, ",")...)
+		}
+	case HeaderXRealIP:
+		chain = values
+	}
+
+	// 右(自分に近いプロキシ)から順にたどる
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr := parseAddr(chain[i])
+		if !addr.IsValid() {
+			break
+		}
+		client = addr
+		if !r.Trusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// String returns the address of the client of req, or "unknown".
+func (r *Resolver) String(req *http.Request) string {
+	addr := r.ClientIP(req)
+	if !addr.IsValid() {
+		return "unknown"
+	}
+	return addr.String()
+}
+
+type contextKey struct{}
+
+// Middleware stores the address of the client in the request context, for
+// FromContext.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), contextKey{}, r.ClientIP(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// FromContext returns the address stored by Middleware.
+func FromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(contextKey{}).(netip.Addr)
+	return addr, ok && addr.IsValid()
+}
+
+// parseAddr parses an address with or without a port, such as 192.0.2.1,
+// 192.0.2.1:4711, 2001:db8::1 or [2001:db8::1]:4711. IPv4 addresses mapped to
+// IPv6 are returned as IPv4.
+func parseAddr(s string) netip.Addr {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	// ゾーン付きのアドレスはクライアントのアドレスとして扱わない
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// forwardedFor returns the for= parameters of the elements of Forwarded
+// headers, in order. Elements without one are returned as "", which stops
+// the chain.
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			var node string
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					node = unquote(value)
+				}
+			}
+			chain = append(chain, node)
+		}
+	}
+	return chain
+}
+
+// splitQuoted splits s at sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes and escapes of a quoted string.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
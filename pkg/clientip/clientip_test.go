@@ -0,0 +1,262 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name        string
+		header      string
+		cidrs       []string
+		expectError bool
+	}{
+		{"none", "", nil, false},
+		{"cidrs", HeaderXForwardedFor, []string{"10.0.0.0/8", "fd00::/8"}, false},
+		{"single addresses", HeaderForwarded, []string{"127.0.0.1", "::1"}, false},
+		{"blanks are skipped", HeaderXRealIP, []string{"", " 10.0.0.1 "}, false},
+		{"header case", "x-forwarded-for", []string{"10.0.0.1"}, false},
+		{"header without proxies", HeaderXForwardedFor, nil, false},
+		{"invalid address", HeaderXForwardedFor, []string{"10.0.0.256"}, true},
+		{"invalid cidr", HeaderXForwardedFor, []string{"10.0.0.0/33"}, true},
+		{"host name", HeaderXForwardedFor, []string{"proxy.internal"}, true},
+		{"proxies without header", "", []string{"10.0.0.0/8"}, true},
+		{"unknown header", "CF-Connecting-IP", []string{"10.0.0.0/8"}, true},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.header, e.cidrs)
+		if e.expectError && err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+		if !e.expectError && err != nil {
+			t.Errorf("%s: expected no error but got %v", e.name, err)
+		}
+	}
+}
+
+func TestResolver_Trusted(t *testing.T) {
+	r, err := Parse(HeaderXForwardedFor, "10.0.0.0/8, 192.168.1.1, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		addr     string
+		expected bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		// IPv6にマップされたIPv4アドレス
+		{"::ffff:10.1.2.3", true},
+	}
+
+	for _, e := range tests {
+		if got := r.Trusted(netip.MustParseAddr(e.addr)); got != e.expected {
+			t.Errorf("%s: expected %t but got %t", e.addr, e.expected, got)
+		}
+	}
+
+	var none *Resolver
+	if none.Trusted(netip.MustParseAddr("10.1.2.3")) {
+		t.Error("expected a nil resolver to trust nothing")
+	}
+}
+
+func TestResolver_ClientIP(t *testing.T) {
+	var tests = []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		// 直接の接続
+		{"direct", HeaderXForwardedFor, "203.0.113.7:51234", nil, "203.0.113.7"},
+		{"direct ipv6", HeaderXForwardedFor, "[2001:db8::7]:51234", nil, "2001:db8::7"},
+		{"untrusted peer sends xff", HeaderXForwardedFor, "203.0.113.7:51234", map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.7"},
+		{"untrusted peer sends forwarded", HeaderForwarded, "203.0.113.7:51234", map[string][]string{"Forwarded": {"for=1.2.3.4"}}, "203.0.113.7"},
+		{"untrusted peer sends x-real-ip", HeaderXRealIP, "203.0.113.7:51234", map[string][]string{"X-Real-IP": {"1.2.3.4"}}, "203.0.113.7"},
+		{"invalid remote addr", HeaderXForwardedFor, "not-an-address", nil, ""},
+		{"empty remote addr", HeaderXForwardedFor, "", nil, ""},
+
+		// X-Forwarded-For
+		{"xff single", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"xff spoofed left entry", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1"},
+		{"xff through two proxies", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"xff several header lines", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"xff all trusted", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"xff garbage stops the chain", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.1, garbage"}}, "10.0.0.1"},
+		{"xff garbage left of the client", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"garbage, 198.51.100.1"}}, "198.51.100.1"},
+		{"xff with port", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.1:4711"}}, "198.51.100.1"},
+		{"xff ipv6", HeaderXForwardedFor, "[fd00::1]:80", map[string][]string{"X-Forwarded-For": {"2001:db8::1"}}, "2001:db8::1"},
+		{"xff mapped ipv4", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"::ffff:198.51.100.1"}}, "198.51.100.1"},
+		{"xff empty entry", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"198.51.100.1, "}}, "10.0.0.1"},
+		{"xff with zone", HeaderXForwardedFor, "10.0.0.1:80", map[string][]string{"X-Forwarded-For": {"fe80::1%eth0"}}, "10.0.0.1"},
+
+		// Forwarded (RFC 7239)
+		{"forwarded", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https;by=10.0.0.1"}}, "198.51.100.1"},
+		{"forwarded quoted ipv6 with port", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711"`}}, "2001:db8::1"},
+		{"forwarded case insensitive", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"For=198.51.100.1"}}, "198.51.100.1"},
+		{"forwarded several elements", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"for=1.2.3.4, for=198.51.100.1, for=10.0.0.2"}}, "198.51.100.1"},
+		{"forwarded several header lines", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"for=1.2.3.4", "for=198.51.100.1"}}, "198.51.100.1"},
+		{"forwarded obfuscated", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"for=198.51.100.1, for=_hidden"}}, "10.0.0.1"},
+		{"forwarded unknown", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"for=unknown"}}, "10.0.0.1"},
+		{"forwarded without for", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {"proto=https"}}, "10.0.0.1"},
+		{"forwarded quoted comma", HeaderForwarded, "10.0.0.1:80", map[string][]string{"Forwarded": {`for=198.51.100.1;host="a,b"`}}, "198.51.100.1"},
+
+		// X-Real-IP
+		{"x-real-ip", HeaderXRealIP, "10.0.0.1:80", map[string][]string{"X-Real-IP": {"198.51.100.1"}}, "198.51.100.1"},
+		{"x-real-ip invalid", HeaderXRealIP, "10.0.0.1:80", map[string][]string{"X-Real-IP": {"nope"}}, "10.0.0.1"},
+		{"x-real-ip spoofed line", HeaderXRealIP, "10.0.0.1:80", map[string][]string{"X-Real-IP": {"1.2.3.4", "198.51.100.1"}}, "198.51.100.1"},
+
+		// ヘッダーのない信頼できるプロキシ
+		{"trusted peer without headers", HeaderXForwardedFor, "10.0.0.1:80", nil, "10.0.0.1"},
+	}
+
+	for _, e := range tests {
+		r, err := New(e.header, []string{"10.0.0.0/8", "fd00::/8"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for name, values := range e.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+
+		got := r.ClientIP(req)
+		if e.expected == "" {
+			if got.IsValid() {
+				t.Errorf("%s: expected no address but got %s", e.name, got)
+			}
+			continue
+		}
+		if got.String() != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+// プロキシが設定しないヘッダーはクライアントが送ったものがそのまま届くので、偽装に使えない
+func TestResolver_ClientIP_spoofing(t *testing.T) {
+	var tests = []struct {
+		name    string
+		header  string
+		headers map[string][]string
+	}{
+		// X-Forwarded-Forを付けるプロキシに、偽のForwardedとX-Real-IPを送る
+		{"xff proxy", HeaderXForwardedFor, map[string][]string{
+			"Forwarded":       {"for=1.2.3.4"},
+			"X-Real-IP":       {"1.2.3.5"},
+			"X-Forwarded-For": {"1.2.3.6, 198.51.100.1"},
+		}},
+		{"forwarded proxy", HeaderForwarded, map[string][]string{
+			"Forwarded":       {"for=1.2.3.4, for=198.51.100.1"},
+			"X-Real-IP":       {"1.2.3.5"},
+			"X-Forwarded-For": {"1.2.3.6"},
+		}},
+		{"x-real-ip proxy", HeaderXRealIP, map[string][]string{
+			"Forwarded":       {"for=1.2.3.4"},
+			"X-Real-IP":       {"198.51.100.1"},
+			"X-Forwarded-For": {"1.2.3.6"},
+		}},
+	}
+
+	for _, e := range tests {
+		r, _ := New(e.header, []string{"10.0.0.0/8"})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:80"
+		for name, values := range e.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+
+		if got := r.ClientIP(req).String(); got != "198.51.100.1" {
+			t.Errorf("%s: expected 198.51.100.1 but got %s", e.name, got)
+		}
+	}
+}
+
+func TestResolver_ClientIP_nil(t *testing.T) {
+	var r *Resolver
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:80"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+
+	if got := r.ClientIP(req).String(); got != "10.0.0.1" {
+		t.Errorf("expected the connection address but got %s", got)
+	}
+}
+
+func TestResolver_String(t *testing.T) {
+	var r *Resolver
+	req := httptest.NewRequest("GET", "/", nil)
+
+	req.RemoteAddr = "192.0.2.1:1234"
+	if got := r.String(req); got != "192.0.2.1" {
+		t.Errorf("expected 192.0.2.1 but got %s", got)
+	}
+	req.RemoteAddr = ""
+	if got := r.String(req); got != "unknown" {
+		t.Errorf("expected unknown but got %s", got)
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	r, _ := New(HeaderXForwardedFor, []string{"10.0.0.0/8"})
+
+	var got netip.Addr
+	var found bool
+	handler := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got, found = FromContext(req.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:80"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !found || got.String() != "198.51.100.1" {
+		t.Errorf("expected 198.51.100.1 in the context but got %v, %t", got, found)
+	}
+
+	if _, ok := FromContext(req.Context()); ok {
+		t.Error("expected no address in a context the middleware did not see")
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	var tests = []struct {
+		in       string
+		expected []string
+	}{
+		{"a,b", []string{"a", "b"}},
+		{`a="x,y",b`, []string{`a="x,y"`, "b"}},
+		{`a="x\",y",b`, []string{`a="x\",y"`, "b"}},
+		{"", []string{""}},
+	}
+
+	for _, e := range tests {
+		got := splitQuoted(e.in, ',')
+		if len(got) != len(e.expected) {
+			t.Errorf("%q: expected %q but got %q", e.in, e.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != e.expected[i] {
+				t.Errorf("%q: expected %q but got %q", e.in, e.expected, got)
+			}
+		}
+	}
+}
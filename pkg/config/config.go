@@ -0,0 +1,361 @@
+// Package config loads the configuration of the servers and the CLI into a
+// typed struct. Every field is read, from lowest to highest precedence, from
+//
+//   - the default already in the struct,
+//   - a YAML file given with -config or WEBAPP_CONFIG,
+//   - an environment variable named after its flag, e.g. WEBAPP_JWT_SECRET
+//     for -jwt-secret, or a file named by WEBAPP_JWT_SECRET_FILE,
+//   - its command-line flag.
+//
+// Fields are described by struct tags: yaml is the key in the file, flag the
+// name of the flag and of the environment variable, usage the help text, and
+// secret:"true" hides the value when the configuration is printed. Fields are
+// strings, numbers, booleans, durations or types implementing
+// encoding.TextUnmarshaler. Nested structs become sections of the file; their
+// fields keep flat flag names.
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is prepended to the environment variables.
+const EnvPrefix = "WEBAPP_"
+
+// FileSuffix is appended to an environment variable to read the value from a
+// file instead, as done for Docker and Kubernetes secrets.
+const FileSuffix = "_FILE"
+
+// redacted replaces secrets when the configuration is printed.
+const redacted = "[redacted]"
+
+// ErrPrinted is returned by Load after -print-config printed the
+// configuration.
+var ErrPrinted = errors.New("config: configuration printed")
+
+// Validator is implemented by configurations that check their values after
+// loading.
+type Validator interface {
+	Validate() error
+}
+
+// field is a setting found in the tags of the configuration.
+type field struct {
+	flag   string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// env returns the name of the environment variable of f.
+func (f field) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.flag, "-", "_"))
+}
+
+// Load fills cfg, a pointer to a struct holding the defaults, from the
+// configuration file, the environment and args, and validates it. name is
+// the program name shown in the usage message. Load returns flag.ErrHelp for
+// -h and ErrPrinted for -print-config.
+func Load(name string, cfg interface{}, args []string) error {
+	_, err := LoadCommand(name, cfg, args)
+	return err
+}
+
+// LoadCommand is Load for programs with subcommands: it returns the
+// arguments after the flags, starting with the name of the command.
+func LoadCommand(name string, cfg interface{}, args []string) ([]string, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+	fields, err := collect(v.Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", "", "YAML file to read the configuration from (env "+EnvPrefix+"CONFIG)")
+	printConfig := fs.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+	for _, f := range fields {
+		fs.Var(value{f.value}, f.flag, f.usage+" (env "+f.env()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// ファイルと環境変数より優先させるため、指定されたフラグを覚えておいて最後にもう一度設定する
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if err := loadEnv(f); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if s, ok := flags[f.flag]; ok {
+			if err := (value{f.value}).Set(s); err != nil {
+				return nil, fmt.Errorf("config: -%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	if c, ok := cfg.(Validator); ok {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+	}
+
+	if *printConfig {
+		if err := Fprint(os.Stdout, cfg); err != nil {
+			return nil, err
+		}
+		return nil, ErrPrinted
+	}
+	return fs.Args(), nil
+}
+
+// MustLoad loads cfg from the command line of the program. It exits after
+// -h and -print-config, and when the configuration is invalid.
+func MustLoad(name string, cfg interface{}) {
+	err := Load(name, cfg, os.Args[1:])
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp), errors.Is(err, ErrPrinted):
+		os.Exit(0)
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+// collect returns the fields of v with a flag tag, descending into nested
+// structs.
+func collect(v reflect.Value) ([]field, error) {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType && !isText(fv) {
+			nested, err := collect(fv)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+			continue
+		}
+		name := sf.Tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		if !settable(fv) {
+			return nil, fmt.Errorf("config: unsupported type %s of %s", sf.Type, sf.Name)
+		}
+		fields = append(fields, field{
+			flag:   name,
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  fv,
+		})
+	}
+	return fields, nil
+}
+
+func loadFile(path string, cfg interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	// 綴りの間違いに気づけるように、知らないキーはエラーにする
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+func loadEnv(f field) error {
+	name := f.env()
+	if path, ok := os.LookupEnv(name + FileSuffix); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", name+FileSuffix, err)
+		}
+		// エディタなどが付ける末尾の改行は値に含めない
+		if err := (value{f.value}).Set(strings.TrimRight(string(b), "\r\n")); err != nil {
+			return fmt.Errorf("config: %s: %w", name+FileSuffix, err)
+		}
+		return nil
+	}
+	if s, ok := os.LookupEnv(name); ok {
+		if err := (value{f.value}).Set(s); err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+var (
+	durationType      = reflect.TypeOf(time.Duration(0))
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isText reports whether v is set from text by its UnmarshalText method, like
+// a value in the YAML file.
+func isText(v reflect.Value) bool {
+	if !v.CanAddr() {
+		return false
+	}
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+func settable(v reflect.Value) bool {
+	if isText(v) {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// value is a flag.Value setting a field of the configuration.
+type value struct {
+	v reflect.Value
+}
+
+func (f value) String() string {
+	// flagパッケージがゼロ値の判定のために空のvalueを作る
+	if !f.v.IsValid() {
+		return ""
+	}
+	if f.v.Type() == durationType {
+		return time.Duration(f.v.Int()).String()
+	}
+	if m, ok := f.v.Interface().(encoding.TextMarshaler); ok {
+		b, _ := m.MarshalText()
+		return string(b)
+	}
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f value) Set(s string) error {
+	if isText(f.v) {
+		return f.v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch {
+	case f.v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.v.SetInt(int64(d))
+		return nil
+	case f.v.Kind() == reflect.String:
+		f.v.SetString(s)
+		return nil
+	case f.v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.v.SetBool(b)
+		return nil
+	case f.v.Kind() == reflect.Int || f.v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return err
+		}
+		f.v.SetInt(n)
+		return nil
+	case f.v.Kind() == reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.v.SetFloat(x)
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", f.v.Type())
+}
+
+// IsBoolFlag lets boolean flags be given without a value.
+func (f value) IsBoolFlag() bool {
+	return f.v.IsValid() && f.v.Kind() == reflect.Bool
+}
+
+// Fprint writes cfg to w as YAML that can be read back with -config, with
+// the values of secrets replaced.
+func Fprint(w io.Writer, cfg interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("config: %T is not a struct", cfg)
+	}
+	b, err := yaml.Marshal(redact(v))
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// redact returns the fields of v in order, keyed like the file.
+func redact(v reflect.Value) yaml.MapSlice {
+	var out yaml.MapSlice
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(sf.Name)
+		}
+
+		fv := v.Field(i)
+		switch {
+		case sf.Type.Kind() == reflect.Struct && !sf.Type.Implements(textMarshalerType):
+			out = append(out, yaml.MapItem{Key: key, Value: redact(fv)})
+		case sf.Tag.Get("secret") == "true" && !fv.IsZero():
+			out = append(out, yaml.MapItem{Key: key, Value: redacted})
+		case sf.Type == durationType:
+			out = append(out, yaml.MapItem{Key: key, Value: time.Duration(fv.Int()).String()})
+		default:
+			out = append(out, yaml.MapItem{Key: key, Value: fv.Interface()})
+		}
+	}
+	return out
+}
@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name    string        `yaml:"name" flag:"name" usage:"name"`
+	Port    int           `yaml:"port" flag:"port" usage:"port"`
+	Timeout time.Duration `yaml:"timeout" flag:"timeout" usage:"timeout"`
+	Debug   bool          `yaml:"debug" flag:"debug" usage:"debug"`
+	Auth    struct {
+		Secret string `yaml:"secret" flag:"secret" usage:"secret" secret:"true"`
+	} `yaml:"auth"`
+}
+
+func (c testConfig) Validate() error {
+	if c.Port <= 0 {
+		return errors.New("port must be positive")
+	}
+	return nil
+}
+
+func defaultTestConfig() testConfig {
+	c := testConfig{Name: "default", Port: 8080, Timeout: time.Second}
+	c.Auth.Secret = "default-secret"
+	return c
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, "config.yml", "name: file\nport: 9000\ntimeout: 5s\nauth:\n  secret: file-secret\n")
+	secretFile := writeFile(t, "secret", "mounted-secret\n")
+
+	var tests = []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		expected func(*testConfig)
+	}{
+		{"defaults", nil, nil, func(c *testConfig) {}},
+		{"file", nil, []string{"-config", file}, func(c *testConfig) {
+			c.Name, c.Port, c.Timeout, c.Auth.Secret = "file", 9000, 5*time.Second, "file-secret"
+		}},
+		{"file from env", map[string]string{"WEBAPP_CONFIG": file}, nil, func(c *testConfig) {
+			c.Name, c.Port, c.Timeout, c.Auth.Secret = "file", 9000, 5*time.Second, "file-secret"
+		}},
+		{"env over file", map[string]string{"WEBAPP_NAME": "env", "WEBAPP_DEBUG": "true"}, []string{"-config", file}, func(c *testConfig) {
+			c.Name, c.Port, c.Timeout, c.Debug, c.Auth.Secret = "env", 9000, 5*time.Second, true, "file-secret"
+		}},
+		{"flags over env", map[string]string{"WEBAPP_NAME": "env", "WEBAPP_PORT": "9001"}, []string{"-name", "flag", "-debug"}, func(c *testConfig) {
+			c.Name, c.Port, c.Debug = "flag", 9001, true
+		}},
+		{"secret from file", map[string]string{"WEBAPP_SECRET_FILE": secretFile, "WEBAPP_SECRET": "ignored"}, nil, func(c *testConfig) {
+			c.Auth.Secret = "mounted-secret"
+		}},
+		{"flag over secret file", map[string]string{"WEBAPP_SECRET_FILE": secretFile}, []string{"-secret", "flag-secret"}, func(c *testConfig) {
+			c.Auth.Secret = "flag-secret"
+		}},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			for k, v := range e.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := defaultTestConfig()
+			if err := Load("test", &cfg, e.args); err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+
+			expected := defaultTestConfig()
+			e.expected(&expected)
+			if cfg != expected {
+				t.Errorf("expected %+v but got %+v", expected, cfg)
+			}
+		})
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	unknownKey := writeFile(t, "unknown.yml", "name: file\nprot: 9000\n")
+	badDuration := writeFile(t, "duration.yml", "timeout: soon\n")
+
+	var tests = []struct {
+		name          string
+		env           map[string]string
+		args          []string
+		expectedError string
+	}{
+		{"unknown key in file", nil, []string{"-config", unknownKey}, "prot"},
+		{"bad duration in file", nil, []string{"-config", badDuration}, "duration.yml"},
+		{"missing file", nil, []string{"-config", "does-not-exist.yml"}, "does-not-exist.yml"},
+		{"bad env", map[string]string{"WEBAPP_PORT": "eighty"}, nil, "WEBAPP_PORT"},
+		{"missing secret file", map[string]string{"WEBAPP_SECRET_FILE": "/does/not/exist"}, nil, "WEBAPP_SECRET_FILE"},
+		{"bad flag", nil, []string{"-port", "eighty"}, "eighty"},
+		{"invalid", nil, []string{"-port", "0"}, "port must be positive"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			for k, v := range e.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := defaultTestConfig()
+			err := Load("test", &cfg, e.args)
+			if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+				t.Errorf("expected an error containing %q but got %v", e.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestLoad_help(t *testing.T) {
+	cfg := defaultTestConfig()
+	err := Load("test", &cfg, []string{"-h"})
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp but got %v", err)
+	}
+}
+
+func TestLoadCommand(t *testing.T) {
+	cfg := defaultTestConfig()
+	args, err := LoadCommand("test", &cfg, []string{"-port", "9000", "user", "show", "-output", "json", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9000 {
+		t.Errorf("expected the flag before the command to be read but got port %d", cfg.Port)
+	}
+	// コマンド以降のフラグはコマンドが読む
+	if strings.Join(args, " ") != "user show -output json 1" {
+		t.Errorf("expected the command and its arguments but got %q", args)
+	}
+}
+
+func TestLoad_notStruct(t *testing.T) {
+	var s string
+	if err := Load("test", &s, nil); err == nil {
+		t.Error("expected an error for a pointer to a string")
+	}
+}
+
+func TestFprint(t *testing.T) {
+	cfg := defaultTestConfig()
+	cfg.Auth.Secret = "do-not-print"
+
+	var buf bytes.Buffer
+	if err := Fprint(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	if strings.Contains(out, "do-not-print") {
+		t.Errorf("expected the secret to be redacted in\n%s", out)
+	}
+	expected := "name: default\nport: 8080\ntimeout: 1s\ndebug: false\nauth:\n  secret: '[redacted]'\n"
+	if out != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, out)
+	}
+
+	// 出力はそのまま設定ファイルとして読める
+	path := writeFile(t, "printed.yml", out)
+	loaded := testConfig{}
+	if err := Load("test", &loaded, []string{"-config", path}); err != nil {
+		t.Fatalf("expected the printed configuration to load but got %v", err)
+	}
+	if loaded.Name != cfg.Name || loaded.Timeout != cfg.Timeout {
+		t.Errorf("expected %+v but got %+v", cfg, loaded)
+	}
+}
+
+func TestLoad_printConfig(t *testing.T) {
+	// -print-config は標準出力に書くので、一時的に差し替える
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	cfg := defaultTestConfig()
+	err = Load("test", &cfg, []string{"-print-config", "-name", "printed"})
+	w.Close()
+	out, _ := io.ReadAll(r)
+
+	if !errors.Is(err, ErrPrinted) {
+		t.Errorf("expected ErrPrinted but got %v", err)
+	}
+	if !strings.Contains(string(out), "name: printed\n") || strings.Contains(string(out), "default-secret") {
+		t.Errorf("unexpected output\n%s", out)
+	}
+}
+
+// pair is read from text like "a:b", as a struct the loader must not descend
+// into.
+type pair struct {
+	A, B string
+}
+
+func (p pair) MarshalText() ([]byte, error) {
+	return []byte(p.A + ":" + p.B), nil
+}
+
+func (p *pair) UnmarshalText(b []byte) error {
+	a, rest, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return errors.New("expected a:b")
+	}
+	p.A, p.B = a, rest
+	return nil
+}
+
+func TestLoad_textUnmarshaler(t *testing.T) {
+	type textConfig struct {
+		Pair pair `yaml:"pair" flag:"pair" usage:"pair"`
+	}
+	file := writeFile(t, "config.yml", "pair: file:value\n")
+
+	var tests = []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		expected    pair
+		expectError bool
+	}{
+		{"default", nil, nil, pair{"x", "y"}, false},
+		{"file", nil, []string{"-config", file}, pair{"file", "value"}, false},
+		{"env", map[string]string{"WEBAPP_PAIR": "env:value"}, nil, pair{"env", "value"}, false},
+		{"flag", map[string]string{"WEBAPP_PAIR": "env:value"}, []string{"-pair", "flag:value"}, pair{"flag", "value"}, false},
+		{"invalid", nil, []string{"-pair", "nocolon"}, pair{}, true},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			for k, v := range e.env {
+				t.Setenv(k, v)
+			}
+			cfg := textConfig{Pair: pair{"x", "y"}}
+			err := Load("test", &cfg, e.args)
+			if e.expectError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Pair != e.expected {
+				t.Errorf("expected %+v but got %+v", e.expected, cfg.Pair)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := Fprint(&buf, textConfig{Pair: pair{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "pair: a:b\n" {
+		t.Errorf("expected the text of the pair but got %q", buf.String())
+	}
+}
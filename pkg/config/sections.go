@@ -0,0 +1,349 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/clientip"
+	"go_test_prac/webApp/pkg/ratelimit"
+	"go_test_prac/webApp/pkg/server"
+	"go_test_prac/webApp/pkg/storage"
+	"net/url"
+	"time"
+)
+
+// The sections below are shared by the servers and the CLI, so that they read
+// the same keys, flags and environment variables.
+
+// Database is the Postgres connection.
+type Database struct {
+	DSN string `yaml:"dsn" flag:"dsn" usage:"Postgres connection" secret:"true"`
+}
+
+// DefaultDatabase connects to the database of docker-compose.yml.
+func DefaultDatabase() Database {
+	return Database{
+		DSN: "host=localhost user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5",
+	}
+}
+
+func (d Database) Validate() error {
+	if d.DSN == "" {
+		return errors.New("dsn is required")
+	}
+	return nil
+}
+
+// Auth holds the key signing the JWTs of the API.
+type Auth struct {
+	JWTSecret string `yaml:"jwt_secret" flag:"jwt-secret" usage:"signing secret for JWT" secret:"true"`
+}
+
+// DefaultAuth has a secret for local development only.
+func DefaultAuth() Auth {
+	return Auth{JWTSecret: "2dce505d96a53c5768052ee90fsdf2055657518ad489160df9913f66042e160"}
+}
+
+// minJWTSecretLength is 32 bytes, the 256 bits HS256 needs. The secret is
+// used as it is, so a hex-encoded key needs twice as many characters.
+const minJWTSecretLength = 32
+
+func (a Auth) Validate() error {
+	if len(a.JWTSecret) < minJWTSecretLength {
+		return fmt.Errorf("jwt-secret must be at least %d characters", minJWTSecretLength)
+	}
+	return nil
+}
+
+// Storage selects where uploaded files are kept. cmd/web and cmd/api must use
+// the same storage.
+type Storage struct {
+	Backend    string `yaml:"backend" flag:"storage" usage:"storage backend: local|s3"`
+	Root       string `yaml:"path" flag:"storage-path" usage:"directory for uploaded files (local storage)"`
+	BaseURL    string `yaml:"url" flag:"storage-url" usage:"URL prefix uploaded files are served from (local storage)"`
+	SigningKey string `yaml:"signing_key" flag:"storage-signing-key" usage:"key used to sign file URLs (local storage); when empty URLs are not signed and only profile pictures are served" secret:"true"`
+	Endpoint   string `yaml:"s3_endpoint" flag:"s3-endpoint" usage:"S3 endpoint"`
+	Region     string `yaml:"s3_region" flag:"s3-region" usage:"S3 region"`
+	Bucket     string `yaml:"s3_bucket" flag:"s3-bucket" usage:"S3 bucket"`
+	AccessKey  string `yaml:"s3_access_key" flag:"s3-access-key" usage:"S3 access key"`
+	SecretKey  string `yaml:"s3_secret_key" flag:"s3-secret-key" usage:"S3 secret key" secret:"true"`
+}
+
+// DefaultStorage keeps files in ./static/img/, served by cmd/web.
+func DefaultStorage() Storage {
+	return Storage{
+		Backend:  "local",
+		Root:     "./static/img/",
+		BaseURL:  "/static/img/",
+		Endpoint: "http://localhost:9000",
+		Region:   "us-east-1",
+		Bucket:   "uploads",
+	}
+}
+
+func (s Storage) Validate() error {
+	switch s.Backend {
+	case "local":
+		if s.Root == "" {
+			return errors.New("storage-path is required for local storage")
+		}
+	case "s3":
+		if s.Endpoint == "" || s.Bucket == "" {
+			return errors.New("s3-endpoint and s3-bucket are required for s3 storage")
+		}
+	default:
+		return fmt.Errorf("unknown storage %q, expected local or s3", s.Backend)
+	}
+	return nil
+}
+
+// StorageConfig returns the configuration for storage.New.
+func (s Storage) StorageConfig() storage.Config {
+	return storage.Config{
+		Backend:    s.Backend,
+		Root:       s.Root,
+		BaseURL:    s.BaseURL,
+		SigningKey: s.SigningKey,
+		Endpoint:   s.Endpoint,
+		Region:     s.Region,
+		Bucket:     s.Bucket,
+		AccessKey:  s.AccessKey,
+		SecretKey:  s.SecretKey,
+	}
+}
+
+// Origin returns the scheme and host files are served from, for the
+// Content-Security-Policy of the pages showing them, or "" when they are
+// served by the same server.
+func (s Storage) Origin() string {
+	base := s.BaseURL
+	if s.Backend == "s3" {
+		base = s.Endpoint
+	}
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Log selects the output of log/slog.
+type Log struct {
+	Format string `yaml:"format" flag:"log-format" usage:"log output format: text|json"`
+	Level  string `yaml:"level" flag:"log-level" usage:"lowest level logged: debug|info|warn|error"`
+}
+
+// DefaultLog logs text from the info level.
+func DefaultLog() Log {
+	return Log{Format: "text", Level: "info"}
+}
+
+func (l Log) Validate() error {
+	if l.Format != "text" && l.Format != "json" {
+		return fmt.Errorf("unknown log-format %q, expected text or json", l.Format)
+	}
+	switch l.Level {
+	case "debug", "info", "warn", "error":
+		return nil
+	}
+	return fmt.Errorf("unknown log-level %q, expected debug, info, warn or error", l.Level)
+}
+
+// Metrics protects /metrics. It is served with the application by default.
+type Metrics struct {
+	Addr     string `yaml:"addr" flag:"metrics-addr" usage:"separate address to serve /metrics on, e.g. 127.0.0.1:9090; served with the application when empty"`
+	User     string `yaml:"user" flag:"metrics-user" usage:"user for basic authentication of /metrics; not protected when empty"`
+	Password string `yaml:"password" flag:"metrics-password" usage:"password for basic authentication of /metrics" secret:"true"`
+}
+
+func (m Metrics) Validate() error {
+	if m.User != "" && m.Password == "" {
+		return errors.New("metrics-password is required with metrics-user")
+	}
+	return nil
+}
+
+// Tracing selects where spans are sent.
+type Tracing struct {
+	Exporter     string  `yaml:"exporter" flag:"trace-exporter" usage:"where spans are sent: none|stdout|otlp"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" flag:"otlp-endpoint" usage:"OTLP/HTTP endpoint of the OpenTelemetry collector"`
+	SampleRatio  float64 `yaml:"sample_ratio" flag:"trace-sample-ratio" usage:"share of new traces that are recorded, between 0 and 1"`
+}
+
+// DefaultTracing records nothing.
+func DefaultTracing() Tracing {
+	return Tracing{Exporter: "none", OTLPEndpoint: "http://localhost:4318", SampleRatio: 1}
+}
+
+func (t Tracing) Validate() error {
+	switch t.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		return fmt.Errorf("unknown trace-exporter %q, expected none, stdout or otlp", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("trace-sample-ratio must be between 0 and 1, got %g", t.SampleRatio)
+	}
+	return nil
+}
+
+// Server is the address and the timeouts of a server.
+type Server struct {
+	Port              int           `yaml:"port" flag:"port" usage:"port to listen on"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" flag:"read-header-timeout" usage:"time allowed to read request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" flag:"read-timeout" usage:"time allowed to read a whole request, including the body"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" flag:"write-timeout" usage:"time allowed to write a response"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" flag:"idle-timeout" usage:"time a keep-alive connection waits for the next request"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" flag:"max-header-bytes" usage:"largest request headers accepted, in bytes"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" flag:"shutdown-timeout" usage:"time requests in flight are given to finish on SIGINT or SIGTERM"`
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay" flag:"shutdown-delay" usage:"time to keep serving after SIGINT or SIGTERM while /readyz fails, so that load balancers stop sending requests"`
+}
+
+// DefaultServer listens on port with the defaults of package server.
+func DefaultServer(port int) Server {
+	return Server{
+		Port:              port,
+		ReadHeaderTimeout: server.DefaultReadHeaderTimeout,
+		ReadTimeout:       server.DefaultReadTimeout,
+		WriteTimeout:      server.DefaultWriteTimeout,
+		IdleTimeout:       server.DefaultIdleTimeout,
+		MaxHeaderBytes:    server.DefaultMaxHeaderBytes,
+		ShutdownTimeout:   server.DefaultShutdownTimeout,
+	}
+}
+
+func (s Server) Validate() error {
+	if s.Port < 1 || s.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got %d", s.Port)
+	}
+	if s.ReadHeaderTimeout < 0 || s.ReadTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 || s.ShutdownTimeout < 0 || s.ShutdownDelay < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	if s.MaxHeaderBytes < 0 {
+		return errors.New("max-header-bytes must not be negative")
+	}
+	return nil
+}
+
+// Addr is the address to listen on.
+func (s Server) Addr() string {
+	return fmt.Sprintf(":%d", s.Port)
+}
+
+// ServerConfig returns the timeouts for package server.
+func (s Server) ServerConfig() server.Config {
+	return server.Config{
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		ReadTimeout:       s.ReadTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+		ShutdownTimeout:   s.ShutdownTimeout,
+		ShutdownDelay:     s.ShutdownDelay,
+	}
+}
+
+// TLS turns on HTTPS. Cookies are marked Secure only when it is on.
+type TLS struct {
+	CertFile       string        `yaml:"cert" flag:"tls-cert" usage:"certificate file (PEM); HTTPS is served when set"`
+	KeyFile        string        `yaml:"key" flag:"tls-key" usage:"private key file (PEM)"`
+	ReloadInterval time.Duration `yaml:"reload_interval" flag:"tls-reload-interval" usage:"how often the certificate files are checked for changes; not reloaded when 0"`
+	RedirectPort   int           `yaml:"redirect_port" flag:"http-redirect-port" usage:"port redirecting plain HTTP to HTTPS, e.g. 80; off when 0"`
+	HSTSMaxAge     time.Duration `yaml:"hsts_max_age" flag:"hsts-max-age" usage:"max-age of the Strict-Transport-Security header; not sent when 0"`
+}
+
+// DefaultTLS serves plain HTTP. Once HTTPS is on, browsers are told to stick
+// to it for a year.
+func DefaultTLS() TLS {
+	return TLS{HSTSMaxAge: 365 * 24 * time.Hour}
+}
+
+// Enabled reports whether HTTPS is served.
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// TLSConfig returns the certificate and its options for package server.
+func (t TLS) TLSConfig() server.TLS {
+	return server.TLS{
+		CertFile:       t.CertFile,
+		KeyFile:        t.KeyFile,
+		ReloadInterval: t.ReloadInterval,
+		RedirectPort:   t.RedirectPort,
+		HSTSMaxAge:     t.HSTSMaxAge,
+	}
+}
+
+func (t TLS) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls-cert and tls-key must be set together")
+	}
+	if t.RedirectPort != 0 && !t.Enabled() {
+		return errors.New("http-redirect-port requires tls-cert and tls-key")
+	}
+	if t.RedirectPort < 0 || t.RedirectPort > 65535 {
+		return fmt.Errorf("http-redirect-port must be between 1 and 65535, got %d", t.RedirectPort)
+	}
+	if t.ReloadInterval < 0 || t.HSTSMaxAge < 0 {
+		return errors.New("tls-reload-interval and hsts-max-age must not be negative")
+	}
+	return nil
+}
+
+// Proxy lists the reverse proxies in front of a server and the header they
+// set to the client address. That header decides the client address when a
+// request comes from one of them; other headers and other peers are ignored.
+type Proxy struct {
+	TrustedProxies string `yaml:"trusted" flag:"trusted-proxies" usage:"comma-separated CIDRs or addresses of reverse proxies whose client IP header is trusted, e.g. 10.0.0.0/8,127.0.0.1"`
+	Header         string `yaml:"header" flag:"client-ip-header" usage:"the header the trusted proxies set to the client IP: Forwarded|X-Forwarded-For|X-Real-IP; required with trusted-proxies"`
+}
+
+func (p Proxy) Validate() error {
+	_, err := p.Resolver()
+	return err
+}
+
+// Resolver returns the resolver of client addresses.
+func (p Proxy) Resolver() (*clientip.Resolver, error) {
+	return clientip.Parse(p.Header, p.TrustedProxies)
+}
+
+// RateLimit limits the request rates of the authentication routes per client
+// address, of the authenticated routes and the CSP violation reports per
+// client address, and of the user routes per user.
+type RateLimit struct {
+	Backend string          `yaml:"backend" flag:"rate-limit-backend" usage:"where the counters are kept: memory|postgres; use postgres when several instances serve the same clients"`
+	Auth    ratelimit.Limit `yaml:"auth" flag:"rate-limit-auth" usage:"requests per client address to login, authentication and token refresh, as requests/period like 10/1m; off to disable"`
+	Clients ratelimit.Limit `yaml:"clients" flag:"rate-limit-clients" usage:"requests per client address to the routes needing a token or API key, counted before they are checked, and to CSP violation reports, as requests/period like 600/1m; off to disable"`
+	Users   ratelimit.Limit `yaml:"users" flag:"rate-limit-users" usage:"requests per user to the user routes, as requests/period like 300/1m; off to disable"`
+}
+
+// DefaultRateLimit allows bursts of 10 login attempts a minute per address,
+// which is plenty for people and slows down password guessing. An address may
+// be shared by several users, so it gets twice the limit of a user.
+func DefaultRateLimit() RateLimit {
+	return RateLimit{
+		Backend: "memory",
+		Auth:    ratelimit.Limit{Requests: 10, Period: time.Minute},
+		Clients: ratelimit.Limit{Requests: 600, Period: time.Minute},
+		Users:   ratelimit.Limit{Requests: 300, Period: time.Minute},
+	}
+}
+
+func (r RateLimit) Validate() error {
+	if r.Backend != "memory" && r.Backend != "postgres" {
+		return fmt.Errorf("unknown rate-limit-backend %q, expected memory or postgres", r.Backend)
+	}
+	return nil
+}
+
+// Idle returns how long a counter is kept after its last request. Counters
+// are full again by then.
+func (r RateLimit) Idle() time.Duration {
+	idle := r.Auth.Period
+	for _, l := range []ratelimit.Limit{r.Clients, r.Users} {
+		if l.Period > idle {
+			idle = l.Period
+		}
+	}
+	return idle
+}
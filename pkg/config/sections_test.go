@@ -0,0 +1,163 @@
+package config
+
+import (
+	"go_test_prac/webApp/pkg/ratelimit"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSections_defaultsAreValid(t *testing.T) {
+	for name, v := range map[string]Validator{
+		"database":  DefaultDatabase(),
+		"auth":      DefaultAuth(),
+		"storage":   DefaultStorage(),
+		"log":       DefaultLog(),
+		"metrics":   Metrics{},
+		"tracing":   DefaultTracing(),
+		"server":    DefaultServer(8080),
+		"tls":       DefaultTLS(),
+		"proxy":     Proxy{},
+		"ratelimit": DefaultRateLimit(),
+	} {
+		if err := v.Validate(); err != nil {
+			t.Errorf("%s: expected the defaults to be valid but got %v", name, err)
+		}
+	}
+}
+
+func TestSections_Validate(t *testing.T) {
+	s3 := DefaultStorage()
+	s3.Backend = "s3"
+	s3.Bucket = ""
+
+	var tests = []struct {
+		name          string
+		section       Validator
+		expectedError string
+	}{
+		{"empty dsn", Database{}, "dsn is required"},
+		{"short jwt secret", Auth{JWTSecret: "short"}, "at least 32"},
+		{"jwt secret one byte short", Auth{JWTSecret: strings.Repeat("a", 31)}, "at least 32"},
+		{"unknown storage", Storage{Backend: "ftp"}, `unknown storage "ftp"`},
+		{"s3 without bucket", s3, "s3-bucket"},
+		{"unknown log format", Log{Format: "xml", Level: "info"}, "log-format"},
+		{"unknown log level", Log{Format: "json", Level: "trace"}, "log-level"},
+		{"metrics user without password", Metrics{User: "prometheus"}, "metrics-password"},
+		{"unknown exporter", Tracing{Exporter: "jaeger"}, "trace-exporter"},
+		{"sample ratio above 1", Tracing{Exporter: "none", SampleRatio: 2}, "trace-sample-ratio"},
+		{"port out of range", Server{Port: 70000}, "port"},
+		{"negative timeout", Server{Port: 8080, ReadTimeout: -time.Second}, "negative"},
+		{"tls cert without key", TLS{CertFile: "cert.pem"}, "set together"},
+		{"redirect without tls", TLS{RedirectPort: 80}, "requires tls-cert"},
+		{"invalid trusted proxy", Proxy{TrustedProxies: "10.0.0.0/8,proxy.internal", Header: "X-Forwarded-For"}, "proxy.internal"},
+		{"trusted proxy without header", Proxy{TrustedProxies: "10.0.0.0/8"}, "header"},
+		{"unknown client ip header", Proxy{TrustedProxies: "10.0.0.0/8", Header: "True-Client-IP"}, "True-Client-IP"},
+		{"unknown rate limit backend", RateLimit{Backend: "redis"}, "rate-limit-backend"},
+		{"redirect port out of range", TLS{CertFile: "cert.pem", KeyFile: "key.pem", RedirectPort: -1}, "http-redirect-port"},
+	}
+
+	for _, e := range tests {
+		err := e.section.Validate()
+		if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+			t.Errorf("%s: expected an error containing %q but got %v", e.name, e.expectedError, err)
+		}
+	}
+}
+
+func TestAuth_Validate(t *testing.T) {
+	if err := (Auth{JWTSecret: strings.Repeat("a", minJWTSecretLength)}).Validate(); err != nil {
+		t.Errorf("expected a secret of %d bytes to be valid but got %v", minJWTSecretLength, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := DefaultServer(8090)
+	s.ShutdownDelay = 5 * time.Second
+
+	if s.Addr() != ":8090" {
+		t.Errorf("expected :8090 but got %s", s.Addr())
+	}
+	c := s.ServerConfig()
+	if c.ReadHeaderTimeout != s.ReadHeaderTimeout || c.ShutdownDelay != 5*time.Second || c.MaxHeaderBytes != s.MaxHeaderBytes {
+		t.Errorf("expected the timeouts of %+v but got %+v", s, c)
+	}
+}
+
+func TestStorage_StorageConfig(t *testing.T) {
+	s := DefaultStorage()
+	s.SecretKey = "secret"
+
+	c := s.StorageConfig()
+	if c.Backend != "local" || c.Root != s.Root || c.BaseURL != s.BaseURL || c.SecretKey != "secret" {
+		t.Errorf("expected the fields of %+v but got %+v", s, c)
+	}
+}
+
+func TestStorage_Origin(t *testing.T) {
+	var tests = []struct {
+		name     string
+		storage  Storage
+		expected string
+	}{
+		{"local relative", Storage{Backend: "local", BaseURL: "/static/img/"}, ""},
+		{"local absolute", Storage{Backend: "local", BaseURL: "http://localhost:8080/static/img/"}, "http://localhost:8080"},
+		{"s3", Storage{Backend: "s3", BaseURL: "/static/img/", Endpoint: "https://s3.example.com/"}, "https://s3.example.com"},
+	}
+
+	for _, e := range tests {
+		if got := e.storage.Origin(); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTLS_Enabled(t *testing.T) {
+	if DefaultTLS().Enabled() {
+		t.Error("expected TLS to be off by default")
+	}
+	if !(TLS{CertFile: "cert.pem", KeyFile: "key.pem"}).Enabled() {
+		t.Error("expected TLS to be on with a certificate")
+	}
+}
+
+func TestTLS_TLSConfig(t *testing.T) {
+	tls := TLS{CertFile: "cert.pem", KeyFile: "key.pem", ReloadInterval: time.Hour, RedirectPort: 80, HSTSMaxAge: time.Minute}
+
+	c := tls.TLSConfig()
+	if c.CertFile != "cert.pem" || c.KeyFile != "key.pem" || c.ReloadInterval != time.Hour || c.RedirectPort != 80 || c.HSTSMaxAge != time.Minute {
+		t.Errorf("expected the fields of %+v but got %+v", tls, c)
+	}
+}
+
+func TestProxy_Resolver(t *testing.T) {
+	r, err := Proxy{TrustedProxies: "10.0.0.0/8, 127.0.0.1", Header: "X-Forwarded-For"}.Resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Trusted(netip.MustParseAddr("127.0.0.1")) || r.Trusted(netip.MustParseAddr("127.0.0.2")) {
+		t.Error("expected only the listed proxies to be trusted")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	type rateLimitConfig struct {
+		RateLimit RateLimit `yaml:"rate_limit"`
+	}
+	cfg := rateLimitConfig{RateLimit: DefaultRateLimit()}
+	if err := Load("test", &cfg, []string{"-rate-limit-auth", "5/30s", "-rate-limit-clients", "20/10s", "-rate-limit-users", "off"}); err != nil {
+		t.Fatal(err)
+	}
+	r := cfg.RateLimit
+	if r.Auth != (ratelimit.Limit{Requests: 5, Period: 30 * time.Second}) || r.Clients != (ratelimit.Limit{Requests: 20, Period: 10 * time.Second}) || r.Users.Enabled() {
+		t.Errorf("expected the limits of the flags but got %+v", r)
+	}
+	if r.Idle() != 30*time.Second {
+		t.Errorf("expected the longest period but got %s", r.Idle())
+	}
+
+	if err := Load("test", &cfg, []string{"-rate-limit-auth", "5"}); err == nil {
+		t.Error("expected an error for a limit without period")
+	}
+}
@@ -0,0 +1,245 @@
+// Package cors implements Cross-Origin Resource Sharing, which lets pages on
+// other origins call a server from the browser.
+//
+// A preflight request, an OPTIONS request with Origin and
+// Access-Control-Request-Method, is answered by the middleware itself: with
+// the allowed methods and headers when the origin, method and headers are
+// allowed, and without any CORS header otherwise, which makes the browser
+// block the actual request. Other requests are passed on and, from an allowed
+// origin, get Access-Control-Allow-Origin and the exposed headers.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configure a Policy.
+type Options struct {
+	// AllowedOrigins are origins such as https://app.example.com. A single *
+	// stands for a part of the host or the port, as in https://*.example.com
+	// or http://localhost:*; "*" alone allows every origin.
+	AllowedOrigins []string
+	// AllowedMethods may be used in addition to GET, HEAD and POST, which
+	// browsers always allow.
+	AllowedMethods []string
+	// AllowedHeaders may be sent by scripts; "*" allows any header.
+	AllowedHeaders []string
+	// ExposedHeaders may be read by scripts in addition to the simple response
+	// headers.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and be read by scripts.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response; not sent
+	// when 0.
+	MaxAge time.Duration
+}
+
+// Policy decides which cross-origin requests are allowed. A nil *Policy
+// allows none and adds no headers.
+type Policy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	patterns    []pattern
+	methods     map[string]bool
+	anyHeader   bool
+	headers     map[string]bool
+	credentials bool
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// pattern is an origin with a wildcard, split around the *.
+type pattern struct {
+	prefix, suffix string
+}
+
+// match reports whether origin is the prefix, one or more host characters
+// and the suffix, so that https://*.example.com does not match
+// https://example.com or https://evil.com/.example.com.
+func (p pattern) match(origin string) bool {
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+	for _, c := range origin[len(p.prefix) : len(origin)-len(p.suffix)] {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// New returns the policy of o. Wildcard origins cannot be combined with
+// credentials, as that would let any site act for the logged in user.
+func New(o Options) (*Policy, error) {
+	p := &Policy{
+		origins:     map[string]bool{},
+		methods:     map[string]bool{http.MethodGet: true, http.MethodHead: true, http.MethodPost: true},
+		headers:     map[string]bool{},
+		credentials: o.AllowCredentials,
+	}
+
+	for _, origin := range o.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch n := strings.Count(origin, "*"); {
+		case origin == "":
+		case origin == "*":
+			p.anyOrigin = true
+		case n == 0:
+			p.origins[origin] = true
+		case n == 1 && strings.Contains(origin, "://"):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			p.patterns = append(p.patterns, pattern{prefix, suffix})
+		default:
+			return nil, fmt.Errorf("cors: invalid origin %q, use at most one * after the scheme", origin)
+		}
+	}
+	if p.anyOrigin && p.credentials {
+		return nil, fmt.Errorf("cors: the origin * cannot be combined with credentials, list the origins instead")
+	}
+
+	var methods []string
+	for _, m := range o.AllowedMethods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if m != "" {
+			p.methods[m] = true
+			methods = append(methods, m)
+		}
+	}
+	p.allowMethods = strings.Join(methods, ", ")
+
+	var headers []string
+	for _, h := range o.AllowedHeaders {
+		h = strings.TrimSpace(h)
+		switch h {
+		case "":
+		case "*":
+			p.anyHeader = true
+		default:
+			p.headers[strings.ToLower(h)] = true
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	p.allowHeaders = strings.Join(headers, ", ")
+
+	var exposed []string
+	for _, h := range o.ExposedHeaders {
+		if h = strings.TrimSpace(h); h != "" {
+			exposed = append(exposed, http.CanonicalHeaderKey(h))
+		}
+	}
+	p.exposeHeaders = strings.Join(exposed, ", ")
+
+	if o.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(o.MaxAge.Seconds()))
+	}
+	return p, nil
+}
+
+// AllowsOrigin reports whether requests from origin are allowed.
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if p == nil || origin == "" {
+		return false
+	}
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, pat := range p.patterns {
+		if pat.match(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether every header in the comma-separated list of
+// Access-Control-Request-Headers is allowed.
+func (p *Policy) allowsHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && !p.headers[h] {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler applies the policy to the requests of next.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			p.preflight(w, r)
+			return
+		}
+
+		// 許可するかどうかがOriginで変わるので、キャッシュにも区別させる
+		w.Header().Add("Vary", "Origin")
+		if p.AllowsOrigin(origin) {
+			p.allowOrigin(w.Header(), origin)
+			if p.exposeHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", p.exposeHeaders)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a preflight request without calling the handler.
+func (p *Policy) preflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	// 許可しない場合はCORSのヘッダーを付けずに答え、ブラウザに本来のリクエストを止めさせる
+	if !p.AllowsOrigin(origin) || !p.methods[method] || !p.allowsHeaders(requested) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	p.allowOrigin(h, origin)
+	if p.allowMethods != "" {
+		h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	}
+	switch {
+	case p.anyHeader && requested != "":
+		h.Set("Access-Control-Allow-Headers", requested)
+	case p.allowHeaders != "":
+		h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Policy) allowOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,237 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name        string
+		options     Options
+		expectError bool
+	}{
+		{"empty", Options{}, false},
+		{"exact and wildcard", Options{AllowedOrigins: []string{"https://app.example.com", "https://*.example.com", "http://localhost:*"}}, false},
+		{"any origin", Options{AllowedOrigins: []string{"*"}}, false},
+		{"any origin with credentials", Options{AllowedOrigins: []string{"*"}, AllowCredentials: true}, true},
+		{"two wildcards", Options{AllowedOrigins: []string{"https://*.*.example.com"}}, true},
+		{"wildcard scheme", Options{AllowedOrigins: []string{"*.example.com"}}, true},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.options)
+		if e.expectError && err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+		if !e.expectError && err != nil {
+			t.Errorf("%s: expected no error but got %v", e.name, err)
+		}
+	}
+}
+
+func TestPolicy_AllowsOrigin(t *testing.T) {
+	p, err := New(Options{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		origin   string
+		expected bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://evil.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://.example.org", false},
+		{"https://evil.com/.example.org", false},
+		{"https://evilexample.org", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"null", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		if got := p.AllowsOrigin(e.origin); got != e.expected {
+			t.Errorf("%q: expected %t but got %t", e.origin, e.expected, got)
+		}
+	}
+
+	var none *Policy
+	if none.AllowsOrigin("https://app.example.com") {
+		t.Error("expected a nil policy to allow no origin")
+	}
+}
+
+var testOptions = Options{
+	AllowedOrigins:   []string{"https://app.example.com"},
+	AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+	AllowedHeaders:   []string{"Authorization", "Content-Type"},
+	ExposedHeaders:   []string{"Location", "Upload-Offset"},
+	AllowCredentials: true,
+	MaxAge:           10 * time.Minute,
+}
+
+func TestPolicy_Handler_preflight(t *testing.T) {
+	p, _ := New(testOptions)
+
+	var tests = []struct {
+		name             string
+		origin           string
+		method           string
+		headers          string
+		expectAllowed    bool
+		expectAllowHeads string
+	}{
+		{"allowed", "https://app.example.com", "PATCH", "authorization, content-type", true, "Authorization, Content-Type"},
+		{"simple method", "https://app.example.com", "GET", "", true, "Authorization, Content-Type"},
+		{"other origin", "https://evil.com", "PATCH", "authorization", false, ""},
+		{"method not allowed", "https://app.example.com", "TRACE", "", false, ""},
+		{"header not allowed", "https://app.example.com", "PATCH", "Authorization, X-Secret", false, ""},
+	}
+
+	for _, e := range tests {
+		called := false
+		handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+		req := httptest.NewRequest(http.MethodOptions, "/users/1", nil)
+		req.Header.Set("Origin", e.origin)
+		req.Header.Set("Access-Control-Request-Method", e.method)
+		if e.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", e.headers)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if called {
+			t.Errorf("%s: expected the preflight not to reach the handler", e.name)
+		}
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("%s: expected 204 but got %d", e.name, rr.Code)
+		}
+		h := rr.Header()
+		if allowed := h.Get("Access-Control-Allow-Origin") == e.origin; allowed != e.expectAllowed {
+			t.Errorf("%s: expected allowed %t but got Access-Control-Allow-Origin %q", e.name, e.expectAllowed, h.Get("Access-Control-Allow-Origin"))
+		}
+		if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Access-Control-Request-Headers") {
+			t.Errorf("%s: expected Vary to list the request headers, got %q", e.name, h.Values("Vary"))
+		}
+		if !e.expectAllowed {
+			if len(h.Values("Access-Control-Allow-Methods")) > 0 || h.Get("Access-Control-Allow-Credentials") != "" {
+				t.Errorf("%s: expected no CORS headers but got %v", e.name, h)
+			}
+			continue
+		}
+		if h.Get("Access-Control-Allow-Methods") != "GET, POST, PUT, PATCH, DELETE" {
+			t.Errorf("%s: unexpected Access-Control-Allow-Methods %q", e.name, h.Get("Access-Control-Allow-Methods"))
+		}
+		if h.Get("Access-Control-Allow-Headers") != e.expectAllowHeads {
+			t.Errorf("%s: expected Access-Control-Allow-Headers %q but got %q", e.name, e.expectAllowHeads, h.Get("Access-Control-Allow-Headers"))
+		}
+		if h.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: expected credentials to be allowed", e.name)
+		}
+		if h.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: expected Access-Control-Max-Age 600 but got %q", e.name, h.Get("Access-Control-Max-Age"))
+		}
+	}
+}
+
+func TestPolicy_Handler_actual(t *testing.T) {
+	p, _ := New(testOptions)
+
+	var tests = []struct {
+		name          string
+		method        string
+		origin        string
+		expectAllowed bool
+	}{
+		{"allowed get", http.MethodGet, "https://app.example.com", true},
+		{"allowed post", http.MethodPost, "https://app.example.com", true},
+		{"other origin", http.MethodGet, "https://evil.com", false},
+		{"same origin", http.MethodGet, "", false},
+		// tusの機能の問い合わせなど、プリフライトでないOPTIONS
+		{"plain options", http.MethodOptions, "https://app.example.com", true},
+	}
+
+	for _, e := range tests {
+		called := false
+		handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+		req := httptest.NewRequest(e.method, "/users/1", nil)
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if !called {
+			t.Errorf("%s: expected the request to reach the handler", e.name)
+		}
+		h := rr.Header()
+		if h.Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin but got %q", e.name, h.Values("Vary"))
+		}
+		if got := h.Get("Access-Control-Allow-Origin") != ""; got != e.expectAllowed {
+			t.Errorf("%s: expected allowed %t but got %v", e.name, e.expectAllowed, h)
+		}
+		if e.expectAllowed {
+			if h.Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("%s: expected credentials on the actual request", e.name)
+			}
+			if h.Get("Access-Control-Expose-Headers") != "Location, Upload-Offset" {
+				t.Errorf("%s: unexpected Access-Control-Expose-Headers %q", e.name, h.Get("Access-Control-Expose-Headers"))
+			}
+			if h.Get("Access-Control-Allow-Methods") != "" {
+				t.Errorf("%s: expected no preflight headers on the actual request", e.name)
+			}
+		}
+	}
+}
+
+func TestPolicy_Handler_anyOrigin(t *testing.T) {
+	p, _ := New(Options{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"PUT"}, AllowedHeaders: []string{"*"}})
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom, Authorization")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin * but got %q", rr.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rr.Header().Get("Access-Control-Allow-Headers") != "X-Custom, Authorization" {
+		t.Errorf("expected the requested headers to be allowed but got %q", rr.Header().Get("Access-Control-Allow-Headers"))
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("expected no credentials with any origin")
+	}
+}
+
+func TestPolicy_Handler_nil(t *testing.T) {
+	var p *Policy
+	called := false
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if !called || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected a nil policy to pass requests on without headers")
+	}
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AuditEvent records who did what to which object. Events are only ever
+// appended; each one carries the hash of the event before it, so that a
+// changed or deleted event breaks the chain, see Seal.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	ActorID    int             `json:"actor_id,omitempty"`    // 0 when no user was logged in, e.g. a failed login or the CLI
+	Actor      string          `json:"actor,omitempty"`       // email of the user, the email tried at a login, or "cli:<os user>"
+	Action     string          `json:"action"`                // e.g. user.update, see package audit
+	TargetType string          `json:"target_type,omitempty"` // user, image, file or users
+	TargetID   string          `json:"target_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Changes    json.RawMessage `json:"changes,omitempty"` // {"field": {"before": ..., "after": ...}}
+	PrevHash   string          `json:"prev_hash"`         // "" for the first event
+	Hash       string          `json:"hash"`
+}
+
+// Seal links e to the event before it, whose hash is prevHash, and sets the
+// hash of e. OccurredAt defaults to now and is rounded to microseconds, the
+// precision the database keeps, so that the hash can be computed again from a
+// stored event.
+func (e *AuditEvent) Seal(prevHash string) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+	e.OccurredAt = e.OccurredAt.UTC().Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+	e.Hash = e.Digest()
+}
+
+// Digest returns the SHA-256 of the fields of e and of PrevHash, hex encoded.
+// It equals Hash unless the event was changed after it was sealed.
+func (e *AuditEvent) Digest() string {
+	h := sha256.New()
+	fields := []string{
+		e.PrevHash,
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(e.ActorID),
+		e.Actor,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		string(e.Changes),
+	}
+	// 長さを前に付けて、値の境目を変えても同じハッシュにならないようにする
+	for _, f := range fields {
+		fmt.Fprintf(h, "%d:%s;", len(f), f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditFilter selects audit events. Zero fields match every event.
+type AuditFilter struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time // inclusive
+	Until      time.Time // exclusive
+	// Cursor continues a listing after the event with this ID: events with
+	// smaller IDs are returned, or larger ones when Ascending.
+	Cursor    int64
+	Ascending bool // oldest first; by default the newest come first
+	Limit     int  // 0 returns every event
+}
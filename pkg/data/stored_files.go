@@ -0,0 +1,16 @@
+package data
+
+import "time"
+
+// StoredFile records an object in the storage backend. Objects are shared by
+// every upload with the same content; RefCount is the number of user images
+// and user files referring to the object.
+type StoredFile struct {
+	FileName    string    `json:"file_name"` // storage key
+	SHA256      string    `json:"sha256"`    // hex encoded hash of the content
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	RefCount    int       `json:"ref_count"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
@@ -0,0 +1,17 @@
+package data
+
+import "time"
+
+// UserFile is the type for files other than profile pictures that a user has
+// uploaded, e.g. videos and documents sent with a resumable upload.
+type UserFile struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	FileName    string    `json:"-"`    // storage key
+	Name        string    `json:"name"` // file name given by the client
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	ScanStatus  string    `json:"scan_status"` // clean, infected or quarantined, see package scanner
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"-"`
+}
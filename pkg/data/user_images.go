@@ -1,12 +1,63 @@
 package data
 
-import "time"
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
 
 // UserImage is the type for user profile images.
 type UserImage struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	FileName  string    `json:"file_name"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	FileName   string    `json:"file_name"`
+	Sizes      []int     `json:"sizes"`       // edge lengths of the generated square variants, ascending
+	IsCurrent  bool      `json:"is_current"`  // whether this is the user's profile picture
+	ScanStatus string    `json:"scan_status"` // clean, infected or quarantined, see package scanner
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
+}
+
+// VariantFileName returns the file name of the square variant of the given size,
+// e.g. "photo.jpg" becomes "photo-256.jpg".
+func (i UserImage) VariantFileName(size int) string {
+	ext := filepath.Ext(i.FileName)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(i.FileName, ext), size, ext)
+}
+
+// FileNames returns the file names of the image and of all of its variants.
+func (i UserImage) FileNames() []string {
+	names := []string{i.FileName}
+	for _, size := range i.Sizes {
+		names = append(names, i.VariantFileName(size))
+	}
+	return names
+}
+
+// Src returns the file name of the smallest variant that is at least size pixels
+// wide, falling back to the largest variant, or to the original file when the
+// image has no variants.
+func (i UserImage) Src(size int) string {
+	if len(i.Sizes) == 0 {
+		return i.FileName
+	}
+
+	for _, s := range i.Sizes {
+		if s >= size {
+			return i.VariantFileName(s)
+		}
+	}
+
+	return i.VariantFileName(i.Sizes[len(i.Sizes)-1])
+}
+
+// SrcSet returns the value of an img srcset attribute listing every variant.
+// urlFor turns a file name into the URL the file is served from.
+func (i UserImage) SrcSet(urlFor func(fileName string) string) string {
+	candidates := make([]string, 0, len(i.Sizes))
+	for _, s := range i.Sizes {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", urlFor(i.VariantFileName(s)), s))
+	}
+	return strings.Join(candidates, ", ")
 }
@@ -2,7 +2,9 @@ package data
 
 import (
 	"errors"
+	"go_test_prac/webApp/pkg/validation"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
 	"time"
 )
 
@@ -16,7 +18,8 @@ type User struct {
 	IsAdmin   int       `json:"is_admin"`
 	CreatedAt time.Time `json:"-"` // don't include in the JSON
 	UpdatedAt time.Time `json:"-"` // don't include in the JSON
-	ProfilePic UserImage `json:"-"`
+	ProfilePic UserImage `json:"-"` // the current profile picture
+	Images []UserImage `json:"-"` // every uploaded profile picture, newest first
 }
 
 // PasswordMatches uses Go's bcrypt package to compare a user supplied password
@@ -36,3 +39,32 @@ func (u *User) PasswordMatches(plainText string) (bool, error) {
 
 	return true, nil
 }
+
+// Validate checks the fields clients can set. The limits follow the columns
+// of the users table.
+func (u *User) Validate() validation.Errors {
+	v := validation.New()
+	v.Field("first_name", u.FirstName, validation.Required(), validation.MaxLength(255))
+	v.Field("last_name", u.LastName, validation.Required(), validation.MaxLength(255))
+	v.Field("email", u.Email, validation.Required(), validation.Email(), validation.MaxLength(255))
+	v.Field("is_admin", strconv.Itoa(u.IsAdmin), validation.OneOf("0", "1"))
+	return v.Errors
+}
+
+// UpsertUser is a user written by email in a bulk import. An empty Password
+// keeps the password of an existing user, and new users get none, so they
+// cannot log in until one is set. A nil IsAdmin keeps the role of an existing
+// user, and new users are not admins.
+type UpsertUser struct {
+	Email     string
+	FirstName string
+	LastName  string
+	Password  string
+	IsAdmin   *int
+}
+
+// UpsertResult is the outcome of writing one UpsertUser.
+type UpsertResult struct {
+	ID      int
+	Created bool // false when the user with the email was updated
+}
@@ -0,0 +1,178 @@
+// Package filegc reconciles the storage backend with the database. Files are
+// shared by every upload with the same content, and deleting an upload only
+// releases its reference: the Collector removes the files nothing refers to
+// once they are older than MinAge, so that a file being stored again for a
+// new upload is not deleted under it. It also finds files left behind when
+// deleting a user cascades to user_images and user_files or a request fails
+// between writing a file and recording it, and fixes reference counts that
+// drifted.
+package filegc
+
+import (
+	"context"
+	"fmt"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMinAge is how long an unreferenced file is left alone, so that a file
+// being uploaded right now is not collected before it is recorded.
+const DefaultMinAge = time.Hour
+
+// uploadsPrefix holds the state of resumable uploads, which expire on their own.
+const uploadsPrefix = "uploads/"
+
+// Report is the outcome of a run.
+type Report struct {
+	Objects    int                  // objects found in the storage
+	Referenced int                  // objects referred to by a user image or user file
+	Orphans    []storage.ObjectInfo // unreferenced objects older than MinAge
+	Recent     int                  // unreferenced objects younger than MinAge
+	Missing    []string             // referenced files missing from the storage
+	Recounted  int                  // stored files whose reference count was wrong
+	Forgotten  int                  // stored files whose object no longer exists
+	Deleted    int                  // orphans removed from the storage
+	Freed      int64                // bytes used by the orphans
+}
+
+// Collector compares the files in Storage with the references in DB.
+type Collector struct {
+	DB      repository.DatabaseRepo
+	Storage storage.Blob
+	MinAge  time.Duration
+	Out     io.Writer // progress is printed here
+}
+
+// New returns a Collector with the default minimum age that prints to stdout.
+func New(db repository.DatabaseRepo, s storage.Blob) *Collector {
+	return &Collector{DB: db, Storage: s, MinAge: DefaultMinAge, Out: os.Stdout}
+}
+
+// Run finds orphaned files and wrong reference counts. In a dry run it only
+// prints what it would do; otherwise it deletes the orphans and fixes the
+// records in the database.
+func (c *Collector) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	refs, err := c.DB.AllFileReferences()
+	if err != nil {
+		return nil, fmt.Errorf("loading file references: %w", err)
+	}
+
+	files, err := c.DB.AllStoredFiles()
+	if err != nil {
+		return nil, fmt.Errorf("loading stored files: %w", err)
+	}
+	stored := make(map[string]*data.StoredFile, len(files))
+	for _, f := range files {
+		stored[f.FileName] = f
+	}
+
+	objects, err := c.Storage.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("listing the storage: %w", err)
+	}
+
+	report := &Report{}
+	exists := make(map[string]bool, len(objects))
+
+	for _, o := range objects {
+		if strings.HasPrefix(o.Key, uploadsPrefix) {
+			continue
+		}
+		exists[o.Key] = true
+		report.Objects++
+
+		if refs[o.Key] > 0 {
+			report.Referenced++
+			continue
+		}
+
+		// 記録し直されたばかりのファイルは、これから参照される可能性がある
+		modified := o.ModTime
+		if f, ok := stored[o.Key]; ok && f.UpdatedAt.After(modified) {
+			modified = f.UpdatedAt
+		}
+		if time.Since(modified) < c.MinAge {
+			report.Recent++
+			continue
+		}
+
+		report.Orphans = append(report.Orphans, o)
+		report.Freed += o.Size
+		c.printf("orphan   %s (%d bytes)\n", o.Key, o.Size)
+
+		if dryRun {
+			continue
+		}
+		if err := c.Storage.Delete(ctx, o.Key); err != nil {
+			return report, fmt.Errorf("deleting %s: %w", o.Key, err)
+		}
+		if _, ok := stored[o.Key]; ok {
+			if err := c.DB.DeleteStoredFile(o.Key); err != nil {
+				return report, fmt.Errorf("forgetting %s: %w", o.Key, err)
+			}
+			delete(stored, o.Key)
+		}
+		report.Deleted++
+	}
+
+	for _, f := range files {
+		if _, ok := stored[f.FileName]; !ok {
+			continue // 孤立ファイルとして削除済み
+		}
+
+		n := refs[f.FileName]
+		if !exists[f.FileName] && n == 0 {
+			report.Forgotten++
+			c.printf("forget   %s (no such object)\n", f.FileName)
+			if !dryRun {
+				if err := c.DB.DeleteStoredFile(f.FileName); err != nil {
+					return report, fmt.Errorf("forgetting %s: %w", f.FileName, err)
+				}
+			}
+			continue
+		}
+
+		if f.RefCount != n {
+			report.Recounted++
+			c.printf("recount  %s (%d -> %d)\n", f.FileName, f.RefCount, n)
+			if !dryRun {
+				if err := c.DB.SetStoredFileRefCount(f.FileName, n); err != nil {
+					return report, fmt.Errorf("recounting %s: %w", f.FileName, err)
+				}
+			}
+		}
+	}
+
+	for name := range refs {
+		if !exists[name] {
+			report.Missing = append(report.Missing, name)
+		}
+	}
+	sort.Strings(report.Missing)
+	for _, name := range report.Missing {
+		c.printf("missing  %s (referenced but not in the storage)\n", name)
+	}
+
+	c.printf("%d objects, %d referenced, %d orphans (%d bytes), %d too recent, %d missing, %d recounted, %d forgotten\n",
+		report.Objects, report.Referenced, len(report.Orphans), report.Freed, report.Recent,
+		len(report.Missing), report.Recounted, report.Forgotten)
+	if dryRun {
+		c.printf("dry run: nothing was changed\n")
+	} else {
+		c.printf("deleted %d orphans\n", report.Deleted)
+	}
+
+	return report, nil
+}
+
+func (c *Collector) printf(format string, args ...any) {
+	if c.Out != nil {
+		fmt.Fprintf(c.Out, format, args...)
+	}
+}
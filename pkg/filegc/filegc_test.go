@@ -0,0 +1,210 @@
+package filegc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go_test_prac/webApp/pkg/avatar"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCollector fills a local storage with the files referenced by the
+// test database, except a few missing ones, plus an old and a new orphan.
+func newTestCollector(t *testing.T) (*Collector, *bytes.Buffer) {
+	t.Helper()
+	root := t.TempDir()
+	s, err := storage.NewLocal(root, "/static/img/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// old-64.png, other.png, files/2/cv.pdfは参照されているがストレージにない
+	keys := []string{"current.png", "current-64.png", "current-256.png", "old.png", "files/1/intro.mp4", "orphan.png", "fresh.png", "uploads/abc/info.json"}
+	for _, key := range keys {
+		if err := s.Put(context.Background(), key, strings.NewReader(key), int64(len(key)), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, key := range []string{"orphan.png", "uploads/abc/info.json"} {
+		if err := os.Chtimes(filepath.Join(root, key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	c := New(&dbrepo.TestDBRepo{}, s)
+	c.Out = out
+	return c, out
+}
+
+func TestCollector_RunDryRun(t *testing.T) {
+	c, out := newTestCollector(t)
+	ctx := context.Background()
+
+	report, err := c.Run(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Objects != 7 || report.Referenced != 5 || report.Recent != 1 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Key != "orphan.png" || report.Freed != int64(len("orphan.png")) {
+		t.Errorf("expected orphan.png to be the only orphan, got %+v", report.Orphans)
+	}
+	if strings.Join(report.Missing, ",") != "files/2/cv.pdf,old-64.png,other.png" {
+		t.Errorf("unexpected missing files %v", report.Missing)
+	}
+	// old.pngは参照数が3と記録されているが、参照しているのは画像2だけ
+	if report.Recounted != 1 || report.Forgotten != 1 || report.Deleted != 0 {
+		t.Errorf("unexpected fixes %+v", report)
+	}
+
+	if _, err := c.Storage.Stat(ctx, "orphan.png"); err != nil {
+		t.Errorf("a dry run deleted the orphan: %s", err)
+	}
+
+	for _, line := range []string{"orphan   orphan.png", "recount  old.png (3 -> 1)", "forget   missing.png", "missing  other.png", "dry run"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in the output:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestCollector_RunDelete(t *testing.T) {
+	c, out := newTestCollector(t)
+	ctx := context.Background()
+
+	report, err := c.Run(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 1 {
+		t.Errorf("expected 1 deleted orphan but got %d", report.Deleted)
+	}
+
+	if _, err := c.Storage.Stat(ctx, "orphan.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the orphan to be deleted, got %v", err)
+	}
+	for _, key := range []string{"current.png", "fresh.png", "uploads/abc/info.json"} {
+		if _, err := c.Storage.Stat(ctx, key); err != nil {
+			t.Errorf("%s should be kept: %s", key, err)
+		}
+	}
+	if !strings.Contains(out.String(), "deleted 1 orphans") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestCollector_RunMinAge(t *testing.T) {
+	c, _ := newTestCollector(t)
+	c.MinAge = 0
+
+	report, err := c.Run(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 2 || report.Recent != 0 {
+		t.Errorf("expected both unreferenced files to be orphans without a minimum age, got %+v", report)
+	}
+}
+
+// unreferencedRepo is a database in which nothing refers to any file, as
+// after the last image using them was deleted.
+type unreferencedRepo struct {
+	dbrepo.TestDBRepo
+}
+
+func (unreferencedRepo) AllFileReferences() (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+func (unreferencedRepo) AllStoredFiles() ([]*data.StoredFile, error) {
+	return nil, nil
+}
+
+// TestCollector_RunWhileSaving interleaves uploading a picture whose files
+// already exist with a run that finds them unreferenced, as when the last
+// image using them has just been deleted. The files must exist once the
+// upload is done, whichever comes first.
+func TestCollector_RunWhileSaving(t *testing.T) {
+	content, err := os.ReadFile("../avatar/testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		runFirst bool
+	}{
+		{"save then collect", false},
+		{"collect then save", true},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			ctx := context.Background()
+			root := t.TempDir()
+			s, err := storage.NewLocal(root, "/static/img/", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			avatars := avatar.New(s)
+
+			// 最初のアップロードのファイルを、削除された画像のものとして古くしておく
+			i, _, err := avatars.Save(ctx, content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-2 * time.Hour)
+			for _, key := range i.FileNames() {
+				if err := os.Chtimes(filepath.Join(root, key), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := &Collector{DB: &unreferencedRepo{}, Storage: s, MinAge: DefaultMinAge}
+			run := func() *Report {
+				report, err := c.Run(ctx, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return report
+			}
+
+			var report *Report
+			if e.runFirst {
+				report = run()
+			}
+			// 同じ画像をもう一度アップロードする。DBに登録する前にGCが走っても消されてはならない
+			if _, _, err := avatars.Save(ctx, content); err != nil {
+				t.Fatal(err)
+			}
+			if !e.runFirst {
+				report = run()
+			}
+
+			// 先に走ったGCは古いファイルを消すが、アップロードがもう一度書き込む
+			orphans, recent := 0, len(i.FileNames())
+			if e.runFirst {
+				orphans, recent = recent, orphans
+			}
+			if len(report.Orphans) != orphans || report.Recent != recent {
+				t.Errorf("expected %d orphans and %d recent files, got %+v", orphans, recent, report)
+			}
+			for _, key := range i.FileNames() {
+				if _, err := s.Stat(ctx, key); err != nil {
+					t.Errorf("%s should be stored after the upload: %s", key, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewServerChecker returns the checker answering /healthz and /readyz of
+// cmd/web and cmd/api. Readiness needs the database of repo with the tables
+// and columns in schema, and a writable storage.
+func NewServerChecker(repo Connector, schema map[string][]string, blob storage.Blob) *Checker {
+	return New(
+		Check{Name: "database", Run: Database(repo)},
+		Check{Name: "schema", Run: Schema(repo, schema)},
+		// S3は書き込み、読み込み、削除で3往復するので長めにする
+		Check{Name: "storage", Timeout: 5 * time.Second, Run: Storage(blob)},
+	)
+}
+
+// Connector returns the connection pool of a repository, see
+// repository.DatabaseRepo.
+type Connector interface {
+	Connection() *sql.DB
+}
+
+var errNoConnection = errors.New("no database connection")
+
+// Database pings the database of repo.
+func Database(repo Connector) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		db := repo.Connection()
+		if db == nil {
+			return errNoConnection
+		}
+		return db.PingContext(ctx)
+	}
+}
+
+// Schema checks that the database of repo has the tables and columns in
+// required, keyed by table, so that a server is not sent traffic before the
+// schema it was built for is applied.
+func Schema(repo Connector, required map[string][]string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		db := repo.Connection()
+		if db == nil {
+			return errNoConnection
+		}
+
+		rows, err := db.QueryContext(ctx, `select table_name, column_name from information_schema.columns where table_schema = 'public'`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		existing := make(map[string]bool)
+		for rows.Next() {
+			var table, column string
+			if err := rows.Scan(&table, &column); err != nil {
+				return err
+			}
+			existing[table+"."+column] = true
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return missingColumns(existing, required)
+	}
+}
+
+// missingColumns returns an error naming the required columns that do not
+// exist.
+func missingColumns(existing map[string]bool, required map[string][]string) error {
+	var missing []string
+	for table, columns := range required {
+		for _, column := range columns {
+			if !existing[table+"."+column] {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("schema is not up to date, missing %s", strings.Join(missing, ", "))
+}
+
+// probePrefix is where Storage writes its probe objects.
+const probePrefix = ".health/"
+
+// Storage writes, reads back and deletes a small object, to make sure the
+// storage accepts uploads.
+func Storage(blob storage.Blob) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		// 複数のサーバが同時に確認しても衝突しないキーにする
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		key := probePrefix + hex.EncodeToString(b)
+		content := []byte("ok")
+
+		if err := blob.Put(ctx, key, bytes.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+			return fmt.Errorf("writing: %w", err)
+		}
+		defer blob.Delete(context.WithoutCancel(ctx), key)
+
+		rc, err := blob.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("reading: %w", err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil {
+			return fmt.Errorf("reading: %w", err)
+		}
+		if !bytes.Equal(got, content) {
+			return errors.New("reading: the probe object was changed")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nilConnector struct{}
+
+func (nilConnector) Connection() *sql.DB { return nil }
+
+func TestDatabase_noConnection(t *testing.T) {
+	if err := Database(nilConnector{})(context.Background()); err == nil {
+		t.Error("expected an error without a connection")
+	}
+	if err := Schema(nilConnector{}, nil)(context.Background()); err == nil {
+		t.Error("expected an error without a connection")
+	}
+}
+
+func Test_missingColumns(t *testing.T) {
+	existing := map[string]bool{"users.id": true, "users.email": true, "user_images.id": true}
+
+	var tests = []struct {
+		name     string
+		required map[string][]string
+		expected string
+	}{
+		{"current", map[string][]string{"users": {"id", "email"}}, ""},
+		{"missing column", map[string][]string{"users": {"id", "nickname"}}, "schema is not up to date, missing users.nickname"},
+		{"missing table", map[string][]string{"users": {"id"}, "user_files": {"size", "id"}}, "schema is not up to date, missing user_files.id, user_files.size"},
+	}
+
+	for _, e := range tests {
+		err := missingColumns(existing, e.required)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+// failingBlob fails every write.
+type failingBlob struct {
+	storage.Blob
+}
+
+func (failingBlob) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	return errors.New("read-only file system")
+}
+
+func TestStorage(t *testing.T) {
+	root := t.TempDir()
+	blob, err := storage.NewLocal(root, "/static/img/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Storage(blob)(context.Background()); err != nil {
+		t.Errorf("expected a writable storage, got %s", err)
+	}
+	// 確認用のファイルは残さない
+	leftovers, _ := os.ReadDir(filepath.Join(root, probePrefix))
+	if len(leftovers) != 0 {
+		t.Errorf("expected the probe object to be deleted, found %d", len(leftovers))
+	}
+
+	err = Storage(failingBlob{blob})(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "writing") {
+		t.Errorf("expected a write error, got %v", err)
+	}
+}
+
+func TestNewServerChecker(t *testing.T) {
+	blob, err := storage.NewLocal(t.TempDir(), "/static/img/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewServerChecker(nilConnector{}, map[string][]string{"users": {"id"}}, blob)
+
+	var tests = []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedCode   int
+		expectedStatus string
+		expectedChecks map[string]string
+	}{
+		{"liveness", c.Liveness, http.StatusOK, StatusOK, nil},
+		// DB接続がなければ準備できていない
+		{"readiness", c.Readiness, http.StatusServiceUnavailable, StatusFail, map[string]string{"database": StatusFail, "schema": StatusFail, "storage": StatusOK}},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		e.handler(rr, httptest.NewRequest("GET", "/", nil))
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		var resp Response
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if resp.Status != e.expectedStatus || len(resp.Checks) != len(e.expectedChecks) {
+			t.Errorf("%s: unexpected response %s", e.name, rr.Body.String())
+		}
+		for name, status := range e.expectedChecks {
+			if resp.Checks[name].Status != status {
+				t.Errorf("%s: expected %s to be %s but got %+v", e.name, name, status, resp.Checks[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package health answers the liveness and readiness probes of orchestrators.
+//
+// Liveness only tells that the process serves requests. Readiness runs every
+// check, each with its own timeout, and answers 503 Service Unavailable with
+// the result of each check when one fails, or once the server is shutting
+// down so that no new traffic is sent to it.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout limits checks without a Timeout.
+const DefaultTimeout = 2 * time.Second
+
+// Check is a dependency the server needs to serve requests.
+type Check struct {
+	Name string
+	// Timeout limits Run; DefaultTimeout is used when it is zero.
+	Timeout time.Duration
+	// Run returns nil when the dependency is usable.
+	Run func(ctx context.Context) error
+}
+
+// Checker runs the checks for the probes.
+type Checker struct {
+	checks       []Check
+	shuttingDown atomic.Bool
+}
+
+// New returns a checker running checks for readiness.
+func New(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Shutdown makes readiness fail from now on.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Status values of responses and checks.
+const (
+	StatusOK           = "ok"
+	StatusFail         = "fail"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Response is the body of the probes.
+type Response struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Liveness answers 200 as long as the process handles requests.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	write(w, http.StatusOK, Response{Status: StatusOK})
+}
+
+// Readiness runs the checks concurrently and answers 200 when all of them
+// pass, 503 otherwise.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	if c.shuttingDown.Load() {
+		write(w, http.StatusServiceUnavailable, Response{Status: StatusShuttingDown})
+		return
+	}
+
+	results := c.Run(r.Context())
+	resp := Response{Status: StatusOK, Checks: results}
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Status != StatusOK {
+			resp.Status = StatusFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+	write(w, status, resp)
+}
+
+// Run runs every check and returns the results by name.
+func (c *Checker) Run(ctx context.Context) map[string]CheckResult {
+	results := make(map[string]CheckResult, len(c.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := run(ctx, check)
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+	return results
+}
+
+func run(ctx context.Context, check Check) CheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	// タイムアウトを守らないチェックがあっても、プローブには時間内に答える
+	done := make(chan error, 1)
+	go func() { done <- check.Run(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Status: StatusOK, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func write(w http.ResponseWriter, status int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	// プローブの結果はキャッシュさせない
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,101 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ok(ctx context.Context) error { return nil }
+
+func TestChecker_Liveness(t *testing.T) {
+	c := New(Check{Name: "database", Run: func(ctx context.Context) error { return errors.New("down") }})
+
+	rr := httptest.NewRecorder()
+	c.Liveness(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	// 依存先が落ちていてもプロセスは生きている
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"status":"ok"}`+"\n" {
+		t.Errorf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestChecker_Readiness(t *testing.T) {
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	// タイムアウトを無視するチェック
+	stuck := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	var tests = []struct {
+		name           string
+		checks         []Check
+		expectedCode   int
+		expectedStatus string
+		expectedChecks map[string]string
+	}{
+		{"no checks", nil, http.StatusOK, StatusOK, map[string]string{}},
+		{"all pass", []Check{{Name: "database", Run: ok}, {Name: "storage", Run: ok}}, http.StatusOK, StatusOK, map[string]string{"database": StatusOK, "storage": StatusOK}},
+		{"one fails", []Check{{Name: "database", Run: func(ctx context.Context) error { return errors.New("connection refused") }}, {Name: "storage", Run: ok}}, http.StatusServiceUnavailable, StatusFail, map[string]string{"database": StatusFail, "storage": StatusOK}},
+		{"timeout", []Check{{Name: "database", Timeout: 10 * time.Millisecond, Run: hang}}, http.StatusServiceUnavailable, StatusFail, map[string]string{"database": StatusFail}},
+		{"ignores timeout", []Check{{Name: "database", Timeout: 10 * time.Millisecond, Run: stuck}}, http.StatusServiceUnavailable, StatusFail, map[string]string{"database": StatusFail}},
+	}
+
+	for _, e := range tests {
+		start := time.Now()
+		rr := httptest.NewRecorder()
+		New(e.checks...).Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		if time.Since(start) > 500*time.Millisecond {
+			t.Errorf("%s: the probe took %s", e.name, time.Since(start))
+		}
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Content-Type") != "application/json" || rr.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: unexpected headers %v", e.name, rr.Header())
+		}
+
+		var resp Response
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if resp.Status != e.expectedStatus || len(resp.Checks) != len(e.expectedChecks) {
+			t.Errorf("%s: unexpected response %+v", e.name, resp)
+		}
+		for name, status := range e.expectedChecks {
+			result := resp.Checks[name]
+			if result.Status != status || result.Duration == "" {
+				t.Errorf("%s: unexpected result of %s: %+v", e.name, name, result)
+			}
+			if status == StatusFail && result.Error == "" {
+				t.Errorf("%s: expected the error of %s", e.name, name)
+			}
+		}
+	}
+}
+
+func TestChecker_Shutdown(t *testing.T) {
+	c := New(Check{Name: "database", Run: ok})
+	c.Shutdown()
+
+	rr := httptest.NewRecorder()
+	c.Readiness(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Body.String() != `{"status":"shutting_down"}`+"\n" {
+		t.Errorf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	c.Liveness(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the process to stay live during shutdown, got %d", rr.Code)
+	}
+}
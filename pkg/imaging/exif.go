@@ -0,0 +1,84 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// exifOrientationTag is the EXIF tag id holding the orientation (1-8) of the image.
+const exifOrientationTag = 0x0112
+
+// Orientation reads the EXIF orientation of a JPEG file. It returns 1 (the
+// normal orientation) when the data is not a JPEG, has no EXIF block, or the
+// block cannot be parsed.
+func Orientation(src []byte) int {
+	// JPEGはSOI(0xFFD8)で始まり、その後にセグメントが続く
+	if len(src) < 4 || src[0] != 0xFF || src[1] != 0xD8 {
+		return 1
+	}
+
+	pos := 2
+	for pos+4 <= len(src) {
+		if src[pos] != 0xFF {
+			return 1
+		}
+		marker := src[pos+1]
+		// SOS(画像データの開始)以降にはEXIFは存在しない
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(src[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(src) {
+			return 1
+		}
+		segment := src[pos+4 : pos+2+length]
+		// APP1セグメントにEXIFが格納されている
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+
+	return 1
+}
+
+// tiffOrientation looks up the orientation tag in the first IFD of a TIFF structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifOrientationTag {
+			continue
+		}
+		// SHORT型の値はエントリーの値フィールドの先頭2バイトに格納される
+		value := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if value < 1 || value > 8 {
+			return 1
+		}
+		return value
+	}
+
+	return 1
+}
@@ -0,0 +1,164 @@
+// Package imaging turns uploaded profile pictures into safe, square images of
+// a few fixed sizes. Decoding and re-encoding drops every metadata block (EXIF,
+// GPS, comments) of the original upload.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"sort"
+)
+
+// DefaultSizes are the edge lengths (in pixels) generated for each profile picture.
+var DefaultSizes = []int{64, 256, 512}
+
+// ErrUnsupportedFormat is returned when the upload is not a JPEG, PNG or GIF image.
+var ErrUnsupportedFormat = errors.New("imaging: unsupported image format, please upload a JPEG, PNG or GIF")
+
+// ErrTooManyPixels is returned when the image is wider, higher or has more
+// pixels than MaxDimension and MaxPixels allow.
+var ErrTooManyPixels = errors.New("imaging: the image is too large, please upload a smaller one")
+
+// Limits of the images Decode accepts. They are checked against the header of
+// the image before it is decoded, so that a small file declaring huge
+// dimensions cannot make the server allocate gigabytes.
+var (
+	MaxDimension = 10000    // width or height, in pixels
+	MaxPixels    = 40000000 // width times height
+)
+
+// Result holds the encoded images produced by Process.
+type Result struct {
+	// Format is the encoding of the output files, "jpeg" or "png".
+	Format string
+	// Original is the full image, upright and without metadata.
+	Original []byte
+	// Variants holds the square images keyed by their edge length.
+	Variants map[int][]byte
+}
+
+// Sizes returns the edge lengths of the generated variants in ascending order.
+func (r *Result) Sizes() []int {
+	sizes := make([]int, 0, len(r.Variants))
+	for size := range r.Variants {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// Decode decodes a JPEG, PNG or GIF image and rotates it upright using its
+// EXIF orientation. It returns the image and the name of its format.
+func Decode(src []byte) (image.Image, string, error) {
+	// 展開する前に、ヘッダーの大きさだけを読んで確かめる
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", ErrUnsupportedFormat
+		}
+		return nil, "", err
+	}
+	if err := checkDimensions(cfg.Width, cfg.Height); err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", ErrUnsupportedFormat
+		}
+		return nil, "", err
+	}
+
+	switch format {
+	case "jpeg", "png", "gif":
+	default:
+		return nil, "", ErrUnsupportedFormat
+	}
+
+	return AutoOrient(img, Orientation(src)), format, nil
+}
+
+// checkDimensions returns ErrTooManyPixels when a w x h image is over the
+// limits.
+func checkDimensions(w, h int) error {
+	if w > MaxDimension || h > MaxDimension || int64(w)*int64(h) > int64(MaxPixels) {
+		return fmt.Errorf("%w (%dx%d pixels, at most %dx%d and %d pixels)", ErrTooManyPixels, w, h, MaxDimension, MaxDimension, MaxPixels)
+	}
+	return nil
+}
+
+// Process decodes the uploaded image, auto-orients it, strips its metadata and
+// generates a center-cropped square for each of the given sizes. Images are
+// never enlarged: sizes bigger than the cropped square are replaced by the
+// square's own edge length.
+func Process(src []byte, sizes []int) (*Result, error) {
+	img, format, err := Decode(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// 透過情報を保持するため、PNGとGIFはPNGで保存する
+	result := Result{Format: "png", Variants: make(map[int][]byte)}
+	if format == "jpeg" {
+		result.Format = "jpeg"
+	}
+
+	result.Original, err = encode(img, result.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	square := CropSquare(img)
+	side := square.Bounds().Dx()
+
+	for _, size := range sizes {
+		if size <= 0 {
+			continue
+		}
+		if size > side {
+			size = side
+		}
+		if _, ok := result.Variants[size]; ok {
+			continue
+		}
+
+		out, err := encode(Resize(square, size, size), result.Format)
+		if err != nil {
+			return nil, err
+		}
+		result.Variants[size] = out
+	}
+
+	return &result, nil
+}
+
+// Extension returns the file extension, including the dot, for an output format.
+func Extension(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+func encode(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
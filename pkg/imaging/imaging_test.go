@@ -0,0 +1,266 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a w x h image whose left half is red and right half is blue.
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+// withEXIF inserts an APP1 EXIF segment holding only the orientation tag
+// (plus a fake GPS marker) right after the SOI marker of a JPEG.
+func withEXIF(t *testing.T, src []byte, orientation uint16) []byte {
+	t.Helper()
+
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(42))
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(8))
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(1))      // one entry
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(0x0112)) // orientation
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(3))      // SHORT
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(1))      // count
+	_ = binary.Write(&tiff, binary.BigEndian, orientation)    // value
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(0))      // padding
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(0))      // next IFD
+	tiff.WriteString("GPSLatitude=35.6812")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(src[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(src[2:])
+	return out.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOrientation(t *testing.T) {
+	plain := encodeJPEG(t, testImage(8, 4))
+
+	var tests = []struct {
+		name     string
+		src      []byte
+		expected int
+	}{
+		{"no exif", plain, 1},
+		{"rotated", withEXIF(t, plain, 6), 6},
+		{"mirrored", withEXIF(t, plain, 2), 2},
+		{"out of range", withEXIF(t, plain, 12), 1},
+		{"not a jpeg", []byte("hello, world"), 1},
+		{"truncated", plain[:3], 1},
+	}
+
+	for _, e := range tests {
+		if got := Orientation(e.src); got != e.expected {
+			t.Errorf("%s: expected orientation %d but got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestAutoOrient(t *testing.T) {
+	src := testImage(4, 2)
+
+	var tests = []struct {
+		name        string
+		orientation int
+		width       int
+		height      int
+		topLeft     color.NRGBA
+	}{
+		{"normal", 1, 4, 2, color.NRGBA{R: 255, A: 255}},
+		{"flip horizontal", 2, 4, 2, color.NRGBA{B: 255, A: 255}},
+		{"rotate 180", 3, 4, 2, color.NRGBA{B: 255, A: 255}},
+		{"rotate 90 cw", 6, 2, 4, color.NRGBA{R: 255, A: 255}},
+		{"rotate 90 ccw", 8, 2, 4, color.NRGBA{B: 255, A: 255}},
+	}
+
+	for _, e := range tests {
+		out := toNRGBA(AutoOrient(src, e.orientation))
+		if out.Bounds().Dx() != e.width || out.Bounds().Dy() != e.height {
+			t.Errorf("%s: expected %dx%d but got %dx%d", e.name, e.width, e.height, out.Bounds().Dx(), out.Bounds().Dy())
+		}
+		if c := out.NRGBAAt(0, 0); c != e.topLeft {
+			t.Errorf("%s: expected top left pixel %v but got %v", e.name, e.topLeft, c)
+		}
+	}
+}
+
+func TestCropSquare(t *testing.T) {
+	out := CropSquare(testImage(30, 10))
+	if out.Bounds().Dx() != 10 || out.Bounds().Dy() != 10 {
+		t.Fatalf("expected 10x10 but got %v", out.Bounds())
+	}
+
+	// 中央を切り抜いているので、左端は赤、右端は青
+	n := toNRGBA(out)
+	if c := n.NRGBAAt(0, 5); c.R != 255 {
+		t.Errorf("expected left edge to be red but got %v", c)
+	}
+	if c := n.NRGBAAt(9, 5); c.B != 255 {
+		t.Errorf("expected right edge to be blue but got %v", c)
+	}
+}
+
+func TestResize(t *testing.T) {
+	out := Resize(testImage(100, 100), 10, 10)
+	if out.Bounds().Dx() != 10 || out.Bounds().Dy() != 10 {
+		t.Fatalf("expected 10x10 but got %v", out.Bounds())
+	}
+	if c := out.NRGBAAt(0, 0); c != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("expected red but got %v", c)
+	}
+	if c := out.NRGBAAt(9, 9); c != (color.NRGBA{B: 255, A: 255}) {
+		t.Errorf("expected blue but got %v", c)
+	}
+
+	out = Resize(testImage(2, 2), 4, 4)
+	if out.Bounds().Dx() != 4 {
+		t.Errorf("expected enlarged image to be 4 pixels wide but got %d", out.Bounds().Dx())
+	}
+}
+
+func TestProcess(t *testing.T) {
+	jpegWithGPS := withEXIF(t, encodeJPEG(t, testImage(600, 300)), 6)
+
+	var pngBuf bytes.Buffer
+	_ = png.Encode(&pngBuf, testImage(100, 80))
+
+	var gifBuf bytes.Buffer
+	_ = gif.Encode(&gifBuf, testImage(40, 40), nil)
+
+	var tests = []struct {
+		name           string
+		src            []byte
+		expectedFormat string
+		expectedSizes  []int
+		errorExpected  bool
+	}{
+		{"jpeg with exif", jpegWithGPS, "jpeg", []int{64, 256, 300}, false},
+		{"small png", pngBuf.Bytes(), "png", []int{64, 80}, false},
+		{"gif", gifBuf.Bytes(), "png", []int{40}, false},
+		{"not an image", []byte("I am not an image"), "", nil, true},
+	}
+
+	for _, e := range tests {
+		result, err := Process(e.src, []int{64, 256, 512})
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: expected error but did not get one", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if result.Format != e.expectedFormat {
+			t.Errorf("%s: expected format %s but got %s", e.name, e.expectedFormat, result.Format)
+		}
+
+		sizes := result.Sizes()
+		if len(sizes) != len(e.expectedSizes) {
+			t.Errorf("%s: expected sizes %v but got %v", e.name, e.expectedSizes, sizes)
+			continue
+		}
+		for i, size := range sizes {
+			if size != e.expectedSizes[i] {
+				t.Errorf("%s: expected sizes %v but got %v", e.name, e.expectedSizes, sizes)
+			}
+
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(result.Variants[size]))
+			if err != nil {
+				t.Errorf("%s: cannot decode variant %d: %s", e.name, size, err)
+				continue
+			}
+			if cfg.Width != size || cfg.Height != size {
+				t.Errorf("%s: expected variant of %dx%d but got %dx%d", e.name, size, size, cfg.Width, cfg.Height)
+			}
+		}
+
+		if bytes.Contains(result.Original, []byte("GPSLatitude")) {
+			t.Errorf("%s: metadata was not stripped", e.name)
+		}
+	}
+
+	// EXIFの回転が適用され、縦長になっているはず
+	result, _ := Process(jpegWithGPS, nil)
+	cfg, _, _ := image.DecodeConfig(bytes.NewReader(result.Original))
+	if cfg.Width != 300 || cfg.Height != 600 {
+		t.Errorf("expected auto-oriented image of 300x600 but got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+// withDimensions returns a 1x1 PNG whose header declares a w x h image, as a
+// decompression bomb would.
+func withDimensions(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.Bytes()
+
+	// シグネチャ(8)、長さ(4)、"IHDR"(4)の後に幅と高さが続き、データの後にCRCがある
+	binary.BigEndian.PutUint32(src[16:], w)
+	binary.BigEndian.PutUint32(src[20:], h)
+	binary.BigEndian.PutUint32(src[29:], crc32.ChecksumIEEE(src[12:29]))
+	return src
+}
+
+func TestDecode_tooManyPixels(t *testing.T) {
+	var tests = []struct {
+		name          string
+		w, h          uint32
+		errorExpected bool
+	}{
+		{"bomb", 50000, 50000, true},
+		{"too wide", uint32(MaxDimension) + 1, 1, true},
+		{"too many pixels", 8000, 8000, true},
+		{"at the limit", uint32(MaxDimension), 1, false},
+	}
+
+	for _, e := range tests {
+		src := withDimensions(t, e.w, e.h)
+		if len(src) > 1024 {
+			t.Fatalf("%s: expected a small file but got %d bytes", e.name, len(src))
+		}
+
+		_, _, err := Decode(src)
+		if e.errorExpected != errors.Is(err, ErrTooManyPixels) {
+			t.Errorf("%s: expected ErrTooManyPixels %v but got %v", e.name, e.errorExpected, err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import "database/sql"
+
+// RegisterDBStats exports the connection pool statistics of db as db_*
+// metrics, read from db.Stats() at every scrape.
+func RegisterDBStats(r *Registry, db *sql.DB) {
+	gauges := []struct {
+		name, help string
+		value      func(s sql.DBStats) float64
+	}{
+		{"db_max_open_connections", "Maximum number of open connections to the database.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"db_open_connections", "Number of established connections, in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"db_in_use_connections", "Number of connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"db_idle_connections", "Number of idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	}
+	for _, g := range gauges {
+		value := g.value
+		r.GaugeFunc(g.name, g.help, func() float64 { return value(db.Stats()) })
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(s sql.DBStats) float64
+	}{
+		{"db_wait_count_total", "Number of connections waited for.", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"db_wait_duration_seconds_total", "Time spent waiting for a connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"db_max_idle_closed_total", "Number of connections closed because of SetMaxIdleConns.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"db_max_idle_time_closed_total", "Number of connections closed because of SetConnMaxIdleTime.", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"db_max_lifetime_closed_total", "Number of connections closed because of SetConnMaxLifetime.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+	for _, c := range counters {
+		value := c.value
+		r.CounterFunc(c.name, c.help, func() float64 { return value(db.Stats()) })
+	}
+}
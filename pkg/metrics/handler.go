@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ServeHTTP writes every metric in the text exposition format, sorted by name
+// and labels so that consecutive scrapes are easy to compare.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := r.families
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", ContentType)
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := families[name]
+		help, typ := f.header()
+		bw.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+		bw.WriteString("# TYPE " + name + " " + typ + "\n")
+
+		samples := f.samples()
+		// 同じラベルのbucket、sum、countはまとめて出力する
+		sort.SliceStable(samples, func(i, j int) bool {
+			return labelKey(samples[i].labels, "le") < labelKey(samples[j].labels, "le")
+		})
+		for _, s := range samples {
+			bw.WriteString(name + s.suffix)
+			if len(s.labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range s.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(l.name + `="` + escapeLabel(l.value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatFloat(s.value) + "\n")
+		}
+	}
+	bw.Flush()
+}
+
+// Handler returns the registry as a handler that asks for HTTP basic
+// authentication when user is not empty.
+func (r *Registry) Handler(user, password string) http.Handler {
+	if user == "" {
+		return r
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		u, p, ok := req.BasicAuth()
+		// 比較にかかる時間から認証情報を推測されないようにする
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		r.ServeHTTP(w, req)
+	})
+}
+
+// labelKey identifies the label values of a sample, ignoring the label skip.
+func labelKey(labels []labelPair, skip string) string {
+	var b strings.Builder
+	for _, l := range labels {
+		if l.name != skip {
+			b.WriteString(l.value)
+			b.WriteByte('\xff')
+		}
+	}
+	return b.String()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	var tests = []struct {
+		name         string
+		user         string
+		password     string
+		sendUser     string
+		sendPassword string
+		expectedCode int
+	}{
+		{"not protected", "", "", "", "", http.StatusOK},
+		{"no credentials", "prometheus", "secret", "", "", http.StatusUnauthorized},
+		{"wrong user", "prometheus", "secret", "admin", "secret", http.StatusUnauthorized},
+		{"wrong password", "prometheus", "secret", "prometheus", "wrong", http.StatusUnauthorized},
+		{"valid", "prometheus", "secret", "prometheus", "secret", http.StatusOK},
+	}
+
+	r := NewRegistry()
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if e.sendUser != "" {
+			req.SetBasicAuth(e.sendUser, e.sendPassword)
+		}
+		rr := httptest.NewRecorder()
+		r.Handler(e.user, e.password).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", e.name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// UnmatchedRoute labels requests no route matched, so that scanners probing
+// random paths cannot create a series per path.
+const UnmatchedRoute = "unmatched"
+
+// HTTP counts requests and measures their latency.
+type HTTP struct {
+	Requests *CounterVec
+	Duration *HistogramVec
+}
+
+// NewHTTP registers http_requests_total and http_request_duration_seconds.
+func NewHTTP(r *Registry) *HTTP {
+	return &HTTP{
+		Requests: r.Counter("http_requests_total", "Number of HTTP requests handled.", "method", "route", "status"),
+		Duration: r.Histogram("http_request_duration_seconds", "Latency of HTTP requests.", DefBuckets, "method", "route"),
+	}
+}
+
+// Middleware records every request under the chi route pattern it matched,
+// e.g. /users/{userID}, instead of its path.
+func (h *HTTP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// ルートパターンはルーティングが終わってから分かる
+		route := RoutePattern(r)
+
+		h.Requests.Inc(r.Method, route, strconv.Itoa(status))
+		h.Duration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// RoutePattern returns the pattern of the route that handled r, such as
+// /users/{userID}, or "unmatched". Unlike chi's RoutePattern it keeps trailing
+// slashes, so that "/" and "/users/" are reported as routed.
+func RoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return UnmatchedRoute
+	}
+	pattern := strings.Join(rctx.RoutePatterns, "")
+	// サブルーターのパターンは"/users/*"のように終わるので、つなぎ目を取り除く
+	for strings.Contains(pattern, "/*/") {
+		pattern = strings.ReplaceAll(pattern, "/*/", "/")
+	}
+	// "/users/"の下の"/"は"/users//"になる
+	if strings.HasSuffix(pattern, "//") {
+		pattern = pattern[:len(pattern)-1]
+	}
+	if pattern == "" {
+		return UnmatchedRoute
+	}
+	return pattern
+}
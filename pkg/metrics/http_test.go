@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHTTP_Middleware(t *testing.T) {
+	r := NewRegistry()
+	h := NewHTTP(r)
+
+	mux := chi.NewRouter()
+	mux.Use(h.Middleware)
+	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		mux.Get("/{userID}", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+	})
+
+	var tests = []struct {
+		target string
+		route  string
+		status string
+	}{
+		{"/", "/", "200"},
+		{"/users/", "/users/", "200"},
+		{"/users/1", "/users/{userID}", "404"},
+		{"/users/2", "/users/{userID}", "404"},
+		{"/random/path", UnmatchedRoute, "404"},
+	}
+
+	for _, e := range tests {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", e.target, nil))
+	}
+	for _, e := range tests {
+		if h.Requests.Value("GET", e.route, e.status) == 0 {
+			t.Errorf("%s: expected a request counted as %s %s", e.target, e.route, e.status)
+		}
+	}
+	// IDごとに系列を作らない
+	if got := h.Requests.Value("GET", "/users/{userID}", "404"); got != 2 {
+		t.Errorf("expected 2 requests for /users/{userID} but got %g", got)
+	}
+	if got := h.Duration.Count("GET", "/users/{userID}"); got != 2 {
+		t.Errorf("expected 2 latencies for /users/{userID} but got %d", got)
+	}
+}
@@ -0,0 +1,282 @@
+// Package metrics collects counters, gauges and histograms and serves them in
+// the Prometheus text exposition format, version 0.0.4. It covers what the
+// servers export, so they do not depend on the Prometheus client library.
+//
+// Metrics are registered once at start up on a Registry, which is an
+// http.Handler for /metrics. The methods of a nil *CounterVec or *HistogramVec
+// do nothing, so code that records metrics also works without a registry.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to request
+// latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, the first one start and each
+// following one factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Registry holds the metrics of a server.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// family is a metric with all of its label combinations.
+type family interface {
+	header() (help, typ string)
+	samples() []sample
+}
+
+// sample is a line of the exposition format.
+type sample struct {
+	suffix string // _bucket, _sum or _count for histograms
+	labels []labelPair
+	value  float64
+}
+
+type labelPair struct {
+	name, value string
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 名前の重複はプログラムの誤りなので、起動時に気づけるようにする
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.families[name] = f
+}
+
+// Counter registers a counter partitioned by the labels.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{help: help, labels: labels, values: make(map[string]*counterValue)}
+	r.register(name, c)
+	return c
+}
+
+// Histogram registers a histogram with the upper bounds buckets, partitioned
+// by the labels.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	r.register(name, h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{help: help, typ: "gauge", fn: fn})
+}
+
+// CounterFunc registers a counter whose value is read from fn at every scrape,
+// for counters kept by other packages such as sql.DBStats.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{help: help, typ: "counter", fn: fn})
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// Inc adds one to the counter of the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if c == nil {
+		return
+	}
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	checkLabels(c.labels, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: labelValues}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+// Value returns the counter of the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cv, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return cv.value
+	}
+	return 0
+}
+
+func (c *CounterVec) header() (string, string) {
+	return c.help, "counter"
+}
+
+func (c *CounterVec) samples() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var samples []sample
+	for _, cv := range c.values {
+		samples = append(samples, sample{labels: pairs(c.labels, cv.labels), value: cv.value})
+	}
+	return samples
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // バケットごとの件数(累積ではない)
+	count  uint64
+	sum    float64
+}
+
+// Observe records v in the histogram of the label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	checkLabels(h.labels, labelValues)
+
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Count returns the number of observations of the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) header() (string, string) {
+	return h.help, "histogram"
+}
+
+func (h *HistogramVec) samples() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var samples []sample
+	for _, hv := range h.values {
+		labels := pairs(h.labels, hv.labels)
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += hv.counts[i]
+			samples = append(samples, sample{suffix: "_bucket", labels: withLabel(labels, "le", formatFloat(upper)), value: float64(cumulative)})
+		}
+		samples = append(samples,
+			sample{suffix: "_bucket", labels: withLabel(labels, "le", "+Inf"), value: float64(hv.count)},
+			sample{suffix: "_sum", labels: labels, value: hv.sum},
+			sample{suffix: "_count", labels: labels, value: float64(hv.count)},
+		)
+	}
+	return samples
+}
+
+// funcMetric is a gauge or counter without labels read at every scrape.
+type funcMetric struct {
+	help string
+	typ  string
+	fn   func() float64
+}
+
+func (f *funcMetric) header() (string, string) {
+	return f.help, f.typ
+}
+
+func (f *funcMetric) samples() []sample {
+	return []sample{{value: f.fn()}}
+}
+
+func checkLabels(names, values []string) {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("metrics: expected %d label values but got %d", len(names), len(values)))
+	}
+}
+
+func pairs(names, values []string) []labelPair {
+	labels := make([]labelPair, len(names))
+	for i, name := range names {
+		labels[i] = labelPair{name, values[i]}
+	}
+	return labels
+}
+
+func withLabel(labels []labelPair, name, value string) []labelPair {
+	return append(append([]labelPair(nil), labels...), labelPair{name, value})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the exposition of r.
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Header().Get("Content-Type") != ContentType {
+		t.Errorf("unexpected content type %q", rr.Header().Get("Content-Type"))
+	}
+	return rr.Body.String()
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	logins := r.Counter("logins_total", "Number of logins.", "result")
+	sizes := r.Histogram("size_bytes", "Size of uploads.", []float64{10, 100}, "kind")
+	r.GaugeFunc("temperature", "Current temperature.", func() float64 { return 21.5 })
+
+	logins.Inc("success")
+	logins.Inc("success")
+	logins.Add(3, `fail"ure`)
+	sizes.Observe(5, "avatar")
+	sizes.Observe(50, "avatar")
+	sizes.Observe(500, "avatar")
+
+	expected := `# HELP logins_total Number of logins.
+# TYPE logins_total counter
+logins_total{result="fail\"ure"} 3
+logins_total{result="success"} 2
+# HELP size_bytes Size of uploads.
+# TYPE size_bytes histogram
+size_bytes_bucket{kind="avatar",le="10"} 1
+size_bytes_bucket{kind="avatar",le="100"} 2
+size_bytes_bucket{kind="avatar",le="+Inf"} 3
+size_bytes_sum{kind="avatar"} 555
+size_bytes_count{kind="avatar"} 3
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 21.5
+`
+	if got := scrape(t, r); got != expected {
+		t.Errorf("unexpected exposition\n%s\nexpected\n%s", got, expected)
+	}
+
+	if logins.Value("success") != 2 || sizes.Count("avatar") != 3 || sizes.Count("file") != 0 {
+		t.Error("unexpected values")
+	}
+}
+
+func TestRegistry_register(t *testing.T) {
+	var tests = []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"duplicate name", func(r *Registry) { r.Counter("a_total", "", "x"); r.Counter("a_total", "", "x") }},
+		{"missing label", func(r *Registry) { r.Counter("b_total", "", "x").Inc() }},
+		{"negative counter", func(r *Registry) { r.Counter("c_total", "").Add(-1) }},
+	}
+
+	for _, e := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", e.name)
+				}
+			}()
+			e.fn(NewRegistry())
+		}()
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	// メトリクスを設定していない場合も記録する側はそのまま動く
+	var c *CounterVec
+	var h *HistogramVec
+	c.Inc("x")
+	h.Observe(1, "x")
+	if c.Value("x") != 0 || h.Count("x") != 0 {
+		t.Error("expected nil metrics to be empty")
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	b := ExponentialBuckets(1, 4, 4)
+	if len(b) != 4 || b[0] != 1 || b[3] != 64 {
+		t.Errorf("unexpected buckets %v", b)
+	}
+}
+
+// noConnector opens a pool that never connects.
+type noConnector struct{}
+
+func (noConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("no database")
+}
+
+func (noConnector) Driver() driver.Driver { return nil }
+
+func TestRegisterDBStats(t *testing.T) {
+	db := sql.OpenDB(noConnector{})
+	defer db.Close()
+	db.SetMaxOpenConns(5)
+
+	r := NewRegistry()
+	RegisterDBStats(r, db)
+	out := scrape(t, r)
+
+	for _, line := range []string{
+		"db_max_open_connections 5",
+		"db_open_connections 0",
+		"# TYPE db_wait_count_total counter",
+		"db_wait_duration_seconds_total 0",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"go_test_prac/webApp/pkg/clientip"
+	"go_test_prac/webApp/pkg/requestlog"
+)
+
+// KeyFunc returns the key a request is counted under, or false when it does
+// not apply to the request.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// IP counts requests per client address, resolved through the trusted proxies
+// of resolver. IPv6 clients are counted per /64, the smallest network usually
+// given to a customer, as they can pick any address inside it.
+func IP(resolver *clientip.Resolver) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		addr, ok := clientip.FromContext(r.Context())
+		if !ok {
+			addr = resolver.ClientIP(r)
+		}
+		if !addr.IsValid() {
+			return "", false
+		}
+		if addr.Is6() {
+			p, _ := addr.Prefix(64)
+			return "ip:" + p.String(), true
+		}
+		return "ip:" + addr.String(), true
+	}
+}
+
+// User counts requests per authenticated user, as recorded with
+// requestlog.SetUserID by the authentication middleware. It must run after
+// that middleware.
+func User() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		id := requestlog.UserID(r.Context())
+		return "user:" + id, id != ""
+	}
+}
+
+// First uses the first of keys applying to the request, such as the user and
+// else the client address.
+func First(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, key := range keys {
+			if k, ok := key(r); ok {
+				return k, true
+			}
+		}
+		return "", false
+	}
+}
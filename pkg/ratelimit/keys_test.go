@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go_test_prac/webApp/pkg/clientip"
+	"go_test_prac/webApp/pkg/requestlog"
+)
+
+func TestIP(t *testing.T) {
+	resolver, _ := clientip.New(clientip.HeaderXForwardedFor, []string{"10.0.0.0/8"})
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+		expectOK   bool
+	}{
+		{"ipv4", "192.0.2.1:1234", "", "ip:192.0.2.1", true},
+		{"behind a trusted proxy", "10.0.0.1:80", "198.51.100.1", "ip:198.51.100.1", true},
+		{"ipv6 per /64", "[2001:db8:1:2:3:4:5:6]:1234", "", "ip:2001:db8:1:2::/64", true},
+		{"no address", "", "", "", false},
+	}
+
+	key := IP(resolver)
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+		got, ok := key(req)
+		if ok != e.expectOK || got != e.expected {
+			t.Errorf("%s: expected %q, %t but got %q, %t", e.name, e.expected, e.expectOK, got, ok)
+		}
+	}
+}
+
+func TestIP_fromContext(t *testing.T) {
+	resolver, _ := clientip.New(clientip.HeaderXForwardedFor, []string{"10.0.0.0/8"})
+
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 別のリゾルバーでもミドルウェアが解決したアドレスを使う
+		got, _ = IP(nil)(r)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:80"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "ip:198.51.100.1" {
+		t.Errorf("expected the address of the context but got %q", got)
+	}
+}
+
+func TestUser(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, ok := User()(req); ok {
+		t.Error("expected no key before authentication")
+	}
+	req = requestlog.SetUserID(req, "42")
+	if got, ok := User()(req); !ok || got != "user:42" {
+		t.Errorf("expected user:42 but got %q, %t", got, ok)
+	}
+}
+
+func TestFirst(t *testing.T) {
+	key := First(User(), IP(nil))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	if got, _ := key(req); got != "ip:192.0.2.1" {
+		t.Errorf("expected the address before authentication but got %q", got)
+	}
+	req = requestlog.SetUserID(req, "42")
+	if got, _ := key(req); got != "user:42" {
+		t.Errorf("expected the user after authentication but got %q", got)
+	}
+	if _, ok := First()(req); ok {
+		t.Error("expected no key without key functions")
+	}
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps the buckets in memory. Each instance of a server then limits
+// on its own.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]Bucket
+	now     func() time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]Bucket{}, now: time.Now}
+}
+
+func (m *Memory) Take(_ context.Context, key string, l Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, res := Take(m.buckets[key], m.now(), l)
+	m.buckets[key] = b
+	return res, nil
+}
+
+func (m *Memory) Sweep(_ context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for key, b := range m.buckets {
+		if b.Updated.Before(before) {
+			delete(m.buckets, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemory_Take(t *testing.T) {
+	m := NewMemory()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	l := Limit{Requests: 3, Period: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if res, _ := m.Take(ctx, "a", l); !res.Allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+	if res, _ := m.Take(ctx, "a", l); res.Allowed {
+		t.Error("expected the fourth request to be rejected")
+	}
+	if res, _ := m.Take(ctx, "b", l); !res.Allowed {
+		t.Error("expected another key to have its own bucket")
+	}
+
+	now = now.Add(20 * time.Second)
+	if res, _ := m.Take(ctx, "a", l); !res.Allowed {
+		t.Error("expected a token after a third of the period")
+	}
+}
+
+func TestMemory_Take_concurrent(t *testing.T) {
+	m := NewMemory()
+	l := Limit{Requests: 50, Period: time.Hour}
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, _ := m.Take(context.Background(), "a", l)
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 50 {
+		t.Errorf("expected 50 requests to be allowed but got %d", allowed)
+	}
+}
+
+func TestMemory_Sweep(t *testing.T) {
+	m := NewMemory()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	l := Limit{Requests: 1, Period: time.Minute}
+	ctx := context.Background()
+
+	m.Take(ctx, "old", l)
+	now = now.Add(2 * time.Minute)
+	m.Take(ctx, "new", l)
+
+	n, err := m.Sweep(ctx, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 bucket to be swept but got %d", n)
+	}
+	if _, ok := m.buckets["new"]; !ok {
+		t.Error("expected the recent bucket to be kept")
+	}
+	if res, _ := m.Take(ctx, "old", l); !res.Allowed {
+		t.Error("expected a swept bucket to start full")
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go_test_prac/webApp/pkg/requestlog"
+)
+
+// Policy limits the requests of a route group.
+type Policy struct {
+	// Name separates the buckets of the policies sharing a store.
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// Limiter applies policies with the buckets of Store. A nil *Limiter limits
+// nothing.
+type Limiter struct {
+	Store Store
+	// Denied writes the response to rejected requests, after the headers were
+	// set. It defaults to a plain 429 Too Many Requests.
+	Denied func(w http.ResponseWriter, r *http.Request, res Result)
+}
+
+// New returns a limiter keeping its buckets in store.
+func New(store Store) *Limiter {
+	return &Limiter{Store: store}
+}
+
+// Limit returns middleware taking a token of p for every request. Requests for
+// which p has no key pass. When the store fails, requests pass as well and
+// the error is logged, so that an outage of the database does not take the
+// servers down with it.
+func (l *Limiter) Limit(p Policy) func(http.Handler) http.Handler {
+	if l == nil || !p.Limit.Enabled() {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := p.Key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := l.Store.Take(r.Context(), p.Name+":"+key, p.Limit)
+			if err != nil {
+				requestlog.FromContext(r.Context()).Error("rate limit", "policy", p.Name, "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			SetHeaders(w.Header(), res)
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				if l.Denied != nil {
+					l.Denied(w, r, res)
+					return
+				}
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetHeaders sets the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers describing res.
+func SetHeaders(h http.Header, res Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit.Requests))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", strconv.Itoa(res.Limit.Requests)+";w="+strconv.Itoa(ceilSeconds(res.Limit.Period)))
+}
+
+// ceilSeconds rounds d up to whole seconds, as the headers carry no
+// fractions.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
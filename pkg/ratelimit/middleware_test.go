@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failingStore fails every call, like an unreachable database.
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("connection refused")
+}
+
+func (failingStore) Sweep(context.Context, time.Time) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestLimiter_Limit(t *testing.T) {
+	l := New(NewMemory())
+	handler := l.Limit(Policy{Name: "login", Limit: Limit{Requests: 2, Period: time.Minute}, Key: IP(nil)})(okHandler)
+
+	var tests = []struct {
+		name             string
+		remoteAddr       string
+		expectedStatus   int
+		expectRemaining  string
+		expectRetryAfter bool
+	}{
+		{"first", "192.0.2.1:1234", http.StatusOK, "1", false},
+		{"second", "192.0.2.1:1234", http.StatusOK, "0", false},
+		{"limited", "192.0.2.1:1234", http.StatusTooManyRequests, "0", true},
+		{"other client", "192.0.2.2:1234", http.StatusOK, "1", false},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = e.remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if got := rr.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("%s: expected RateLimit-Limit 2 but got %q", e.name, got)
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != e.expectRemaining {
+			t.Errorf("%s: expected RateLimit-Remaining %s but got %q", e.name, e.expectRemaining, got)
+		}
+		if got := rr.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+			t.Errorf("%s: expected RateLimit-Policy 2;w=60 but got %q", e.name, got)
+		}
+		if rr.Header().Get("RateLimit-Reset") == "" {
+			t.Errorf("%s: expected RateLimit-Reset", e.name)
+		}
+		if got := rr.Header().Get("Retry-After"); (got != "") != e.expectRetryAfter {
+			t.Errorf("%s: expected Retry-After %t but got %q", e.name, e.expectRetryAfter, got)
+		}
+	}
+}
+
+func TestLimiter_Limit_retryAfter(t *testing.T) {
+	m := NewMemory()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	handler := New(m).Limit(Policy{Name: "auth", Limit: Limit{Requests: 1, Period: time.Minute}, Key: IP(nil)})(okHandler)
+
+	req := httptest.NewRequest("POST", "/auth", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	now = now.Add(30 * time.Second)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("expected Retry-After 30 but got %q", got)
+	}
+}
+
+func TestLimiter_Limit_policiesAreSeparate(t *testing.T) {
+	l := New(NewMemory())
+	limit := Limit{Requests: 1, Period: time.Minute}
+	login := l.Limit(Policy{Name: "login", Limit: limit, Key: IP(nil)})(okHandler)
+	users := l.Limit(Policy{Name: "users", Limit: limit, Key: IP(nil)})(okHandler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	login.ServeHTTP(httptest.NewRecorder(), req)
+	rr := httptest.NewRecorder()
+	users.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected another policy to have its own bucket but got %d", rr.Code)
+	}
+}
+
+func TestLimiter_Limit_denied(t *testing.T) {
+	l := New(NewMemory())
+	l.Denied = func(w http.ResponseWriter, r *http.Request, res Result) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":true}`))
+	}
+	handler := l.Limit(Policy{Name: "auth", Limit: Limit{Requests: 1, Period: time.Minute}, Key: IP(nil)})(okHandler)
+
+	req := httptest.NewRequest("POST", "/auth", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests || rr.Body.String() != `{"error":true}` {
+		t.Errorf("expected the Denied response but got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After to be set before Denied")
+	}
+}
+
+func TestLimiter_Limit_passes(t *testing.T) {
+	limit := Limit{Requests: 1, Period: time.Minute}
+	noKey := func(r *http.Request) (string, bool) { return "", false }
+
+	var tests = []struct {
+		name    string
+		limiter *Limiter
+		policy  Policy
+	}{
+		{"nil limiter", nil, Policy{Name: "auth", Limit: limit, Key: IP(nil)}},
+		{"disabled policy", New(NewMemory()), Policy{Name: "auth", Key: IP(nil)}},
+		{"no key", New(NewMemory()), Policy{Name: "auth", Limit: limit, Key: noKey}},
+		{"failing store", New(failingStore{}), Policy{Name: "auth", Limit: limit, Key: IP(nil)}},
+	}
+
+	for _, e := range tests {
+		handler := e.limiter.Limit(e.policy)(okHandler)
+		for i := 0; i < 3; i++ {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			if rr.Code != http.StatusOK {
+				t.Errorf("%s: expected request %d to pass but got %d", e.name, i+1, rr.Code)
+			}
+		}
+	}
+}
+
+func TestSweepEvery(t *testing.T) {
+	m := NewMemory()
+	m.Take(context.Background(), "a", Limit{Requests: 1, Period: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		SweepEvery(ctx, m, time.Millisecond, -time.Hour)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		n := len(m.buckets)
+		m.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the bucket to be swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// postgresTimeout bounds each query of Postgres.
+const postgresTimeout = 3 * time.Second
+
+// Postgres keeps the buckets in the rate_limits table of sql/users.sql, so
+// that every instance of a server counts against the same limits.
+type Postgres struct {
+	DB *sql.DB
+}
+
+// NewStore returns the store of backend, as set by -rate-limit-backend:
+// Postgres on db for "postgres", else Memory.
+func NewStore(backend string, db *sql.DB) Store {
+	if backend == "postgres" {
+		return &Postgres{DB: db}
+	}
+	return NewMemory()
+}
+
+// Take takes a token from the bucket of key. The row is locked for the
+// transaction and the time of the database is used, so that instances with
+// skewed clocks agree.
+func (s *Postgres) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, postgresTimeout)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	// 新しいキーは満杯のバケツとして作る。同時に作られても片方は何もしない
+	_, err = tx.ExecContext(ctx, `insert into rate_limits (key, tokens, updated_at) values ($1, $2, now())
+	on conflict (key) do nothing`, key, float64(l.Requests))
+	if err != nil {
+		return Result{}, err
+	}
+
+	var b Bucket
+	var now time.Time
+	err = tx.QueryRowContext(ctx, `select tokens, updated_at, now() from rate_limits where key = $1 for update`, key).
+		Scan(&b.Tokens, &b.Updated, &now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b, res := Take(b, now, l)
+	_, err = tx.ExecContext(ctx, `update rate_limits set tokens = $2, updated_at = $3 where key = $1`, key, b.Tokens, b.Updated)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Sweep deletes the buckets not used since before.
+func (s *Postgres) Sweep(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, postgresTimeout)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `delete from rate_limits where updated_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,12 @@
+package ratelimit
+
+import "testing"
+
+func TestNewStore(t *testing.T) {
+	if _, ok := NewStore("postgres", nil).(*Postgres); !ok {
+		t.Error("expected the Postgres store for postgres")
+	}
+	if _, ok := NewStore("memory", nil).(*Memory); !ok {
+		t.Error("expected the Memory store for memory")
+	}
+}
@@ -0,0 +1,156 @@
+// Package ratelimit limits request rates with token buckets.
+//
+// Every key, such as a client address or a user, has a bucket holding up to
+// Limit.Requests tokens that refills at Requests per Period. A request takes
+// one token and is rejected when the bucket is empty, so a client may send a
+// burst of Requests at once and then Requests per Period on average.
+//
+// Buckets live in a Store: Memory for a single instance, or Postgres when
+// several instances serve the same clients. Limiter.Limit returns the
+// middleware applying a Policy to a route group and sends the RateLimit-*
+// headers of the IETF draft "RateLimit header fields for HTTP" and
+// Retry-After.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Period. The zero Limit allows everything.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses limits written as requests/period, such as 10/1m or
+// 300/1h. "off" and "" return the zero Limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: invalid limit %q, use requests/period like 10/1m", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid number of requests in %q", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid period in %q", s)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// Enabled reports whether l limits anything.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// String returns l as accepted by ParseLimit.
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return strconv.Itoa(l.Requests) + "/" + l.Period.String()
+}
+
+// MarshalText lets limits be written to configuration files.
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText lets limits be read from configuration files and flags.
+func (l *Limit) UnmarshalText(b []byte) error {
+	parsed, err := ParseLimit(string(b))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// rate returns the tokens added per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	Limit   Limit
+	// Remaining is the number of requests allowed right now.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, when this
+	// one was not.
+	RetryAfter time.Duration
+}
+
+// Bucket is the state of a key kept by a Store.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Take takes a token from b at now and returns the new state of the bucket.
+// A zero Bucket is full. Stores call Take while holding the bucket.
+func Take(b Bucket, now time.Time, l Limit) (Bucket, Result) {
+	capacity := float64(l.Requests)
+	tokens := capacity
+	if !b.Updated.IsZero() {
+		// 時計が戻っても残りが増えないようにする
+		elapsed := math.Max(0, now.Sub(b.Updated).Seconds())
+		tokens = math.Min(capacity, b.Tokens+elapsed*l.rate())
+	}
+
+	res := Result{Limit: l}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / l.rate())
+	}
+	res.Remaining = int(tokens)
+	res.Reset = seconds((capacity - tokens) / l.rate())
+	return Bucket{Tokens: tokens, Updated: now}, res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps the buckets.
+type Store interface {
+	// Take takes a token from the bucket of key.
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+	// Sweep removes the buckets not used since before, which are full again
+	// if before is at least the longest period ago.
+	Sweep(ctx context.Context, before time.Time) (int64, error)
+}
+
+// SweepEvery sweeps the buckets of s idle for longer than idle every
+// interval until ctx is done.
+func SweepEvery(ctx context.Context, s Store, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Sweep(ctx, time.Now().Add(-idle)); err != nil && ctx.Err() == nil {
+				slog.Error("sweeping rate limits", "err", err)
+			}
+		}
+	}
+}
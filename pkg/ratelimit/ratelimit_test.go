@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	var tests = []struct {
+		in          string
+		expected    Limit
+		expectError bool
+	}{
+		{"10/1m", Limit{10, time.Minute}, false},
+		{" 300/1h ", Limit{300, time.Hour}, false},
+		{"off", Limit{}, false},
+		{"", Limit{}, false},
+		{"10", Limit{}, true},
+		{"0/1m", Limit{}, true},
+		{"-1/1m", Limit{}, true},
+		{"ten/1m", Limit{}, true},
+		{"10/minute", Limit{}, true},
+		{"10/0s", Limit{}, true},
+	}
+
+	for _, e := range tests {
+		got, err := ParseLimit(e.in)
+		if e.expectError {
+			if err == nil {
+				t.Errorf("%q: expected an error", e.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: expected no error but got %v", e.in, err)
+		}
+		if got != e.expected {
+			t.Errorf("%q: expected %+v but got %+v", e.in, e.expected, got)
+		}
+	}
+}
+
+func TestLimit_text(t *testing.T) {
+	var l Limit
+	if err := l.UnmarshalText([]byte("5/30s")); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := l.MarshalText()
+	if string(b) != "5/30s" {
+		t.Errorf("expected 5/30s but got %s", b)
+	}
+	if (Limit{}).String() != "off" {
+		t.Errorf("expected the zero limit to be off but got %s", Limit{})
+	}
+	if err := l.UnmarshalText([]byte("5")); err == nil {
+		t.Error("expected an error for a limit without period")
+	}
+}
+
+func TestTake(t *testing.T) {
+	l := Limit{Requests: 2, Period: 10 * time.Second}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name              string
+		after             time.Duration
+		expectAllowed     bool
+		expectRemaining   int
+		expectRetryAfter  time.Duration
+		expectResetAtMost time.Duration
+	}{
+		{"first request of a full bucket", 0, true, 1, 0, 5 * time.Second},
+		{"second request of the burst", 0, true, 0, 0, 10 * time.Second},
+		{"empty bucket", time.Second, false, 0, 4 * time.Second, 9 * time.Second},
+		{"refilled one token", 5 * time.Second, true, 0, 0, 10 * time.Second},
+		{"refill is capped at the burst", time.Hour, true, 1, 0, 5 * time.Second},
+	}
+
+	var b Bucket
+	now := start
+	for _, e := range tests {
+		now = now.Add(e.after)
+		var res Result
+		b, res = Take(b, now, l)
+		if res.Allowed != e.expectAllowed {
+			t.Errorf("%s: expected allowed %t but got %t", e.name, e.expectAllowed, res.Allowed)
+		}
+		if res.Remaining != e.expectRemaining {
+			t.Errorf("%s: expected %d remaining but got %d", e.name, e.expectRemaining, res.Remaining)
+		}
+		if res.RetryAfter != e.expectRetryAfter {
+			t.Errorf("%s: expected retry after %s but got %s", e.name, e.expectRetryAfter, res.RetryAfter)
+		}
+		if res.Reset > e.expectResetAtMost {
+			t.Errorf("%s: expected reset within %s but got %s", e.name, e.expectResetAtMost, res.Reset)
+		}
+		if res.Limit != l {
+			t.Errorf("%s: expected the limit in the result", e.name)
+		}
+	}
+}
+
+func TestTake_clockGoesBack(t *testing.T) {
+	l := Limit{Requests: 1, Period: time.Minute}
+	now := time.Now()
+	b, _ := Take(Bucket{}, now, l)
+
+	if _, res := Take(b, now.Add(-time.Hour), l); res.Allowed {
+		t.Error("expected no tokens to be added when the clock goes back")
+	}
+}
@@ -0,0 +1,134 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"go_test_prac/webApp/pkg/data"
+	"strings"
+	"time"
+)
+
+// apiKeyColumns are the columns scanned by scanAPIKey.
+const apiKeyColumns = `id, user_id, name, prefix, hash, scopes, expires_at, last_used_at, last_used_ip, revoked_at, created_at`
+
+// InsertAPIKey stores a new API key and returns its ID.
+func (m *PostgresDBRepo) InsertAPIKey(k data.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var id int
+	stmt := `insert into api_keys (user_id, name, prefix, hash, scopes, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		k.Hash,
+		strings.Join(k.Scopes, ","),
+		k.ExpiresAt,
+		k.CreatedAt,
+	).Scan(&id)
+	return id, err
+}
+
+// GetAPIKeyByPrefix returns the API key with the prefix, revoked and expired
+// ones included, or sql.ErrNoRows.
+func (m *PostgresDBRepo) GetAPIKeyByPrefix(prefix string) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where prefix = $1`
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, prefix))
+}
+
+// UserAPIKeys returns every API key of a user, newest first.
+func (m *PostgresDBRepo) UserAPIKeys(userID int) ([]*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where user_id = $1 order by created_at desc, id desc`
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*data.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			m.logger().Error("scanning a row", "err", err)
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes the API key id of a user at at. A key revoked earlier
+// keeps its time of revocation. sql.ErrNoRows is returned when the user has
+// no such key.
+func (m *PostgresDBRepo) RevokeAPIKey(userID, id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = coalesce(revoked_at, $3) where id = $1 and user_id = $2`
+	res, err := m.DB.ExecContext(ctx, stmt, id, userID, at)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIKey records that the API key id was used at at from ip.
+func (m *PostgresDBRepo) TouchAPIKey(id int, at time.Time, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $2, last_used_ip = $3 where id = $1`
+	_, err := m.DB.ExecContext(ctx, stmt, id, at, ip)
+	return err
+}
+
+// scanAPIKey reads the columns of apiKeyColumns.
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (*data.APIKey, error) {
+	var (
+		k                   data.APIKey
+		scopes              string
+		lastUsed, revokedAt sql.NullTime
+	)
+	err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.Hash,
+		&scopes,
+		&k.ExpiresAt,
+		&lastUsed,
+		&k.LastUsedIP,
+		&revokedAt,
+		&k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
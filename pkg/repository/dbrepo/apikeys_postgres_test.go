@@ -0,0 +1,77 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"go_test_prac/webApp/pkg/apikey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostgresDBRepoAPIKeys(t *testing.T) {
+	now := time.Now().Truncate(time.Microsecond)
+	key, k, err := apikey.New(1, apikey.Request{Name: "deploy", Scopes: []string{"read", "write"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.ID, err = testRepo.InsertAPIKey(k)
+	if err != nil {
+		t.Fatalf("error inserting API key: %s", err)
+	}
+
+	got, err := testRepo.GetAPIKeyByPrefix(k.Prefix)
+	if err != nil {
+		t.Fatalf("error getting API key: %s", err)
+	}
+	if err := apikey.Check(got, key, now); err != nil {
+		t.Errorf("expected the stored key to match but got %s", err)
+	}
+	if got.ID != k.ID || got.Name != k.Name || strings.Join(got.Scopes, ",") != "read,write" || !got.ExpiresAt.Equal(k.ExpiresAt) || got.LastUsedAt != nil || got.RevokedAt != nil {
+		t.Errorf("GetAPIKeyByPrefix returned %+v, want %+v", got, k)
+	}
+
+	if _, err := testRepo.GetAPIKeyByPrefix("wak_ffffffffffff"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown prefix but got %v", err)
+	}
+
+	// 同じプレフィックスは登録できない
+	if _, err := testRepo.InsertAPIKey(k); err == nil {
+		t.Error("expected a duplicate prefix to be rejected")
+	}
+
+	if err := testRepo.TouchAPIKey(k.ID, now, "192.0.2.1"); err != nil {
+		t.Fatalf("error touching API key: %s", err)
+	}
+
+	_, newer, _ := apikey.New(1, apikey.Request{Name: "backup", Scopes: []string{"read"}}, now.Add(time.Second))
+	if _, err := testRepo.InsertAPIKey(newer); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := testRepo.UserAPIKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Name != "backup" || keys[1].LastUsedAt == nil || !keys[1].LastUsedAt.Equal(now) || keys[1].LastUsedIP != "192.0.2.1" {
+		t.Errorf("expected the keys newest first but got %+v", keys)
+	}
+
+	// 2回目の失効では最初の時刻が残る
+	first := now.Add(time.Minute)
+	for _, at := range []time.Time{first, first.Add(time.Minute)} {
+		if err := testRepo.RevokeAPIKey(1, k.ID, at); err != nil {
+			t.Fatalf("error revoking API key: %s", err)
+		}
+	}
+	got, _ = testRepo.GetAPIKeyByPrefix(k.Prefix)
+	if got.RevokedAt == nil || !got.RevokedAt.Equal(first) {
+		t.Errorf("expected the key revoked at %s but got %v", first, got.RevokedAt)
+	}
+
+	// 他のユーザのキーは失効できない
+	if err := testRepo.RevokeAPIKey(2, k.ID, now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows revoking the key of another user but got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"go_test_prac/webApp/pkg/apikey"
+	"go_test_prac/webApp/pkg/data"
+	"time"
+)
+
+// API keys of admin@example.com that TestDBRepo knows.
+const (
+	TestAPIKeyRead    = "wak_000000000001_read-only-test-key"
+	TestAPIKeyFull    = "wak_000000000002_read-write-admin-test-key"
+	TestAPIKeyExpired = "wak_000000000003_expired-test-key"
+	TestAPIKeyRevoked = "wak_000000000004_revoked-test-key"
+)
+
+// testAPIKeys returns the stored keys of TestAPIKeyRead, TestAPIKeyFull,
+// TestAPIKeyExpired and TestAPIKeyRevoked, in this order.
+func testAPIKeys() []*data.APIKey {
+	created := time.Date(2022, 8, 19, 9, 0, 0, 0, time.UTC)
+	revokedAt := created.Add(time.Hour)
+	// 期限内のキーは、テストがいつ動いても有効なようにする
+	future := time.Now().AddDate(0, 0, apikey.DefaultExpiryDays)
+
+	keys := []*data.APIKey{
+		{ID: 1, Name: "read only", Scopes: []string{apikey.ScopeRead}, ExpiresAt: future},
+		{ID: 2, Name: "everything", Scopes: apikey.Scopes, ExpiresAt: future},
+		{ID: 3, Name: "expired", Scopes: apikey.Scopes, ExpiresAt: created.AddDate(0, 0, 1)},
+		{ID: 4, Name: "revoked", Scopes: apikey.Scopes, ExpiresAt: future, RevokedAt: &revokedAt},
+	}
+	for i, key := range []string{TestAPIKeyRead, TestAPIKeyFull, TestAPIKeyExpired, TestAPIKeyRevoked} {
+		keys[i].UserID = 1
+		keys[i].Prefix, _ = apikey.Prefix(key)
+		keys[i].Hash = apikey.Hash(key)
+		keys[i].CreatedAt = created
+	}
+	return keys
+}
+
+// InsertAPIKey returns the ID after the test keys. Nothing is kept.
+func (m *TestDBRepo) InsertAPIKey(k data.APIKey) (int, error) {
+	return len(testAPIKeys()) + 1, nil
+}
+
+// GetAPIKeyByPrefix returns one of the test keys.
+func (m *TestDBRepo) GetAPIKeyByPrefix(prefix string) (*data.APIKey, error) {
+	for _, k := range testAPIKeys() {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UserAPIKeys returns the test keys for admin@example.com.
+func (m *TestDBRepo) UserAPIKeys(userID int) ([]*data.APIKey, error) {
+	if userID != 1 {
+		return nil, nil
+	}
+	keys := testAPIKeys()
+	// 新しい順に返す
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey succeeds for the test keys of admin@example.com.
+func (m *TestDBRepo) RevokeAPIKey(userID, id int, at time.Time) error {
+	if userID != 1 || id < 1 || id > len(testAPIKeys()) {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIKey records nothing.
+func (m *TestDBRepo) TouchAPIKey(id int, at time.Time, ip string) error {
+	return nil
+}
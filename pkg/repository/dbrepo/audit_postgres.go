@@ -0,0 +1,157 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"strconv"
+	"strings"
+)
+
+// InsertAuditEvent seals e with the hash of the newest event and appends it.
+// The table is locked against other inserts until the transaction ends, so
+// that two events never follow the same one.
+func (m *PostgresDBRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// EXCLUSIVEは読み取りを妨げず、他の書き込みだけを待たせる
+	if _, err := tx.ExecContext(ctx, `lock table audit_events in exclusive mode`); err != nil {
+		return nil, err
+	}
+
+	var prevHash string
+	err = tx.QueryRowContext(ctx, `select hash from audit_events order by id desc limit 1`).Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	e.Seal(prevHash)
+
+	// 空の差分はNULLとして保存する。json型は受け取った文字列をそのまま保つので、ハッシュを計算し直せる
+	var changes interface{}
+	if len(e.Changes) > 0 {
+		changes = string(e.Changes)
+	}
+
+	stmt := `insert into audit_events (occurred_at, actor_id, actor, action, target_type, target_id,
+			ip, user_agent, request_id, changes, prev_hash, hash)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		e.OccurredAt,
+		e.ActorID,
+		e.Actor,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		changes,
+		e.PrevHash,
+		e.Hash,
+	).Scan(&e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, tx.Commit()
+}
+
+// AuditEvents returns the events matching f, newest first unless f.Ascending.
+func (m *PostgresDBRepo) AuditEvents(f data.AuditFilter) ([]*data.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if f.ActorID != 0 {
+		add("actor_id = ?", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = ?", f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		add("occurred_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("occurred_at < ?", f.Until)
+	}
+	order := "desc"
+	if f.Ascending {
+		order = "asc"
+	}
+	if f.Cursor != 0 {
+		if f.Ascending {
+			add("id > ?", f.Cursor)
+		} else {
+			add("id < ?", f.Cursor)
+		}
+	}
+
+	query := `select id, occurred_at, actor_id, actor, action, target_type, target_id,
+			ip, user_agent, request_id, changes, prev_hash, hash
+		from audit_events`
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by id " + order
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += " limit $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*data.AuditEvent
+	for rows.Next() {
+		var e data.AuditEvent
+		var changes []byte
+		err := rows.Scan(
+			&e.ID,
+			&e.OccurredAt,
+			&e.ActorID,
+			&e.Actor,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetID,
+			&e.IP,
+			&e.UserAgent,
+			&e.RequestID,
+			&changes,
+			&e.PrevHash,
+			&e.Hash,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			e.Changes = changes
+		}
+		events = append(events, &e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,106 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"go_test_prac/webApp/pkg/audit"
+	"go_test_prac/webApp/pkg/data"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPostgresDBRepoAuditEvents(t *testing.T) {
+	changes := audit.Diff(audit.UserFields(&data.User{FirstName: "Jane", Email: "jane@example.com"}), audit.UserFields(&data.User{FirstName: "Janet", Email: "jane@example.com"}))
+	first, err := testRepo.InsertAuditEvent(data.AuditEvent{
+		ActorID:    1,
+		Actor:      "admin@example.com",
+		Action:     audit.ActionUserUpdate,
+		TargetType: audit.TargetUser,
+		TargetID:   "2",
+		IP:         "192.0.2.1",
+		UserAgent:  "test",
+		RequestID:  "req-1",
+		Changes:    changes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || first.PrevHash != "" || first.Hash == "" {
+		t.Fatalf("expected the first event of the chain but got %+v", first)
+	}
+
+	// 同時に書き込んでも連鎖は分岐しない
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := data.AuditEvent{Action: audit.ActionLoginFailed, Actor: "user" + strconv.Itoa(i) + "@example.com", TargetType: audit.TargetUser}
+			if _, err := testRepo.InsertAuditEvent(e); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	v, err := audit.Verify(testRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Events != 11 {
+		t.Errorf("expected 11 events but got %d", v.Events)
+	}
+
+	// 読み直した差分は書き込んだものと同じ
+	events, err := testRepo.AuditEvents(data.AuditFilter{Action: audit.ActionUserUpdate, TargetType: audit.TargetUser, TargetID: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || string(events[0].Changes) != string(changes) || events[0].Hash != first.Hash || !events[0].OccurredAt.Equal(first.OccurredAt) {
+		t.Errorf("expected the first event back but got %+v", events)
+	}
+
+	tests := []struct {
+		name string
+		f    data.AuditFilter
+		want int
+	}{
+		{"all", data.AuditFilter{}, 11},
+		{"by actor", data.AuditFilter{ActorID: 1}, 1},
+		{"by action", data.AuditFilter{Action: audit.ActionLoginFailed}, 10},
+		{"limited", data.AuditFilter{Limit: 4}, 4},
+		{"after the cursor", data.AuditFilter{Cursor: first.ID, Ascending: true}, 10},
+		{"before the cursor", data.AuditFilter{Cursor: first.ID}, 0},
+		{"since", data.AuditFilter{Since: time.Now().Add(time.Hour)}, 0},
+		{"until", data.AuditFilter{Until: time.Now().Add(time.Hour)}, 11},
+	}
+	for _, e := range tests {
+		events, err := testRepo.AuditEvents(e.f)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if len(events) != e.want {
+			t.Errorf("%s: expected %d events but got %d", e.name, e.want, len(events))
+		}
+	}
+
+	newest, _ := testRepo.AuditEvents(data.AuditFilter{Limit: 1})
+	if len(newest) != 1 || newest[0].Hash != v.Head {
+		t.Errorf("expected the newest event first but got %+v", newest)
+	}
+
+	// 記録は変更も削除もできない
+	for _, stmt := range []string{
+		`update audit_events set actor = 'someone else' where id = $1`,
+		`delete from audit_events where id = $1`,
+	} {
+		if _, err := testDB.Exec(stmt, first.ID); err == nil {
+			t.Errorf("expected %q to be rejected", stmt)
+		}
+	}
+	if _, err := testDB.Exec(`truncate audit_events`); err == nil {
+		t.Error("expected truncate to be rejected")
+	}
+}
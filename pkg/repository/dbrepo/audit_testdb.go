@@ -0,0 +1,79 @@
+package dbrepo
+
+import (
+	"go_test_prac/webApp/pkg/data"
+	"sort"
+	"time"
+)
+
+// testAuditEvents returns a chain of two events: admin@example.com logging in
+// and then renaming themselves.
+func testAuditEvents() []*data.AuditEvent {
+	login := &data.AuditEvent{
+		ID:         1,
+		OccurredAt: time.Date(2022, 8, 19, 9, 0, 0, 0, time.UTC),
+		ActorID:    1,
+		Actor:      "admin@example.com",
+		Action:     "auth.login",
+		TargetType: "user",
+		TargetID:   "1",
+		IP:         "127.0.0.1",
+		UserAgent:  "Go-http-client/1.1",
+		RequestID:  "test-request-1",
+	}
+	login.Seal("")
+
+	update := &data.AuditEvent{
+		ID:         2,
+		OccurredAt: time.Date(2022, 8, 19, 9, 5, 0, 0, time.UTC),
+		ActorID:    1,
+		Actor:      "admin@example.com",
+		Action:     "user.update",
+		TargetType: "user",
+		TargetID:   "1",
+		IP:         "127.0.0.1",
+		UserAgent:  "Go-http-client/1.1",
+		RequestID:  "test-request-2",
+		Changes:    []byte(`{"first_name":{"before":"Admin","after":"Ada"}}`),
+	}
+	update.Seal(login.Hash)
+
+	return []*data.AuditEvent{login, update}
+}
+
+// InsertAuditEvent seals e after the test events and returns it with the
+// next ID. Nothing is kept.
+func (m *TestDBRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	events := testAuditEvents()
+	last := events[len(events)-1]
+	e.Seal(last.Hash)
+	e.ID = last.ID + 1
+	return &e, nil
+}
+
+// AuditEvents filters the test events.
+func (m *TestDBRepo) AuditEvents(f data.AuditFilter) ([]*data.AuditEvent, error) {
+	var events []*data.AuditEvent
+	for _, e := range testAuditEvents() {
+		switch {
+		case f.ActorID != 0 && e.ActorID != f.ActorID,
+			f.Action != "" && e.Action != f.Action,
+			f.TargetType != "" && e.TargetType != f.TargetType,
+			f.TargetID != "" && e.TargetID != f.TargetID,
+			!f.Since.IsZero() && e.OccurredAt.Before(f.Since),
+			!f.Until.IsZero() && !e.OccurredAt.Before(f.Until),
+			f.Cursor != 0 && f.Ascending && e.ID <= f.Cursor,
+			f.Cursor != 0 && !f.Ascending && e.ID >= f.Cursor:
+			continue
+		}
+		events = append(events, e)
+	}
+
+	if !f.Ascending {
+		sort.Slice(events, func(i, j int) bool { return events[i].ID > events[j].ID })
+	}
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[:f.Limit]
+	}
+	return events, nil
+}
@@ -0,0 +1,51 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"runtime"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPasswords hashes the passwords of users, in their order, on as many
+// goroutines as there are CPUs. Users without a password get "". It stops
+// when ctx is done.
+func hashPasswords(ctx context.Context, users []data.UpsertUser) ([]string, error) {
+	hashes := make([]string, len(users))
+	errs := make([]error, len(users))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+
+	for i, u := range users {
+		if u.Password == "" {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, password string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			hashed, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+			hashes[i], errs[i] = string(hashed), err
+		}(i, u.Password)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
@@ -0,0 +1,41 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_hashPasswords(t *testing.T) {
+	users := []data.UpsertUser{
+		{Email: "a@example.com", Password: "password-a"},
+		{Email: "b@example.com"},
+		{Email: "c@example.com", Password: "password-c"},
+	}
+
+	hashes, err := hashPasswords(context.Background(), users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ハッシュは利用者の順に並ぶ
+	for i, u := range users {
+		if u.Password == "" {
+			if hashes[i] != "" {
+				t.Errorf("%s: expected no hash but got %q", u.Email, hashes[i])
+			}
+			continue
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hashes[i]), []byte(u.Password)); err != nil {
+			t.Errorf("%s: the hash does not match the password: %v", u.Email, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hashPasswords(ctx, users); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"go_test_prac/webApp/pkg/ratelimit"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The Postgres store of package ratelimit is tested here, against the database
+// the tests of this package start.
+
+func TestRateLimitPostgres_Take(t *testing.T) {
+	s := &ratelimit.Postgres{DB: testDB}
+	ctx := context.Background()
+	l := ratelimit.Limit{Requests: 2, Period: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		res, err := s.Take(ctx, "test:take", l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+
+	res, err := s.Take(ctx, "test:take", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter <= 0 {
+		t.Errorf("expected the third request to be rejected with a retry time but got %+v", res)
+	}
+
+	res, _ = s.Take(ctx, "test:other", l)
+	if !res.Allowed || res.Remaining != 1 {
+		t.Errorf("expected another key to have its own bucket but got %+v", res)
+	}
+}
+
+func TestRateLimitPostgres_Take_concurrent(t *testing.T) {
+	s := &ratelimit.Postgres{DB: testDB}
+	l := ratelimit.Limit{Requests: 10, Period: time.Hour}
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := s.Take(context.Background(), "test:concurrent", l)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 行ロックで直列化されるので、インスタンスが複数でも上限を超えない
+	if allowed != 10 {
+		t.Errorf("expected 10 requests to be allowed but got %d", allowed)
+	}
+}
+
+func TestRateLimitPostgres_Sweep(t *testing.T) {
+	s := &ratelimit.Postgres{DB: testDB}
+	ctx := context.Background()
+	l := ratelimit.Limit{Requests: 1, Period: time.Hour}
+
+	if _, err := s.Take(ctx, "test:sweep", l); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Sweep(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < 1 {
+		t.Errorf("expected the bucket to be swept but got %d", n)
+	}
+	if res, _ := s.Take(ctx, "test:sweep", l); !res.Allowed {
+		t.Error("expected a swept bucket to start full")
+	}
+}
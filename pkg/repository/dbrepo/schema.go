@@ -0,0 +1,19 @@
+package dbrepo
+
+// Schema lists the tables and columns PostgresDBRepo and the Postgres store
+// of package ratelimit query, keyed by table.
+// The readiness check compares it with the database, so that a server is not
+// sent traffic before sql/users.sql has been applied. Add columns here together
+// with the queries using them.
+var Schema = map[string][]string{
+	"users":               {"id", "first_name", "last_name", "email", "password", "is_admin", "created_at", "updated_at"},
+	"user_images":         {"id", "user_id", "file_name", "sizes", "is_current", "scan_status", "created_at", "updated_at"},
+	"user_files":          {"id", "user_id", "file_name", "name", "content_type", "size", "scan_status", "created_at", "updated_at"},
+	"stored_files":        {"file_name", "sha256", "size", "content_type", "ref_count", "created_at", "updated_at"},
+	"rate_limits":         {"key", "tokens", "updated_at"},
+	"session_revocations": {"user_id", "revoked_at"},
+	"audit_events": {"id", "occurred_at", "actor_id", "actor", "action", "target_type", "target_id",
+		"ip", "user_agent", "request_id", "changes", "prev_hash", "hash"},
+	"api_keys": {"id", "user_id", "name", "prefix", "hash", "scopes", "expires_at",
+		"last_used_at", "last_used_ip", "revoked_at", "created_at"},
+}
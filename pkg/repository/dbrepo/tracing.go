@@ -0,0 +1,318 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/tracing"
+	"io"
+	"time"
+)
+
+// TracingDBRepo decorates a repository with a span for every method call.
+// Most repository methods take no context, so the decorator is bound to the
+// context of a request with WithContext; the spans then become children of
+// the request span.
+type TracingDBRepo struct {
+	Repo repository.DatabaseRepo
+	ctx  context.Context
+}
+
+var _ repository.DatabaseRepo = (*TracingDBRepo)(nil)
+
+// WithContext returns the decorator bound to ctx, with the decorated
+// repository bound to it as well.
+func (m *TracingDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return &TracingDBRepo{Repo: WithContext(ctx, m.Repo), ctx: ctx}
+}
+
+// WithContext binds repo to ctx if it supports it, as TracingDBRepo and
+// PostgresDBRepo do, and returns repo unchanged otherwise.
+func WithContext(ctx context.Context, repo repository.DatabaseRepo) repository.DatabaseRepo {
+	if bound, ok := repo.(interface {
+		WithContext(context.Context) repository.DatabaseRepo
+	}); ok {
+		return bound.WithContext(ctx)
+	}
+	return repo
+}
+
+// start starts the span of the method name.
+func (m *TracingDBRepo) start(name string, attrs ...tracing.Attribute) *tracing.Span {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs = append(attrs, tracing.String("db.operation.name", name))
+	_, span := tracing.Start(ctx, "DatabaseRepo."+name, attrs...)
+	return span
+}
+
+func (m *TracingDBRepo) Connection() *sql.DB {
+	return m.Repo.Connection()
+}
+
+func (m *TracingDBRepo) AllUsers() ([]*data.User, error) {
+	span := m.start("AllUsers")
+	v, err := m.Repo.AllUsers()
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) GetUser(id int) (*data.User, error) {
+	span := m.start("GetUser", tracing.Int("user.id", id))
+	v, err := m.Repo.GetUser(id)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) GetUserByEmail(email string) (*data.User, error) {
+	span := m.start("GetUserByEmail")
+	v, err := m.Repo.GetUserByEmail(email)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) UpdateUser(u data.User) error {
+	span := m.start("UpdateUser", tracing.Int("user.id", u.ID))
+	err := m.Repo.UpdateUser(u)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) DeleteUser(id int) error {
+	span := m.start("DeleteUser", tracing.Int("user.id", id))
+	err := m.Repo.DeleteUser(id)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) InsertUser(user data.User) (int, error) {
+	span := m.start("InsertUser")
+	v, err := m.Repo.InsertUser(user)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) ResetPassword(id int, password string) error {
+	span := m.start("ResetPassword", tracing.Int("user.id", id))
+	err := m.Repo.ResetPassword(id, password)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) RevokeSessions(userID int, at time.Time) error {
+	span := m.start("RevokeSessions", tracing.Int("user.id", userID))
+	err := m.Repo.RevokeSessions(userID, at)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) SessionsRevokedAt(userID int) (time.Time, error) {
+	span := m.start("SessionsRevokedAt", tracing.Int("user.id", userID))
+	v, err := m.Repo.SessionsRevokedAt(userID)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) UpsertUsers(ctx context.Context, users []data.UpsertUser) ([]data.UpsertResult, error) {
+	span := m.start("UpsertUsers", tracing.Int("users.count", len(users)))
+	v, err := m.Repo.UpsertUsers(ctx, users)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) ExportUsers(ctx context.Context, w io.Writer) error {
+	span := m.start("ExportUsers")
+	err := m.Repo.ExportUsers(ctx, w)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) InsertUserImage(i data.UserImage) (int, error) {
+	span := m.start("InsertUserImage", tracing.Int("user.id", i.UserID))
+	v, err := m.Repo.InsertUserImage(i)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) AllUserImages(userID int) ([]*data.UserImage, error) {
+	span := m.start("AllUserImages", tracing.Int("user.id", userID))
+	v, err := m.Repo.AllUserImages(userID)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) GetUserImage(id int) (*data.UserImage, error) {
+	span := m.start("GetUserImage", tracing.Int("image.id", id))
+	v, err := m.Repo.GetUserImage(id)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) SetCurrentUserImage(userID, imageID int) error {
+	span := m.start("SetCurrentUserImage", tracing.Int("user.id", userID), tracing.Int("image.id", imageID))
+	err := m.Repo.SetCurrentUserImage(userID, imageID)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) DeleteUserImage(id int) error {
+	span := m.start("DeleteUserImage", tracing.Int("image.id", id))
+	err := m.Repo.DeleteUserImage(id)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) InsertUserFile(f data.UserFile) (int, error) {
+	span := m.start("InsertUserFile", tracing.Int("user.id", f.UserID))
+	v, err := m.Repo.InsertUserFile(f)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) AllUserFiles(userID int) ([]*data.UserFile, error) {
+	span := m.start("AllUserFiles", tracing.Int("user.id", userID))
+	v, err := m.Repo.AllUserFiles(userID)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) GetUserFile(id int) (*data.UserFile, error) {
+	span := m.start("GetUserFile", tracing.Int("file.id", id))
+	v, err := m.Repo.GetUserFile(id)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) DeleteUserFile(id int) error {
+	span := m.start("DeleteUserFile", tracing.Int("file.id", id))
+	err := m.Repo.DeleteUserFile(id)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) InsertStoredFiles(files ...data.StoredFile) error {
+	span := m.start("InsertStoredFiles", tracing.Int("files", len(files)))
+	err := m.Repo.InsertStoredFiles(files...)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) GetStoredFileBySHA256(sum string) (*data.StoredFile, error) {
+	span := m.start("GetStoredFileBySHA256")
+	v, err := m.Repo.GetStoredFileBySHA256(sum)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) AllStoredFiles() ([]*data.StoredFile, error) {
+	span := m.start("AllStoredFiles")
+	v, err := m.Repo.AllStoredFiles()
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) SetStoredFileRefCount(fileName string, n int) error {
+	span := m.start("SetStoredFileRefCount")
+	err := m.Repo.SetStoredFileRefCount(fileName, n)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) DeleteStoredFile(fileName string) error {
+	span := m.start("DeleteStoredFile")
+	err := m.Repo.DeleteStoredFile(fileName)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) AllFileReferences() (map[string]int, error) {
+	span := m.start("AllFileReferences")
+	v, err := m.Repo.AllFileReferences()
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error) {
+	span := m.start("InsertAuditEvent", tracing.String("audit.action", e.Action))
+	v, err := m.Repo.InsertAuditEvent(e)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) AuditEvents(f data.AuditFilter) ([]*data.AuditEvent, error) {
+	span := m.start("AuditEvents")
+	v, err := m.Repo.AuditEvents(f)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) InsertAPIKey(k data.APIKey) (int, error) {
+	span := m.start("InsertAPIKey", tracing.Int("user.id", k.UserID))
+	v, err := m.Repo.InsertAPIKey(k)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) GetAPIKeyByPrefix(prefix string) (*data.APIKey, error) {
+	span := m.start("GetAPIKeyByPrefix", tracing.String("api_key.prefix", prefix))
+	v, err := m.Repo.GetAPIKeyByPrefix(prefix)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) UserAPIKeys(userID int) ([]*data.APIKey, error) {
+	span := m.start("UserAPIKeys", tracing.Int("user.id", userID))
+	v, err := m.Repo.UserAPIKeys(userID)
+	span.RecordError(err)
+	span.End()
+	return v, err
+}
+
+func (m *TracingDBRepo) RevokeAPIKey(userID, id int, at time.Time) error {
+	span := m.start("RevokeAPIKey", tracing.Int("user.id", userID), tracing.Int("api_key.id", id))
+	err := m.Repo.RevokeAPIKey(userID, id, at)
+	span.RecordError(err)
+	span.End()
+	return err
+}
+
+func (m *TracingDBRepo) TouchAPIKey(id int, at time.Time, ip string) error {
+	span := m.start("TouchAPIKey", tracing.Int("api_key.id", id))
+	err := m.Repo.TouchAPIKey(id, at, ip)
+	span.RecordError(err)
+	span.End()
+	return err
+}
@@ -0,0 +1,87 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"go_test_prac/webApp/pkg/requestlog"
+	"go_test_prac/webApp/pkg/tracing"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// spanRecorder keeps exported spans in memory.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, service string, spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTracingDBRepo(t *testing.T) {
+	rec := &spanRecorder{}
+	provider := tracing.NewProvider("test", rec, 1)
+	tracing.SetProvider(provider)
+	defer tracing.SetProvider(nil)
+
+	ctx, request := tracing.Start(context.Background(), "GET /users/{userID}")
+	repo := (&TracingDBRepo{Repo: &TestDBRepo{}}).WithContext(ctx)
+
+	if u, err := repo.GetUser(1); err != nil || u.ID != 1 {
+		t.Fatalf("expected the call to reach the repository, got %v, %v", u, err)
+	}
+	if _, err := repo.GetUser(2); err == nil {
+		t.Fatal("expected the error of the repository")
+	}
+	request.End()
+	provider.Shutdown(context.Background())
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("expected 3 spans but got %d", len(rec.spans))
+	}
+	found, missing := rec.spans[0], rec.spans[1]
+
+	// リクエストのスパンの子になる
+	for _, s := range []tracing.SpanData{found, missing} {
+		if s.Name != "DatabaseRepo.GetUser" || s.Parent != request.SpanContext().SpanID {
+			t.Errorf("unexpected span %s with parent %s", s.Name, s.Parent)
+		}
+	}
+	if found.Status != tracing.StatusUnset {
+		t.Errorf("expected no error on %+v", found)
+	}
+	if missing.Status != tracing.StatusError || missing.StatusMessage != sql.ErrNoRows.Error() {
+		t.Errorf("expected the error to be recorded on %+v", missing)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := requestlog.WithLogger(context.Background(), logger)
+
+	// デコレーターを通しても、Postgresのリポジトリがリクエストのロガーを使う
+	repo := WithContext(ctx, &TracingDBRepo{Repo: &PostgresDBRepo{}})
+	pg, ok := repo.(*TracingDBRepo).Repo.(*PostgresDBRepo)
+	if !ok {
+		t.Fatalf("unexpected repository %T", repo.(*TracingDBRepo).Repo)
+	}
+	if pg.logger() != logger {
+		t.Error("expected the repository to log through the logger of the request")
+	}
+
+	if (&PostgresDBRepo{}).logger() != slog.Default() {
+		t.Error("expected an unbound repository to use the default logger")
+	}
+
+	// 結び付けられないリポジトリはそのまま返る
+	test := &TestDBRepo{}
+	if WithContext(ctx, test) != test {
+		t.Error("expected the test repository to be returned unchanged")
+	}
+}
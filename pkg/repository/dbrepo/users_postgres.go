@@ -3,17 +3,43 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"go_test_prac/webApp/pkg/data"
-	"log"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/requestlog"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v4/stdlib"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const dbTimeout = time.Second * 3
 
+// bulkTimeout limits imports and exports of many users.
+const bulkTimeout = time.Minute * 5
+
+// PostgresDBRepo stores everything in Postgres. It logs through the logger of
+// the request it is bound to with WithContext, or the default logger.
 type PostgresDBRepo struct {
-	DB *sql.DB
+	DB  *sql.DB
+	ctx context.Context
+}
+
+// WithContext returns the repository bound to ctx.
+func (m *PostgresDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return &PostgresDBRepo{DB: m.DB, ctx: ctx}
+}
+
+func (m *PostgresDBRepo) logger() *slog.Logger {
+	if m.ctx == nil {
+		return slog.Default()
+	}
+	return requestlog.FromContext(m.ctx)
 }
 
 // implementing
@@ -50,7 +76,7 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.UpdatedAt,
 		)
 		if err != nil {
-			log.Println("Error scanning", err)
+			m.logger().Error("scanning a row", "err", err)
 			return nil, err
 		}
 
@@ -67,13 +93,11 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
+			id, email, first_name, last_name, password, is_admin, created_at, updated_at
 		from 
-			users u
-			left join user_images ui on (ui.user_id = u.id)
+			users
 		where 
-		    u.id = $1`
+		    id = $1`
 
 	var user data.User
 	row := m.DB.QueryRowContext(ctx, query, id)
@@ -87,13 +111,17 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
-		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	err = m.loadUserImages(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
@@ -104,13 +132,11 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
+			id, email, first_name, last_name, password, is_admin, created_at, updated_at
 		from 
-			users u
-			left join user_images ui on (ui.user_id = u.id)
+			users
 		where 
-		    u.email = $1`
+		    email = $1`
 
 	var user data.User
 	row := m.DB.QueryRowContext(ctx, query, email)
@@ -124,13 +150,17 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
-		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	err = m.loadUserImages(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
@@ -229,24 +259,373 @@ func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
 	return nil
 }
 
-// InsertUserImage inserts a user profile image into the database.
+// RevokeSessions ends the sessions of a user: tokens and web sessions started
+// at or before at are rejected from then on.
+func (m *PostgresDBRepo) RevokeSessions(userID int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into session_revocations (user_id, revoked_at) values ($1, $2)
+		on conflict (user_id) do update set revoked_at = excluded.revoked_at`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, at)
+	return err
+}
+
+// SessionsRevokedAt returns when the sessions of a user were last revoked, or
+// the zero time if they never were.
+func (m *PostgresDBRepo) SessionsRevokedAt(userID int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var at time.Time
+	stmt := `select revoked_at from session_revocations where user_id = $1`
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return at, err
+}
+
+// UpsertUsers writes users by email in one transaction: users with a new email
+// are inserted and the others updated. The results are in the order of users.
+// Nothing is written if any user fails or ctx is done first.
+func (m *PostgresDBRepo) UpsertUsers(ctx context.Context, users []data.UpsertUser) ([]data.UpsertResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	// パスワードのハッシュは遅いので、トランザクションを始める前に計算する
+	hashes, err := hashPasswords(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// xmax = 0 は挿入された行を表す
+	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
+		values ($1, $2, $3, $4, coalesce($5::integer, 0), $6, $6)
+		on conflict (email) do update set
+			first_name = excluded.first_name,
+			last_name = excluded.last_name,
+			password = coalesce(nullif(excluded.password, ''), users.password),
+			is_admin = coalesce($5::integer, users.is_admin),
+			updated_at = excluded.updated_at
+		returning id, xmax = 0`
+
+	now := time.Now()
+	results := make([]data.UpsertResult, len(users))
+	for i, u := range users {
+		err := tx.QueryRowContext(ctx, stmt, u.Email, u.FirstName, u.LastName, hashes[i], u.IsAdmin, now).
+			Scan(&results[i].ID, &results[i].Created)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", u.Email, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ExportUsers streams every user, without the password, to w as CSV with a
+// header, using COPY. The columns are id, email, first_name, last_name,
+// is_admin, created_at and updated_at. The export stops when ctx is done.
+func (m *PostgresDBRepo) ExportUsers(ctx context.Context, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, bulkTimeout)
+	defer cancel()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	query := `copy (
+			select id, email, first_name, last_name, is_admin, created_at, updated_at from users order by id
+		) to stdout with (format csv, header)`
+
+	// COPYはdatabase/sqlにないので、pgxの接続を直接使う
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("exporting users needs the pgx driver, got %T", driverConn)
+		}
+		_, err := c.Conn().PgConn().CopyTo(ctx, w, query)
+		return err
+	})
+}
+
+// InsertUserImage inserts a user profile image into the database and makes it
+// the user's current profile picture. Earlier images are kept as history.
 func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `update user_images set is_current = false, updated_at = $1 where user_id = $2 and is_current`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), i.UserID)
 	if err != nil {
 		return 0, err
 	}
 
 	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
+	stmt = `insert into user_images (user_id, file_name, sizes, is_current, scan_status, created_at, updated_at)
+		values ($1, $2, $3, true, $4, $5, $6) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		i.UserID,
 		i.FileName,
+		joinSizes(i.Sizes),
+		scanStatus(i.ScanStatus),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = addReferences(ctx, tx, i.FileNames()...); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// AllUserImages returns every profile image uploaded by a user, newest first.
+func (m *PostgresDBRepo) AllUserImages(userID int) ([]*data.UserImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return m.allUserImages(ctx, userID)
+}
+
+func (m *PostgresDBRepo) allUserImages(ctx context.Context, userID int) ([]*data.UserImage, error) {
+	query := `select id, user_id, file_name, sizes, is_current, scan_status, created_at, updated_at
+		from user_images where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*data.UserImage
+
+	for rows.Next() {
+		var i data.UserImage
+		var sizes string
+		err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.FileName,
+			&sizes,
+			&i.IsCurrent,
+			&i.ScanStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			m.logger().Error("scanning a row", "err", err)
+			return nil, err
+		}
+		i.Sizes = splitSizes(sizes)
+
+		images = append(images, &i)
+	}
+
+	return images, rows.Err()
+}
+
+// loadUserImages fills the current profile picture and the image history of a user.
+func (m *PostgresDBRepo) loadUserImages(ctx context.Context, user *data.User) error {
+	images, err := m.allUserImages(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	user.Images = make([]data.UserImage, 0, len(images))
+	for _, i := range images {
+		if i.IsCurrent {
+			user.ProfilePic = *i
+		}
+		user.Images = append(user.Images, *i)
+	}
+
+	return nil
+}
+
+// GetUserImage returns one user image by id.
+func (m *PostgresDBRepo) GetUserImage(id int) (*data.UserImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, file_name, sizes, is_current, scan_status, created_at, updated_at
+		from user_images where id = $1`
+
+	var i data.UserImage
+	var sizes string
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&i.ID,
+		&i.UserID,
+		&i.FileName,
+		&sizes,
+		&i.IsCurrent,
+		&i.ScanStatus,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	i.Sizes = splitSizes(sizes)
+
+	return &i, nil
+}
+
+// SetCurrentUserImage makes one of the user's images the current profile picture.
+// It returns sql.ErrNoRows when the image does not belong to the user.
+func (m *PostgresDBRepo) SetCurrentUserImage(userID, imageID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `select exists(select 1 from user_images where id = $1 and user_id = $2)`,
+		imageID, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	// 部分ユニークインデックスに違反しないよう、先に現在の画像を解除する
+	stmt := `update user_images set is_current = false, updated_at = $1 where user_id = $2 and is_current`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update user_images set is_current = true, updated_at = $1 where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), imageID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUserImage deletes one user image by id. When it was the current profile
+// picture, the most recent remaining image becomes the current one. Files no
+// other image or file refers to any more are left in the storage for package
+// filegc, as a concurrent upload of the same picture may refer to them again.
+func (m *PostgresDBRepo) DeleteUserImage(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var i data.UserImage
+	var sizes string
+	err = tx.QueryRowContext(ctx, `delete from user_images where id = $1 returning user_id, is_current, file_name, sizes`, id).
+		Scan(&i.UserID, &i.IsCurrent, &i.FileName, &sizes)
+	if err != nil {
+		return err
+	}
+	i.Sizes = splitSizes(sizes)
+
+	if i.IsCurrent {
+		stmt := `update user_images set is_current = true, updated_at = $1
+			where id = (select id from user_images where user_id = $2 order by created_at desc, id desc limit 1)`
+		_, err = tx.ExecContext(ctx, stmt, time.Now(), i.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = releaseReferences(ctx, tx, i.FileNames()...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// scanStatus defaults the scan status of records created without a scanner to clean.
+func scanStatus(status string) string {
+	if status == "" {
+		return "clean"
+	}
+	return status
+}
+
+// joinSizes stores the variant sizes of an image as a comma separated list, e.g. "64,256,512".
+func joinSizes(sizes []int) string {
+	s := make([]string, 0, len(sizes))
+	for _, size := range sizes {
+		s = append(s, strconv.Itoa(size))
+	}
+	return strings.Join(s, ",")
+}
+
+// splitSizes parses a list stored by joinSizes, skipping malformed entries.
+func splitSizes(s string) []int {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			continue
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// InsertUserFile records a file uploaded by a user.
+func (m *PostgresDBRepo) InsertUserFile(f data.UserFile) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `insert into user_files (user_id, file_name, name, content_type, size, scan_status, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		f.UserID,
+		f.FileName,
+		f.Name,
+		f.ContentType,
+		f.Size,
+		scanStatus(f.ScanStatus),
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -255,5 +634,300 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 		return 0, err
 	}
 
+	if err = addReferences(ctx, tx, f.FileName); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
+
+// AllUserFiles returns every file uploaded by a user, newest first.
+func (m *PostgresDBRepo) AllUserFiles(userID int) ([]*data.UserFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, file_name, name, content_type, size, scan_status, created_at, updated_at
+		from user_files where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*data.UserFile
+
+	for rows.Next() {
+		var f data.UserFile
+		err := rows.Scan(
+			&f.ID,
+			&f.UserID,
+			&f.FileName,
+			&f.Name,
+			&f.ContentType,
+			&f.Size,
+			&f.ScanStatus,
+			&f.CreatedAt,
+			&f.UpdatedAt,
+		)
+		if err != nil {
+			m.logger().Error("scanning a row", "err", err)
+			return nil, err
+		}
+
+		files = append(files, &f)
+	}
+
+	return files, rows.Err()
+}
+
+// GetUserFile returns one user file by id.
+func (m *PostgresDBRepo) GetUserFile(id int) (*data.UserFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, file_name, name, content_type, size, scan_status, created_at, updated_at
+		from user_files where id = $1`
+
+	var f data.UserFile
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&f.ID,
+		&f.UserID,
+		&f.FileName,
+		&f.Name,
+		&f.ContentType,
+		&f.Size,
+		&f.ScanStatus,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// DeleteUserFile deletes one user file by id. A file nothing refers to any more
+// is left in the storage for package filegc, like in DeleteUserImage.
+func (m *PostgresDBRepo) DeleteUserFile(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fileName string
+	err = tx.QueryRowContext(ctx, `delete from user_files where id = $1 returning file_name`, id).Scan(&fileName)
+	if err != nil {
+		return err
+	}
+
+	if err = releaseReferences(ctx, tx, fileName); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertStoredFiles records objects written to the storage. Objects that are
+// already recorded keep their reference count; only updated_at is refreshed so
+// that the garbage collector leaves them alone until they are referenced.
+func (m *PostgresDBRepo) InsertStoredFiles(files ...data.StoredFile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into stored_files (file_name, sha256, size, content_type, ref_count, created_at, updated_at)
+		values ($1, $2, $3, $4, 0, $5, $6)
+		on conflict (file_name) do update set updated_at = excluded.updated_at`
+
+	for _, f := range files {
+		_, err := m.DB.ExecContext(ctx, stmt,
+			f.FileName,
+			f.SHA256,
+			f.Size,
+			f.ContentType,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetStoredFileBySHA256 returns a stored object with the given content hash.
+func (m *PostgresDBRepo) GetStoredFileBySHA256(sum string) (*data.StoredFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select file_name, sha256, size, content_type, ref_count, created_at, updated_at
+		from stored_files where sha256 = $1 order by created_at limit 1`
+
+	var f data.StoredFile
+	err := m.DB.QueryRowContext(ctx, query, sum).Scan(
+		&f.FileName,
+		&f.SHA256,
+		&f.Size,
+		&f.ContentType,
+		&f.RefCount,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// AllStoredFiles returns every recorded object, ordered by file name.
+func (m *PostgresDBRepo) AllStoredFiles() ([]*data.StoredFile, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select file_name, sha256, size, content_type, ref_count, created_at, updated_at
+		from stored_files order by file_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*data.StoredFile
+
+	for rows.Next() {
+		var f data.StoredFile
+		err := rows.Scan(
+			&f.FileName,
+			&f.SHA256,
+			&f.Size,
+			&f.ContentType,
+			&f.RefCount,
+			&f.CreatedAt,
+			&f.UpdatedAt,
+		)
+		if err != nil {
+			m.logger().Error("scanning a row", "err", err)
+			return nil, err
+		}
+
+		files = append(files, &f)
+	}
+
+	return files, rows.Err()
+}
+
+// SetStoredFileRefCount overwrites the reference count of a stored object.
+func (m *PostgresDBRepo) SetStoredFileRefCount(fileName string, n int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update stored_files set ref_count = $1, updated_at = $2 where file_name = $3`
+	_, err := m.DB.ExecContext(ctx, stmt, n, time.Now(), fileName)
+	return err
+}
+
+// DeleteStoredFile forgets a stored object.
+func (m *PostgresDBRepo) DeleteStoredFile(fileName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stored_files where file_name = $1`, fileName)
+	return err
+}
+
+// AllFileReferences counts how many user images and user files refer to each
+// object in the storage.
+func (m *PostgresDBRepo) AllFileReferences() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	refs := make(map[string]int)
+
+	rows, err := m.DB.QueryContext(ctx, `select file_name, sizes from user_images`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i data.UserImage
+		var sizes string
+		if err := rows.Scan(&i.FileName, &sizes); err != nil {
+			return nil, err
+		}
+		i.Sizes = splitSizes(sizes)
+		for _, name := range i.FileNames() {
+			refs[name]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = m.DB.QueryContext(ctx, `select file_name, count(*) from user_files group by file_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var n int
+		if err := rows.Scan(&name, &n); err != nil {
+			return nil, err
+		}
+		refs[name] += n
+	}
+
+	return refs, rows.Err()
+}
+
+// addReferences increments the reference count of stored objects.
+func addReferences(ctx context.Context, tx *sql.Tx, fileNames ...string) error {
+	stmt := `update stored_files set ref_count = ref_count + 1, updated_at = $1 where file_name = $2`
+	for _, name := range fileNames {
+		if _, err := tx.ExecContext(ctx, stmt, time.Now(), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseReferences decrements the reference count of stored objects and
+// forgets the ones nothing refers to any more. Their objects stay in the
+// storage until package filegc finds them unreferenced for long enough.
+func releaseReferences(ctx context.Context, tx *sql.Tx, fileNames ...string) error {
+	stmt := `update stored_files set ref_count = ref_count - 1, updated_at = $1 where file_name = $2 returning ref_count`
+	for _, name := range fileNames {
+		var n int
+		err := tx.QueryRowContext(ctx, stmt, time.Now(), name).Scan(&n)
+		// 記録される前にアップロードされたファイルには行がない
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `delete from stored_files where file_name = $1`, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
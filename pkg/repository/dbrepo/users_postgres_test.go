@@ -3,12 +3,15 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/health"
 	"go_test_prac/webApp/pkg/repository"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -249,10 +252,104 @@ func TestPostgresDBRepoResetPassword(t *testing.T) {
 	}
 }
 
+func TestPostgresDBRepoSessionRevocations(t *testing.T) {
+	at, err := testRepo.SessionsRevokedAt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at.IsZero() {
+		t.Errorf("expected no revocation yet but got %s", at)
+	}
+
+	// 2回目の失効で時刻が更新される
+	first := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	second := time.Now().Truncate(time.Microsecond)
+	for _, revokedAt := range []time.Time{first, second} {
+		if err := testRepo.RevokeSessions(1, revokedAt); err != nil {
+			t.Fatalf("error revoking sessions: %s", err)
+		}
+	}
+
+	at, err = testRepo.SessionsRevokedAt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(second) {
+		t.Errorf("expected the sessions revoked at %s but got %s", second, at)
+	}
+
+	if err := testRepo.RevokeSessions(999, second); err == nil {
+		t.Error("expected an error revoking the sessions of an unknown user")
+	}
+}
+
+func TestPostgresDBRepoUpsertUsers(t *testing.T) {
+	notAdmin := 0
+	users := []data.UpsertUser{
+		{Email: "admin@example.com", FirstName: "Ada", LastName: "User"},
+		{Email: "bulk@example.com", FirstName: "Bulk", LastName: "User", Password: "bulk password", IsAdmin: &notAdmin},
+	}
+	results, err := testRepo.UpsertUsers(context.Background(), users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0] != (data.UpsertResult{ID: 1}) || !results[1].Created {
+		t.Fatalf("expected the admin to be updated and a new user but got %+v", results)
+	}
+
+	// パスワードと権限は指定がなければ変えない
+	admin, _ := testRepo.GetUser(1)
+	if admin.FirstName != "Ada" || admin.IsAdmin != 1 {
+		t.Errorf("expected the name to change and the role to stay, got %+v", admin)
+	}
+	if ok, _ := admin.PasswordMatches("newPassword"); !ok {
+		t.Error("expected the password of the admin to stay")
+	}
+	created, _ := testRepo.GetUser(results[1].ID)
+	if ok, _ := created.PasswordMatches("bulk password"); !ok || created.IsAdmin != 0 {
+		t.Errorf("unexpected new user %+v", created)
+	}
+
+	// 1件でも失敗すれば何も書かない
+	_, err = testRepo.UpsertUsers(context.Background(), []data.UpsertUser{
+		{Email: "rollback@example.com", FirstName: "Roll", LastName: "Back"},
+		{Email: strings.Repeat("x", 256) + "@example.com", FirstName: "Too", LastName: "Long"},
+	})
+	if err == nil {
+		t.Error("expected an error for an email longer than the column")
+	}
+	if _, err := testRepo.GetUserByEmail("rollback@example.com"); err == nil {
+		t.Error("expected the first user of a failed upsert to be rolled back")
+	}
+
+	_ = testRepo.DeleteUser(results[1].ID)
+	admin.FirstName = "Admin"
+	_ = testRepo.UpdateUser(*admin)
+}
+
+func TestPostgresDBRepoExportUsers(t *testing.T) {
+	var buf strings.Builder
+	if err := testRepo.ExportUsers(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "id,email,first_name,last_name,is_admin,created_at,updated_at" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "1,admin@example.com,Admin,User,1,") {
+		t.Errorf("expected the admin only but got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), "$2a$") {
+		t.Error("expected no password hashes in the export")
+	}
+}
+
 func TestPostgresDBRepoInsertUserImage(t *testing.T) {
 	image := data.UserImage{
 		UserID:    1,
 		FileName:  "test.jpg",
+		Sizes:     []int{64, 256},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -266,6 +363,15 @@ func TestPostgresDBRepoInsertUserImage(t *testing.T) {
 		t.Errorf("insert user image returned wrong id: want 1, got %d", newID)
 	}
 
+	user, _ := testRepo.GetUser(1)
+	if len(user.ProfilePic.Sizes) != 2 || user.ProfilePic.Sizes[1] != 256 {
+		t.Errorf("user image sizes not stored: want [64 256], got %v", user.ProfilePic.Sizes)
+	}
+	// 検査結果を指定しなければcleanになる
+	if user.ProfilePic.ScanStatus != "clean" {
+		t.Errorf("default scan status not stored: want clean, got %q", user.ProfilePic.ScanStatus)
+	}
+
 	image = data.UserImage{
 		UserID:    100,
 		FileName:  "test.jpg",
@@ -277,3 +383,188 @@ func TestPostgresDBRepoInsertUserImage(t *testing.T) {
 		t.Errorf("no error reported when inserting user image with non existent user id")
 	}
 }
+
+func TestPostgresDBRepoUserImageHistory(t *testing.T) {
+	// TestPostgresDBRepoInsertUserImageで画像1が登録済み
+	newID, err := testRepo.InsertUserImage(data.UserImage{UserID: 1, FileName: "second.jpg", Sizes: []int{64}})
+	if err != nil {
+		t.Fatalf("error inserting second user image: %s", err)
+	}
+
+	images, err := testRepo.AllUserImages(1)
+	if err != nil {
+		t.Errorf("AllUserImages returned an error: %s", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("AllUserImages returned wrong number of images: want 2, got %d", len(images))
+	}
+	if images[0].ID != newID || !images[0].IsCurrent || images[1].IsCurrent {
+		t.Errorf("newest image should be first and current, got %+v, %+v", images[0], images[1])
+	}
+
+	user, _ := testRepo.GetUser(1)
+	if user.ProfilePic.ID != newID || len(user.Images) != 2 {
+		t.Errorf("GetUser returned wrong images: current %d, history %d", user.ProfilePic.ID, len(user.Images))
+	}
+
+	err = testRepo.SetCurrentUserImage(1, 1)
+	if err != nil {
+		t.Errorf("error setting current image: %s", err)
+	}
+	user, _ = testRepo.GetUser(1)
+	if user.ProfilePic.ID != 1 {
+		t.Errorf("current image not updated: want 1, got %d", user.ProfilePic.ID)
+	}
+
+	err = testRepo.SetCurrentUserImage(2, 1)
+	if err == nil {
+		t.Errorf("no error reported when setting an image of another user")
+	}
+
+	err = testRepo.DeleteUserImage(1)
+	if err != nil {
+		t.Errorf("error deleting user image: %s", err)
+	}
+
+	_, err = testRepo.GetUserImage(1)
+	if err == nil {
+		t.Errorf("retrieved user image 1, which should have been deleted")
+	}
+
+	// 現在の画像を削除したので、残っている最新の画像が現在の画像になる
+	user, _ = testRepo.GetUser(1)
+	if user.ProfilePic.ID != newID {
+		t.Errorf("remaining image did not become current: want %d, got %d", newID, user.ProfilePic.ID)
+	}
+}
+
+func TestPostgresDBRepoUserFiles(t *testing.T) {
+	f := data.UserFile{UserID: 1, FileName: "files/1/intro.mp4", Name: "intro.mp4", ContentType: "video/mp4", Size: 1024, ScanStatus: "quarantined"}
+	newID, err := testRepo.InsertUserFile(f)
+	if err != nil {
+		t.Fatalf("error inserting user file: %s", err)
+	}
+
+	got, err := testRepo.GetUserFile(newID)
+	if err != nil {
+		t.Fatalf("error getting user file: %s", err)
+	}
+	if got.FileName != f.FileName || got.Name != f.Name || got.ContentType != f.ContentType || got.Size != f.Size || got.ScanStatus != f.ScanStatus {
+		t.Errorf("GetUserFile returned %+v, want %+v", got, f)
+	}
+
+	files, err := testRepo.AllUserFiles(1)
+	if err != nil {
+		t.Errorf("AllUserFiles returned an error: %s", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("AllUserFiles returned wrong number of files: want 1, got %d", len(files))
+	}
+
+	// stored_filesに記録されていないファイルも削除できる
+	err = testRepo.DeleteUserFile(newID)
+	if err != nil {
+		t.Errorf("error deleting user file: %s", err)
+	}
+
+	err = testRepo.DeleteUserFile(newID)
+	if err == nil {
+		t.Errorf("no error reported when deleting a missing user file")
+	}
+}
+
+func TestPostgresDBRepoStoredFiles(t *testing.T) {
+	shared := data.StoredFile{FileName: "files/1/shared.pdf", SHA256: strings.Repeat("a", 64), Size: 2048, ContentType: "application/pdf"}
+	err := testRepo.InsertStoredFiles(shared)
+	if err != nil {
+		t.Fatalf("error inserting stored file: %s", err)
+	}
+	// 2回目の登録は参照数を変えない
+	if err := testRepo.InsertStoredFiles(shared); err != nil {
+		t.Errorf("error inserting the same stored file again: %s", err)
+	}
+
+	got, err := testRepo.GetStoredFileBySHA256(shared.SHA256)
+	if err != nil {
+		t.Fatalf("error getting stored file by hash: %s", err)
+	}
+	if got.FileName != shared.FileName || got.Size != shared.Size || got.RefCount != 0 {
+		t.Errorf("GetStoredFileBySHA256 returned %+v, want %+v", got, shared)
+	}
+
+	// 同じ内容のファイルを2回アップロードする
+	var ids []int
+	for _, name := range []string{"cv.pdf", "cv (1).pdf"} {
+		id, err := testRepo.InsertUserFile(data.UserFile{UserID: 1, FileName: shared.FileName, Name: name, ContentType: shared.ContentType, Size: shared.Size})
+		if err != nil {
+			t.Fatalf("error inserting user file: %s", err)
+		}
+		ids = append(ids, id)
+	}
+
+	got, _ = testRepo.GetStoredFileBySHA256(shared.SHA256)
+	if got.RefCount != 2 {
+		t.Errorf("expected 2 references but got %d", got.RefCount)
+	}
+
+	refs, err := testRepo.AllFileReferences()
+	if err != nil {
+		t.Errorf("AllFileReferences returned an error: %s", err)
+	}
+	if refs[shared.FileName] != 2 {
+		t.Errorf("AllFileReferences counted %d references, want 2", refs[shared.FileName])
+	}
+
+	if err := testRepo.DeleteUserFile(ids[0]); err != nil {
+		t.Errorf("error deleting the first copy: %s", err)
+	}
+	got, err = testRepo.GetStoredFileBySHA256(shared.SHA256)
+	if err != nil || got.RefCount != 1 {
+		t.Errorf("deleting the first copy should keep the file, got %+v, %v", got, err)
+	}
+
+	if err := testRepo.DeleteUserFile(ids[1]); err != nil {
+		t.Errorf("error deleting the last copy: %s", err)
+	}
+
+	_, err = testRepo.GetStoredFileBySHA256(shared.SHA256)
+	if err == nil {
+		t.Errorf("the released file is still recorded")
+	}
+
+	if err := testRepo.InsertStoredFiles(shared); err != nil {
+		t.Fatalf("error inserting stored file: %s", err)
+	}
+	if err := testRepo.SetStoredFileRefCount(shared.FileName, 5); err != nil {
+		t.Errorf("error setting the reference count: %s", err)
+	}
+	files, err := testRepo.AllStoredFiles()
+	if err != nil {
+		t.Errorf("AllStoredFiles returned an error: %s", err)
+	}
+	if len(files) != 1 || files[0].RefCount != 5 {
+		t.Errorf("AllStoredFiles returned %+v", files)
+	}
+
+	if err := testRepo.DeleteStoredFile(shared.FileName); err != nil {
+		t.Errorf("error deleting stored file: %s", err)
+	}
+	files, _ = testRepo.AllStoredFiles()
+	if len(files) != 0 {
+		t.Errorf("expected no stored files but got %d", len(files))
+	}
+}
+
+// Schemaがテスト用のDB(testdata/users.sql)と一致していることを確認する
+func TestPostgresDBRepoSchema(t *testing.T) {
+	check := health.Schema(testRepo, Schema)
+	if err := check(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	// 足りない列は名前で報告される
+	check = health.Schema(testRepo, map[string][]string{"users": {"id", "nickname"}})
+	if err := check(context.Background()); err == nil || !strings.Contains(err.Error(), "users.nickname") {
+		t.Errorf("expected users.nickname to be reported missing, got %v", err)
+	}
+}
@@ -1,9 +1,12 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"go_test_prac/webApp/pkg/data"
+	"io"
+	"strings"
 	"time"
 )
 
@@ -29,10 +32,18 @@ func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
 			LastName: "User",
 			Email: "admin@example.com",
 		}
+		images, _ := m.AllUserImages(1)
+		for _, i := range images {
+			if i.IsCurrent {
+				user.ProfilePic = *i
+			}
+			user.Images = append(user.Images, *i)
+		}
 		return &user, nil
 	}
 
-	return nil, errors.New("user not found")
+	// Postgresと同じく、いないユーザはsql.ErrNoRowsになる
+	return nil, sql.ErrNoRows
 }
 
 // GetUserByEmail returns one user by email address
@@ -77,9 +88,173 @@ func (m *TestDBRepo) ResetPassword(id int, password string) error {
 	return nil
 }
 
+// RevokeSessions ends the sessions of a user.
+func (m *TestDBRepo) RevokeSessions(userID int, at time.Time) error {
+	return nil
+}
+
+// SessionsRevokedAt returns when the sessions of a user were last revoked.
+// テスト用に、どのユーザのセッションも失効していない
+func (m *TestDBRepo) SessionsRevokedAt(userID int) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+// UpsertUsers writes users by email.
+// テスト用に、admin@example.comは更新し、それ以外は2から順に挿入したことにする
+func (m *TestDBRepo) UpsertUsers(ctx context.Context, users []data.UpsertUser) ([]data.UpsertResult, error) {
+	results := make([]data.UpsertResult, len(users))
+	nextID := 2
+	for i, u := range users {
+		if u.Email == "admin@example.com" {
+			results[i] = data.UpsertResult{ID: 1}
+			continue
+		}
+		results[i] = data.UpsertResult{ID: nextID, Created: true}
+		nextID++
+	}
+	return results, nil
+}
+
+// ExportUsers writes every user as CSV, like the COPY of PostgresDBRepo.
+func (m *TestDBRepo) ExportUsers(ctx context.Context, w io.Writer) error {
+	_, err := io.WriteString(w, "id,email,first_name,last_name,is_admin,created_at,updated_at\n"+
+		"1,admin@example.com,Admin,User,1,2022-08-19 00:00:00,2022-08-19 00:00:00\n")
+	return err
+}
+
 // InsertUserImage inserts a user profile image into the database.
 func (m *TestDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 		return 2, nil
 }
 
+// AllUserImages returns every profile image uploaded by a user, newest first.
+// テスト用に、ユーザ1は現在の画像と過去の画像を1枚ずつ持っている
+func (m *TestDBRepo) AllUserImages(userID int) ([]*data.UserImage, error) {
+	var images []*data.UserImage
+	if userID == 1 {
+		current, _ := m.GetUserImage(1)
+		old, _ := m.GetUserImage(2)
+		images = append(images, current, old)
+	}
+	return images, nil
+}
+
+// GetUserImage returns one user image by id.
+// 画像1,2はユーザ1、画像3はユーザ2のもの
+func (m *TestDBRepo) GetUserImage(id int) (*data.UserImage, error) {
+	switch id {
+	case 1:
+		return &data.UserImage{ID: 1, UserID: 1, FileName: "current.png", Sizes: []int{64, 256}, IsCurrent: true, ScanStatus: "clean"}, nil
+	case 2:
+		return &data.UserImage{ID: 2, UserID: 1, FileName: "old.png", Sizes: []int{64}, ScanStatus: "clean"}, nil
+	case 3:
+		return &data.UserImage{ID: 3, UserID: 2, FileName: "other.png", IsCurrent: true, ScanStatus: "clean"}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// SetCurrentUserImage makes one of the user's images the current profile picture.
+func (m *TestDBRepo) SetCurrentUserImage(userID, imageID int) error {
+	i, err := m.GetUserImage(imageID)
+	if err != nil || i.UserID != userID {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserImage deletes one user image by id.
+func (m *TestDBRepo) DeleteUserImage(id int) error {
+	_, err := m.GetUserImage(id)
+	return err
+}
+
+// InsertUserFile inserts a user file into the database.
+func (m *TestDBRepo) InsertUserFile(f data.UserFile) (int, error) {
+	return 2, nil
+}
+
+// AllUserFiles returns every file uploaded by a user, newest first.
+func (m *TestDBRepo) AllUserFiles(userID int) ([]*data.UserFile, error) {
+	var files []*data.UserFile
+	if userID == 1 {
+		f, _ := m.GetUserFile(1)
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// GetUserFile returns one user file by id.
+// ファイル1はユーザ1、ファイル3はユーザ2のもの
+func (m *TestDBRepo) GetUserFile(id int) (*data.UserFile, error) {
+	switch id {
+	case 1:
+		return &data.UserFile{ID: 1, UserID: 1, FileName: "files/1/intro.mp4", Name: "intro.mp4", ContentType: "video/mp4", Size: 1024, ScanStatus: "clean"}, nil
+	case 3:
+		return &data.UserFile{ID: 3, UserID: 2, FileName: "files/2/cv.pdf", Name: "cv.pdf", ContentType: "application/pdf", Size: 2048, ScanStatus: "clean"}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// DeleteUserFile deletes one user file by id.
+func (m *TestDBRepo) DeleteUserFile(id int) error {
+	_, err := m.GetUserFile(id)
+	return err
+}
+
+// InsertStoredFiles records objects written to the storage.
+func (m *TestDBRepo) InsertStoredFiles(files ...data.StoredFile) error {
+	return nil
+}
 
+// GetStoredFileBySHA256 returns a stored object with the given content hash.
+// テスト用に、ファイル1の中身は0x00が1024バイト
+func (m *TestDBRepo) GetStoredFileBySHA256(sum string) (*data.StoredFile, error) {
+	files, _ := m.AllStoredFiles()
+	for _, f := range files {
+		if f.SHA256 == sum {
+			return f, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// AllStoredFiles returns every recorded object, ordered by file name.
+// 参照数がずれているもの(old.png)と、ストレージにないもの(missing.png)を含む
+func (m *TestDBRepo) AllStoredFiles() ([]*data.StoredFile, error) {
+	old := time.Now().Add(-48 * time.Hour)
+	return []*data.StoredFile{
+		{FileName: "current-256.png", SHA256: strings.Repeat("2", 64), Size: 2048, ContentType: "image/png", RefCount: 1, CreatedAt: old},
+		{FileName: "current-64.png", SHA256: strings.Repeat("6", 64), Size: 512, ContentType: "image/png", RefCount: 1, CreatedAt: old},
+		{FileName: "current.png", SHA256: strings.Repeat("c", 64), Size: 4096, ContentType: "image/png", RefCount: 1, CreatedAt: old},
+		{FileName: "files/1/intro.mp4", SHA256: "5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef", Size: 1024, ContentType: "video/mp4", RefCount: 1, CreatedAt: old},
+		{FileName: "missing.png", SHA256: strings.Repeat("d", 64), Size: 100, ContentType: "image/png", RefCount: 0, CreatedAt: old},
+		{FileName: "old.png", SHA256: strings.Repeat("0", 64), Size: 4096, ContentType: "image/png", RefCount: 3, CreatedAt: old},
+	}, nil
+}
+
+// SetStoredFileRefCount overwrites the reference count of a stored object.
+func (m *TestDBRepo) SetStoredFileRefCount(fileName string, n int) error {
+	return nil
+}
+
+// DeleteStoredFile forgets a stored object.
+func (m *TestDBRepo) DeleteStoredFile(fileName string) error {
+	return nil
+}
+
+// AllFileReferences counts how many user images and user files refer to each
+// object in the storage.
+func (m *TestDBRepo) AllFileReferences() (map[string]int, error) {
+	refs := make(map[string]int)
+	for id := 1; id <= 3; id++ {
+		i, _ := m.GetUserImage(id)
+		for _, name := range i.FileNames() {
+			refs[name]++
+		}
+	}
+	for _, id := range []int{1, 3} {
+		f, _ := m.GetUserFile(id)
+		refs[f.FileName]++
+	}
+	return refs, nil
+}
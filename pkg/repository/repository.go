@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"go_test_prac/webApp/pkg/data"
+	"io"
+	"time"
 )
 
 type DatabaseRepo interface {
@@ -14,6 +17,31 @@ type DatabaseRepo interface {
 	DeleteUser(id int) error
 	InsertUser(user data.User) (int, error)
 	ResetPassword(id int, password string) error
+	RevokeSessions(userID int, at time.Time) error
+	SessionsRevokedAt(userID int) (time.Time, error)
+	UpsertUsers(ctx context.Context, users []data.UpsertUser) ([]data.UpsertResult, error)
+	ExportUsers(ctx context.Context, w io.Writer) error
 	InsertUserImage(i data.UserImage) (int, error)
+	AllUserImages(userID int) ([]*data.UserImage, error)
+	GetUserImage(id int) (*data.UserImage, error)
+	SetCurrentUserImage(userID, imageID int) error
+	DeleteUserImage(id int) error
+	InsertUserFile(f data.UserFile) (int, error)
+	AllUserFiles(userID int) ([]*data.UserFile, error)
+	GetUserFile(id int) (*data.UserFile, error)
+	DeleteUserFile(id int) error
+	InsertStoredFiles(files ...data.StoredFile) error
+	GetStoredFileBySHA256(sum string) (*data.StoredFile, error)
+	AllStoredFiles() ([]*data.StoredFile, error)
+	SetStoredFileRefCount(fileName string, n int) error
+	DeleteStoredFile(fileName string) error
+	AllFileReferences() (map[string]int, error)
+	InsertAuditEvent(e data.AuditEvent) (*data.AuditEvent, error)
+	AuditEvents(f data.AuditFilter) ([]*data.AuditEvent, error)
+	InsertAPIKey(k data.APIKey) (int, error)
+	GetAPIKeyByPrefix(prefix string) (*data.APIKey, error)
+	UserAPIKeys(userID int) ([]*data.APIKey, error)
+	RevokeAPIKey(userID, id int, at time.Time) error
+	TouchAPIKey(id int, at time.Time, ip string) error
 }
 
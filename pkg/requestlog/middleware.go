@@ -0,0 +1,126 @@
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxRequestIDLength limits the IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// Options configure Middleware.
+type Options struct {
+	// ClientIP returns the address of the client. The host of RemoteAddr is
+	// used when it is nil.
+	ClientIP func(r *http.Request) string
+}
+
+// entry collects what is only known once the handlers ran.
+type entry struct {
+	userID string
+}
+
+// Middleware assigns a request ID, stores a logger carrying it in the request
+// context and logs one line per request with the method, path, status, bytes
+// written, latency, user ID and client IP. Server errors are logged at error
+// level and client errors at warn level.
+func Middleware(base *slog.Logger, opts Options) func(http.Handler) http.Handler {
+	clientIP := opts.ClientIP
+	if clientIP == nil {
+		clientIP = remoteHost
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(Header)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(Header, id)
+
+			logger := base.With("request_id", id)
+			e := &entry{}
+			ctx := WithRequestID(r.Context(), id)
+			ctx = WithLogger(ctx, logger)
+			ctx = context.WithValue(ctx, entryKey, e)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+
+			logger.LogAttrs(ctx, level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("user_id", e.userID),
+				slog.String("client_ip", clientIP(r)),
+			)
+		})
+	}
+}
+
+// SetUserID records the authenticated user of the request for the request log
+// and returns r with a logger that adds the user ID to every line. The ID can
+// be read back with UserID.
+func SetUserID(r *http.Request, userID string) *http.Request {
+	ctx := r.Context()
+	if e, ok := ctx.Value(entryKey).(*entry); ok {
+		e.userID = userID
+	}
+	ctx = context.WithValue(ctx, userIDKey, userID)
+	return r.WithContext(WithLogger(ctx, FromContext(ctx).With("user_id", userID)))
+}
+
+// validRequestID accepts IDs of printable ASCII characters, so that clients
+// cannot inject line breaks or huge values into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns 16 random bytes in hex.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// 乱数が使えない環境は想定しないが、時刻で代用する
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,133 @@
+package requestlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLine is a line of the JSON log.
+type logLine struct {
+	Level     string  `json:"level"`
+	Msg       string  `json:"msg"`
+	RequestID string  `json:"request_id"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Latency   float64 `json:"latency"`
+	UserID    string  `json:"user_id"`
+	ClientIP  string  `json:"client_ip"`
+}
+
+func readLog(t *testing.T, buf *bytes.Buffer) []logLine {
+	t.Helper()
+	var lines []logLine
+	for _, s := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var l logLine
+		if err := json.Unmarshal([]byte(s), &l); err != nil {
+			t.Fatalf("log line %q: %s", s, err)
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestMiddleware(t *testing.T) {
+	var tests = []struct {
+		name          string
+		requestID     string
+		status        int
+		body          string
+		userID        string
+		expectID      string
+		expectLevel   string
+		expectedBytes int
+	}{
+		{"propagated", "abc-123", http.StatusOK, "hello", "", "abc-123", "INFO", 5},
+		{"generated", "", http.StatusCreated, "", "", "", "INFO", 0},
+		{"line break", "abc\ndef", http.StatusOK, "", "", "", "INFO", 0},
+		{"too long", strings.Repeat("a", 129), http.StatusOK, "", "", "", "INFO", 0},
+		{"client error", "abc", http.StatusNotFound, "missing", "", "abc", "WARN", 7},
+		{"server error", "abc", http.StatusInternalServerError, "", "", "abc", "ERROR", 0},
+		{"user", "abc", http.StatusOK, "", "42", "abc", "INFO", 0},
+	}
+
+	for _, e := range tests {
+		var buf bytes.Buffer
+		logger, _ := NewLogger(&buf, "json", "info")
+
+		handler := Middleware(logger, Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if e.userID != "" {
+				r = SetUserID(r, e.userID)
+			}
+			// ハンドラーのログにもリクエストIDが付く
+			FromContext(r.Context()).Info("handler")
+			w.WriteHeader(e.status)
+			w.Write([]byte(e.body))
+		}))
+
+		req := httptest.NewRequest("GET", "/some/path", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if e.requestID != "" {
+			req.Header.Set(Header, e.requestID)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		id := rr.Header().Get(Header)
+		if e.expectID != "" && id != e.expectID {
+			t.Errorf("%s: expected request ID %q but got %q", e.name, e.expectID, id)
+		}
+		if e.expectID == "" && (len(id) != 32 || id == e.requestID) {
+			t.Errorf("%s: expected a generated request ID but got %q", e.name, id)
+		}
+
+		lines := readLog(t, &buf)
+		if len(lines) != 2 {
+			t.Fatalf("%s: expected 2 log lines but got %d", e.name, len(lines))
+		}
+		handlerLine, requestLine := lines[0], lines[1]
+
+		if handlerLine.RequestID != id {
+			t.Errorf("%s: handler logged request ID %q, expected %q", e.name, handlerLine.RequestID, id)
+		}
+		if handlerLine.UserID != e.userID {
+			t.Errorf("%s: handler logged user ID %q, expected %q", e.name, handlerLine.UserID, e.userID)
+		}
+
+		if requestLine.Msg != "request" || requestLine.Level != e.expectLevel {
+			t.Errorf("%s: unexpected request line %+v", e.name, requestLine)
+		}
+		if requestLine.RequestID != id || requestLine.Method != "GET" || requestLine.Path != "/some/path" {
+			t.Errorf("%s: unexpected request line %+v", e.name, requestLine)
+		}
+		if requestLine.Status != e.status || requestLine.Bytes != e.expectedBytes {
+			t.Errorf("%s: expected status %d and %d bytes but got %+v", e.name, e.status, e.expectedBytes, requestLine)
+		}
+		if requestLine.UserID != e.userID {
+			t.Errorf("%s: expected user ID %q but got %q", e.name, e.userID, requestLine.UserID)
+		}
+		if requestLine.ClientIP != "10.0.0.1" {
+			t.Errorf("%s: expected client IP 10.0.0.1 but got %q", e.name, requestLine.ClientIP)
+		}
+	}
+}
+
+func TestMiddleware_clientIP(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := NewLogger(&buf, "json", "info")
+
+	opts := Options{ClientIP: func(r *http.Request) string { return "203.0.113.7" }}
+	handler := Middleware(logger, opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	lines := readLog(t, &buf)
+	// 何も書かなかったハンドラーは200として記録する
+	if lines[0].ClientIP != "203.0.113.7" || lines[0].Status != http.StatusOK {
+		t.Errorf("unexpected request line %+v", lines[0])
+	}
+}
@@ -0,0 +1,79 @@
+// Package requestlog logs every request with log/slog and ties the log lines
+// of a request together with a request ID. The ID is taken from the
+// X-Request-ID header when a client or proxy sent one, so the same ID appears
+// in the logs of the web server and the API, or is generated otherwise.
+//
+// Middleware stores a logger carrying the request ID in the request context;
+// handlers log through FromContext(r.Context()) so their lines can be found
+// with the ID.
+package requestlog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Header is the request and response header carrying the request ID.
+const Header = "X-Request-ID"
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	entryKey
+	userIDKey
+)
+
+// NewLogger returns a logger writing to w in format, "text" or "json", that
+// drops records below level ("debug", "info", "warn" or "error").
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("requestlog: unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("requestlog: unknown log format %q, use text or json", format)
+	}
+}
+
+// FromContext returns the logger of the request, or the default logger
+// outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// WithLogger returns a context carrying l.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// RequestID returns the ID of the request, or "" outside of a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithRequestID returns a context carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// UserID returns the user recorded by SetUserID, or "" before authentication.
+func UserID(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey).(string)
+	return id
+}
@@ -0,0 +1,90 @@
+package requestlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var tests = []struct {
+		name        string
+		format      string
+		level       string
+		expectError bool
+		expectDebug bool
+		expectJSON  bool
+	}{
+		{"text", "text", "info", false, false, false},
+		{"json", "json", "info", false, false, true},
+		{"upper case", "JSON", "DEBUG", false, true, true},
+		{"debug", "text", "debug", false, true, false},
+		{"unknown format", "xml", "info", true, false, false},
+		{"unknown level", "text", "verbose", true, false, false},
+	}
+
+	for _, e := range tests {
+		var buf bytes.Buffer
+		logger, err := NewLogger(&buf, e.format, e.level)
+		if e.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		logger.Debug("debug line")
+		logger.Info("info line", "key", "value")
+
+		out := buf.String()
+		if strings.Contains(out, "debug line") != e.expectDebug {
+			t.Errorf("%s: unexpected debug output %q", e.name, out)
+		}
+		if !strings.Contains(out, "info line") {
+			t.Errorf("%s: info line missing from %q", e.name, out)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		isJSON := json.Valid([]byte(lines[len(lines)-1]))
+		if isJSON != e.expectJSON {
+			t.Errorf("%s: expected json %t but got %q", e.name, e.expectJSON, out)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	// リクエストの外ではデフォルトのロガーを返す
+	if FromContext(context.Background()) == nil {
+		t.Fatal("expected the default logger")
+	}
+
+	var buf bytes.Buffer
+	logger, _ := NewLogger(&buf, "text", "info")
+	ctx := WithLogger(context.Background(), logger)
+	FromContext(ctx).Info("hello")
+	if !strings.Contains(buf.String(), "hello") {
+		t.Errorf("expected the logger of the context to be used, got %q", buf.String())
+	}
+
+	if RequestID(context.Background()) != "" {
+		t.Error("expected no request ID outside of a request")
+	}
+	if got := RequestID(WithRequestID(ctx, "abc")); got != "abc" {
+		t.Errorf("expected request ID abc but got %q", got)
+	}
+
+	if UserID(context.Background()) != "" {
+		t.Error("expected no user ID before authentication")
+	}
+	r := SetUserID(httptest.NewRequest("GET", "/", nil), "42")
+	if got := UserID(r.Context()); got != "42" {
+		t.Errorf("expected user ID 42 but got %q", got)
+	}
+}
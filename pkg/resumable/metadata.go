@@ -0,0 +1,67 @@
+package resumable
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidMetadata is returned for an Upload-Metadata header that cannot be parsed.
+var ErrInvalidMetadata = errors.New("invalid Upload-Metadata header")
+
+// ParseMetadata parses the Upload-Metadata header: comma separated pairs of a
+// key and a base64 encoded value, separated by a space. The value may be
+// omitted, e.g. "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential".
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, ErrInvalidMetadata
+		}
+
+		key := fields[0]
+		if _, ok := metadata[key]; ok {
+			return nil, ErrInvalidMetadata
+		}
+
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, ErrInvalidMetadata
+			}
+			value = string(decoded)
+		}
+
+		metadata[key] = value
+	}
+
+	return metadata, nil
+}
+
+// EncodeMetadata returns metadata in the format of the Upload-Metadata header,
+// sorted by key.
+func EncodeMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if metadata[k] == "" {
+			pairs = append(pairs, k)
+			continue
+		}
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(metadata[k])))
+	}
+
+	return strings.Join(pairs, ",")
+}
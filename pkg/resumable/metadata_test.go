@@ -0,0 +1,55 @@
+package resumable
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	var tests = []struct {
+		name          string
+		header        string
+		expected      map[string]string
+		errorExpected bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{"one pair", "filename aW50cm8ubXA0", map[string]string{"filename": "intro.mp4"}, false},
+		{"several pairs", "filename aW50cm8ubXA0, filetype dmlkZW8vbXA0", map[string]string{"filename": "intro.mp4", "filetype": "video/mp4"}, false},
+		{"key without value", "filename aW50cm8ubXA0,is_confidential", map[string]string{"filename": "intro.mp4", "is_confidential": ""}, false},
+		{"invalid base64", "filename intro.mp4", nil, true},
+		{"duplicate key", "filename aW50cm8ubXA0,filename aW50cm8ubXA0", nil, true},
+		{"empty pair", "filename aW50cm8ubXA0,,", nil, true},
+		{"too many fields", "filename aW50cm8ubXA0 aW50cm8ubXA0", nil, true},
+	}
+
+	for _, e := range tests {
+		got, err := ParseMetadata(e.header)
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: expected an error but got none", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	metadata := map[string]string{"filetype": "video/mp4", "filename": "intro.mp4", "is_confidential": ""}
+
+	got := EncodeMetadata(metadata)
+	if got != "filename aW50cm8ubXA0,filetype dmlkZW8vbXA0,is_confidential" {
+		t.Errorf("unexpected header %q", got)
+	}
+
+	parsed, err := ParseMetadata(got)
+	if err != nil || !reflect.DeepEqual(parsed, metadata) {
+		t.Errorf("round trip failed: %v, %s", parsed, err)
+	}
+}
@@ -0,0 +1,482 @@
+// Package resumable implements resumable uploads following the core protocol
+// of tus 1.0.0 (https://tus.io/protocols/resumable-upload) and its creation,
+// expiration and termination extensions. The HTTP handlers live in cmd/api;
+// this package keeps the state of the uploads.
+//
+// Uploads are kept in a storage backend, so they survive restarts and work the
+// same with every backend: each PATCH request is stored as a separate chunk
+// object next to a JSON info object below "uploads/<id>/", and the chunks are
+// concatenated into the final object when the upload is complete.
+package resumable
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Version is the tus protocol version implemented by this package.
+	Version = "1.0.0"
+	// Extensions lists the supported tus extensions, for the Tus-Extension header.
+	Extensions = "creation,expiration,termination"
+
+	// DefaultMaxSize is the largest upload accepted by default, 500MB.
+	DefaultMaxSize = 500 << 20
+	// DefaultMaxChunkSize is the largest PATCH request body accepted by default, 16MB.
+	DefaultMaxChunkSize = 16 << 20
+	// DefaultExpiry is how long an upload is kept after its last chunk by default.
+	DefaultExpiry = 24 * time.Hour
+)
+
+var (
+	// ErrNotFound is returned for unknown uploads.
+	ErrNotFound = errors.New("upload not found")
+	// ErrExpired is returned for uploads that were abandoned for longer than the expiry.
+	ErrExpired = errors.New("upload expired")
+	// ErrInvalidLength is returned when the length of a new upload is negative.
+	ErrInvalidLength = errors.New("invalid upload length")
+	// ErrTooLarge is returned when the upload is larger than the maximum size.
+	ErrTooLarge = errors.New("upload too large")
+	// ErrChunkTooLarge is returned when a chunk is larger than the maximum chunk size.
+	ErrChunkTooLarge = errors.New("chunk too large")
+	// ErrExceedsLength is returned when a chunk goes past the declared length of the upload.
+	ErrExceedsLength = errors.New("chunk exceeds the upload length")
+	// ErrOffsetMismatch is returned when a chunk does not start at the current offset.
+	ErrOffsetMismatch = errors.New("offset does not match the current offset of the upload")
+	// ErrLocked is returned while another request is writing to the same upload.
+	ErrLocked = errors.New("upload is being written by another request")
+	// ErrIncomplete is returned when completing an upload that has not received every byte.
+	ErrIncomplete = errors.New("upload is not complete")
+)
+
+// Info is the state of an upload.
+type Info struct {
+	ID          string            `json:"id"`
+	UserID      int               `json:"user_id"`
+	Length      int64             `json:"length"`
+	Offset      int64             `json:"offset"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Chunks      []int64           `json:"chunks,omitempty"`       // offsets of the stored chunks, ascending
+	Completed   bool              `json:"completed"`              // the chunks have been concatenated and removed
+	ContentType string            `json:"content_type,omitempty"` // detected from the content by Complete
+	SHA256      string            `json:"sha256,omitempty"`       // hex encoded hash of the content, computed by Complete
+	FileID      int               `json:"file_id,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
+
+// Done reports whether every byte of the upload has been received.
+func (i *Info) Done() bool {
+	return i.Offset == i.Length
+}
+
+// Manager creates uploads, stores their chunks and completes them. Requests
+// writing to the same upload are serialized within one process; a second
+// concurrent request gets ErrLocked.
+type Manager struct {
+	Storage      storage.Blob
+	MaxSize      int64         // largest upload, DefaultMaxSize when 0
+	MaxChunkSize int64         // largest chunk, kept in memory while it is received; DefaultMaxChunkSize when 0
+	Expiry       time.Duration // uploads are removed after Expiry without a new chunk; DefaultExpiry when 0
+
+	now  func() time.Time
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewManager returns a Manager with the default limits.
+func NewManager(s storage.Blob) *Manager {
+	return &Manager{
+		Storage:      s,
+		MaxSize:      DefaultMaxSize,
+		MaxChunkSize: DefaultMaxChunkSize,
+		Expiry:       DefaultExpiry,
+	}
+}
+
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func infoKey(id string) string {
+	return "uploads/" + id + "/info.json"
+}
+
+func chunkKey(id string, offset int64) string {
+	return fmt.Sprintf("uploads/%s/chunk-%020d", id, offset)
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func (m *Manager) maxSize() int64 {
+	if m.MaxSize > 0 {
+		return m.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+func (m *Manager) maxChunkSize() int64 {
+	if m.MaxChunkSize > 0 {
+		return m.MaxChunkSize
+	}
+	return DefaultMaxChunkSize
+}
+
+func (m *Manager) expiry() time.Duration {
+	if m.Expiry > 0 {
+		return m.Expiry
+	}
+	return DefaultExpiry
+}
+
+// lock marks the upload as being written, returning false when it already is.
+func (m *Manager) lock(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.busy == nil {
+		m.busy = make(map[string]bool)
+	}
+	if m.busy[id] {
+		return false
+	}
+	m.busy[id] = true
+	return true
+}
+
+func (m *Manager) unlock(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.busy, id)
+}
+
+// Create starts a new upload of length bytes for the user.
+func (m *Manager) Create(ctx context.Context, userID int, length int64, metadata map[string]string) (*Info, error) {
+	if length < 0 {
+		return nil, ErrInvalidLength
+	}
+	if length > m.maxSize() {
+		return nil, ErrTooLarge
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := m.clock()
+	info := &Info{
+		ID:        hex.EncodeToString(b),
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.expiry()),
+	}
+
+	if err := m.save(ctx, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Get returns the state of an upload.
+func (m *Manager) Get(ctx context.Context, id string) (*Info, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+
+	rc, err := m.Storage.Get(ctx, infoKey(id))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var info Info
+	if err := json.NewDecoder(rc).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	if m.clock().After(info.ExpiresAt) {
+		return &info, ErrExpired
+	}
+
+	return &info, nil
+}
+
+func (m *Manager) save(ctx context.Context, info *Info) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return m.Storage.Put(ctx, infoKey(info.ID), bytes.NewReader(b), int64(len(b)), "application/json")
+}
+
+// WriteChunk stores the bytes read from r at offset, which must be the
+// current offset of the upload. When reading r fails part way, for example
+// because the client went away, the bytes received so far are kept so that
+// the client can resume from there, and the read error is returned with the
+// updated state.
+func (m *Manager) WriteChunk(ctx context.Context, id string, offset int64, r io.Reader) (*Info, error) {
+	if !m.lock(id) {
+		return nil, ErrLocked
+	}
+	defer m.unlock(id)
+
+	info, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Completed || offset != info.Offset {
+		return info, ErrOffsetMismatch
+	}
+
+	remaining := info.Length - info.Offset
+	limit := remaining
+	if limit > m.maxChunkSize() {
+		limit = m.maxChunkSize()
+	}
+
+	chunk, readErr := io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(chunk)) > limit {
+		if limit == remaining {
+			return info, ErrExceedsLength
+		}
+		return info, ErrChunkTooLarge
+	}
+
+	if len(chunk) > 0 {
+		err = m.Storage.Put(ctx, chunkKey(id, info.Offset), bytes.NewReader(chunk), int64(len(chunk)), "application/offset+octet-stream")
+		if err != nil {
+			return info, err
+		}
+
+		info.Chunks = append(info.Chunks, info.Offset)
+		info.Offset += int64(len(chunk))
+		info.ExpiresAt = m.clock().Add(m.expiry())
+
+		if err := m.save(ctx, info); err != nil {
+			return info, err
+		}
+	}
+
+	return info, readErr
+}
+
+// Complete concatenates the chunks of a finished upload into the object key
+// and calls finish, which typically records the file in the database and may
+// set info.FileID. The content type is detected from the first bytes of the
+// file; the type claimed by the client is not trusted. The SHA-256 of the
+// content is available to finish in info.SHA256. The chunks are removed
+// only when finish succeeds; otherwise the object is deleted again and
+// Complete can be retried. Completing an upload twice does not call finish again.
+func (m *Manager) Complete(ctx context.Context, id, key string, finish func(info *Info) error) (*Info, error) {
+	if !m.lock(id) {
+		return nil, ErrLocked
+	}
+	defer m.unlock(id)
+
+	info, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Completed {
+		return info, nil
+	}
+	if !info.Done() {
+		return info, ErrIncomplete
+	}
+
+	rc := &chunkReader{ctx: ctx, storage: m.Storage, id: id, offsets: info.Chunks}
+	defer rc.Close()
+
+	// http.DetectContentTypeは先頭512バイトまでしか見ない
+	br := bufio.NewReaderSize(rc, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return info, err
+	}
+	info.ContentType = http.DetectContentType(head)
+
+	hash := sha256.New()
+	err = m.Storage.Put(ctx, key, io.TeeReader(br, hash), info.Length, info.ContentType)
+	if err != nil {
+		return info, err
+	}
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	if err := finish(info); err != nil {
+		_ = m.Storage.Delete(ctx, key)
+		return info, err
+	}
+
+	for _, offset := range info.Chunks {
+		if err := m.Storage.Delete(ctx, chunkKey(id, offset)); err != nil {
+			return info, err
+		}
+	}
+
+	// 完了した状態を有効期限まで残し、再開したクライアントが完了を確認できるようにする
+	info.Chunks = nil
+	info.Completed = true
+	info.ExpiresAt = m.clock().Add(m.expiry())
+
+	return info, m.save(ctx, info)
+}
+
+// Terminate removes an upload and its chunks.
+func (m *Manager) Terminate(ctx context.Context, id string) error {
+	if !idPattern.MatchString(id) {
+		return ErrNotFound
+	}
+	if !m.lock(id) {
+		return ErrLocked
+	}
+	defer m.unlock(id)
+
+	return m.remove(ctx, id)
+}
+
+// remove deletes every object of the upload, the info object last so that an
+// interrupted removal is picked up again by Expire.
+func (m *Manager) remove(ctx context.Context, id string) error {
+	objects, err := m.Storage.List(ctx, "uploads/"+id+"/")
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		if o.Key == infoKey(id) {
+			continue
+		}
+		if err := m.Storage.Delete(ctx, o.Key); err != nil {
+			return err
+		}
+	}
+
+	return m.Storage.Delete(ctx, infoKey(id))
+}
+
+// Expire removes the uploads that have expired, and chunks left without an
+// info object for longer than the expiry. It returns the number of uploads removed.
+func (m *Manager) Expire(ctx context.Context) (int, error) {
+	objects, err := m.Storage.List(ctx, "uploads/")
+	if err != nil {
+		return 0, err
+	}
+
+	// アップロードごとに、info.jsonの有無と最後に更新された時刻をまとめる
+	type upload struct {
+		hasInfo bool
+		modTime time.Time
+	}
+	uploads := make(map[string]*upload)
+	var ids []string
+	for _, o := range objects {
+		id, name, ok := strings.Cut(strings.TrimPrefix(o.Key, "uploads/"), "/")
+		if !ok {
+			continue
+		}
+		u, seen := uploads[id]
+		if !seen {
+			u = &upload{}
+			uploads[id] = u
+			ids = append(ids, id)
+		}
+		if name == "info.json" {
+			u.hasInfo = true
+		}
+		if o.ModTime.After(u.modTime) {
+			u.modTime = o.ModTime
+		}
+	}
+
+	removed := 0
+	for _, id := range ids {
+		u := uploads[id]
+
+		expired := false
+		if u.hasInfo {
+			_, err := m.Get(ctx, id)
+			expired = errors.Is(err, ErrExpired)
+		} else {
+			expired = m.clock().Sub(u.modTime) > m.expiry()
+		}
+		if !expired || !m.lock(id) {
+			continue
+		}
+
+		err := m.remove(ctx, id)
+		m.unlock(id)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// chunkReader reads the chunks of an upload one after the other.
+type chunkReader struct {
+	ctx     context.Context
+	storage storage.Blob
+	id      string
+	offsets []int64
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.offsets) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := c.storage.Get(c.ctx, chunkKey(c.id, c.offsets[0]))
+			if err != nil {
+				return 0, err
+			}
+			c.current, c.offsets = rc, c.offsets[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
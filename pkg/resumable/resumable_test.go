@@ -0,0 +1,355 @@
+package resumable
+
+import (
+	"context"
+	"errors"
+	"go_test_prac/webApp/pkg/storage"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a Manager storing uploads in a temporary directory,
+// with a clock that the test can move forward.
+func newTestManager(t *testing.T) (*Manager, *time.Time) {
+	t.Helper()
+	s, err := storage.NewLocal(t.TempDir(), "/files/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	m := NewManager(s)
+	m.MaxSize = 100
+	m.MaxChunkSize = 10
+	m.Expiry = time.Hour
+	m.now = func() time.Time { return now }
+
+	return m, &now
+}
+
+func readObject(t *testing.T, s storage.Blob, key string) string {
+	t.Helper()
+	rc, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %s", key, err)
+	}
+	defer rc.Close()
+	b, _ := io.ReadAll(rc)
+	return string(b)
+}
+
+func TestManager_Create(t *testing.T) {
+	m, now := newTestManager(t)
+	ctx := context.Background()
+
+	var tests = []struct {
+		name        string
+		length      int64
+		expectedErr error
+	}{
+		{"empty", 0, nil},
+		{"max size", 100, nil},
+		{"too large", 101, ErrTooLarge},
+		{"negative", -1, ErrInvalidLength},
+	}
+
+	for _, e := range tests {
+		info, err := m.Create(ctx, 1, e.length, map[string]string{"filename": "a.txt"})
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if !idPattern.MatchString(info.ID) || info.UserID != 1 || info.Length != e.length || info.Offset != 0 {
+			t.Errorf("%s: unexpected info %+v", e.name, info)
+		}
+		if !info.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("%s: expected expiry %s but got %s", e.name, now.Add(time.Hour), info.ExpiresAt)
+		}
+
+		got, err := m.Get(ctx, info.ID)
+		if err != nil {
+			t.Errorf("%s: get: %s", e.name, err)
+			continue
+		}
+		if got.Metadata["filename"] != "a.txt" {
+			t.Errorf("%s: metadata not stored, got %+v", e.name, got)
+		}
+	}
+}
+
+func TestManager_Get(t *testing.T) {
+	m, now := newTestManager(t)
+	ctx := context.Background()
+
+	info, _ := m.Create(ctx, 1, 10, nil)
+
+	var tests = []struct {
+		name        string
+		id          string
+		expectedErr error
+	}{
+		{"existing", info.ID, nil},
+		{"unknown", strings.Repeat("0", 32), ErrNotFound},
+		{"invalid id", "../info", ErrNotFound},
+	}
+
+	for _, e := range tests {
+		if _, err := m.Get(ctx, e.id); !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+	}
+
+	*now = now.Add(2 * time.Hour)
+	if _, err := m.Get(ctx, info.ID); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired but got %v", err)
+	}
+}
+
+func TestManager_WriteChunk(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	info, _ := m.Create(ctx, 1, 25, nil)
+
+	var tests = []struct {
+		name           string
+		offset         int64
+		chunk          string
+		expectedErr    error
+		expectedOffset int64
+	}{
+		{"first chunk", 0, "0123456789", nil, 10},
+		{"wrong offset", 0, "0123456789", ErrOffsetMismatch, 10},
+		{"chunk too large", 10, "0123456789a", ErrChunkTooLarge, 10},
+		{"empty chunk", 10, "", nil, 10},
+		{"second chunk", 10, "abcdefghij", nil, 20},
+		{"past the length", 20, "ABCDEF", ErrExceedsLength, 20},
+		{"last chunk", 20, "ABCDE", nil, 25},
+		{"after the end", 25, "x", ErrExceedsLength, 25},
+	}
+
+	for _, e := range tests {
+		got, err := m.WriteChunk(ctx, info.ID, e.offset, strings.NewReader(e.chunk))
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if got == nil || got.Offset != e.expectedOffset {
+			t.Errorf("%s: expected offset %d but got %+v", e.name, e.expectedOffset, got)
+		}
+	}
+
+	info, _ = m.Get(ctx, info.ID)
+	if !info.Done() || len(info.Chunks) != 3 {
+		t.Errorf("expected a finished upload of 3 chunks, got %+v", info)
+	}
+}
+
+// brokenReader returns some bytes and then fails, like a dropped connection.
+type brokenReader struct {
+	data string
+	done bool
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b.done = true
+	return copy(p, b.data), nil
+}
+
+func TestManager_WriteChunk_interrupted(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	info, _ := m.Create(ctx, 1, 10, nil)
+
+	got, err := m.WriteChunk(ctx, info.ID, 0, &brokenReader{data: "0123"})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected the read error to be returned, got %v", err)
+	}
+	if got.Offset != 4 {
+		t.Errorf("expected the received bytes to be kept, got offset %d", got.Offset)
+	}
+
+	// 受け取った位置から再開できる
+	got, err = m.WriteChunk(ctx, info.ID, 4, strings.NewReader("456789"))
+	if err != nil || !got.Done() {
+		t.Errorf("resuming failed: %+v, %v", got, err)
+	}
+}
+
+func TestManager_WriteChunk_locked(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	info, _ := m.Create(ctx, 1, 10, nil)
+
+	m.lock(info.ID)
+	if _, err := m.WriteChunk(ctx, info.ID, 0, strings.NewReader("0123")); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked but got %v", err)
+	}
+	m.unlock(info.ID)
+
+	if _, err := m.WriteChunk(ctx, info.ID, 0, strings.NewReader("0123")); err != nil {
+		t.Errorf("unexpected error after unlocking: %s", err)
+	}
+}
+
+func TestManager_Complete(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	info, _ := m.Create(ctx, 1, 15, nil)
+	_, _ = m.WriteChunk(ctx, info.ID, 0, strings.NewReader("0123456789"))
+
+	finish := func(i *Info) error {
+		i.FileID = 7
+		return nil
+	}
+
+	if _, err := m.Complete(ctx, info.ID, "files/1/digits.txt", finish); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("expected ErrIncomplete but got %v", err)
+	}
+
+	_, _ = m.WriteChunk(ctx, info.ID, 10, strings.NewReader("abcde"))
+
+	// finishが失敗した場合は、結合したファイルを削除してチャンクを残す
+	failing := func(i *Info) error { return errors.New("db is down") }
+	if _, err := m.Complete(ctx, info.ID, "files/1/digits.txt", failing); err == nil {
+		t.Error("expected the error of finish")
+	}
+	if _, err := m.Storage.Stat(ctx, "files/1/digits.txt"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected the object to be removed after a failed finish, got %v", err)
+	}
+
+	got, err := m.Complete(ctx, info.ID, "files/1/digits.txt", finish)
+	if err != nil {
+		t.Fatalf("complete: %s", err)
+	}
+	if !got.Completed || got.FileID != 7 || got.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected info %+v", got)
+	}
+	// sha256("0123456789abcde")
+	if got.SHA256 != "027687e87ab072c778c8f2e66177fb78c6aa19952987b97b3140b68ebd5f90ea" {
+		t.Errorf("unexpected hash %s", got.SHA256)
+	}
+	if content := readObject(t, m.Storage, "files/1/digits.txt"); content != "0123456789abcde" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	objects, _ := m.Storage.List(ctx, "uploads/"+info.ID+"/")
+	if len(objects) != 1 || objects[0].Key != infoKey(info.ID) {
+		t.Errorf("expected only the info object to remain, got %+v", objects)
+	}
+
+	// 2回目はfinishを呼ばない
+	called := false
+	got, err = m.Complete(ctx, info.ID, "files/1/other.txt", func(i *Info) error {
+		called = true
+		return nil
+	})
+	if err != nil || called || got.FileID != 7 {
+		t.Errorf("completing twice should be a no-op, got %+v, %v, called %t", got, err, called)
+	}
+	if _, err := m.WriteChunk(ctx, info.ID, 15, strings.NewReader("")); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("expected ErrOffsetMismatch when writing to a completed upload, got %v", err)
+	}
+}
+
+func TestManager_Terminate(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	info, _ := m.Create(ctx, 1, 15, nil)
+	_, _ = m.WriteChunk(ctx, info.ID, 0, strings.NewReader("0123456789"))
+
+	if err := m.Terminate(ctx, info.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Get(ctx, info.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after terminate, got %v", err)
+	}
+	objects, _ := m.Storage.List(ctx, "uploads/")
+	if len(objects) != 0 {
+		t.Errorf("expected no objects left, got %+v", objects)
+	}
+
+	if err := m.Terminate(ctx, "../../photo"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an invalid id, got %v", err)
+	}
+}
+
+func TestManager_Expire(t *testing.T) {
+	m, now := newTestManager(t)
+	ctx := context.Background()
+
+	abandoned, _ := m.Create(ctx, 1, 15, nil)
+	_, _ = m.WriteChunk(ctx, abandoned.ID, 0, strings.NewReader("0123456789"))
+
+	*now = now.Add(50 * time.Minute)
+	active, _ := m.Create(ctx, 1, 15, nil)
+
+	// info.jsonのないチャンクは、ファイルの更新時刻で判断する
+	orphan := "uploads/" + strings.Repeat("f", 32) + "/chunk-00000000000000000000"
+	_ = m.Storage.Put(ctx, orphan, strings.NewReader("x"), 1, "")
+
+	*now = now.Add(20 * time.Minute)
+	removed, err := m.Expire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 upload to be removed but got %d", removed)
+	}
+	if _, err := m.Get(ctx, abandoned.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the abandoned upload to be removed, got %v", err)
+	}
+	if _, err := m.Get(ctx, active.ID); err != nil {
+		t.Errorf("expected the active upload to be kept, got %v", err)
+	}
+
+	// 実際の時刻で1時間以上経過したことにする
+	*now = time.Now().Add(2 * time.Hour)
+	removed, err = m.Expire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("expected the active upload and the orphan to be removed, got %d", removed)
+	}
+	objects, _ := m.Storage.List(ctx, "uploads/")
+	if len(objects) != 0 {
+		t.Errorf("expected no objects left, got %+v", objects)
+	}
+}
+
+func Test_chunkReader(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	for offset, chunk := range map[int64]string{0: "abc", 3: "abc", 6: "defg"} {
+		_ = m.Storage.Put(ctx, chunkKey("id", offset), strings.NewReader(chunk), int64(len(chunk)), "")
+	}
+
+	rc := &chunkReader{ctx: ctx, storage: m.Storage, id: "id", offsets: []int64{0, 3, 6}}
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "abcabcdefg" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	rc = &chunkReader{ctx: ctx, storage: m.Storage, id: "id", offsets: []int64{0, 99}}
+	if _, err := io.ReadAll(rc); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing chunk, got %v", err)
+	}
+}
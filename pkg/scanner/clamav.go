@@ -0,0 +1,166 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultChunkSize is the size of the chunks streamed to clamd. It must stay
+// below the StreamMaxLength setting of clamd.
+const DefaultChunkSize = 64 * 1024
+
+// DefaultTimeout limits a whole scan, including sending the file.
+const DefaultTimeout = time.Minute
+
+// ClamAV scans files with a clamd daemon using the INSTREAM command of its
+// protocol (see clamd(8)). Files are streamed, so clamd does not need access
+// to the storage.
+type ClamAV struct {
+	Network   string // "tcp" or "unix"
+	Address   string // e.g. "localhost:3310" or "/run/clamav/clamd.ctl"
+	Timeout   time.Duration
+	ChunkSize int
+}
+
+// NewClamAV returns a client for the clamd listening at address, either a
+// host:port pair or a unix socket written as "unix:/path/to/clamd.ctl".
+func NewClamAV(address string) *ClamAV {
+	c := &ClamAV{Network: "tcp", Address: address, Timeout: DefaultTimeout, ChunkSize: DefaultChunkSize}
+	if strings.HasPrefix(address, "unix:") {
+		c.Network = "unix"
+		c.Address = strings.TrimPrefix(address, "unix:")
+	}
+	return c
+}
+
+// Ping checks that clamd is reachable.
+func (c *ClamAV) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply to PING: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd and parses its verdict. Files clamd refuses to scan
+// completely, because they exceed its size limit, are reported as quarantined.
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// command sends a null terminated command, followed by the content of body as
+// length prefixed chunks when body is not nil, and returns the reply.
+func (c *ClamAV) command(ctx context.Context, name string, body io.Reader) (string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	// "z"で始まるコマンドは、応答もnull終端になる
+	if _, err := conn.Write([]byte("z" + name + "\x00")); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+
+	if body != nil {
+		if err := c.stream(conn, body); err != nil {
+			// 上限を超えるとclamdは応答を返して接続を切るので、書き込みの失敗なら応答を読んでみる
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				if reply, readErr := readReply(conn); readErr == nil {
+					return reply, nil
+				}
+			}
+			return "", fmt.Errorf("clamd: %w", err)
+		}
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	return reply, nil
+}
+
+// stream writes body as chunks prefixed with their length as a 4 byte big
+// endian integer, terminated by an empty chunk.
+func (c *ClamAV) stream(w io.Writer, body io.Reader) error {
+	size := c.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	buf := make([]byte, 4+size)
+	for {
+		n, err := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := w.Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// readReply reads a null terminated reply.
+func readReply(r io.Reader) (string, error) {
+	reply, err := bufio.NewReader(r).ReadBytes(0)
+	if err != nil && !(errors.Is(err, io.EOF) && len(reply) > 0) {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply turns the reply to INSTREAM into a result. Replies look like
+// "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR".
+func parseReply(reply string) (Result, error) {
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(reply, " FOUND")
+		if i := strings.Index(signature, ": "); i >= 0 {
+			signature = signature[i+2:]
+		}
+		return Result{Status: Infected, Signature: signature}, nil
+	case strings.HasSuffix(reply, ": OK"):
+		return Result{Status: Clean}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return Result{Status: Quarantined}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
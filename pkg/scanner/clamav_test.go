@@ -0,0 +1,175 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the EICAR anti-malware test file, which every scanner detects.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd is an in-process server speaking the parts of the clamd protocol
+// used by ClamAV. It finds the EICAR test file and refuses streams longer than
+// maxLength, like StreamMaxLength of clamd.
+type fakeClamd struct {
+	listener  net.Listener
+	maxLength int
+	scanned   [][]byte
+}
+
+func newFakeClamd(t *testing.T, maxLength int) *fakeClamd {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeClamd{listener: l, maxLength: maxLength}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch command {
+	case "zPING\x00":
+		_, _ = conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var content bytes.Buffer
+		for {
+			var length uint32
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return
+			}
+			if length == 0 {
+				break
+			}
+			if content.Len()+int(length) > f.maxLength {
+				_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+			if _, err := io.CopyN(&content, r, int64(length)); err != nil {
+				return
+			}
+		}
+		f.scanned = append(f.scanned, content.Bytes())
+
+		if bytes.Contains(content.Bytes(), []byte(eicar)) {
+			_, _ = conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			return
+		}
+		_, _ = conn.Write([]byte("stream: OK\x00"))
+	default:
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamAV_Scan(t *testing.T) {
+	clamd := newFakeClamd(t, 1024)
+	c := NewClamAV(clamd.listener.Addr().String())
+	c.ChunkSize = 16 // 複数のチャンクに分けて送る
+
+	var tests = []struct {
+		name              string
+		content           string
+		expectedStatus    Status
+		expectedSignature string
+	}{
+		{"clean", "just a text file", Clean, ""},
+		{"empty", "", Clean, ""},
+		{"infected", "prefix " + eicar + " suffix", Infected, "Eicar-Signature"},
+		{"too large", strings.Repeat("a", 2000), Quarantined, ""},
+	}
+
+	for _, e := range tests {
+		result, err := c.Scan(context.Background(), strings.NewReader(e.content))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+		if result.Status != e.expectedStatus || result.Signature != e.expectedSignature {
+			t.Errorf("%s: expected %s %q but got %+v", e.name, e.expectedStatus, e.expectedSignature, result)
+		}
+	}
+
+	if len(clamd.scanned) == 0 || string(clamd.scanned[0]) != "just a text file" {
+		t.Errorf("the content did not reach clamd intact: %q", clamd.scanned)
+	}
+}
+
+func TestClamAV_Ping(t *testing.T) {
+	clamd := newFakeClamd(t, 1024)
+
+	if err := NewClamAV(clamd.listener.Addr().String()).Ping(context.Background()); err != nil {
+		t.Errorf("ping: %s", err)
+	}
+}
+
+func TestClamAV_Unavailable(t *testing.T) {
+	// 接続できないアドレス
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := l.Addr().String()
+	l.Close()
+
+	c := NewClamAV(address)
+	c.Timeout = time.Second
+	if _, err := c.Scan(context.Background(), strings.NewReader("content")); err == nil {
+		t.Error("expected an error when clamd is not running")
+	}
+}
+
+func TestNewClamAV(t *testing.T) {
+	c := NewClamAV("unix:/run/clamav/clamd.ctl")
+	if c.Network != "unix" || c.Address != "/run/clamav/clamd.ctl" {
+		t.Errorf("unexpected unix socket client %+v", c)
+	}
+
+	c = NewClamAV("localhost:3310")
+	if c.Network != "tcp" || c.Address != "localhost:3310" {
+		t.Errorf("unexpected tcp client %+v", c)
+	}
+}
+
+func Test_parseReply(t *testing.T) {
+	var tests = []struct {
+		reply          string
+		expectedStatus Status
+		expectedErr    bool
+	}{
+		{"stream: OK", Clean, false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", Infected, false},
+		{"INSTREAM size limit exceeded. ERROR", Quarantined, false},
+		{"stream: Can't allocate memory ERROR", "", true},
+	}
+
+	for _, e := range tests {
+		result, err := parseReply(e.reply)
+		if (err != nil) != e.expectedErr || result.Status != e.expectedStatus {
+			t.Errorf("%q: unexpected result %+v, %v", e.reply, result, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package scanner checks uploaded files for malware before they are recorded.
+// Uploads call an UploadScanner; ClamAV talks to a clamd daemon and Nop
+// accepts everything when no scanner is configured. Files that could not be
+// scanned are rejected like infected ones, so an upload is never stored
+// without a verdict.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/requestlog"
+	"io"
+)
+
+// Status is the outcome of a scan, stored with the image or file record.
+type Status string
+
+const (
+	// Clean files were scanned and nothing was found.
+	Clean Status = "clean"
+	// Infected files contain malware and are rejected.
+	Infected Status = "infected"
+	// Quarantined files could not be scanned completely, e.g. because the
+	// scanner was unavailable or the file exceeded its limits. Check rejects
+	// them; records from before may still carry this status.
+	Quarantined Status = "quarantined"
+)
+
+var (
+	// ErrInfected is returned by Check when malware was found.
+	ErrInfected = errors.New("the uploaded file contains malware and was rejected")
+	// ErrUnavailable is returned by Check when the scanner failed. The same
+	// file can be uploaded again once the scanner is back.
+	ErrUnavailable = errors.New("the uploaded file could not be scanned for malware, try again later")
+	// ErrTooLargeToScan is returned by Check when the scanner refused to scan
+	// the whole file, e.g. because it exceeded the size limit of clamd.
+	ErrTooLargeToScan = errors.New("the uploaded file is too large to be scanned for malware")
+)
+
+// Result is what a scanner found in a file.
+type Result struct {
+	Status    Status
+	Signature string // name of the malware when Status is Infected
+}
+
+// UploadScanner scans the content of an upload.
+type UploadScanner interface {
+	// Scan reads r and reports what it found. An error means that the
+	// scanner could not give a verdict.
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// Nop is an UploadScanner that reports every file as clean. It is used when
+// no scanner is configured.
+type Nop struct{}
+
+// Scan reports the content as clean without reading it.
+func (Nop) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{Status: Clean}, nil
+}
+
+// New returns a ClamAV client for the clamd at address, or Nop when address
+// is empty.
+func New(address string) UploadScanner {
+	if address == "" {
+		return Nop{}
+	}
+	return NewClamAV(address)
+}
+
+// Check scans r with s and returns the status to record, which is Clean
+// unless there is an error. Infected files return an error wrapping
+// ErrInfected that names the malware. When the scanner fails the upload is
+// rejected with ErrUnavailable, and files the scanner could not scan
+// completely with ErrTooLargeToScan.
+func Check(ctx context.Context, s UploadScanner, r io.Reader) (Status, error) {
+	if s == nil {
+		s = Nop{}
+	}
+
+	result, err := s.Scan(ctx, r)
+	if err != nil {
+		// 検査できなかったファイルは受け付けない。原因はクライアントに見せずログに残す
+		requestlog.FromContext(ctx).Warn("scanning upload failed, rejecting the file", "err", err)
+		return Quarantined, ErrUnavailable
+	}
+
+	switch result.Status {
+	case Infected:
+		return Infected, fmt.Errorf("%w (%s)", ErrInfected, result.Signature)
+	case Quarantined:
+		return Quarantined, ErrTooLargeToScan
+	}
+
+	return result.Status, nil
+}
@@ -0,0 +1,64 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// scannerFunc adapts a function to UploadScanner for the tests of Check.
+type scannerFunc func(ctx context.Context, r io.Reader) (Result, error)
+
+func (f scannerFunc) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return f(ctx, r)
+}
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		name           string
+		scanner        UploadScanner
+		expectedStatus Status
+		expectedErr    error
+	}{
+		{"no scanner", nil, Clean, nil},
+		{"clean", scannerFunc(func(context.Context, io.Reader) (Result, error) { return Result{Status: Clean}, nil }), Clean, nil},
+		{"infected", scannerFunc(func(context.Context, io.Reader) (Result, error) {
+			return Result{Status: Infected, Signature: "Eicar-Signature"}, nil
+		}), Infected, ErrInfected},
+		{"scanner down", scannerFunc(func(context.Context, io.Reader) (Result, error) { return Result{}, errors.New("connection refused") }), Quarantined, ErrUnavailable},
+		{"too large", scannerFunc(func(context.Context, io.Reader) (Result, error) { return Result{Status: Quarantined}, nil }), Quarantined, ErrTooLargeToScan},
+	}
+
+	for _, e := range tests {
+		status, err := Check(context.Background(), e.scanner, strings.NewReader("content"))
+		if status != e.expectedStatus || !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %s, %v but got %s, %v", e.name, e.expectedStatus, e.expectedErr, status, err)
+		}
+	}
+
+	_, err := Check(context.Background(), scannerFunc(func(context.Context, io.Reader) (Result, error) {
+		return Result{Status: Infected, Signature: "Eicar-Signature"}, nil
+	}), strings.NewReader(""))
+	if err == nil || !strings.Contains(err.Error(), "Eicar-Signature") {
+		t.Errorf("expected the error to name the malware, got %v", err)
+	}
+
+	// 接続先などの内部の情報はクライアントに返すエラーに含めない
+	_, err = Check(context.Background(), scannerFunc(func(context.Context, io.Reader) (Result, error) {
+		return Result{}, errors.New("clamd: dial tcp 10.0.0.5:3310: connection refused")
+	}), strings.NewReader(""))
+	if err == nil || strings.Contains(err.Error(), "10.0.0.5") {
+		t.Errorf("expected an error without the details of the scanner, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, ok := New("").(Nop); !ok {
+		t.Error("expected Nop without an address")
+	}
+	if c, ok := New("localhost:3310").(*ClamAV); !ok || c.Address != "localhost:3310" {
+		t.Errorf("expected a ClamAV client, got %#v", c)
+	}
+}
@@ -0,0 +1,146 @@
+package secheaders
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"go_test_prac/webApp/pkg/requestlog"
+)
+
+// maxReportSize limits the body of violation reports. A report is well below
+// 1 KiB, and browsers send a few at a time.
+const maxReportSize = 16 << 10
+
+// Violation is a report of a blocked, or in report-only mode reported,
+// resource.
+type Violation struct {
+	DocumentURL        string
+	BlockedURL         string
+	EffectiveDirective string
+	Disposition        string
+	SourceFile         string
+	LineNumber         int
+	ColumnNumber       int
+	Sample             string
+}
+
+// cspReport is the body browsers send to report-uri.
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// report is an element of the body browsers send to report-to endpoints,
+// with the body of a csp-violation.
+type report struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// ParseReports reads the violations of a report-uri (application/csp-report)
+// or report-to (application/reports+json) request body.
+func ParseReports(contentType string, body io.Reader) ([]Violation, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	dec := json.NewDecoder(body)
+
+	if mediaType == "application/reports+json" {
+		var reports []report
+		if err := dec.Decode(&reports); err != nil {
+			return nil, err
+		}
+		var violations []Violation
+		for _, rep := range reports {
+			if rep.Type != "csp-violation" {
+				continue
+			}
+			b := rep.Body
+			violations = append(violations, Violation{
+				DocumentURL:        b.DocumentURL,
+				BlockedURL:         b.BlockedURL,
+				EffectiveDirective: b.EffectiveDirective,
+				Disposition:        b.Disposition,
+				SourceFile:         b.SourceFile,
+				LineNumber:         b.LineNumber,
+				ColumnNumber:       b.ColumnNumber,
+				Sample:             b.Sample,
+			})
+		}
+		return violations, nil
+	}
+
+	var rep cspReport
+	if err := dec.Decode(&rep); err != nil {
+		return nil, err
+	}
+	b := rep.Report
+	directive := b.EffectiveDirective
+	if directive == "" {
+		directive = b.ViolatedDirective
+	}
+	return []Violation{{
+		DocumentURL:        b.DocumentURI,
+		BlockedURL:         b.BlockedURI,
+		EffectiveDirective: directive,
+		Disposition:        b.Disposition,
+		SourceFile:         b.SourceFile,
+		LineNumber:         b.LineNumber,
+		ColumnNumber:       b.ColumnNumber,
+		Sample:             b.ScriptSample,
+	}}, nil
+}
+
+// ReportHandler logs the violation reports sent by browsers at warn level and
+// answers 204 No Content. Bodies over maxReportSize get 413 without being read.
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > maxReportSize {
+		http.Error(w, "report too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	violations, err := ParseReports(r.Header.Get("Content-Type"), http.MaxBytesReader(w, r.Body, maxReportSize))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		http.Error(w, "report too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid report", http.StatusBadRequest)
+		return
+	}
+
+	logger := requestlog.FromContext(r.Context())
+	for _, v := range violations {
+		logger.Warn("csp violation",
+			"document", v.DocumentURL,
+			"blocked", v.BlockedURL,
+			"directive", v.EffectiveDirective,
+			"disposition", v.Disposition,
+			"source", v.SourceFile,
+			"line", v.LineNumber,
+			"column", v.ColumnNumber,
+			"sample", v.Sample,
+		)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
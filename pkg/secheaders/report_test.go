@@ -0,0 +1,106 @@
+package secheaders
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go_test_prac/webApp/pkg/requestlog"
+)
+
+func TestParseReports(t *testing.T) {
+	var tests = []struct {
+		name            string
+		contentType     string
+		body            string
+		expectError     bool
+		expectCount     int
+		expectBlocked   string
+		expectDirective string
+	}{
+		{
+			"report-uri", "application/csp-report",
+			`{"csp-report":{"document-uri":"https://example.com/user/profile","blocked-uri":"inline","violated-directive":"script-src-elem","effective-directive":"script-src-elem","disposition":"enforce","line-number":12}}`,
+			false, 1, "inline", "script-src-elem",
+		},
+		{
+			"violated directive only", "application/csp-report",
+			`{"csp-report":{"document-uri":"https://example.com/","blocked-uri":"https://evil.com/x.js","violated-directive":"script-src"}}`,
+			false, 1, "https://evil.com/x.js", "script-src",
+		},
+		{
+			"report-to", "application/reports+json",
+			`[{"type":"csp-violation","url":"https://example.com/","body":{"documentURL":"https://example.com/","blockedURL":"https://evil.com/a.css","effectiveDirective":"style-src-elem","disposition":"report"}},{"type":"deprecation","body":{}}]`,
+			false, 1, "https://evil.com/a.css", "style-src-elem",
+		},
+		{"invalid", "application/csp-report", `{"csp-report":`, true, 0, "", ""},
+		{"invalid reports", "application/reports+json", `{}`, true, 0, "", ""},
+	}
+
+	for _, e := range tests {
+		violations, err := ParseReports(e.contentType, strings.NewReader(e.body))
+		if e.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error but got %v", e.name, err)
+			continue
+		}
+		if len(violations) != e.expectCount {
+			t.Errorf("%s: expected %d violations but got %d", e.name, e.expectCount, len(violations))
+			continue
+		}
+		if violations[0].BlockedURL != e.expectBlocked || violations[0].EffectiveDirective != e.expectDirective {
+			t.Errorf("%s: unexpected violation %+v", e.name, violations[0])
+		}
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	tooLarge := `{"csp-report":{"blocked-uri":"` + strings.Repeat("a", maxReportSize) + `"}}`
+
+	var tests = []struct {
+		name          string
+		body          string
+		unknownLength bool
+		expectStatus  int
+		expectLog     bool
+	}{
+		{"valid", `{"csp-report":{"blocked-uri":"inline","effective-directive":"script-src-elem"}}`, false, http.StatusNoContent, true},
+		{"invalid", `not json`, false, http.StatusBadRequest, false},
+		{"too large", tooLarge, false, http.StatusRequestEntityTooLarge, false},
+		{"too large without Content-Length", tooLarge, true, http.StatusRequestEntityTooLarge, false},
+	}
+
+	for _, e := range tests {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(e.body))
+		req = req.WithContext(requestlog.WithLogger(context.Background(), logger))
+		req.Header.Set("Content-Type", "application/csp-report")
+		if e.unknownLength {
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+
+		ReportHandler(rr, req)
+
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectStatus, rr.Code)
+		}
+		logged := strings.Contains(buf.String(), "csp violation")
+		if logged != e.expectLog {
+			t.Errorf("%s: expected logged %t but got %q", e.name, e.expectLog, buf.String())
+		}
+		if e.expectLog && !strings.Contains(buf.String(), "directive=script-src-elem") {
+			t.Errorf("%s: expected the directive in the log but got %q", e.name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package secheaders
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCSP(t *testing.T) {
+	var tests = []struct {
+		name        string
+		policy      string
+		expected    string
+		expectError bool
+	}{
+		{"empty", "", "", false},
+		{"single", "default-src 'self'", "default-src 'self'", false},
+		{"spaces and case", "  Default-Src   'self' ;img-src 'self' data: ; ", "default-src 'self'; img-src 'self' data:", false},
+		{"no sources", "upgrade-insecure-requests; sandbox", "upgrade-insecure-requests; sandbox", false},
+		{"repeated directive", "img-src 'self'; img-src data:", "img-src data:", false},
+		{"invalid name", "img_src 'self'", "", true},
+		{"comma", "img-src 'self', data:", "", true},
+	}
+
+	for _, e := range tests {
+		c, err := ParseCSP(e.policy)
+		if e.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error but got %v", e.name, err)
+			continue
+		}
+		if c.String() != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, c.String())
+		}
+	}
+}
+
+func TestCSP_With(t *testing.T) {
+	c, _ := ParseCSP("default-src 'self'; img-src 'self'")
+
+	replaced := c.With("img-src", "'self'", "https://s3.example.com")
+	if replaced.String() != "default-src 'self'; img-src 'self' https://s3.example.com" {
+		t.Errorf("unexpected policy %q", replaced.String())
+	}
+	appended := c.With("sandbox")
+	if appended.String() != "default-src 'self'; img-src 'self'; sandbox" {
+		t.Errorf("unexpected policy %q", appended.String())
+	}
+	if c.String() != "default-src 'self'; img-src 'self'" {
+		t.Errorf("expected With not to modify the policy but got %q", c.String())
+	}
+	if c.Without("img-src").String() != "default-src 'self'" {
+		t.Errorf("unexpected policy %q", c.Without("img-src").String())
+	}
+}
+
+func TestCSP_Header(t *testing.T) {
+	c, _ := ParseCSP("script-src 'self' 'nonce'; style-src 'nonce'")
+
+	if got := c.Header("abc"); got != "script-src 'self' 'nonce-abc'; style-src 'nonce-abc'" {
+		t.Errorf("unexpected header %q", got)
+	}
+	// ノンスがなければ何も許可しない
+	if got := c.Header(""); got != "script-src 'self'; style-src" {
+		t.Errorf("unexpected header %q", got)
+	}
+}
+
+func TestPolicy_Handler(t *testing.T) {
+	csp, _ := ParseCSP("default-src 'self'; script-src 'self' 'nonce'; frame-ancestors 'none'")
+
+	var tests = []struct {
+		name              string
+		policy            Policy
+		expectHeader      string
+		expectFrame       string
+		expectReportingTo bool
+	}{
+		{"enforced", Policy{CSP: csp}, "Content-Security-Policy", "DENY", false},
+		{"report only", Policy{CSP: csp, ReportOnly: true, ReportURI: "/csp-report"}, "Content-Security-Policy-Report-Only", "", true},
+		{"same origin frames", Policy{CSP: csp.With("frame-ancestors", "'self'")}, "Content-Security-Policy", "SAMEORIGIN", false},
+		{"other frames", Policy{CSP: csp.With("frame-ancestors", "https://a.example.com")}, "Content-Security-Policy", "", false},
+		{"no csp", Policy{}, "", "", false},
+	}
+
+	for _, e := range tests {
+		var nonce string
+		handler := e.policy.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = Nonce(r.Context())
+		}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		h := rr.Header()
+
+		if h.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: expected X-Content-Type-Options nosniff", e.name)
+		}
+		if h.Get("X-Frame-Options") != e.expectFrame {
+			t.Errorf("%s: expected X-Frame-Options %q but got %q", e.name, e.expectFrame, h.Get("X-Frame-Options"))
+		}
+		if nonce == "" {
+			t.Errorf("%s: expected a nonce in the context", e.name)
+		}
+
+		for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+			if name != e.expectHeader && h.Get(name) != "" {
+				t.Errorf("%s: expected no %s but got %q", e.name, name, h.Get(name))
+			}
+		}
+		if e.expectHeader == "" {
+			continue
+		}
+		value := h.Get(e.expectHeader)
+		if !strings.Contains(value, "'nonce-"+nonce+"'") {
+			t.Errorf("%s: expected %s to contain the nonce %q but got %q", e.name, e.expectHeader, nonce, value)
+		}
+		if e.expectReportingTo {
+			if !strings.Contains(value, "report-uri /csp-report; report-to csp") {
+				t.Errorf("%s: expected the report endpoints in %q", e.name, value)
+			}
+			if h.Get("Reporting-Endpoints") != `csp="/csp-report"` {
+				t.Errorf("%s: unexpected Reporting-Endpoints %q", e.name, h.Get("Reporting-Endpoints"))
+			}
+		}
+	}
+}
+
+func TestPolicy_Handler_nonceIsRandom(t *testing.T) {
+	csp, _ := ParseCSP("script-src 'nonce'")
+	handler := Policy{CSP: csp}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		value := rr.Header().Get("Content-Security-Policy")
+		if seen[value] {
+			t.Fatalf("expected a new nonce for each request but got %q twice", value)
+		}
+		seen[value] = true
+	}
+}
+
+func TestPolicy_Handler_routeOverride(t *testing.T) {
+	csp, _ := ParseCSP("default-src 'self'; script-src 'self' 'nonce'; frame-ancestors 'none'")
+	global := Policy{CSP: csp, ReportOnly: true, ReferrerPolicy: "no-referrer"}
+	route := Policy{CSP: csp.With("default-src", "'none'").With("sandbox")}
+
+	var inner string
+	handler := global.Handler(route.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = Nonce(r.Context())
+	})))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	h := rr.Header()
+
+	if h.Get("Content-Security-Policy-Report-Only") != "" {
+		t.Errorf("expected the route policy to replace the global one but got %q", h.Get("Content-Security-Policy-Report-Only"))
+	}
+	expected := "default-src 'none'; script-src 'self' 'nonce-" + inner + "'; frame-ancestors 'none'; sandbox"
+	if h.Get("Content-Security-Policy") != expected {
+		t.Errorf("expected %q but got %q", expected, h.Get("Content-Security-Policy"))
+	}
+	if h.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("expected X-Frame-Options DENY but got %q", h.Get("X-Frame-Options"))
+	}
+	// ルートのポリシーで設定しないヘッダーは全体のものが残る
+	if h.Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("expected the global Referrer-Policy but got %q", h.Get("Referrer-Policy"))
+	}
+}
+
+func TestPolicy_Handler_otherHeaders(t *testing.T) {
+	p := Policy{ReferrerPolicy: "strict-origin-when-cross-origin", PermissionsPolicy: "camera=(), geolocation=()"}
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Header().Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+		t.Errorf("unexpected Referrer-Policy %q", rr.Header().Get("Referrer-Policy"))
+	}
+	if rr.Header().Get("Permissions-Policy") != "camera=(), geolocation=()" {
+		t.Errorf("unexpected Permissions-Policy %q", rr.Header().Get("Permissions-Policy"))
+	}
+}
@@ -0,0 +1,154 @@
+// Package server runs the HTTP servers of cmd/web and cmd/api with timeouts
+// against slow clients, and shuts them down gracefully: on SIGINT or SIGTERM
+// the servers stop accepting connections and the requests in flight are given
+// a deadline to finish before the connections are closed.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Config holds the timeouts of the servers.
+type Config struct {
+	// ReadHeaderTimeout limits reading the request headers. It protects
+	// against slowloris clients that never finish their headers.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout limits reading the whole request, including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout limits writing the response.
+	WriteTimeout time.Duration
+	// IdleTimeout limits how long keep-alive connections wait for the next
+	// request.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of the request headers.
+	MaxHeaderBytes int
+
+	// ShutdownDelay keeps serving after the signal, while readiness already
+	// fails, so that load balancers stop sending requests first.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is the deadline for requests in flight; connections
+	// still open afterwards are closed.
+	ShutdownTimeout time.Duration
+}
+
+// Defaults for the flags of the servers.
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = time.Minute
+	DefaultWriteTimeout      = time.Minute
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultMaxHeaderBytes    = http.DefaultMaxHeaderBytes
+	DefaultShutdownTimeout   = 30 * time.Second
+)
+
+// New returns a server for handler on addr with the timeouts of c. Errors of
+// the server, such as failed TLS handshakes, are logged with slog.
+func (c Config) New(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// ErrForcedShutdown is returned by Run when requests were still running at
+// the shutdown deadline.
+var ErrForcedShutdown = errors.New("server: requests did not finish before the shutdown deadline")
+
+// Run serves every server until ctx is done, usually by a signal, or one of
+// them fails. It then calls onShutdown, e.g. to fail readiness, waits
+// ShutdownDelay and shuts the servers down within ShutdownTimeout. Servers
+// with a TLSConfig serve HTTPS.
+func (c Config) Run(ctx context.Context, onShutdown func(), servers ...*http.Server) error {
+	return c.run(ctx, onShutdown, servers, func(srv *http.Server) error {
+		// 証明書はTLSConfig.GetCertificateから取る
+		if srv.TLSConfig != nil {
+			return srv.ListenAndServeTLS("", "")
+		}
+		return srv.ListenAndServe()
+	})
+}
+
+// run takes the function starting a server, so that tests can use listeners
+// of their own.
+func (c Config) run(ctx context.Context, onShutdown func(), servers []*http.Server, serve func(*http.Server) error) error {
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			err := serve(srv)
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			if err != nil {
+				err = fmt.Errorf("serving %s: %w", srv.Addr, err)
+			}
+			errs <- err
+		}(srv)
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down", "deadline", c.ShutdownTimeout)
+	case serveErr = <-errs:
+		// 1つでも起動に失敗したら、他のサーバも止める
+	}
+
+	if onShutdown != nil {
+		onShutdown()
+	}
+	if serveErr == nil && c.ShutdownDelay > 0 {
+		time.Sleep(c.ShutdownDelay)
+	}
+
+	shutdownErr := c.shutdown(servers)
+	if serveErr != nil {
+		return serveErr
+	}
+	return shutdownErr
+}
+
+// shutdown stops the servers concurrently and closes the connections left at
+// the deadline.
+func (c Config) shutdown(servers []*http.Server) error {
+	ctx := context.Background()
+	if c.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ShutdownTimeout)
+		defer cancel()
+	}
+
+	var mu sync.Mutex
+	var forced bool
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				// 期限までに終わらなかったリクエストの接続を切る
+				srv.Close()
+				mu.Lock()
+				forced = true
+				mu.Unlock()
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	if forced {
+		return ErrForcedShutdown
+	}
+	return nil
+}
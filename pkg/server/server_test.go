@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_New(t *testing.T) {
+	c := Config{
+		ReadHeaderTimeout: time.Second,
+		ReadTimeout:       2 * time.Second,
+		WriteTimeout:      3 * time.Second,
+		IdleTimeout:       4 * time.Second,
+		MaxHeaderBytes:    1 << 10,
+	}
+	srv := c.New(":8080", http.NotFoundHandler())
+
+	if srv.Addr != ":8080" {
+		t.Errorf("expected addr :8080 but got %s", srv.Addr)
+	}
+	if srv.ReadHeaderTimeout != time.Second || srv.ReadTimeout != 2*time.Second || srv.WriteTimeout != 3*time.Second || srv.IdleTimeout != 4*time.Second {
+		t.Errorf("timeouts not set: %+v", srv)
+	}
+	if srv.MaxHeaderBytes != 1<<10 {
+		t.Errorf("expected max header bytes 1024 but got %d", srv.MaxHeaderBytes)
+	}
+	if srv.ErrorLog == nil {
+		t.Error("expected an error log")
+	}
+}
+
+// start runs c with srv on a free port and returns its URL and the result of
+// run.
+func start(t *testing.T, c Config, ctx context.Context, onShutdown func(), srv *http.Server) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.run(ctx, onShutdown, []*http.Server{srv}, func(srv *http.Server) error {
+			return srv.Serve(ln)
+		})
+	}()
+	return "http://" + ln.Addr().String(), done
+}
+
+func TestConfig_Run(t *testing.T) {
+	var tests = []struct {
+		name            string
+		shutdownTimeout time.Duration
+		handlerDelay    time.Duration
+		expectedErr     error
+		expectResponse  bool
+	}{
+		{"drains requests in flight", time.Second, 100 * time.Millisecond, nil, true},
+		{"closes requests at the deadline", 50 * time.Millisecond, time.Second, ErrForcedShutdown, false},
+	}
+
+	for _, e := range tests {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			select {
+			case <-time.After(e.handlerDelay):
+			case <-release:
+			}
+			io.WriteString(w, "done")
+		})
+
+		c := Config{ShutdownTimeout: e.shutdownTimeout}
+		ctx, cancel := context.WithCancel(context.Background())
+		shutdownCalled := false
+		url, done := start(t, c, ctx, func() { shutdownCalled = true }, c.New("", handler))
+
+		type result struct {
+			body string
+			err  error
+		}
+		responses := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(url)
+			if err != nil {
+				responses <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			responses <- result{string(b), err}
+		}()
+
+		<-started
+		// リクエストの処理中にシグナルを受けた場合
+		cancel()
+
+		err := <-done
+		close(release)
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.expectedErr, err)
+		}
+		if !shutdownCalled {
+			t.Errorf("%s: expected onShutdown to be called", e.name)
+		}
+
+		res := <-responses
+		if e.expectResponse && (res.err != nil || res.body != "done") {
+			t.Errorf("%s: expected the request to finish but got %q, %v", e.name, res.body, res.err)
+		}
+		if !e.expectResponse && res.err == nil {
+			t.Errorf("%s: expected the connection to be closed but got %q", e.name, res.body)
+		}
+	}
+}
+
+func TestConfig_Run_serveError(t *testing.T) {
+	// 使用中のアドレスで起動できない場合は、他のサーバも止めてエラーを返す
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	c := Config{ShutdownTimeout: time.Second}
+	other := c.New("127.0.0.1:0", http.NotFoundHandler())
+	busy := c.New(ln.Addr().String(), http.NotFoundHandler())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(context.Background(), nil, other, busy)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), ln.Addr().String()) {
+			t.Errorf("expected an error for %s but got %v", ln.Addr(), err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestConfig_Run_shutdownDelay(t *testing.T) {
+	c := Config{ShutdownDelay: 100 * time.Millisecond, ShutdownTimeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	url, done := start(t, c, ctx, nil, c.New("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})))
+
+	cancel()
+	// 遅延の間は新しいリクエストも受け付ける
+	time.Sleep(20 * time.Millisecond)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("expected requests to be served during the delay but got %v", err)
+	}
+	resp.Body.Close()
+
+	if err := <-done; err != nil {
+		t.Errorf("expected no error but got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"go_test_prac/webApp/pkg/certs"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS is the certificate of a server and how HTTPS is enforced.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is how often the certificate files are checked for
+	// changes; they are not reloaded when 0.
+	ReloadInterval time.Duration
+	// RedirectPort serves a redirect from plain HTTP to HTTPS; none when 0.
+	RedirectPort int
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header; it
+	// is not sent when 0.
+	HSTSMaxAge time.Duration
+}
+
+// EnableTLS makes srv serve HTTPS with the certificate of t, adds HSTS to its
+// responses and returns the server redirecting plain HTTP to it on port, or
+// nil. The certificate is reloaded until ctx is done when t asks for it.
+func (c Config) EnableTLS(ctx context.Context, t TLS, srv *http.Server, port int) (*http.Server, error) {
+	reloader, err := certs.NewReloader(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = reloader.TLSConfig()
+	srv.Handler = HSTS(t.HSTSMaxAge)(srv.Handler)
+	if t.ReloadInterval > 0 {
+		go reloader.Watch(ctx, t.ReloadInterval)
+	}
+
+	if t.RedirectPort == 0 {
+		return nil, nil
+	}
+	slog.Info("redirecting to https", "port", t.RedirectPort)
+	return c.New(fmt.Sprintf(":%d", t.RedirectPort), RedirectHTTPS(port)), nil
+}
+
+// RedirectHTTPS redirects plain HTTP requests to the same URL over HTTPS on
+// port. It is served on its own port, e.g. 80, next to the TLS server.
+func RedirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			// IPv6のアドレスは角括弧で囲む
+			host = "[" + host + "]"
+		}
+
+		// GET以外はメソッドとボディを保ったままリダイレクトさせる
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// HSTS tells browsers to use only HTTPS for maxAge, with the
+// Strict-Transport-Security header. The header is only sent on responses over
+// TLS, as browsers ignore it otherwise.
+func HSTS(maxAge time.Duration) func(http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil && maxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
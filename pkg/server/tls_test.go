@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"go_test_prac/webApp/pkg/certs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	var tests = []struct {
+		name             string
+		method           string
+		host             string
+		target           string
+		port             int
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"get", "GET", "example.com", "/users?page=2", 443, http.StatusMovedPermanently, "https://example.com/users?page=2"},
+		{"host with port", "GET", "example.com:80", "/", 443, http.StatusMovedPermanently, "https://example.com/"},
+		{"other https port", "GET", "localhost:8000", "/login", 8080, http.StatusMovedPermanently, "https://localhost:8080/login"},
+		{"ipv6", "GET", "[::1]:80", "/", 443, http.StatusMovedPermanently, "https://[::1]/"},
+		{"post keeps method", "POST", "example.com", "/login", 443, http.StatusPermanentRedirect, "https://example.com/login"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.target, nil)
+		req.Host = e.host
+		rr := httptest.NewRecorder()
+		RedirectHTTPS(e.port).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if got := rr.Header().Get("Location"); got != e.expectedLocation {
+			t.Errorf("%s: expected location %s but got %s", e.name, e.expectedLocation, got)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	var tests = []struct {
+		name     string
+		tls      bool
+		maxAge   time.Duration
+		expected string
+	}{
+		{"over tls", true, 365 * 24 * time.Hour, "max-age=31536000"},
+		{"plain http", false, 365 * 24 * time.Hour, ""},
+		{"disabled", true, 0, ""},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if e.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rr := httptest.NewRecorder()
+		HSTS(e.maxAge)(http.NotFoundHandler()).ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Strict-Transport-Security"); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestConfig_EnableTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := certs.WriteSelfSigned(certFile, keyFile, []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var tests = []struct {
+		name             string
+		tls              TLS
+		expectedRedirect bool
+	}{
+		{"without redirect", TLS{CertFile: certFile, KeyFile: keyFile, HSTSMaxAge: time.Hour}, false},
+		{"with redirect", TLS{CertFile: certFile, KeyFile: keyFile, HSTSMaxAge: time.Hour, RedirectPort: 8081, ReloadInterval: time.Minute}, true},
+	}
+
+	for _, e := range tests {
+		srv := Config{}.New(":8443", http.NotFoundHandler())
+		redirect, err := Config{}.EnableTLS(ctx, e.tls, srv, 8443)
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if srv.TLSConfig == nil || srv.TLSConfig.GetCertificate == nil {
+			t.Errorf("%s: expected the certificate to be served", e.name)
+		}
+
+		// HSTSはTLSの応答にだけ付く
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		if rr.Header().Get("Strict-Transport-Security") != "max-age=3600" {
+			t.Errorf("%s: expected HSTS but got %q", e.name, rr.Header().Get("Strict-Transport-Security"))
+		}
+
+		if (redirect != nil) != e.expectedRedirect {
+			t.Fatalf("%s: expected a redirect server %t but got %v", e.name, e.expectedRedirect, redirect)
+		}
+		if redirect == nil {
+			continue
+		}
+		if redirect.Addr != ":8081" {
+			t.Errorf("%s: expected the redirect on :8081 but got %s", e.name, redirect.Addr)
+		}
+		rr = httptest.NewRecorder()
+		redirect.Handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://localhost/login", nil))
+		if rr.Header().Get("Location") != "https://localhost:8443/login" {
+			t.Errorf("%s: expected a redirect to port 8443 but got %q", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestConfig_EnableTLS_missingFiles(t *testing.T) {
+	srv := Config{}.New(":8443", http.NotFoundHandler())
+	_, err := Config{}.EnableTLS(context.Background(), TLS{CertFile: "missing.pem", KeyFile: "missing.pem"}, srv, 8443)
+	if err == nil {
+		t.Error("expected an error for missing certificate files")
+	}
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocal_PutGetStatDelete(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewLocal(t.TempDir(), "/static/img", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Put(ctx, "users/1/img.png", strings.NewReader("png data"), 8, "image/png"); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+
+	rc, err := l.Get(ctx, "users/1/img.png")
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(content) != "png data" {
+		t.Errorf("expected content %q but got %q", "png data", content)
+	}
+
+	info, err := l.Stat(ctx, "users/1/img.png")
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+	if info.Size != 8 || info.ContentType != "image/png" {
+		t.Errorf("unexpected object info %+v", info)
+	}
+
+	if err := l.Delete(ctx, "users/1/img.png"); err != nil {
+		t.Errorf("delete: %s", err)
+	}
+	if _, err := l.Get(ctx, "users/1/img.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete but got %v", err)
+	}
+	if _, err := l.Stat(ctx, "users/1/img.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound from stat but got %v", err)
+	}
+	if err := l.Delete(ctx, "users/1/img.png"); err != nil {
+		t.Errorf("deleting a missing object should not fail: %s", err)
+	}
+
+	if err := l.Put(ctx, "../escape.png", strings.NewReader("x"), 1, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey but got %v", err)
+	}
+}
+
+func TestLocal_List(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), "/static/img", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testList(t, l)
+}
+
+func TestLocal_URL(t *testing.T) {
+	ctx := context.Background()
+
+	unsigned, _ := NewLocal(t.TempDir(), "/static/img/", "")
+	u, _ := unsigned.URL(ctx, "my photo.png", time.Minute)
+	if u != "/static/img/my%20photo.png" {
+		t.Errorf("unexpected unsigned url %s", u)
+	}
+
+	signed, _ := NewLocal(t.TempDir(), "/static/img/", "secret")
+	u, _ = signed.URL(ctx, "img.png", time.Minute)
+	if !strings.HasPrefix(u, "/static/img/img.png?expires=") || !strings.Contains(u, "&signature=") {
+		t.Errorf("unexpected signed url %s", u)
+	}
+
+	u, _ = signed.URL(ctx, "img.png", 0)
+	if u != "/static/img/img.png" {
+		t.Errorf("expected url without signature when expiry is 0, got %s", u)
+	}
+}
+
+func TestLocal_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	l, _ := NewLocal(t.TempDir(), "/static/img/", "secret")
+	_ = l.Put(ctx, "img.png", strings.NewReader("png data"), 8, "image/png")
+
+	valid, _ := l.URL(ctx, "img.png", time.Minute)
+	expired := "/static/img/img.png?expires=1&signature=" + l.sign("img.png", 1)
+	otherFile, _ := l.URL(ctx, "other.png", time.Minute)
+	tampered := strings.Replace(valid, "img.png?", "other.png?", 1)
+
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"valid signature", valid, http.StatusOK},
+		{"no signature", "/static/img/img.png", http.StatusForbidden},
+		{"expired", expired, http.StatusForbidden},
+		{"signature for another file", tampered, http.StatusForbidden},
+		{"missing file", otherFile, http.StatusNotFound},
+	}
+
+	handler := http.StripPrefix("/static/img", l)
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", e.url, nil)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedStatusCode == http.StatusOK && rr.Body.String() != "png data" {
+			t.Errorf("%s: unexpected body %q", e.name, rr.Body.String())
+		}
+		if e.expectedStatusCode == http.StatusOK && rr.Header().Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("%s: uploaded files must be served in a sandbox", e.name)
+		}
+	}
+}
+
+// 署名鍵がなければプロフィール画像だけを配信し、ユーザのファイルとアップロード中のデータは配信しない
+func TestLocal_ServeHTTP_unsigned(t *testing.T) {
+	ctx := context.Background()
+	l, _ := NewLocal(t.TempDir(), "/static/img/", "")
+	for _, key := range []string{"img.png", "files/1/cv.pdf", "uploads/abc/info.json"} {
+		_ = l.Put(ctx, key, strings.NewReader("data"), 4, "application/octet-stream")
+	}
+
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+	}{
+		{"profile picture", "/static/img/img.png", http.StatusOK},
+		{"user file", "/static/img/files/1/cv.pdf", http.StatusForbidden},
+		{"upload", "/static/img/uploads/abc/info.json", http.StatusForbidden},
+		{"made up signature", "/static/img/files/1/cv.pdf?expires=9999999999&signature=abc", http.StatusForbidden},
+	}
+
+	handler := http.StripPrefix("/static/img", l)
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", e.url, nil))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
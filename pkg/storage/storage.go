@@ -0,0 +1,91 @@
+// Package storage abstracts where uploaded files are kept. The Blob interface
+// is implemented by a local filesystem backend and an S3-compatible backend,
+// chosen at start up from a Config.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned for keys that are empty or try to escape the storage root.
+var ErrInvalidKey = errors.New("storage: invalid object key")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Blob is the interface for storing uploaded files. Keys are slash separated
+// relative paths, e.g. "photo-256.jpg".
+type Blob interface {
+	// Put stores the content of r under key, replacing any existing object.
+	// size may be -1 when it is not known in advance.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object for reading; the caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// Stat returns information about the object.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// URL returns an URL a browser can use to download the object. When expiry
+	// is greater than zero the URL is signed and stops working after expiry.
+	URL(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// List returns the objects whose keys start with prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// Config selects and configures a Blob backend.
+type Config struct {
+	// Backend is either "local" or "s3".
+	Backend string
+
+	// local backend
+	Root       string // directory the files are written to
+	BaseURL    string // URL prefix the files are served from, e.g. "/static/img/"
+	SigningKey string // key used to sign URLs; URLs are not signed when empty
+
+	// s3 backend
+	Endpoint  string // e.g. "https://s3.ap-northeast-1.amazonaws.com" or "http://localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// New returns the backend selected by cfg.Backend.
+func New(cfg Config) (Blob, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.Root, cfg.BaseURL, cfg.SigningKey)
+	case "s3":
+		return NewS3(cfg.Endpoint, cfg.Region, cfg.Bucket, cfg.AccessKey, cfg.SecretKey)
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", cfg.Backend)
+	}
+}
+
+// cleanKey validates a key and returns it in canonical form.
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+
+	return cleaned, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name          string
+		cfg           Config
+		errorExpected bool
+	}{
+		{"default is local", Config{Root: t.TempDir(), BaseURL: "/static/img/"}, false},
+		{"local", Config{Backend: "local", Root: t.TempDir(), BaseURL: "/static/img/"}, false},
+		{"s3", Config{Backend: "s3", Endpoint: "http://localhost:9000", Bucket: "avatars"}, false},
+		{"s3 without bucket", Config{Backend: "s3", Endpoint: "http://localhost:9000"}, true},
+		{"s3 bad endpoint", Config{Backend: "s3", Endpoint: "localhost", Bucket: "avatars"}, true},
+		{"unknown", Config{Backend: "ftp"}, true},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.cfg)
+		if e.errorExpected && err == nil {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+		}
+	}
+}
+
+func Test_cleanKey(t *testing.T) {
+	var tests = []struct {
+		key           string
+		expected      string
+		errorExpected bool
+	}{
+		{"img.png", "img.png", false},
+		{"users/1/img.png", "users/1/img.png", false},
+		{"users//1/./img.png", "users/1/img.png", false},
+		{"", "", true},
+		{"/etc/passwd", "", true},
+		{"../secret", "", true},
+		{"users/../../secret", "", true},
+		{`..\secret`, "", true},
+		{".", "", true},
+	}
+
+	for _, e := range tests {
+		got, err := cleanKey(e.key)
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%q: expected error, but did not get one", e.key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", e.key, err)
+		}
+		if got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.key, e.expected, got)
+		}
+	}
+}
+
+// testList checks the List method of a backend, which must be empty.
+func testList(t *testing.T, b Blob) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, key := range []string{"uploads/b/chunk-1", "photo.png", "uploads/a/info", "uploads/b/info", "uploadsx"} {
+		if err := b.Put(ctx, key, strings.NewReader(key), int64(len(key)), ""); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+	}
+
+	var tests = []struct {
+		name     string
+		prefix   string
+		expected []string
+	}{
+		{"everything", "", []string{"photo.png", "uploads/a/info", "uploads/b/chunk-1", "uploads/b/info", "uploadsx"}},
+		{"directory", "uploads/", []string{"uploads/a/info", "uploads/b/chunk-1", "uploads/b/info"}},
+		{"partial name", "upload", []string{"uploads/a/info", "uploads/b/chunk-1", "uploads/b/info", "uploadsx"}},
+		{"no match", "videos/", nil},
+	}
+
+	for _, e := range tests {
+		objects, err := b.List(ctx, e.prefix)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		var keys []string
+		for _, o := range objects {
+			keys = append(keys, o.Key)
+			if o.Size != int64(len(o.Key)) || o.ModTime.IsZero() {
+				t.Errorf("%s: unexpected object info %+v", e.name, o)
+			}
+		}
+		if strings.Join(keys, ",") != strings.Join(e.expected, ",") {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, keys)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package token issues and verifies the JSON Web Tokens of the API: a short
+// lived access token carrying the user's name and role, and a longer lived
+// refresh token to get a new pair. Both are signed with HS256.
+//
+// The API and the admin CLI issue tokens with the same Issuer, so a token
+// from the CLI is accepted like one from /auth.
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/data"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Default lifetimes of the tokens.
+const (
+	DefaultAccessExpiry  = 15 * time.Minute
+	DefaultRefreshExpiry = 24 * time.Hour
+)
+
+var (
+	// ErrExpired is returned for a token past its expiry.
+	ErrExpired = errors.New("token expired")
+	// ErrIssuer is returned for an access token of another domain.
+	ErrIssuer = errors.New("incorrect token issuer")
+)
+
+// Pair is the response of a login or a refresh.
+type Pair struct {
+	Token        string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Claims are the claims of the tokens. Refresh tokens only carry the
+// subject, the expiry and the time of issue.
+type Claims struct {
+	UserName string `json:"username"`
+	Name     string `json:"name,omitempty"`
+	Admin    bool   `json:"admin,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Issuer signs and verifies the tokens of a domain.
+type Issuer struct {
+	Secret string
+	// Domain is the issuer and the audience of access tokens.
+	Domain        string
+	AccessExpiry  time.Duration
+	RefreshExpiry time.Duration
+}
+
+// Issue returns a new pair of tokens for user.
+func (i Issuer) Issue(user *data.User) (Pair, error) {
+	now := time.Now()
+
+	// create the token
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	// set claims
+	claims := token.Claims.(jwt.MapClaims)
+	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+	claims["sub"] = fmt.Sprintf("%d", user.ID)
+	claims["aud"] = i.Domain
+	claims["iss"] = i.Domain
+	claims["admin"] = user.IsAdmin == 1
+	// セッションの失効より前に発行されたかを判定するため
+	claims["iat"] = now.Unix()
+
+	// set the expiry
+	claims["exp"] = now.Add(i.AccessExpiry).Unix()
+
+	// create the signed token
+	signedAccessToken, err := token.SignedString([]byte(i.Secret))
+	if err != nil {
+		return Pair{}, err
+	}
+
+	// create the refresh token
+	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshTokenClaims["sub"] = fmt.Sprintf("%d", user.ID)
+	refreshTokenClaims["iat"] = now.Unix()
+	// set the expiry; must be longer than the access token
+	refreshTokenClaims["exp"] = now.Add(i.RefreshExpiry).Unix()
+
+	// create the signed refresh token
+	signedRefreshToken, err := refreshToken.SignedString([]byte(i.Secret))
+	if err != nil {
+		return Pair{}, err
+	}
+
+	return Pair{Token: signedAccessToken, RefreshToken: signedRefreshToken}, nil
+}
+
+// Verify checks the signature, the expiry and the issuer of an access token
+// and returns its claims.
+func (i Issuer) Verify(token string) (*Claims, error) {
+	claims, err := i.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	// make sure that we issued the token
+	if claims.Issuer != i.Domain {
+		return nil, ErrIssuer
+	}
+	return claims, nil
+}
+
+// VerifyRefresh checks the signature and the expiry of a refresh token and
+// returns its claims.
+func (i Issuer) VerifyRefresh(token string) (*Claims, error) {
+	return i.parse(token)
+}
+
+func (i Issuer) parse(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		// validate the signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(i.Secret), nil
+	})
+	// note that this catches expired tokens as well
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Decode returns the header and the claims of a token without verifying it,
+// to inspect tokens of any secret.
+func Decode(token string) (header, claims map[string]any, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("token must have three parts separated by dots")
+	}
+	if err := decodePart(parts[0], &header); err != nil {
+		return nil, nil, fmt.Errorf("header: %w", err)
+	}
+	if err := decodePart(parts[1], &claims); err != nil {
+		return nil, nil, fmt.Errorf("claims: %w", err)
+	}
+	return header, claims, nil
+}
+
+func decodePart(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	// 数値の時刻を丸めずにそのまま表示する
+	dec.UseNumber()
+	return dec.Decode(v)
+}
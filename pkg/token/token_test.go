@@ -0,0 +1,127 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var testIssuer = Issuer{
+	Secret:        "verysecret",
+	Domain:        "example.com",
+	AccessExpiry:  DefaultAccessExpiry,
+	RefreshExpiry: DefaultRefreshExpiry,
+}
+
+var testUser = data.User{ID: 7, FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", IsAdmin: 1}
+
+func TestIssuer_Issue(t *testing.T) {
+	pair, err := testIssuer.Issue(&testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := testIssuer.Verify(pair.Token)
+	if err != nil {
+		t.Fatalf("expected a valid access token but got %v", err)
+	}
+	if claims.Subject != "7" || claims.Name != "Ada Lovelace" || !claims.Admin || claims.Issuer != "example.com" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time) > time.Minute {
+		t.Errorf("expected the time of issue but got %v", claims.IssuedAt)
+	}
+	if d := time.Until(claims.ExpiresAt.Time); d <= 14*time.Minute || d > DefaultAccessExpiry {
+		t.Errorf("expected the access token to expire in %s but got %s", DefaultAccessExpiry, d)
+	}
+
+	refresh, err := testIssuer.VerifyRefresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("expected a valid refresh token but got %v", err)
+	}
+	if refresh.Subject != "7" || refresh.IssuedAt == nil {
+		t.Errorf("unexpected refresh claims %+v", refresh)
+	}
+	if !refresh.ExpiresAt.After(claims.ExpiresAt.Time) {
+		t.Error("expected the refresh token to outlive the access token")
+	}
+}
+
+func TestIssuer_Verify(t *testing.T) {
+	valid, _ := testIssuer.Issue(&testUser)
+
+	expiredIssuer := testIssuer
+	expiredIssuer.AccessExpiry = -time.Hour
+	expired, _ := expiredIssuer.Issue(&testUser)
+
+	otherDomain := testIssuer
+	otherDomain.Domain = "evil.com"
+	foreign, _ := otherDomain.Issue(&testUser)
+
+	otherSecret := testIssuer
+	otherSecret.Secret = "other"
+	forged, _ := otherSecret.Issue(&testUser)
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "7", "iss": "example.com"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	var tests = []struct {
+		name      string
+		token     string
+		expectErr error
+	}{
+		{"valid", valid.Token, nil},
+		{"expired", expired.Token, ErrExpired},
+		{"other issuer", foreign.Token, ErrIssuer},
+		{"other secret", forged.Token, jwt.ErrSignatureInvalid},
+		{"unsigned", none, nil},
+		{"garbage", "abc", nil},
+	}
+
+	for _, e := range tests {
+		_, err := testIssuer.Verify(e.token)
+		switch {
+		case e.name == "valid":
+			if err != nil {
+				t.Errorf("%s: expected no error but got %v", e.name, err)
+			}
+		case err == nil:
+			t.Errorf("%s: expected an error", e.name)
+		case e.expectErr != nil && !errors.Is(err, e.expectErr):
+			t.Errorf("%s: expected %v but got %v", e.name, e.expectErr, err)
+		}
+	}
+
+	// リフレッシュトークンには発行者がない
+	if _, err := testIssuer.Verify(valid.RefreshToken); !errors.Is(err, ErrIssuer) {
+		t.Errorf("expected a refresh token not to pass as an access token but got %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	pair, _ := testIssuer.Issue(&testUser)
+
+	header, claims, err := Decode(pair.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "HS256" {
+		t.Errorf("expected alg HS256 but got %v", header["alg"])
+	}
+	if claims["sub"] != "7" || claims["name"] != "Ada Lovelace" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	// 時刻は指数表記にせずに表示できるようにjson.Numberで返す
+	if _, ok := claims["exp"].(json.Number); !ok {
+		t.Errorf("expected exp as a json.Number but got %T", claims["exp"])
+	}
+
+	for _, bad := range []string{"", "a.b", "a.b.c", pair.Token[:10] + ".e30.x"} {
+		if _, _, err := Decode(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSpans() []SpanData {
+	start := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	return []SpanData{{
+		Name:          "GET /users/{userID}",
+		Kind:          KindServer,
+		SpanContext:   SpanContext{TraceID: TraceID{0x4b, 0xf9}, SpanID: SpanID{0x01}, Sampled: true},
+		Parent:        SpanID{0x02},
+		Start:         start,
+		End:           start.Add(15 * time.Millisecond),
+		Attributes:    []Attribute{String("http.route", "/users/{userID}"), Int("http.response.status_code", 500), Bool("cached", false)},
+		Status:        StatusError,
+		StatusMessage: "Internal Server Error",
+	}}
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name        string
+		exporter    string
+		expectNil   bool
+		expectError bool
+	}{
+		{"none", "none", true, false},
+		{"empty", "", true, false},
+		{"stdout", "stdout", false, false},
+		{"otlp", "OTLP", false, false},
+		{"unknown", "jaeger", true, true},
+	}
+
+	for _, e := range tests {
+		exporter, err := New(e.exporter, io.Discard, "http://localhost:4318")
+		if (err != nil) != e.expectError {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if (exporter == nil) != e.expectNil {
+			t.Errorf("%s: unexpected exporter %v", e.name, exporter)
+		}
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewStdoutExporter(&buf).Export(context.Background(), "api", testSpans()); err != nil {
+		t.Fatal(err)
+	}
+
+	var span map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &span); err != nil {
+		t.Fatalf("%s: %s", buf.String(), err)
+	}
+	if span["service"] != "api" || span["duration"] != "15ms" || span["error"] != "Internal Server Error" {
+		t.Errorf("unexpected span %s", buf.String())
+	}
+	if span["parent_id"] != "0200000000000000" {
+		t.Errorf("unexpected parent %v", span["parent_id"])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	var path, contentType string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	exporter := NewOTLPExporter(srv.URL + "/")
+	if err := exporter.Export(context.Background(), "api", testSpans()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("unexpected request to %s with %s", path, contentType)
+	}
+
+	expected := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"go_test_prac/webApp/pkg/tracing"},"spans":[{` +
+		`"traceId":"4bf90000000000000000000000000000","spanId":"0100000000000000","parentSpanId":"0200000000000000",` +
+		`"name":"GET /users/{userID}","kind":2,"startTimeUnixNano":"1688212800000000000","endTimeUnixNano":"1688212800015000000",` +
+		`"attributes":[{"key":"http.route","value":{"stringValue":"/users/{userID}"}},` +
+		`{"key":"http.response.status_code","value":{"intValue":"500"}},{"key":"cached","value":{"boolValue":false}}],` +
+		`"status":{"code":2,"message":"Internal Server Error"}}]}]}]}`
+	if string(body) != expected {
+		t.Errorf("unexpected body\n%s\nexpected\n%s", body, expected)
+	}
+
+	// コレクターのエラーを返す
+	status = http.StatusBadRequest
+	if err := exporter.Export(context.Background(), "api", testSpans()); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected the status of the collector in the error, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"go_test_prac/webApp/pkg/metrics"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Middleware starts a server span for every request, continuing the trace of
+// the caller when it sent a traceparent header. The span is named after the
+// chi route, e.g. "GET /users/{userID}", once routing is done.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := start(ctx, r.Method, KindServer, []Attribute{
+			String("http.request.method", r.Method),
+			String("url.path", r.URL.Path),
+		})
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// ルートに一致しなかった場合はメソッドだけを名前にする
+		if route := metrics.RoutePattern(r); route != metrics.UnmatchedRoute {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(String("http.route", route))
+		}
+		span.SetAttributes(Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(StatusError, http.StatusText(status))
+		}
+	})
+}
+
+// Transport injects the trace context into outgoing requests, so that the
+// services called continue the trace.
+type Transport struct {
+	// Base is the transport used for the request, http.DefaultTransport
+	// when nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !SpanContextFromContext(req.Context()).IsValid() {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTripperはリクエストを書き換えてはいけないので、複製してから設定する
+	req = req.Clone(req.Context())
+	Inject(req.Context(), req.Header)
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMiddleware(t *testing.T) {
+	flush := record(t, 1)
+
+	var handlerSpan *Span
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Get("/users/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = SpanFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nothing", nil))
+
+	spans := flush()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans but got %d", len(spans))
+	}
+	routed, unmatched := spans[0], spans[1]
+
+	if routed.Name != "GET /users/{userID}" || routed.Kind != KindServer || routed.Status != StatusError {
+		t.Errorf("unexpected span %+v", routed)
+	}
+	if routed.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || routed.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the span to continue the trace of the caller, got %+v", routed.SpanContext)
+	}
+	if handlerSpan == nil || handlerSpan.SpanContext() != routed.SpanContext {
+		t.Error("expected the handler to get the request span")
+	}
+	attrs := make(map[string]interface{})
+	for _, a := range routed.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["http.route"] != "/users/{userID}" || attrs["http.response.status_code"] != int64(500) {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	if unmatched.Name != "GET" || unmatched.Parent.IsValid() {
+		t.Errorf("unexpected span %+v", unmatched)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	SetProvider(nil)
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(TraceparentHeader)
+	}))
+	defer srv.Close()
+
+	ctx, span := Start(context.Background(), "call")
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got != formatTraceparent(span.SpanContext()) {
+		t.Errorf("expected the trace context to be sent, got %q", got)
+	}
+	if req.Header.Get(TraceparentHeader) != "" {
+		t.Error("expected the request of the caller to be left unchanged")
+	}
+}
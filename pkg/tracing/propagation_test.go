@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func Test_parseTraceparent(t *testing.T) {
+	var tests = []struct {
+		name          string
+		header        string
+		expectValid   bool
+		expectSampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"extra fields in version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", false, false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-01", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, e := range tests {
+		sc, ok := parseTraceparent(e.header)
+		if ok != e.expectValid {
+			t.Errorf("%s: expected valid %t but got %t", e.name, e.expectValid, ok)
+			continue
+		}
+		if ok && sc.Sampled != e.expectSampled {
+			t.Errorf("%s: expected sampled %t", e.name, e.expectSampled)
+		}
+		if ok && sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: unexpected trace ID %s", e.name, sc.TraceID)
+		}
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	SetProvider(nil)
+	ctx, span := Start(context.Background(), "outgoing")
+
+	h := http.Header{}
+	Inject(ctx, h)
+	expected := "00-" + span.SpanContext().TraceID.String() + "-" + span.SpanContext().SpanID.String() + "-00"
+	if h.Get(TraceparentHeader) != expected {
+		t.Errorf("expected %s but got %s", expected, h.Get(TraceparentHeader))
+	}
+
+	sc := SpanContextFromContext(Extract(context.Background(), h))
+	if sc != span.SpanContext() {
+		t.Errorf("expected %+v but got %+v", span.SpanContext(), sc)
+	}
+
+	// トレースがなければ何も付けない
+	h = http.Header{}
+	Inject(context.Background(), h)
+	if h.Get(TraceparentHeader) != "" {
+		t.Error("expected no traceparent header")
+	}
+}
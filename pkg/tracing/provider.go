@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// maxQueueSize is the number of finished spans kept for the exporter.
+	// Spans finished while the queue is full are dropped.
+	maxQueueSize = 2048
+	// maxBatchSize is the number of spans sent to the exporter at once.
+	maxBatchSize = 512
+	// exportTimeout limits a single export.
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	// Export sends spans of the service called service.
+	Export(ctx context.Context, service string, spans []SpanData) error
+}
+
+// Provider batches finished spans and hands them to an exporter in the
+// background, so that requests never wait for it.
+type Provider struct {
+	service  string
+	exporter Exporter
+	ratio    float64
+	interval time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan SpanData
+	flush  chan chan struct{}
+	done   chan struct{}
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+}
+
+// NewProvider returns a provider exporting the spans of service. New traces
+// are sampled with the probability ratio, between 0 and 1; spans continuing a
+// trace of another service follow its decision.
+func NewProvider(service string, exporter Exporter, ratio float64) *Provider {
+	p := &Provider{
+		service:  service,
+		exporter: exporter,
+		ratio:    ratio,
+		interval: 5 * time.Second,
+		queue:    make(chan SpanData, maxQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go p.run()
+	return p
+}
+
+// sample decides whether a new trace is recorded.
+func (p *Provider) sample() bool {
+	if p == nil || p.ratio <= 0 {
+		return false
+	}
+	if p.ratio >= 1 {
+		return true
+	}
+	p.randMu.Lock()
+	defer p.randMu.Unlock()
+	return p.rand.Float64() < p.ratio
+}
+
+func (p *Provider) enqueue(s SpanData) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- s:
+	default:
+		// エクスポートが追いつかない場合は捨てる
+	}
+}
+
+func (p *Provider) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := p.exporter.Export(ctx, p.service, batch); err != nil {
+			slog.Warn("exporting spans", "spans", len(batch), "err", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s, ok := <-p.queue:
+			if !ok {
+				export()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-p.flush:
+			// キューに残っている分もまとめて送る
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+			}
+			export()
+			close(flushed)
+		}
+	}
+}
+
+// ForceFlush exports the spans finished so far.
+func (p *Provider) ForceFlush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case p.flush <- flushed:
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the remaining spans and stops the provider. Spans finished
+// afterwards are dropped.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,276 @@
+// Package tracing records spans following the OpenTelemetry data model and
+// exports them to stdout or to an OpenTelemetry collector over OTLP/HTTP.
+// Trace context is propagated in W3C traceparent headers.
+//
+// Spans are started with Start, which makes the new span a child of the span
+// in the context. Nothing is recorded until SetProvider installs a provider
+// with an exporter; spans are still created then, so that the trace context of
+// incoming requests is passed on.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid reports whether t is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span passed on to children and other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// SpanKind is the role of a span, with the values of OTLP.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// StatusCode is the status of a span, with the values of OTLP.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key and a string, int64, float64 or bool value.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute { return Attribute{key, value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute { return Attribute{key, int64(value)} }
+
+// Int64 returns an integer attribute.
+func Int64(key string, value int64) Attribute { return Attribute{key, value} }
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// SpanData is a finished span, as handed to exporters.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	SpanContext   SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Span is an operation being timed. Its methods are safe for concurrent use
+// and do nothing on a span that is not sampled.
+type Span struct {
+	mu       sync.Mutex
+	provider *Provider
+	data     SpanData
+	ended    bool
+}
+
+// SpanContext returns the identity of s.
+func (s *Span) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *Span) recording() bool {
+	return s.data.SpanContext.Sampled && s.provider != nil
+}
+
+// SetName replaces the name given to Start, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttributes adds attributes to s.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// SetStatus sets the status of s.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Status = code
+	s.data.StatusMessage = message
+}
+
+// RecordError marks s as failed with err. A nil err is ignored, so the error
+// of the traced call can be passed without checking it.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes s and hands it to the exporter. Calls after the first do
+// nothing.
+func (s *Span) End() {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.provider.enqueue(data)
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// SpanFromContext returns the span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// SpanContextFromContext returns the identity of the span of ctx, or of the
+// remote parent extracted from a request.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteSpanContext returns a context whose spans continue the
+// trace of another service.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+var global atomic.Value // *Provider
+
+// SetProvider installs p for Start. A nil p stops recording.
+func SetProvider(p *Provider) {
+	global.Store(&p)
+}
+
+func currentProvider() *Provider {
+	p, _ := global.Load().(**Provider)
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// Start starts an internal span as a child of the span in ctx and returns a
+// context carrying it. The caller must End the span.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return start(ctx, name, KindInternal, attrs)
+}
+
+func start(ctx context.Context, name string, kind SpanKind, attrs []Attribute) (context.Context, *Span) {
+	p := currentProvider()
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{SpanID: newSpanID()}
+	if parent.IsValid() {
+		// 親の判断に従う(親がサンプリングされていなければ子も記録しない)
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		sc.TraceID = newTraceID()
+		sc.Sampled = p.sample()
+	}
+
+	s := &Span{provider: p}
+	s.data = SpanData{
+		Name:        name,
+		Kind:        kind,
+		SpanContext: sc,
+		Start:       time.Now(),
+	}
+	if parent.IsValid() {
+		s.data.Parent = parent.SpanID
+	}
+	if s.recording() {
+		s.data.Attributes = append(s.data.Attributes, attrs...)
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+// IDはランダムであればよく、暗号学的な強さは要らない
+var (
+	randMu sync.Mutex
+	rng    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func newTraceID() TraceID {
+	var t TraceID
+	randMu.Lock()
+	defer randMu.Unlock()
+	for !t.IsValid() {
+		rng.Read(t[:])
+	}
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	randMu.Lock()
+	defer randMu.Unlock()
+	for !s.IsValid() {
+		rng.Read(s[:])
+	}
+	return s
+}
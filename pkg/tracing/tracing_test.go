@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recorder keeps exported spans in memory.
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(ctx context.Context, service string, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// record installs a provider exporting to a recorder for the test. flush
+// returns the spans exported so far.
+func record(t *testing.T, ratio float64) (flush func() []SpanData) {
+	t.Helper()
+	rec := &recorder{}
+	p := NewProvider("test", rec, ratio)
+	SetProvider(p)
+	t.Cleanup(func() {
+		SetProvider(nil)
+		p.Shutdown(context.Background())
+	})
+	return func() []SpanData {
+		if err := p.ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return append([]SpanData(nil), rec.spans...)
+	}
+}
+
+func TestStart(t *testing.T) {
+	flush := record(t, 1)
+
+	ctx, parent := Start(context.Background(), "parent", String("key", "value"))
+	_, child := Start(ctx, "child")
+	child.RecordError(errors.New("boom"))
+	child.End()
+	parent.RecordError(nil)
+	parent.End()
+	parent.End()
+
+	spans := flush()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans but got %d", len(spans))
+	}
+	c, p := spans[0], spans[1]
+
+	if c.Name != "child" || p.Name != "parent" {
+		t.Errorf("unexpected names %s, %s", c.Name, p.Name)
+	}
+	if c.SpanContext.TraceID != p.SpanContext.TraceID || c.Parent != p.SpanContext.SpanID {
+		t.Error("expected the child to belong to the parent")
+	}
+	if p.Parent.IsValid() {
+		t.Error("expected the parent to be a root span")
+	}
+	if c.Status != StatusError || c.StatusMessage != "boom" || p.Status != StatusUnset {
+		t.Errorf("unexpected status %d %q, %d", c.Status, c.StatusMessage, p.Status)
+	}
+	if len(p.Attributes) != 1 || p.Attributes[0] != String("key", "value") {
+		t.Errorf("unexpected attributes %v", p.Attributes)
+	}
+	if p.End.Before(p.Start) {
+		t.Error("expected the span to end after it started")
+	}
+}
+
+func TestStart_notRecording(t *testing.T) {
+	// プロバイダがなくてもIDは作られ、伝播できる
+	SetProvider(nil)
+	ctx, span := Start(context.Background(), "ignored")
+	span.SetAttributes(String("a", "b"))
+	span.End()
+	if !span.SpanContext().IsValid() || span.SpanContext().Sampled {
+		t.Errorf("unexpected span context %+v", span.SpanContext())
+	}
+	if SpanFromContext(ctx) != span {
+		t.Error("expected the span in the context")
+	}
+
+	// サンプリングされなかったトレースは子も記録しない
+	flush := record(t, 0)
+	ctx, root := Start(context.Background(), "root")
+	_, child := Start(ctx, "child")
+	child.End()
+	root.End()
+	if spans := flush(); len(spans) != 0 {
+		t.Errorf("expected no spans but got %d", len(spans))
+	}
+}
+
+func TestStart_remoteParent(t *testing.T) {
+	flush := record(t, 0)
+
+	remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+	_, span := Start(ctx, "continued")
+	span.End()
+
+	// 呼び出し元がサンプリングしていれば、比率が0でも記録する
+	spans := flush()
+	if len(spans) != 1 || spans[0].SpanContext.TraceID != remote.TraceID || spans[0].Parent != remote.SpanID {
+		t.Errorf("expected the span to continue the remote trace, got %+v", spans)
+	}
+}
+
+func TestProvider_Shutdown(t *testing.T) {
+	rec := &recorder{}
+	p := NewProvider("test", rec, 1)
+	SetProvider(p)
+	defer SetProvider(nil)
+
+	_, span := Start(context.Background(), "before")
+	span.End()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, span = Start(context.Background(), "after")
+	span.End()
+
+	if len(rec.spans) != 1 || rec.spans[0].Name != "before" {
+		t.Errorf("expected only the span ended before the shutdown, got %+v", rec.spans)
+	}
+}
@@ -0,0 +1,101 @@
+package userio
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/repository"
+	"io"
+	"strconv"
+)
+
+// exportedUser is a line of a JSON Lines export. The columns of the CSV
+// export have the same names.
+type exportedUser struct {
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	IsAdmin   int    `json:"is_admin"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Export streams every user of db to w. CSV is written as the database sends
+// it; JSON Lines are converted from it row by row, so neither is held in
+// memory. The export stops when ctx is done.
+func Export(ctx context.Context, db repository.DatabaseRepo, w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return db.ExportUsers(ctx, w)
+	case FormatJSONL:
+		return exportJSONL(ctx, db, w)
+	}
+	return fmt.Errorf("unknown format %q, expected %s or %s", format, FormatCSV, FormatJSONL)
+}
+
+func exportJSONL(ctx context.Context, db repository.DatabaseRepo, w io.Writer) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := db.ExportUsers(ctx, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err := convertCSV(pr, w)
+	// 書き込みに失敗した場合もエクスポートを止めてから戻る
+	pr.CloseWithError(errors.New("userio: the export was abandoned"))
+	if exportErr := <-done; err == nil {
+		err = exportErr
+	}
+	return err
+}
+
+// convertCSV writes the CSV of ExportUsers as JSON Lines.
+func convertCSV(r io.Reader, w io.Writer) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[column] = i
+	}
+	get := func(values []string, column string) string {
+		if i, ok := index[column]; ok && i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+
+	enc := json.NewEncoder(w)
+	for {
+		values, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		u := exportedUser{
+			Email:     get(values, "email"),
+			FirstName: get(values, "first_name"),
+			LastName:  get(values, "last_name"),
+			CreatedAt: get(values, "created_at"),
+			UpdatedAt: get(values, "updated_at"),
+		}
+		if u.ID, err = strconv.Atoi(get(values, "id")); err != nil {
+			return fmt.Errorf("id %q: %w", get(values, "id"), err)
+		}
+		// is_adminがnullの行は管理者ではない
+		u.IsAdmin, _ = strconv.Atoi(get(values, "is_admin"))
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package userio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"io"
+	"strings"
+	"testing"
+)
+
+// exportRepo writes csv as the export of the users, unless ctx is done.
+type exportRepo struct {
+	dbrepo.TestDBRepo
+	csv string
+	err error
+}
+
+func (m *exportRepo) ExportUsers(ctx context.Context, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, m.csv); err != nil {
+		return err
+	}
+	return m.err
+}
+
+func TestExport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(context.Background(), &dbrepo.TestDBRepo{}, &buf, FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "id,email,first_name,last_name,is_admin,") {
+		t.Errorf("expected the CSV of the repository but got %q", buf.String())
+	}
+
+	buf.Reset()
+	repo := &exportRepo{csv: "id,email,first_name,last_name,is_admin,created_at,updated_at\n" +
+		"1,admin@example.com,Admin,User,1,2022-08-19 00:00:00,2022-08-19 00:00:00\n" +
+		"2,\"jane,doe@example.com\",Jane,\"Doe\nJr\",,2022-08-20 00:00:00,2022-08-20 00:00:00\n"}
+	if err := Export(context.Background(), repo, &buf, FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines but got %q", buf.String())
+	}
+	var jane exportedUser
+	if err := json.Unmarshal([]byte(lines[1]), &jane); err != nil {
+		t.Fatal(err)
+	}
+	if jane.ID != 2 || jane.Email != "jane,doe@example.com" || jane.LastName != "Doe\nJr" || jane.IsAdmin != 0 {
+		t.Errorf("unexpected user %+v", jane)
+	}
+
+	// エクスポートし直したものはそのまま取り込める
+	records, err := read(&buf, FormatJSONL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, invalid := rows(records, nil); len(invalid) != 1 || invalid[0].Line != 2 {
+		t.Errorf("expected only the invalid email to be rejected but got %+v", invalid)
+	}
+}
+
+func TestExport_errors(t *testing.T) {
+	repo := &exportRepo{csv: "id,email\n1,a@example.com\n", err: errors.New("connection reset")}
+	if err := Export(context.Background(), repo, io.Discard, FormatJSONL); err == nil || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("expected the error of the repository but got %v", err)
+	}
+
+	repo = &exportRepo{csv: "id,email\nx,a@example.com\n"}
+	if err := Export(context.Background(), repo, io.Discard, FormatJSONL); err == nil {
+		t.Error("expected an error for an invalid id")
+	}
+
+	if err := Export(context.Background(), repo, io.Discard, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+
+	// リクエストが中断されたらエクスポートも止まる
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, format := range []string{FormatCSV, FormatJSONL} {
+		if err := Export(ctx, repo, io.Discard, format); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected the export to be canceled but got %v", format, err)
+		}
+	}
+}
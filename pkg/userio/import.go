@@ -0,0 +1,106 @@
+package userio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository"
+	"go_test_prac/webApp/pkg/validation"
+	"io"
+)
+
+// ErrInvalidRows is returned with the report of an import that has invalid
+// rows. Nothing is written then.
+var ErrInvalidRows = errors.New("the import has invalid rows")
+
+// ErrTooManyRows is returned with the report of an import of more rows than
+// Options.MaxRows. Nothing is written then.
+var ErrTooManyRows = errors.New("the import has too many rows")
+
+// Options control an import.
+type Options struct {
+	Mapping Mapping
+	// DryRun only reads and checks the rows.
+	DryRun bool
+	// BatchSize is the number of rows committed together. With 0 every row is
+	// written in one transaction; otherwise a failed batch leaves the earlier
+	// batches written.
+	BatchSize int
+	// Catalog translates the messages of invalid rows; nil is English.
+	Catalog validation.Catalog
+	// MaxRows limits the rows of an import; 0 is no limit. Every password is
+	// hashed with bcrypt, which takes a while for many rows.
+	MaxRows int
+}
+
+// Report is the outcome of an import.
+type Report struct {
+	Rows    int        `json:"rows"`    // rows read, valid or not
+	Created int        `json:"created"` // users created, none in a dry run
+	Updated int        `json:"updated"` // users updated, none in a dry run
+	DryRun  bool       `json:"dry_run"`
+	Errors  []RowError `json:"errors"` // the invalid rows, in the order of the input
+}
+
+// Import reads users from r and writes them to db by email. The report is
+// returned with any error that stops the import after reading, such as
+// ErrInvalidRows or a failed batch, so that written rows can be told. No more
+// batches are written once ctx is done.
+func Import(ctx context.Context, db repository.DatabaseRepo, r io.Reader, format string, opts Options) (*Report, error) {
+	if opts.BatchSize < 0 {
+		return nil, errors.New("the batch size must not be negative")
+	}
+	catalog := opts.Catalog
+	if catalog == nil {
+		catalog = validation.English
+	}
+
+	records, err := read(r, format, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	valid, invalid := rows(records, catalog)
+
+	report := &Report{Rows: len(records), DryRun: opts.DryRun, Errors: invalid}
+	if report.Errors == nil {
+		report.Errors = []RowError{}
+	}
+	if opts.MaxRows > 0 && len(records) > opts.MaxRows {
+		return report, fmt.Errorf("%w: %d rows, at most %d", ErrTooManyRows, len(records), opts.MaxRows)
+	}
+	if len(invalid) > 0 {
+		return report, ErrInvalidRows
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	size := opts.BatchSize
+	if size == 0 {
+		size = len(valid)
+	}
+	for start := 0; start < len(valid); start += size {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		batch := valid[start:min(start+size, len(valid))]
+		users := make([]data.UpsertUser, len(batch))
+		for i, row := range batch {
+			users[i] = row.User
+		}
+
+		results, err := db.UpsertUsers(ctx, users)
+		if err != nil {
+			return report, fmt.Errorf("writing the rows from line %d: %w", batch[0].Line, err)
+		}
+		for _, result := range results {
+			if result.Created {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+		}
+	}
+	return report, nil
+}
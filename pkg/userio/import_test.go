@@ -0,0 +1,131 @@
+package userio
+
+import (
+	"context"
+	"errors"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/repository/dbrepo"
+	"go_test_prac/webApp/pkg/validation"
+	"strings"
+	"testing"
+)
+
+// batchRepo records the batches written and fails the batch number failAt.
+type batchRepo struct {
+	dbrepo.TestDBRepo
+	batches [][]data.UpsertUser
+	failAt  int
+}
+
+func (m *batchRepo) UpsertUsers(ctx context.Context, users []data.UpsertUser) ([]data.UpsertResult, error) {
+	if len(m.batches)+1 == m.failAt {
+		return nil, errors.New("connection reset")
+	}
+	m.batches = append(m.batches, users)
+	return m.TestDBRepo.UpsertUsers(ctx, users)
+}
+
+const importCSV = `email,first_name,last_name,is_admin
+admin@example.com,Admin,User,1
+jane@example.com,Jane,Doe,
+john@example.com,John,Smith,0
+`
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		opts        Options
+		failAt      int
+		wantErr     error
+		wantBatches []int
+		wantCreated int
+		wantUpdated int
+	}{
+		{"one transaction", importCSV, Options{}, 0, nil, []int{3}, 2, 1},
+		{"batches", importCSV, Options{BatchSize: 2}, 0, nil, []int{2, 1}, 2, 1},
+		{"dry run", importCSV, Options{DryRun: true}, 0, nil, nil, 0, 0},
+		{"invalid rows", importCSV + "bad,,,\n", Options{}, 0, ErrInvalidRows, nil, 0, 0},
+		{"invalid rows in a dry run", importCSV + "bad,,,\n", Options{DryRun: true}, 0, ErrInvalidRows, nil, 0, 0},
+		{"failed batch", importCSV, Options{BatchSize: 2}, 2, errors.New("line 4"), []int{2}, 1, 1},
+		{"too many rows", importCSV, Options{MaxRows: 2}, 0, ErrTooManyRows, nil, 0, 0},
+		{"at the row limit", importCSV, Options{MaxRows: 3}, 0, nil, []int{3}, 2, 1},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			repo := &batchRepo{failAt: e.failAt}
+			report, err := Import(context.Background(), repo, strings.NewReader(e.input), FormatCSV, e.opts)
+			switch {
+			case e.wantErr == nil && err != nil:
+				t.Fatal(err)
+			case e.wantErr != nil && (err == nil || !errors.Is(err, e.wantErr) && !strings.Contains(err.Error(), e.wantErr.Error())):
+				t.Fatalf("expected %v but got %v", e.wantErr, err)
+			}
+
+			if len(repo.batches) != len(e.wantBatches) {
+				t.Fatalf("expected batches of %v but got %d batches", e.wantBatches, len(repo.batches))
+			}
+			for i, n := range e.wantBatches {
+				if len(repo.batches[i]) != n {
+					t.Errorf("batch %d: expected %d users but got %d", i, n, len(repo.batches[i]))
+				}
+			}
+			if report.Created != e.wantCreated || report.Updated != e.wantUpdated || report.DryRun != e.opts.DryRun {
+				t.Errorf("unexpected report %+v", report)
+			}
+			if errors.Is(e.wantErr, ErrInvalidRows) && (len(report.Errors) != 1 || report.Errors[0].Line != 5) {
+				t.Errorf("expected line 5 to be invalid but got %+v", report.Errors)
+			}
+		})
+	}
+}
+
+// リクエストが終わったら、残りのバッチは書き込まない
+func TestImport_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	repo := &batchRepo{}
+	_, err := Import(ctx, repo, strings.NewReader(importCSV), FormatCSV, Options{BatchSize: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled but got %v", err)
+	}
+	if len(repo.batches) != 0 {
+		t.Errorf("expected no batches but got %d", len(repo.batches))
+	}
+}
+
+func TestImport_values(t *testing.T) {
+	repo := &batchRepo{}
+	if _, err := Import(context.Background(), repo, strings.NewReader(importCSV), FormatCSV, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := repo.batches[0]
+	if *users[0].IsAdmin != 1 || users[1].IsAdmin != nil || *users[2].IsAdmin != 0 {
+		t.Errorf("expected is_admin 1, kept and 0 but got %v, %v, %v", users[0].IsAdmin, users[1].IsAdmin, users[2].IsAdmin)
+	}
+	if users[1].Email != "jane@example.com" || users[1].FirstName != "Jane" || users[1].Password != "" {
+		t.Errorf("unexpected user %+v", users[1])
+	}
+}
+
+func TestImport_catalog(t *testing.T) {
+	report, err := Import(context.Background(), &batchRepo{}, strings.NewReader("email,first_name,last_name\nx,,Doe\n"), FormatCSV, Options{Catalog: validation.Japanese})
+	if !errors.Is(err, ErrInvalidRows) {
+		t.Fatalf("expected invalid rows but got %v", err)
+	}
+	if msg := report.Errors[0].Errors["first_name"]; msg != validation.Japanese["required"] {
+		t.Errorf("expected the message in Japanese but got %q", msg)
+	}
+}
+
+func TestImport_errors(t *testing.T) {
+	if _, err := Import(context.Background(), &batchRepo{}, strings.NewReader(importCSV), FormatCSV, Options{BatchSize: -1}); err == nil {
+		t.Error("expected an error for a negative batch size")
+	}
+	if _, err := Import(context.Background(), &batchRepo{}, strings.NewReader(importCSV), FormatCSV, Options{Mapping: Mapping{"x": "id"}}); err == nil {
+		t.Error("expected an error for an invalid mapping")
+	}
+}
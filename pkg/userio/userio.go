@@ -0,0 +1,360 @@
+// Package userio imports and exports users in bulk, as CSV or JSON Lines.
+//
+// An import reads every row first and checks it with the rules of the API.
+// Rows are matched to existing users by email: users with a new email are
+// created and the others updated. If any row is invalid nothing is written and
+// the Report lists the errors of each row, so a file can be fixed and sent
+// again. Valid imports are written in one transaction, or in batches of
+// Options.BatchSize rows each committed on its own.
+//
+// Columns are read as the user field of the same name (email, first_name,
+// last_name, is_admin and password) unless a Mapping says otherwise. Other
+// columns are ignored, so an export can be imported again.
+package userio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_test_prac/webApp/pkg/data"
+	"go_test_prac/webApp/pkg/validation"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Formats of imports and exports.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Fields are the user fields a column can be mapped to.
+var Fields = []string{"email", "first_name", "last_name", "is_admin", "password"}
+
+// requiredFields must each be read from a column.
+var requiredFields = []string{"email", "first_name", "last_name"}
+
+// minPasswordLength is the shortest password an import sets.
+const minPasswordLength = 8
+
+// maxLineLength limits a line of JSON Lines.
+const maxLineLength = 1 << 20
+
+// ParseFormat checks the name of a format. "ndjson" is accepted for JSON Lines.
+func ParseFormat(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatJSONL, "ndjson":
+		return FormatJSONL, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected %s or %s", s, FormatCSV, FormatJSONL)
+}
+
+// FormatOf returns the format of a file by its extension, or "" if unknown.
+func FormatOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	}
+	return ""
+}
+
+// ContentType returns the media type of a format.
+func ContentType(format string) string {
+	if format == FormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Mapping maps the columns of an import to user fields, e.g. "E-Mail" to
+// "email".
+type Mapping map[string]string
+
+// ParseMapping parses a comma-separated list of column=field pairs, e.g.
+// "E-Mail=email,Given name=first_name".
+func ParseMapping(s string) (Mapping, error) {
+	m := Mapping{}
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		column, field, ok := strings.Cut(pair, "=")
+		column, field = strings.TrimSpace(column), strings.TrimSpace(field)
+		if !ok || column == "" || field == "" {
+			return nil, fmt.Errorf("mapping %q must be column=field", pair)
+		}
+		m[column] = field
+	}
+	return m, m.Validate()
+}
+
+// Validate checks that every column is mapped to a known field, and no field
+// twice.
+func (m Mapping) Validate() error {
+	seen := make(map[string]string)
+	for column, field := range m {
+		if !isField(field) {
+			return fmt.Errorf("column %q is mapped to unknown field %q, expected one of %s", column, field, strings.Join(Fields, ", "))
+		}
+		if other, ok := seen[field]; ok {
+			return fmt.Errorf("columns %q and %q are both mapped to %s", other, column, field)
+		}
+		seen[field] = column
+	}
+	return nil
+}
+
+// field returns the field a column is read as, or "" if it is ignored.
+func (m Mapping) field(column string) string {
+	if field, ok := m[column]; ok {
+		return field
+	}
+	// 対応づけのない列は、他の列が使っていなければ同名のフィールドとして読む
+	for _, field := range m {
+		if field == column {
+			return ""
+		}
+	}
+	if isField(column) {
+		return column
+	}
+	return ""
+}
+
+func isField(name string) bool {
+	for _, f := range Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Row is a user read from an import.
+type Row struct {
+	Line int // line of the input the row starts on
+	User data.UpsertUser
+}
+
+// RowError lists why a row of an import was rejected.
+type RowError struct {
+	Line   int               `json:"line"`
+	Email  string            `json:"email,omitempty"`
+	Errors map[string]string `json:"errors"` // messages keyed by field, "row" if the row could not be read
+}
+
+// rowError renders errs with c.
+func rowError(line int, email string, errs validation.Errors, c validation.Catalog) RowError {
+	return RowError{Line: line, Email: email, Errors: errs.First(c)}
+}
+
+// malformed is the error of a row that could not be read.
+func malformed(reason string) validation.Errors {
+	return validation.Errors{{Field: "row", Rule: "malformed", Params: map[string]string{"reason": reason}}}
+}
+
+// record is a row before validation: the values of the fields it has, by
+// field name.
+type record struct {
+	line   int
+	values map[string]string
+	errs   validation.Errors
+}
+
+// read reads the records of r. Errors of single rows are kept on the record;
+// the error returned stops the import.
+func read(r io.Reader, format string, m Mapping) ([]record, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatCSV:
+		return readCSV(r, m)
+	case FormatJSONL:
+		return readJSONL(r, m)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected %s or %s", format, FormatCSV, FormatJSONL)
+}
+
+func readCSV(r io.Reader, m Mapping) ([]record, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the input is empty, expected a header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// Excelが付けるBOMを除く
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	fields := make([]string, len(header))
+	present := make(map[string]bool)
+	for i, column := range header {
+		fields[i] = m.field(strings.TrimSpace(column))
+		present[fields[i]] = true
+	}
+	for _, field := range requiredFields {
+		if !present[field] {
+			return nil, fmt.Errorf("no column is read as %s; name a column %s or map one with column=%s", field, field, field)
+		}
+	}
+
+	var records []record
+	for {
+		values, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		rec := record{values: make(map[string]string)}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount):
+			// 列の数が違う行だけを不正とし、続きを読む
+			rec.line = parseErr.StartLine
+			rec.errs = malformed(fmt.Sprintf("expected %d columns but got %d", len(header), len(values)))
+		case err != nil:
+			return nil, err
+		default:
+			rec.line, _ = cr.FieldPos(0)
+			for i, value := range values {
+				if fields[i] != "" {
+					rec.values[fields[i]] = value
+				}
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+func readJSONL(r io.Reader, m Mapping) ([]record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	var records []record
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+
+		rec := record{line: line, values: make(map[string]string)}
+		rec.errs = decodeObject(text, m, rec.values)
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the input is empty")
+	}
+	return records, nil
+}
+
+// decodeObject reads the fields of one JSON object into values.
+func decodeObject(text string, m Mapping, values map[string]string) validation.Errors {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var object map[string]any
+	if err := dec.Decode(&object); err != nil {
+		return malformed("invalid JSON: " + err.Error())
+	}
+	if object == nil {
+		return malformed("expected a JSON object")
+	}
+
+	var errs validation.Errors
+	for key, value := range object {
+		field := m.field(key)
+		if field == "" {
+			continue
+		}
+		switch v := value.(type) {
+		case nil:
+		case string:
+			values[field] = v
+		case json.Number:
+			values[field] = v.String()
+		case bool:
+			values[field] = strconv.FormatBool(v)
+		default:
+			errs = append(errs, validation.FieldError{Field: field, Rule: "malformed", Params: map[string]string{"reason": "expected a string, number or boolean"}})
+		}
+	}
+	return errs
+}
+
+// rows validates records and returns the users to write, or the errors of the
+// invalid rows. Emails must be unique within the input.
+func rows(records []record, c validation.Catalog) ([]Row, []RowError) {
+	var (
+		valid   []Row
+		invalid []RowError
+	)
+	firstLine := make(map[string]int)
+	for _, rec := range records {
+		email := strings.TrimSpace(rec.values["email"])
+		if len(rec.errs) > 0 {
+			invalid = append(invalid, rowError(rec.line, email, rec.errs, c))
+			continue
+		}
+
+		user, v := parseUser(rec.values)
+		if line, ok := firstLine[email]; ok && email != "" {
+			v.Add("email", "duplicate", map[string]string{"line": strconv.Itoa(line)})
+		} else if email != "" {
+			firstLine[email] = rec.line
+		}
+
+		if !v.Valid() {
+			invalid = append(invalid, rowError(rec.line, email, v.Errors, c))
+			continue
+		}
+		valid = append(valid, Row{Line: rec.line, User: user})
+	}
+	return valid, invalid
+}
+
+// parseUser checks the values of a row with the rules of the API.
+func parseUser(values map[string]string) (data.UpsertUser, *validation.Validator) {
+	user := data.UpsertUser{
+		Email:     strings.TrimSpace(values["email"]),
+		FirstName: strings.TrimSpace(values["first_name"]),
+		LastName:  strings.TrimSpace(values["last_name"]),
+		Password:  values["password"],
+	}
+
+	v := validation.New()
+	check := data.User{Email: user.Email, FirstName: user.FirstName, LastName: user.LastName}
+	v.Errors = append(v.Errors, check.Validate()...)
+	v.Field("password", user.Password, validation.MinLength(minPasswordLength))
+
+	// 空の場合は既存ユーザの権限を変えない
+	switch strings.ToLower(strings.TrimSpace(values["is_admin"])) {
+	case "":
+	case "1", "true":
+		isAdmin := 1
+		user.IsAdmin = &isAdmin
+	case "0", "false":
+		isAdmin := 0
+		user.IsAdmin = &isAdmin
+	default:
+		v.Add("is_admin", "one_of", map[string]string{"values": "0, 1, true, false"})
+	}
+	return user, v
+}
@@ -0,0 +1,218 @@
+package userio
+
+import (
+	"go_test_prac/webApp/pkg/validation"
+	"strings"
+	"testing"
+)
+
+func TestParseMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Mapping
+		wantErr string
+	}{
+		{"empty", "", Mapping{}, ""},
+		{"pairs", "E-Mail=email, Given name = first_name", Mapping{"E-Mail": "email", "Given name": "first_name"}, ""},
+		{"no field", "E-Mail", nil, "column=field"},
+		{"unknown field", "Role=role", nil, "unknown field"},
+		{"field twice", "Mail=email,E-Mail=email", nil, "both mapped"},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			m, err := ParseMapping(e.input)
+			if e.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+					t.Errorf("expected %q but got %v", e.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m) != len(e.want) {
+				t.Fatalf("expected %v but got %v", e.want, m)
+			}
+			for column, field := range e.want {
+				if m[column] != field {
+					t.Errorf("expected %s=%s but got %v", column, field, m)
+				}
+			}
+		})
+	}
+}
+
+func TestMapping_field(t *testing.T) {
+	m := Mapping{"Mail": "email", "email": "first_name"}
+	tests := map[string]string{
+		"Mail":       "email",
+		"email":      "first_name", // 対応づけが同名より優先される
+		"first_name": "",           // 他の列が読まれる
+		"last_name":  "last_name",
+		"id":         "",
+	}
+	for column, want := range tests {
+		if got := m.field(column); got != want {
+			t.Errorf("%s: expected %q but got %q", column, want, got)
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for input, want := range map[string]string{"csv": FormatCSV, "JSONL": FormatJSONL, "ndjson": FormatJSONL} {
+		if got, err := ParseFormat(input); err != nil || got != want {
+			t.Errorf("%s: expected %s but got %s, %v", input, want, got, err)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("expected an error for xlsx")
+	}
+
+	for name, want := range map[string]string{"users.CSV": FormatCSV, "users.jsonl": FormatJSONL, "users.ndjson": FormatJSONL, "users.txt": "", "-": ""} {
+		if got := FormatOf(name); got != want {
+			t.Errorf("%s: expected %q but got %q", name, want, got)
+		}
+	}
+}
+
+func Test_read(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		input     string
+		mapping   Mapping
+		wantLines []int
+		wantEmail []string
+		wantErr   string
+	}{
+		{
+			name:      "csv",
+			format:    FormatCSV,
+			input:     "\ufeffemail,first_name,last_name,id\na@example.com,A,Aa,7\n\"b@example.com\",\"B\nB\",Bb,8\n",
+			wantLines: []int{2, 3},
+			wantEmail: []string{"a@example.com", "b@example.com"},
+		},
+		{
+			name:      "csv with mapping",
+			format:    FormatCSV,
+			input:     "Mail,Given,Family\na@example.com,A,Aa\n",
+			mapping:   Mapping{"Mail": "email", "Given": "first_name", "Family": "last_name"},
+			wantLines: []int{2},
+			wantEmail: []string{"a@example.com"},
+		},
+		{
+			name:      "csv with a short row",
+			format:    FormatCSV,
+			input:     "email,first_name,last_name\na@example.com,A\nb@example.com,B,Bb\n",
+			wantLines: []int{2, 3},
+			wantEmail: []string{"", "b@example.com"},
+		},
+		{
+			name:    "csv without a required column",
+			format:  FormatCSV,
+			input:   "email,first_name\na@example.com,A\n",
+			wantErr: "last_name",
+		},
+		{
+			name:    "empty csv",
+			format:  FormatCSV,
+			input:   "",
+			wantErr: "header",
+		},
+		{
+			name:      "jsonl",
+			format:    FormatJSONL,
+			input:     "{\"Mail\":\"a@example.com\",\"email\":\"ignored@example.com\",\"first_name\":\"A\",\"last_name\":\"Aa\",\"is_admin\":1}\n\n{\"Mail\":\"b@example.com\"}\n",
+			mapping:   Mapping{"Mail": "email"},
+			wantLines: []int{1, 3},
+			wantEmail: []string{"a@example.com", "b@example.com"},
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			input:   "<users/>",
+			wantErr: "unknown format",
+		},
+	}
+
+	for _, e := range tests {
+		t.Run(e.name, func(t *testing.T) {
+			records, err := read(strings.NewReader(e.input), e.format, e.mapping)
+			if e.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+					t.Errorf("expected %q but got %v", e.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(e.wantLines) {
+				t.Fatalf("expected %d records but got %+v", len(e.wantLines), records)
+			}
+			for i, rec := range records {
+				if rec.line != e.wantLines[i] || rec.values["email"] != e.wantEmail[i] {
+					t.Errorf("record %d: expected line %d and %q but got %+v", i, e.wantLines[i], e.wantEmail[i], rec)
+				}
+			}
+		})
+	}
+}
+
+func Test_rows(t *testing.T) {
+	input := strings.Join([]string{
+		`{"email":"a@example.com","first_name":"A","last_name":"Aa","is_admin":true,"password":"long enough"}`,
+		`{"email":"b@example.com","first_name":"B","last_name":"Bb","is_admin":"yes"}`,
+		`{"email":"c","first_name":"","last_name":"Cc","password":"short"}`,
+		`{"email":"a@example.com","first_name":"A","last_name":"Aa"}`,
+		`not json`,
+		`{"email":"d@example.com","first_name":["D"],"last_name":"Dd"}`,
+		`{"email":"e@example.com","first_name":"E","last_name":"Ee","is_admin":null}`,
+	}, "\n")
+	records, err := read(strings.NewReader(input), FormatJSONL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, invalid := rows(records, validation.English)
+	if len(valid) != 2 || valid[0].Line != 1 || valid[1].Line != 7 {
+		t.Fatalf("expected lines 1 and 7 to be valid but got %+v", valid)
+	}
+	if a := valid[0].User; a.IsAdmin == nil || *a.IsAdmin != 1 || a.Password != "long enough" {
+		t.Errorf("unexpected user %+v", a)
+	}
+	if valid[1].User.IsAdmin != nil {
+		t.Error("expected a null is_admin to keep the role")
+	}
+
+	want := []struct {
+		line   int
+		fields []string
+	}{
+		{2, []string{"is_admin"}},
+		{3, []string{"email", "first_name", "password"}},
+		{4, []string{"email"}},
+		{5, []string{"row"}},
+		{6, []string{"first_name"}},
+	}
+	if len(invalid) != len(want) {
+		t.Fatalf("expected %d invalid rows but got %+v", len(want), invalid)
+	}
+	for i, w := range want {
+		got := invalid[i]
+		if got.Line != w.line || len(got.Errors) != len(w.fields) {
+			t.Errorf("expected line %d with errors on %v but got %+v", w.line, w.fields, got)
+			continue
+		}
+		for _, field := range w.fields {
+			if got.Errors[field] == "" {
+				t.Errorf("line %d: expected an error on %s but got %v", w.line, field, got.Errors)
+			}
+		}
+	}
+	if msg := invalid[2].Errors["email"]; msg != "is also on line 1" {
+		t.Errorf("unexpected duplicate message %q", msg)
+	}
+}
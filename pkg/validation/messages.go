@@ -0,0 +1,70 @@
+package validation
+
+import "strings"
+
+// Catalog maps the names of rules to messages. Parameters of the rule are
+// written in braces, e.g. "must be at most {max} characters long".
+type Catalog map[string]string
+
+// English is the default catalog.
+var English = Catalog{
+	"required":   "must not be blank",
+	"email":      "must be a valid email address",
+	"min_length": "must be at least {min} characters long",
+	"max_length": "must be at most {max} characters long",
+	"one_of":     "must be one of {values}",
+	"between":    "must be from {min} to {max}",
+	"pattern":    "is not in the expected format",
+	"duplicate":  "is also on line {line}",
+	"malformed":  "could not be read: {reason}",
+}
+
+// Japanese is the catalog for Accept-Language: ja.
+var Japanese = Catalog{
+	"required":   "入力してください",
+	"email":      "有効なメールアドレスを入力してください",
+	"min_length": "{min}文字以上で入力してください",
+	"max_length": "{max}文字以内で入力してください",
+	"one_of":     "{values}のいずれかを指定してください",
+	"between":    "{min}から{max}の範囲で指定してください",
+	"pattern":    "形式が正しくありません",
+	"duplicate":  "{line}行目と重複しています",
+	"malformed":  "読み取れません: {reason}",
+}
+
+// Catalogs are the available catalogs keyed by language.
+var Catalogs = map[string]Catalog{
+	"en": English,
+	"ja": Japanese,
+}
+
+// Message renders e. Rules missing from c fall back to English, and rules
+// missing from English to their name.
+func (c Catalog) Message(e FieldError) string {
+	message, ok := c[e.Rule]
+	if !ok {
+		message, ok = English[e.Rule]
+	}
+	if !ok {
+		message = e.Rule
+	}
+
+	for name, value := range e.Params {
+		message = strings.ReplaceAll(message, "{"+name+"}", value)
+	}
+	return message
+}
+
+// CatalogFor returns the catalog of the first language in an Accept-Language
+// header that has one, or English. Quality values are ignored; clients list
+// their preferred language first.
+func CatalogFor(acceptLanguage string) Catalog {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		lang := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if c, ok := Catalogs[lang]; ok {
+			return c
+		}
+	}
+	return English
+}
@@ -0,0 +1,42 @@
+package validation
+
+import "testing"
+
+func TestCatalog_Message(t *testing.T) {
+	var tests = []struct {
+		name     string
+		catalog  Catalog
+		err      FieldError
+		expected string
+	}{
+		{"english", English, FieldError{Field: "name", Rule: "min_length", Params: map[string]string{"min": "3"}}, "must be at least 3 characters long"},
+		{"japanese", Japanese, FieldError{Field: "name", Rule: "max_length", Params: map[string]string{"max": "255"}}, "255文字以内で入力してください"},
+		{"falls back to english", Catalog{}, FieldError{Field: "email", Rule: "email"}, "must be a valid email address"},
+		{"unknown rule", English, FieldError{Field: "password", Rule: "mismatch"}, "mismatch"},
+	}
+
+	for _, e := range tests {
+		if msg := e.catalog.Message(e.err); msg != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, msg)
+		}
+	}
+}
+
+func TestCatalogFor(t *testing.T) {
+	var tests = []struct {
+		acceptLanguage string
+		expected       string
+	}{
+		{"", English["required"]},
+		{"ja", Japanese["required"]},
+		{"ja-JP,ja;q=0.9,en;q=0.8", Japanese["required"]},
+		{"fr-FR, ja;q=0.5", Japanese["required"]},
+		{"de", English["required"]},
+	}
+
+	for _, e := range tests {
+		if got := CatalogFor(e.acceptLanguage)["required"]; got != e.expected {
+			t.Errorf("%q: expected %q but got %q", e.acceptLanguage, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package validation
+
+import (
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rule is a condition on the value of a field. Every rule but Required
+// accepts blank values, so optional fields only need Required left out.
+type Rule struct {
+	Name   string            // message key in a Catalog
+	Params map[string]string // values substituted into the message
+	Check  func(value string) bool
+}
+
+// blank reports whether value is empty or only white space.
+func blank(value string) bool {
+	return strings.TrimSpace(value) == ""
+}
+
+// Required rejects blank values.
+func Required() Rule {
+	return Rule{Name: "required", Check: func(value string) bool {
+		return !blank(value)
+	}}
+}
+
+// Email accepts a bare address such as "admin@example.com", without a
+// display name or angle brackets.
+func Email() Rule {
+	return Rule{Name: "email", Check: func(value string) bool {
+		if blank(value) {
+			return true
+		}
+		addr, err := mail.ParseAddress(value)
+		return err == nil && addr.Address == value
+	}}
+}
+
+// MinLength rejects values shorter than min characters.
+func MinLength(min int) Rule {
+	return Rule{Name: "min_length", Params: map[string]string{"min": strconv.Itoa(min)}, Check: func(value string) bool {
+		return blank(value) || utf8.RuneCountInString(value) >= min
+	}}
+}
+
+// MaxLength rejects values longer than max characters.
+func MaxLength(max int) Rule {
+	return Rule{Name: "max_length", Params: map[string]string{"max": strconv.Itoa(max)}, Check: func(value string) bool {
+		return utf8.RuneCountInString(value) <= max
+	}}
+}
+
+// Truncate shortens s to at most max characters, as varchar columns count
+// them, for values that are stored cut rather than rejected. A character is
+// never split. Invalid UTF-8, which the database would refuse, is replaced
+// with U+FFFD first.
+func Truncate(s string, max int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	n := 0
+	for i := range s {
+		if n == max {
+			return s[:i]
+		}
+		n++
+	}
+	return s
+}
+
+// OneOf accepts only the given values.
+func OneOf(values ...string) Rule {
+	return Rule{Name: "one_of", Params: map[string]string{"values": strings.Join(values, ", ")}, Check: func(value string) bool {
+		if blank(value) {
+			return true
+		}
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}}
+}
+
+// Matches accepts values matching re. Anchor the expression to match the
+// whole value.
+func Matches(re *regexp.Regexp) Rule {
+	return Rule{Name: "pattern", Params: map[string]string{"pattern": re.String()}, Check: func(value string) bool {
+		return blank(value) || re.MatchString(value)
+	}}
+}
@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRules(t *testing.T) {
+	var tests = []struct {
+		name     string
+		rule     Rule
+		value    string
+		expected bool
+	}{
+		{"required", Required(), "admin", true},
+		{"required blank", Required(), "", false},
+		{"required white space", Required(), "  ", false},
+		{"email", Email(), "admin@example.com", true},
+		{"email blank", Email(), "", true},
+		{"email without at", Email(), "admin.example.com", false},
+		{"email with display name", Email(), "Admin <admin@example.com>", false},
+		{"min length", MinLength(3), "abc", true},
+		{"min length too short", MinLength(3), "ab", false},
+		{"min length counts characters", MinLength(3), "日本語", true},
+		{"max length", MaxLength(3), "abc", true},
+		{"max length too long", MaxLength(3), "abcd", false},
+		{"max length counts characters", MaxLength(3), "日本語", true},
+		{"one of", OneOf("admin", "user"), "user", true},
+		{"one of other", OneOf("admin", "user"), "root", false},
+		{"matches", Matches(regexp.MustCompile(`^[a-z]+$`)), "admin", true},
+		{"matches not", Matches(regexp.MustCompile(`^[a-z]+$`)), "Admin1", false},
+		{"long value", MaxLength(255), strings.Repeat("a", 256), false},
+	}
+
+	for _, e := range tests {
+		if ok := e.rule.Check(e.value); ok != e.expected {
+			t.Errorf("%s: expected %t for %q but got %t", e.name, e.expected, e.value, ok)
+		}
+	}
+}
+
+// 文字の途中で切らず、不正なUTF-8は置き換える
+func TestTruncate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		value    string
+		max      int
+		expected string
+	}{
+		{"short", "abc", 5, "abc"},
+		{"exact", "abcde", 5, "abcde"},
+		{"ascii", "abcdef", 5, "abcde"},
+		{"multi-byte at the cut", "abcd日本", 5, "abcd日"},
+		{"counts characters", "日本語テキスト", 3, "日本語"},
+		{"emoji", "ab😀cd", 3, "ab😀"},
+		{"invalid utf-8", "ab\xffcd", 3, "ab\uFFFD"},
+		{"cut invalid utf-8 of a multi-byte character", "abcd\xe6\x97", 10, "abcd\uFFFD"},
+		{"zero", "abc", 0, ""},
+	}
+
+	for _, e := range tests {
+		got := Truncate(e.value, e.max)
+		if got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: %q is not valid UTF-8", e.name, got)
+		}
+	}
+}
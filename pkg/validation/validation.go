@@ -0,0 +1,124 @@
+// Package validation checks request payloads with rules declared per field:
+//
+//	v := validation.New()
+//	v.Field("email", u.Email, validation.Required(), validation.Email(), validation.MaxLength(255))
+//	v.Field("role", role, validation.OneOf("admin", "user"))
+//	if !v.Valid() {
+//		return v.Errors
+//	}
+//
+// Failures are kept as FieldErrors naming the field, the rule and its
+// parameters rather than as finished sentences, so the API and the web forms
+// can render them in the language of the client with a Catalog.
+package validation
+
+import (
+	"sort"
+	"strings"
+)
+
+// FieldError is a rule a field did not satisfy.
+type FieldError struct {
+	Field  string
+	Rule   string            // message key in a Catalog, e.g. "max_length"
+	Params map[string]string // values substituted into the message, e.g. {"max": "255"}
+}
+
+// Error returns the English message prefixed with the field name.
+func (e FieldError) Error() string {
+	return e.Field + " " + English.Message(e)
+}
+
+// Errors are the failures of one payload, in the order the fields were
+// checked.
+type Errors []FieldError
+
+// Error lists every failure in English.
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Fields returns the fields that failed, sorted by name.
+func (e Errors) Fields() []string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, fe := range e {
+		if !seen[fe.Field] {
+			seen[fe.Field] = true
+			fields = append(fields, fe.Field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Messages returns every message of each field, translated with c.
+func (e Errors) Messages(c Catalog) map[string][]string {
+	messages := make(map[string][]string)
+	for _, fe := range e {
+		messages[fe.Field] = append(messages[fe.Field], c.Message(fe))
+	}
+	return messages
+}
+
+// First returns the first message of each field, translated with c.
+func (e Errors) First(c Catalog) map[string]string {
+	messages := make(map[string]string)
+	for _, fe := range e {
+		if _, ok := messages[fe.Field]; !ok {
+			messages[fe.Field] = c.Message(fe)
+		}
+	}
+	return messages
+}
+
+// Validator collects the errors of one payload.
+type Validator struct {
+	Errors Errors
+}
+
+// New returns an empty Validator.
+func New() *Validator {
+	return &Validator{}
+}
+
+// Field checks value against rules in order. Only the first failing rule is
+// reported, so a blank field is not also said to be too short.
+func (v *Validator) Field(name, value string, rules ...Rule) {
+	for _, rule := range rules {
+		if !rule.Check(value) {
+			v.Add(name, rule.Name, rule.Params)
+			return
+		}
+	}
+}
+
+// Check records a failure of the rule named rule when ok is false. It is used
+// for conditions no Rule describes, such as comparing two fields.
+func (v *Validator) Check(ok bool, field, rule string, params map[string]string) {
+	if !ok {
+		v.Add(field, rule, params)
+	}
+}
+
+// Add records a failure.
+func (v *Validator) Add(field, rule string, params map[string]string) {
+	v.Errors = append(v.Errors, FieldError{Field: field, Rule: rule, Params: params})
+}
+
+// Valid reports whether every rule was satisfied.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Err returns the errors, or nil when the payload is valid.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return v.Errors
+}
@@ -0,0 +1,60 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidator_Field(t *testing.T) {
+	v := New()
+	v.Field("first_name", "", Required(), MaxLength(3))
+	v.Field("last_name", "toolong", Required(), MaxLength(3))
+	v.Field("email", "admin@example.com", Required(), Email())
+	v.Check(false, "password", "mismatch", nil)
+
+	if v.Valid() {
+		t.Fatal("expected the validator to be invalid")
+	}
+
+	var rules []string
+	for _, e := range v.Errors {
+		rules = append(rules, e.Field+":"+e.Rule)
+	}
+	// 最初に失敗したルールだけが記録される
+	if strings.Join(rules, ",") != "first_name:required,last_name:max_length,password:mismatch" {
+		t.Errorf("unexpected errors %v", rules)
+	}
+
+	if v.Err() == nil {
+		t.Error("expected an error")
+	}
+	if New().Err() != nil || !New().Valid() {
+		t.Error("an empty validator should be valid")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	errs := Errors{
+		{Field: "name", Rule: "required"},
+		{Field: "email", Rule: "email"},
+		{Field: "name", Rule: "max_length", Params: map[string]string{"max": "10"}},
+	}
+
+	if got := strings.Join(errs.Fields(), ","); got != "email,name" {
+		t.Errorf("unexpected fields %s", got)
+	}
+
+	messages := errs.Messages(English)
+	if len(messages["name"]) != 2 || messages["name"][1] != "must be at most 10 characters long" {
+		t.Errorf("unexpected messages %v", messages)
+	}
+
+	first := errs.First(English)
+	if first["name"] != "must not be blank" || first["email"] != "must be a valid email address" {
+		t.Errorf("unexpected first messages %v", first)
+	}
+
+	if errs.Error() != "name must not be blank; email must be a valid email address; name must be at most 10 characters long" {
+		t.Errorf("unexpected error %q", errs.Error())
+	}
+}